@@ -246,6 +246,9 @@ export function formatRole(role: string): string | null {
   if (role === "CI_RUNNER") {
     return "Workflow";
   }
+  if (role === "CI_RUNNER_SCHEDULED") {
+    return "Scheduled workflow";
+  }
   if (role === "CI") {
     return "CI";
   }
@@ -13,6 +13,7 @@ import { formatDate } from "../format/format";
 import { durationToMillisWithFallback, timestampToDateWithFallback } from "../util/proto";
 
 export const CI_RUNNER_ROLE = "CI_RUNNER";
+export const CI_RUNNER_SCHEDULED_ROLE = "CI_RUNNER_SCHEDULED";
 export const HOSTED_BAZEL_ROLE = "HOSTED_BAZEL";
 
 export const InvocationStatus = invocation.Invocation.InvocationStatus;
@@ -384,7 +385,7 @@ export default class InvocationModel {
   }
 
   isWorkflowInvocation() {
-    return this.getRole() === CI_RUNNER_ROLE;
+    return this.getRole() === CI_RUNNER_ROLE || this.getRole() === CI_RUNNER_SCHEDULED_ROLE;
   }
 
   isHostedBazelInvocation() {
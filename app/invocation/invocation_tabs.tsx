@@ -1,5 +1,5 @@
 import React from "react";
-import { CI_RUNNER_ROLE, HOSTED_BAZEL_ROLE } from "./invocation_model";
+import { CI_RUNNER_ROLE, CI_RUNNER_SCHEDULED_ROLE, HOSTED_BAZEL_ROLE } from "./invocation_model";
 
 export type InvocationTabsProps = TabsContext;
 
@@ -39,7 +39,7 @@ export function getActiveTab({ hash, role, denseMode }: TabsContext): TabId {
 
   if (!denseMode) return "all";
 
-  return role === CI_RUNNER_ROLE ? "commands" : "targets";
+  return role === CI_RUNNER_ROLE || role === CI_RUNNER_SCHEDULED_ROLE ? "commands" : "targets";
 }
 
 export default class InvocationTabsComponent extends React.Component<InvocationTabsProps> {
@@ -52,7 +52,10 @@ export default class InvocationTabsComponent extends React.Component<InvocationT
   }
 
   render() {
-    const isBazelInvocation = this.props.role !== CI_RUNNER_ROLE && this.props.role !== HOSTED_BAZEL_ROLE;
+    const isBazelInvocation =
+      this.props.role !== CI_RUNNER_ROLE &&
+      this.props.role !== CI_RUNNER_SCHEDULED_ROLE &&
+      this.props.role !== HOSTED_BAZEL_ROLE;
 
     return (
       <div className="tabs">
@@ -0,0 +1,282 @@
+package artifacts
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildbuddy-io/buildbuddy/cli/autoconfig"
+	"github.com/buildbuddy-io/buildbuddy/server/util/grpc_client"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"google.golang.org/grpc/metadata"
+
+	apipb "github.com/buildbuddy-io/buildbuddy/proto/api/v1"
+)
+
+const (
+	downloadCommand = "download"
+	logsCommand     = "logs"
+
+	// logFileName is the name of the file that build logs are written to
+	// within the output directory.
+	logFileName = "build.log"
+
+	// targetsDir is the directory within the output directory that target
+	// outputs are written to.
+	targetsDir = "targets"
+)
+
+// IsCommand returns whether the given (filtered) command line invokes one of
+// the commands implemented by this package, rather than a bazel command.
+func IsCommand(args []string) bool {
+	return len(args) > 0 && (args[0] == downloadCommand || args[0] == logsCommand)
+}
+
+type commandOpts struct {
+	invocationID string
+	targetLabel  string
+	outputDir    string
+}
+
+// parseArgs parses the arguments following a command. Flags may appear before
+// or after the invocation ID.
+func parseArgs(command string, args []string) (*commandOpts, error) {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	targetLabel := fs.String("target", "", "If set, only fetch outputs of the target with this label, e.g. //foo:bar.")
+	outputDir := fs.String("output_dir", "", "The local directory to write to. Defaults to a directory named after the invocation ID when downloading.")
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, status.InvalidArgumentErrorf("invalid arguments to %q: %s", command, err)
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != 1 {
+		return nil, status.InvalidArgumentErrorf("usage: bb %s <invocation-id> [--target=<label>] [--output_dir=<dir>]", command)
+	}
+	opts := &commandOpts{
+		invocationID: positional[0],
+		targetLabel:  *targetLabel,
+		outputDir:    *outputDir,
+	}
+	if command == downloadCommand && opts.outputDir == "" {
+		opts.outputDir = opts.invocationID
+	}
+	return opts, nil
+}
+
+// Run runs the `bb download` or `bb logs` command described by the given
+// (filtered) command line, and returns the exit code that the CLI should exit
+// with.
+//
+// `bb logs <invocation-id>` prints the build logs of an invocation, or writes
+// them to --output_dir if set.
+//
+// `bb download <invocation-id>` writes the build logs along with the files
+// produced by each target of the invocation to --output_dir, optionally
+// restricted to a single target with --target.
+func Run(ctx context.Context, args []string) (int, error) {
+	if !IsCommand(args) {
+		return 1, status.InvalidArgumentErrorf("unknown command %q", strings.Join(args, " "))
+	}
+	command := args[0]
+	opts, err := parseArgs(command, args[1:])
+	if err != nil {
+		return 1, err
+	}
+
+	apiKey := autoconfig.APIKey()
+	if apiKey == "" {
+		return 1, status.FailedPreconditionError("an API key is required to fetch invocation data; pass it with --api_key")
+	}
+	endpoint, err := autoconfig.BuildBuddyEndpoint(ctx)
+	if err != nil {
+		return 1, err
+	}
+	conn, err := grpc_client.DialTarget(endpoint)
+	if err != nil {
+		return 1, err
+	}
+	defer conn.Close()
+	client := apipb.NewApiServiceClient(conn)
+	ctx = metadata.AppendToOutgoingContext(ctx, "x-buildbuddy-api-key", apiKey)
+
+	switch command {
+	case logsCommand:
+		err = logs(ctx, client, opts)
+	case downloadCommand:
+		err = download(ctx, client, opts)
+	}
+	if err != nil {
+		return 1, err
+	}
+	return 0, nil
+}
+
+func logs(ctx context.Context, client apipb.ApiServiceClient, opts *commandOpts) error {
+	if opts.outputDir == "" {
+		return writeLogs(ctx, client, opts.invocationID, os.Stdout)
+	}
+	if err := os.MkdirAll(opts.outputDir, 0755); err != nil {
+		return err
+	}
+	return writeLogsToFile(ctx, client, opts.invocationID, filepath.Join(opts.outputDir, logFileName))
+}
+
+// writeLogs writes every page of the invocation's build logs to w.
+func writeLogs(ctx context.Context, client apipb.ApiServiceClient, invocationID string, w io.Writer) error {
+	pageToken := ""
+	for {
+		rsp, err := client.GetLog(ctx, &apipb.GetLogRequest{
+			Selector:  &apipb.LogSelector{InvocationId: invocationID},
+			PageToken: pageToken,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, rsp.GetLog().GetContents()); err != nil {
+			return err
+		}
+		if rsp.GetNextPageToken() == "" || rsp.GetNextPageToken() == pageToken {
+			return nil
+		}
+		pageToken = rsp.GetNextPageToken()
+	}
+}
+
+func writeLogsToFile(ctx context.Context, client apipb.ApiServiceClient, invocationID, path string) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := writeLogs(ctx, client, invocationID, f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func download(ctx context.Context, client apipb.ApiServiceClient, opts *commandOpts) error {
+	invRsp, err := client.GetInvocation(ctx, &apipb.GetInvocationRequest{
+		Selector: &apipb.InvocationSelector{InvocationId: opts.invocationID},
+	})
+	if err != nil {
+		return err
+	}
+	if len(invRsp.GetInvocation()) == 0 {
+		return status.NotFoundErrorf("invocation %q not found", opts.invocationID)
+	}
+	if opts.targetLabel != "" {
+		targetRsp, err := client.GetTarget(ctx, &apipb.GetTargetRequest{
+			Selector: &apipb.TargetSelector{InvocationId: opts.invocationID, Label: opts.targetLabel},
+		})
+		if err != nil {
+			return err
+		}
+		if len(targetRsp.GetTarget()) == 0 {
+			return status.NotFoundErrorf("target %q not found in invocation %q", opts.targetLabel, opts.invocationID)
+		}
+	}
+
+	if err := os.MkdirAll(opts.outputDir, 0755); err != nil {
+		return err
+	}
+	logPath := filepath.Join(opts.outputDir, logFileName)
+	if err := writeLogsToFile(ctx, client, opts.invocationID, logPath); err != nil {
+		return err
+	}
+	log.Printf("Wrote build logs to %s", logPath)
+
+	downloaded := make(map[string]struct{})
+	pageToken := ""
+	for {
+		rsp, err := client.GetAction(ctx, &apipb.GetActionRequest{
+			Selector: &apipb.ActionSelector{
+				InvocationId: opts.invocationID,
+				TargetLabel:  opts.targetLabel,
+			},
+			PageToken: pageToken,
+		})
+		if err != nil {
+			return err
+		}
+		for _, action := range rsp.GetAction() {
+			for _, file := range action.GetFile() {
+				path, err := outputPath(opts.outputDir, action.GetTargetLabel(), file.GetName())
+				if err != nil {
+					return err
+				}
+				if _, ok := downloaded[path]; ok {
+					continue
+				}
+				downloaded[path] = struct{}{}
+				if err := downloadFile(ctx, client, file.GetUri(), path); err != nil {
+					return status.UnavailableErrorf("failed to download %q of target %q: %s", file.GetName(), action.GetTargetLabel(), err)
+				}
+			}
+		}
+		if rsp.GetNextPageToken() == "" || rsp.GetNextPageToken() == pageToken {
+			break
+		}
+		pageToken = rsp.GetNextPageToken()
+	}
+	log.Printf("Downloaded %d files to %s", len(downloaded), opts.outputDir)
+	return nil
+}
+
+// outputPath returns the local path that a file produced by the given target
+// is written to. For example, the test.log of //foo/bar:baz is written to
+// <outputDir>/targets/foo/bar/baz/test.log.
+func outputPath(outputDir, targetLabel, fileName string) (string, error) {
+	label := strings.TrimLeft(targetLabel, "@/")
+	label = strings.ReplaceAll(label, ":", "/")
+	targetDir := filepath.Join(outputDir, targetsDir, filepath.FromSlash(label))
+	path := filepath.Join(targetDir, filepath.FromSlash(fileName))
+	// Guard against names that would escape the output directory.
+	if rel, err := filepath.Rel(targetDir, path); err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", status.InvalidArgumentErrorf("invalid file name %q for target %q", fileName, targetLabel)
+	}
+	return path, nil
+}
+
+func downloadFile(ctx context.Context, client apipb.ApiServiceClient, uri, path string) error {
+	if uri == "" {
+		return fmt.Errorf("file has no URI")
+	}
+	stream, err := client.GetFile(ctx, &apipb.GetFileRequest{Uri: uri})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	for {
+		rsp, err := stream.Recv()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+		if _, err := f.Write(rsp.GetData()); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	return f.Close()
+}
@@ -101,6 +101,21 @@ func configRemoteEndpoints(ctx context.Context) (*remoteEndpoints, error) {
 	}
 }
 
+// BuildBuddyEndpoint returns the gRPC endpoint of the BuildBuddy environment
+// selected with --env.
+func BuildBuddyEndpoint(ctx context.Context) (string, error) {
+	endpoints, err := configRemoteEndpoints(ctx)
+	if err != nil {
+		return "", err
+	}
+	return endpoints.grpcURL, nil
+}
+
+// APIKey returns the API key passed with --api_key.
+func APIKey() string {
+	return *apiKey
+}
+
 func Configure(ctx context.Context, bazelFlags *commandline.BazelFlags) (*BazelOpts, error) {
 	endpoints, err := configRemoteEndpoints(ctx)
 	if err != nil {
@@ -12,10 +12,12 @@ import (
 
 	"github.com/bazelbuild/bazelisk/core"
 	"github.com/bazelbuild/bazelisk/repositories"
+	"github.com/buildbuddy-io/buildbuddy/cli/artifacts"
 	"github.com/buildbuddy-io/buildbuddy/cli/autoconfig"
 	"github.com/buildbuddy-io/buildbuddy/cli/commandline"
 	"github.com/buildbuddy-io/buildbuddy/cli/parser"
 	"github.com/buildbuddy-io/buildbuddy/cli/remotebazel"
+	"github.com/buildbuddy-io/buildbuddy/cli/repro"
 	"github.com/buildbuddy-io/buildbuddy/cli/sidecar"
 	"github.com/buildbuddy-io/buildbuddy/server/util/grpc_client"
 	"github.com/buildbuddy-io/buildbuddy/server/version"
@@ -134,6 +136,17 @@ func main() {
 
 	ctx := context.Background()
 
+	// Handle commands that inspect past invocations and actions rather than
+	// run bazel.
+	if artifacts.IsCommand(bazelArgs.Filtered) {
+		exitCode, err := artifacts.Run(ctx, bazelArgs.Filtered)
+		die(exitCode, err)
+	}
+	if repro.IsCommand(bazelArgs.Filtered) {
+		exitCode, err := repro.Run(ctx, bazelArgs.Filtered)
+		die(exitCode, err)
+	}
+
 	if *disable {
 		bblog.Printf("Buildbuddy was disabled, just running bazel.")
 		runBazelAndDie(ctx, bazelArgs, &autoconfig.BazelOpts{})
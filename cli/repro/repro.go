@@ -0,0 +1,312 @@
+package repro
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/buildbuddy-io/buildbuddy/cli/autoconfig"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/remote_execution/dirtools"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/remote_execution/operation"
+	"github.com/buildbuddy-io/buildbuddy/server/environment"
+	"github.com/buildbuddy-io/buildbuddy/server/real_environment"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/cachetools"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/digest"
+	"github.com/buildbuddy-io/buildbuddy/server/util/grpc_client"
+	"github.com/buildbuddy-io/buildbuddy/server/util/healthcheck"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"google.golang.org/grpc/metadata"
+
+	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
+	bspb "google.golang.org/genproto/googleapis/bytestream"
+	gstatus "google.golang.org/grpc/status"
+)
+
+const (
+	reproCommand     = "repro"
+	executionCommand = "execution"
+)
+
+// IsCommand returns whether the given (filtered) command line invokes the
+// repro command, rather than a bazel command.
+func IsCommand(args []string) bool {
+	return len(args) > 0 && (args[0] == reproCommand || args[0] == executionCommand)
+}
+
+type reproOpts struct {
+	actionDigest       *digest.ResourceName
+	inputRoot          string
+	runLocally         bool
+	resubmit           bool
+	skipInputDownload  bool
+	remoteInstanceName string
+}
+
+// parseArgs parses the arguments following the command. Flags may appear
+// before or after the action digest.
+func parseArgs(command string, args []string) (*reproOpts, error) {
+	fs := flag.NewFlagSet(command, flag.ContinueOnError)
+	remoteInstanceName := fs.String("remote_instance_name", "", "The remote instance name that the action was executed with.")
+	inputRoot := fs.String("input_root", "", "The local directory to materialize the action's input tree in. Defaults to a directory named after the action digest.")
+	runLocally := fs.Bool("run", false, "If set, run the action locally after materializing its inputs.")
+	resubmit := fs.Bool("resubmit", false, "If set, re-execute the action remotely via the Execution API, skipping the action cache.")
+	skipInputDownload := fs.Bool("skip_input_download", false, "If set, only print the action details without downloading its inputs.")
+
+	var positional []string
+	for {
+		if err := fs.Parse(args); err != nil {
+			return nil, status.InvalidArgumentErrorf("invalid arguments to %q: %s", command, err)
+		}
+		if fs.NArg() == 0 {
+			break
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+	if len(positional) != 1 {
+		return nil, status.InvalidArgumentErrorf("usage: bb %s <action-hash>/<action-size> [--remote_instance_name=<name>] [--input_root=<dir>] [--run] [--resubmit]", command)
+	}
+	d, err := digest.Parse(positional[0])
+	if err != nil {
+		return nil, err
+	}
+	opts := &reproOpts{
+		actionDigest:       digest.NewResourceName(d, *remoteInstanceName),
+		inputRoot:          *inputRoot,
+		runLocally:         *runLocally,
+		resubmit:           *resubmit,
+		skipInputDownload:  *skipInputDownload,
+		remoteInstanceName: *remoteInstanceName,
+	}
+	if opts.inputRoot == "" {
+		opts.inputRoot = "action-" + d.GetHash()
+	}
+	if opts.runLocally && opts.skipInputDownload {
+		return nil, status.InvalidArgumentError("--run requires the action's inputs, so it cannot be combined with --skip_input_download")
+	}
+	return opts, nil
+}
+
+// Run runs the `bb repro` command described by the given (filtered) command
+// line, and returns the exit code that the CLI should exit with.
+//
+// `bb repro <action-digest>` (or `bb execution <action-digest>`) fetches a
+// remote action's Command and cached ActionResult, prints them, and
+// materializes the action's input tree locally. With --run, the action is then
+// run locally, and with --resubmit it is re-executed remotely with
+// skip_cache_lookup set. When the action is run, the exit code of the action is
+// returned.
+func Run(ctx context.Context, args []string) (int, error) {
+	if !IsCommand(args) {
+		return 1, status.InvalidArgumentErrorf("unknown command %q", strings.Join(args, " "))
+	}
+	opts, err := parseArgs(args[0], args[1:])
+	if err != nil {
+		return 1, err
+	}
+
+	endpoint, err := autoconfig.BuildBuddyEndpoint(ctx)
+	if err != nil {
+		return 1, err
+	}
+	conn, err := grpc_client.DialTarget(endpoint)
+	if err != nil {
+		return 1, status.UnavailableErrorf("could not connect to BuildBuddy %q: %s", endpoint, err)
+	}
+	defer conn.Close()
+	if apiKey := autoconfig.APIKey(); apiKey != "" {
+		ctx = metadata.AppendToOutgoingContext(ctx, "x-buildbuddy-api-key", apiKey)
+	}
+
+	env := real_environment.NewRealEnv(healthcheck.NewHealthChecker("bb-repro"))
+	env.SetByteStreamClient(bspb.NewByteStreamClient(conn))
+	env.SetContentAddressableStorageClient(repb.NewContentAddressableStorageClient(conn))
+	env.SetActionCacheClient(repb.NewActionCacheClient(conn))
+	env.SetCapabilitiesClient(repb.NewCapabilitiesClient(conn))
+	env.SetRemoteExecutionClient(repb.NewExecutionClient(conn))
+
+	action, cmd, err := cachetools.GetActionAndCommand(ctx, env.GetByteStreamClient(), opts.actionDigest)
+	if err != nil {
+		return 1, err
+	}
+	describeAction(os.Stdout, opts.actionDigest, action, cmd)
+
+	ar, err := cachetools.GetActionResult(ctx, env.GetActionCacheClient(), opts.actionDigest)
+	if err == nil {
+		fmt.Println("Cached action result:")
+		describeActionResult(os.Stdout, ar)
+	} else if status.IsNotFoundError(err) {
+		fmt.Println("No cached action result.")
+	} else {
+		return 1, status.WrapError(err, "could not fetch action result")
+	}
+
+	if !opts.skipInputDownload {
+		if err := materializeInputs(ctx, env, opts, action, cmd); err != nil {
+			return 1, err
+		}
+	}
+
+	exitCode := 0
+	if opts.runLocally {
+		exitCode, err = runLocally(ctx, opts.inputRoot, cmd)
+		if err != nil {
+			return 1, err
+		}
+	}
+	if opts.resubmit {
+		exitCode, err = resubmit(ctx, env, opts.actionDigest)
+		if err != nil {
+			return 1, err
+		}
+	}
+	return exitCode, nil
+}
+
+func describeAction(w io.Writer, actionDigest *digest.ResourceName, action *repb.Action, cmd *repb.Command) {
+	fmt.Fprintf(w, "Action: %s/%d\n", actionDigest.GetDigest().GetHash(), actionDigest.GetDigest().GetSizeBytes())
+	fmt.Fprintf(w, "Command: %s/%d\n", action.GetCommandDigest().GetHash(), action.GetCommandDigest().GetSizeBytes())
+	fmt.Fprintf(w, "Input root: %s/%d\n", action.GetInputRootDigest().GetHash(), action.GetInputRootDigest().GetSizeBytes())
+	if action.GetTimeout() != nil {
+		fmt.Fprintf(w, "Timeout: %s\n", action.GetTimeout().AsDuration())
+	}
+	fmt.Fprintf(w, "Do not cache: %t\n", action.GetDoNotCache())
+	fmt.Fprintf(w, "Arguments:\n")
+	for _, arg := range cmd.GetArguments() {
+		fmt.Fprintf(w, "  %s\n", arg)
+	}
+	if cmd.GetWorkingDirectory() != "" {
+		fmt.Fprintf(w, "Working directory: %s\n", cmd.GetWorkingDirectory())
+	}
+	fmt.Fprintf(w, "Environment:\n")
+	for _, ev := range cmd.GetEnvironmentVariables() {
+		fmt.Fprintf(w, "  %s=%s\n", ev.GetName(), ev.GetValue())
+	}
+	fmt.Fprintf(w, "Platform properties:\n")
+	for _, p := range cmd.GetPlatform().GetProperties() {
+		fmt.Fprintf(w, "  %s=%s\n", p.GetName(), p.GetValue())
+	}
+	fmt.Fprintf(w, "Output files:\n")
+	for _, p := range cmd.GetOutputFiles() {
+		fmt.Fprintf(w, "  %s\n", p)
+	}
+	fmt.Fprintf(w, "Output directories:\n")
+	for _, p := range cmd.GetOutputDirectories() {
+		fmt.Fprintf(w, "  %s\n", p)
+	}
+}
+
+func describeActionResult(w io.Writer, ar *repb.ActionResult) {
+	fmt.Fprintf(w, "  Exit code: %d\n", ar.GetExitCode())
+	for _, f := range ar.GetOutputFiles() {
+		fmt.Fprintf(w, "  Output file: %s (%s/%d)\n", f.GetPath(), f.GetDigest().GetHash(), f.GetDigest().GetSizeBytes())
+	}
+	for _, d := range ar.GetOutputDirectories() {
+		fmt.Fprintf(w, "  Output directory: %s (%s/%d)\n", d.GetPath(), d.GetTreeDigest().GetHash(), d.GetTreeDigest().GetSizeBytes())
+	}
+	if d := ar.GetStdoutDigest(); d != nil {
+		fmt.Fprintf(w, "  Stdout: %s/%d\n", d.GetHash(), d.GetSizeBytes())
+	}
+	if d := ar.GetStderrDigest(); d != nil {
+		fmt.Fprintf(w, "  Stderr: %s/%d\n", d.GetHash(), d.GetSizeBytes())
+	}
+	md := ar.GetExecutionMetadata()
+	if md.GetWorker() != "" {
+		fmt.Fprintf(w, "  Worker: %s\n", md.GetWorker())
+	}
+}
+
+func materializeInputs(ctx context.Context, env environment.Env, opts *reproOpts, action *repb.Action, cmd *repb.Command) error {
+	if err := os.MkdirAll(opts.inputRoot, 0755); err != nil {
+		return err
+	}
+	rootDigest := digest.NewResourceName(action.GetInputRootDigest(), opts.remoteInstanceName)
+	tree, err := cachetools.GetTreeFromRootDirectoryDigest(ctx, env.GetContentAddressableStorageClient(), rootDigest)
+	if err != nil {
+		return status.WrapError(err, "could not fetch input tree")
+	}
+	txInfo, err := dirtools.DownloadTree(ctx, env, opts.remoteInstanceName, tree, opts.inputRoot, &dirtools.DownloadTreeOpts{})
+	if err != nil {
+		return status.WrapError(err, "could not download inputs")
+	}
+
+	// Actions expect the parent directories of their outputs to exist.
+	dirHelper := dirtools.NewDirHelper(filepath.Join(opts.inputRoot, cmd.GetWorkingDirectory()), cmd.GetOutputFiles(), cmd.GetOutputDirectories(), 0755)
+	if err := dirHelper.CreateOutputDirs(); err != nil {
+		return err
+	}
+	fmt.Printf("Downloaded %d input files (%d bytes) to %s\n", txInfo.FileCount, txInfo.BytesTransferred, opts.inputRoot)
+	return nil
+}
+
+// runLocally runs the command in the materialized input root, with only the
+// environment variables specified by the command, and returns its exit code.
+func runLocally(ctx context.Context, inputRoot string, cmd *repb.Command) (int, error) {
+	if len(cmd.GetArguments()) == 0 {
+		return 1, status.FailedPreconditionError("command has no arguments")
+	}
+	args := cmd.GetArguments()
+	c := exec.CommandContext(ctx, args[0], args[1:]...)
+	c.Dir = filepath.Join(inputRoot, cmd.GetWorkingDirectory())
+	c.Env = []string{}
+	for _, ev := range cmd.GetEnvironmentVariables() {
+		c.Env = append(c.Env, ev.GetName()+"="+ev.GetValue())
+	}
+	c.Stdout = os.Stdout
+	c.Stderr = os.Stderr
+	fmt.Printf("Running action locally in %s\n", c.Dir)
+	err := c.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		fmt.Printf("Local run exited with code %d\n", e.ExitCode())
+		return e.ExitCode(), nil
+	}
+	if err != nil {
+		return 1, err
+	}
+	fmt.Println("Local run exited with code 0")
+	return 0, nil
+}
+
+// resubmit re-executes the action remotely, bypassing the action cache, and
+// returns the exit code of the remote run.
+func resubmit(ctx context.Context, env environment.Env, actionDigest *digest.ResourceName) (int, error) {
+	stream, err := env.GetRemoteExecutionClient().Execute(ctx, &repb.ExecuteRequest{
+		InstanceName:    actionDigest.GetInstanceName(),
+		ActionDigest:    actionDigest.GetDigest(),
+		SkipCacheLookup: true,
+	})
+	if err != nil {
+		return 1, err
+	}
+	fmt.Println("Re-executing action remotely...")
+	for {
+		op, err := stream.Recv()
+		if err == io.EOF {
+			return 1, status.UnavailableError("execution stream ended before the action completed")
+		}
+		if err != nil {
+			return 1, err
+		}
+		if op.GetName() != "" {
+			fmt.Printf("Operation: %s (stage: %s)\n", op.GetName(), operation.ExtractStage(op))
+		}
+		if !op.GetDone() {
+			continue
+		}
+		rsp := operation.ExtractExecuteResponse(op)
+		if rsp == nil {
+			return 1, status.InternalError("execution completed without a response")
+		}
+		if err := gstatus.ErrorProto(rsp.GetStatus()); err != nil {
+			return 1, status.WrapError(err, "remote execution failed")
+		}
+		fmt.Println("Remote action result:")
+		describeActionResult(os.Stdout, rsp.GetResult())
+		return int(rsp.GetResult().GetExitCode()), nil
+	}
+}
@@ -14,6 +14,7 @@ import TextInput from "../../../app/components/input/input";
 import Spinner from "../../../app/components/spinner/spinner";
 import Modal from "../../../app/components/modal/modal";
 import errorService from "../../../app/errors/error_service";
+import format from "../../../app/format/format";
 import rpcService from "../../../app/service/rpc_service";
 import { BuildBuddyError } from "../../../app/util/errors";
 import { api_key } from "../../../proto/api_key_ts_proto";
@@ -145,6 +146,7 @@ export default class ApiKeysComponent extends React.Component<ApiKeysComponentPr
           label: apiKey.label,
           capability: [...apiKey.capability],
           visibleToDevelopers: apiKey.visibleToDevelopers,
+          expiryUsec: apiKey.expiryUsec,
         }),
       },
     });
@@ -184,6 +186,19 @@ export default class ApiKeysComponent extends React.Component<ApiKeysComponentPr
     await this.fetchApiKeys();
   }
 
+  // Rotate
+
+  private async onClickRotate(apiKey: api_key.ApiKey) {
+    try {
+      await rpcService.service.rotateApiKey(new api_key.RotateApiKeyRequest({ id: apiKey.id }));
+    } catch (e) {
+      errorService.handleError(e);
+      return;
+    }
+
+    await this.fetchApiKeys();
+  }
+
   // Delete modal
 
   private onClickDelete(keyToDelete: api_key.ApiKey) {
@@ -276,6 +291,14 @@ export default class ApiKeysComponent extends React.Component<ApiKeysComponentPr
     );
   }
 
+  private onChangeOrgAdmin<T extends ApiKeyFields>(
+    request: T,
+    onChange: (name: string, value: any) => any,
+    e: React.ChangeEvent<HTMLInputElement>
+  ) {
+    this.onChangeCapability(request, api_key.ApiKey.Capability.ORG_ADMIN_CAPABILITY, e.target.checked, onChange);
+  }
+
   private renderModal<T extends ApiKeyFields>({
     title,
     submitLabel,
@@ -358,6 +381,18 @@ export default class ApiKeysComponent extends React.Component<ApiKeysComponentPr
                   </label>
                 </div>
               )}
+              <div className="field-container">
+                <label className="checkbox-row">
+                  <input
+                    type="checkbox"
+                    onChange={this.onChangeOrgAdmin.bind(this, request, onChange)}
+                    checked={hasCapability(request, api_key.ApiKey.Capability.ORG_ADMIN_CAPABILITY)}
+                  />
+                  <span>
+                    Org admin key <span className="field-description">(for SCIM user provisioning)</span>
+                  </span>
+                </label>
+              </div>
               <div className="field-container">
                 <label className="checkbox-row">
                   <input
@@ -452,7 +487,7 @@ export default class ApiKeysComponent extends React.Component<ApiKeysComponentPr
               <div className="api-key-capabilities">
                 <span>{describeCapabilities(key)}</span>
               </div>
-              <div className="api-key-value">
+              <div className="api-key-value" title={describeUsage(key)}>
                 <Key className="icon" />
                 <span>{key.value}</span>
               </div>
@@ -461,6 +496,13 @@ export default class ApiKeysComponent extends React.Component<ApiKeysComponentPr
                   Edit
                 </OutlinedButton>
               )}
+              {this.props.user.canCall("rotateApiKey") && (
+                <OutlinedButton
+                  title="Create a replacement key. This key will stop working after 24 hours."
+                  onClick={this.onClickRotate.bind(this, key)}>
+                  Rotate
+                </OutlinedButton>
+              )}
               {this.props.user.canCall("deleteApiKey") && (
                 <OutlinedButton onClick={this.onClickDelete.bind(this, key)} className="destructive">
                   Delete
@@ -548,8 +590,25 @@ function describeCapabilities<T extends ApiKeyFields>(apiKey: T | null) {
   if (hasCapability(apiKey, api_key.ApiKey.Capability.REGISTER_EXECUTOR_CAPABILITY)) {
     capabilities += "+Executor";
   }
+  if (hasCapability(apiKey, api_key.ApiKey.Capability.ORG_ADMIN_CAPABILITY)) {
+    capabilities += "+Admin";
+  }
   if (isVisibleToDevelopers(apiKey)) {
     capabilities += " [D]";
   }
   return capabilities;
 }
+
+function describeUsage(apiKey: api_key.ApiKey) {
+  const lines: string[] = [];
+  if (Number(apiKey.expiryUsec)) {
+    lines.push(`Expires ${format.formatTimestampUsec(apiKey.expiryUsec)}`);
+  }
+  if (Number(apiKey.lastUsedUsec)) {
+    const ip = apiKey.lastUsedIp || "unknown IP";
+    lines.push(`Last used ${format.formatTimestampUsec(apiKey.lastUsedUsec)} from ${ip}`);
+  } else {
+    lines.push("Never used");
+  }
+  return lines.join("\n");
+}
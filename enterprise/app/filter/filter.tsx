@@ -267,6 +267,9 @@ export default class FilterComponent extends React.Component<FilterProps, State>
             {selectedRoles.has("") && <span className="role-badge DEFAULT">Default</span>}
             {selectedRoles.has("CI") && <span className="role-badge CI">CI</span>}
             {selectedRoles.has("CI_RUNNER") && <span className="role-badge CI_RUNNER">Workflow</span>}
+            {selectedRoles.has("CI_RUNNER_SCHEDULED") && (
+              <span className="role-badge CI_RUNNER_SCHEDULED">Scheduled workflow</span>
+            )}
             {userValue && (
               <span className="advanced-badge">
                 <User /> {userValue}
@@ -304,6 +307,7 @@ export default class FilterComponent extends React.Component<FilterProps, State>
                   {this.renderRoleCheckbox("Default", "", selectedRoles)}
                   {this.renderRoleCheckbox("CI", "CI", selectedRoles)}
                   {this.renderRoleCheckbox("Workflow", "CI_RUNNER", selectedRoles)}
+                  {this.renderRoleCheckbox("Scheduled workflow", "CI_RUNNER_SCHEDULED", selectedRoles)}
                 </div>
               </div>
               <div className="option-group">
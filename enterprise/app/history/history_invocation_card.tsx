@@ -146,7 +146,7 @@ export default class HistoryInvocationCardComponent extends React.Component<Prop
   }
 
   getTitle() {
-    if (this.props.invocation.role === "CI_RUNNER") {
+    if (this.props.invocation.role === "CI_RUNNER" || this.props.invocation.role === "CI_RUNNER_SCHEDULED") {
       return this.getTitleForWorkflow();
     }
 
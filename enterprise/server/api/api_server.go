@@ -17,6 +17,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/tables"
 	"github.com/buildbuddy-io/buildbuddy/server/util/capabilities"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
+	"github.com/buildbuddy-io/buildbuddy/server/util/paging"
 	"github.com/buildbuddy-io/buildbuddy/server/util/perms"
 	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
 	"github.com/buildbuddy-io/buildbuddy/server/util/query_builder"
@@ -29,6 +30,19 @@ import (
 	apipb "github.com/buildbuddy-io/buildbuddy/proto/api/v1"
 	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
 	elpb "github.com/buildbuddy-io/buildbuddy/proto/eventlog"
+	espb "github.com/buildbuddy-io/buildbuddy/proto/execution_stats"
+	inpb "github.com/buildbuddy-io/buildbuddy/proto/invocation"
+	pgpb "github.com/buildbuddy-io/buildbuddy/proto/pagination"
+)
+
+const (
+	// maxSearchPageSize is the maximum number of invocations returned by a
+	// single SearchInvocation request.
+	maxSearchPageSize = 1000
+
+	// executionPageSize is the number of executions returned by a single
+	// GetExecution request.
+	executionPageSize = 1000
 )
 
 var (
@@ -89,7 +103,7 @@ func (s *APIServer) GetInvocation(ctx context.Context, req *apipb.GetInvocationR
 	if req.GetSelector().GetCommitSha() != "" {
 		q = q.AddWhereClause(`commit_sha = ?`, req.GetSelector().GetCommitSha())
 	}
-	if err := perms.AddPermissionsCheckToQuery(ctx, s.env, q); err != nil {
+	if err := perms.AddInvocationPermissionsCheckToQueryWithTableAlias(ctx, s.env, q, ""); err != nil {
 		return nil, err
 	}
 	queryStr, args := q.Build()
@@ -133,6 +147,89 @@ func (s *APIServer) GetInvocation(ctx context.Context, req *apipb.GetInvocationR
 	}, nil
 }
 
+func (s *APIServer) SearchInvocation(ctx context.Context, req *apipb.SearchInvocationRequest) (*apipb.SearchInvocationResponse, error) {
+	user, err := s.checkPreconditions(ctx)
+	if err != nil {
+		return nil, err
+	}
+	searcher := s.env.GetInvocationSearchService()
+	if searcher == nil {
+		return nil, status.UnimplementedError("Invocation search is not configured")
+	}
+	if req.GetPageSize() < 0 {
+		return nil, status.InvalidArgumentError("page_size must not be negative")
+	}
+	pageSize := req.GetPageSize()
+	if pageSize > maxSearchPageSize {
+		pageSize = maxSearchPageSize
+	}
+
+	q := req.GetQuery()
+	searchReq := &inpb.SearchInvocationRequest{
+		Query: &inpb.InvocationQuery{
+			// Only return invocations owned by the API key's group, even if
+			// other groups' invocations are public.
+			GroupId:       user.GetGroupID(),
+			RepoUrl:       q.GetRepoUrl(),
+			BranchName:    q.GetBranchName(),
+			CommitSha:     q.GetCommitSha(),
+			User:          q.GetUser(),
+			Host:          q.GetHost(),
+			Role:          q.GetRole(),
+			UpdatedAfter:  q.GetUpdatedAfter(),
+			UpdatedBefore: q.GetUpdatedBefore(),
+		},
+		Sort: &inpb.InvocationSort{
+			SortField: inpb.InvocationSort_UPDATED_AT_USEC_SORT_FIELD,
+		},
+		Count:     pageSize,
+		PageToken: req.GetPageToken(),
+	}
+	for _, st := range q.GetStatus() {
+		switch st {
+		case apipb.InvocationQuery_SUCCESS:
+			searchReq.Query.Status = append(searchReq.Query.Status, inpb.OverallStatus_SUCCESS)
+		case apipb.InvocationQuery_FAILURE:
+			searchReq.Query.Status = append(searchReq.Query.Status, inpb.OverallStatus_FAILURE)
+		case apipb.InvocationQuery_IN_PROGRESS:
+			searchReq.Query.Status = append(searchReq.Query.Status, inpb.OverallStatus_IN_PROGRESS)
+		case apipb.InvocationQuery_DISCONNECTED:
+			searchReq.Query.Status = append(searchReq.Query.Status, inpb.OverallStatus_DISCONNECTED)
+		default:
+			return nil, status.InvalidArgumentErrorf("Unknown invocation status %s", st)
+		}
+	}
+
+	searchRsp, err := searcher.QueryInvocations(ctx, searchReq)
+	if err != nil {
+		return nil, err
+	}
+	rsp := &apipb.SearchInvocationResponse{
+		NextPageToken: searchRsp.GetNextPageToken(),
+	}
+	for _, inv := range searchRsp.GetInvocation() {
+		rsp.Invocation = append(rsp.Invocation, &apipb.Invocation{
+			Id: &apipb.Invocation_Id{
+				InvocationId: inv.GetInvocationId(),
+			},
+			Success:       inv.GetSuccess(),
+			User:          inv.GetUser(),
+			DurationUsec:  inv.GetDurationUsec(),
+			Host:          inv.GetHost(),
+			Command:       inv.GetCommand(),
+			Pattern:       strings.Join(inv.GetPattern(), ", "),
+			ActionCount:   inv.GetActionCount(),
+			CreatedAtUsec: inv.GetCreatedAtUsec(),
+			UpdatedAtUsec: inv.GetUpdatedAtUsec(),
+			RepoUrl:       inv.GetRepoUrl(),
+			BranchName:    inv.GetBranchName(),
+			CommitSha:     inv.GetCommitSha(),
+			Role:          inv.GetRole(),
+		})
+	}
+	return rsp, nil
+}
+
 func (s *APIServer) redisCachedTarget(ctx context.Context, userInfo interfaces.UserInfo, iid, targetLabel string) (*apipb.Target, error) {
 	if !api_config.CacheEnabled() || s.env.GetMetricsCollector() == nil {
 		return nil, nil
@@ -290,6 +387,71 @@ func (s *APIServer) GetAction(ctx context.Context, req *apipb.GetActionRequest)
 	return rsp, nil
 }
 
+func (s *APIServer) GetExecution(ctx context.Context, req *apipb.GetExecutionRequest) (*apipb.GetExecutionResponse, error) {
+	if _, err := s.checkPreconditions(ctx); err != nil {
+		return nil, err
+	}
+	if req.GetSelector().GetInvocationId() == "" {
+		return nil, status.InvalidArgumentErrorf("ExecutionSelector must contain a valid invocation_id")
+	}
+	executionService := s.env.GetExecutionService()
+	if executionService == nil {
+		return nil, status.UnimplementedError("Remote execution is not configured")
+	}
+	offset := int64(0)
+	if req.GetPageToken() != "" {
+		token, err := paging.DecodeOffsetLimit(req.GetPageToken())
+		if err != nil {
+			return nil, err
+		}
+		if token.GetOffset() < 0 {
+			return nil, status.InvalidArgumentError("invalid page token")
+		}
+		offset = token.GetOffset()
+	}
+	iid := req.GetSelector().GetInvocationId()
+	rsp, err := executionService.GetExecution(ctx, &espb.GetExecutionRequest{
+		ExecutionLookup: &espb.ExecutionLookup{InvocationId: iid},
+		// Fetch one extra execution to find out whether there is another page.
+		Page: &pgpb.OffsetLimit{Offset: offset, Limit: executionPageSize + 1},
+	})
+	if err != nil {
+		return nil, err
+	}
+	page := rsp.GetExecution()
+	nextPageToken := ""
+	if len(page) > executionPageSize {
+		page = page[:executionPageSize]
+		nextPageToken, err = paging.EncodeOffsetLimit(&pgpb.OffsetLimit{
+			Offset: offset + executionPageSize,
+			Limit:  executionPageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	executions := []*apipb.Execution{}
+	for _, e := range page {
+		executions = append(executions, &apipb.Execution{
+			Id: &apipb.Execution_Id{
+				InvocationId: iid,
+				ExecutionId:  e.GetExecutionId(),
+			},
+			ActionDigest:           e.GetActionDigest(),
+			ActionResultDigest:     e.GetActionResultDigest(),
+			Stage:                  e.GetStage(),
+			Status:                 e.GetStatus(),
+			ExitCode:               e.GetExitCode(),
+			CommandSnippet:         e.GetCommandSnippet(),
+			ExecutedActionMetadata: e.GetExecutedActionMetadata(),
+		})
+	}
+	return &apipb.GetExecutionResponse{
+		Execution:     executions,
+		NextPageToken: nextPageToken,
+	}, nil
+}
+
 func (s *APIServer) GetLog(ctx context.Context, req *apipb.GetLogRequest) (*apipb.GetLogResponse, error) {
 	// No need for user here because user filters will be applied by LookupInvocation.
 	if _, err := s.checkPreconditions(ctx); err != nil {
@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"testing"
 
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/execution_service"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/invocation_search_service"
 	"github.com/buildbuddy-io/buildbuddy/proto/api_key"
 	"github.com/buildbuddy-io/buildbuddy/proto/build_event_stream"
 	"github.com/buildbuddy-io/buildbuddy/server/build_event_protocol/build_event_handler"
@@ -13,7 +15,10 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testauth"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testdigest"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
+	"github.com/buildbuddy-io/buildbuddy/server/util/paging"
+	"github.com/buildbuddy-io/buildbuddy/server/util/perms"
 	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/buildbuddy-io/buildbuddy/server/util/testing/flags"
 	"github.com/google/uuid"
@@ -23,7 +28,9 @@ import (
 
 	apipb "github.com/buildbuddy-io/buildbuddy/proto/api/v1"
 	bepb "github.com/buildbuddy-io/buildbuddy/proto/build_events"
+	pgpb "github.com/buildbuddy-io/buildbuddy/proto/pagination"
 	pepb "github.com/buildbuddy-io/buildbuddy/proto/publish_build_event"
+	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
 )
 
 var userMap = testauth.TestUsers("user1", "group1")
@@ -70,6 +77,97 @@ func TestGetInvocationAuth(t *testing.T) {
 	require.Nil(t, resp)
 }
 
+func TestSearchInvocation(t *testing.T) {
+	env, ctx := getEnvAndCtx(t, "user1")
+	env.SetInvocationSearchService(invocation_search_service.NewInvocationSearchService(env, env.GetDBHandle()))
+	iids := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		testUUID, err := uuid.NewRandom()
+		require.NoError(t, err)
+		iids[testUUID.String()] = true
+		streamBuild(t, env, testUUID.String())
+	}
+	s := NewAPIServer(env)
+
+	resp, err := s.SearchInvocation(ctx, &apipb.SearchInvocationRequest{
+		Query: &apipb.InvocationQuery{Status: []apipb.InvocationQuery_Status{apipb.InvocationQuery_SUCCESS}},
+	})
+	require.NoError(t, err)
+	found := map[string]bool{}
+	for _, inv := range resp.GetInvocation() {
+		found[inv.GetId().GetInvocationId()] = true
+	}
+	assert.Equal(t, iids, found)
+
+	// Page through the results one invocation at a time.
+	found = map[string]bool{}
+	pageToken := ""
+	for i := 0; i < 2; i++ {
+		resp, err := s.SearchInvocation(ctx, &apipb.SearchInvocationRequest{PageSize: 1, PageToken: pageToken})
+		require.NoError(t, err)
+		require.Equal(t, 1, len(resp.GetInvocation()))
+		found[resp.GetInvocation()[0].GetId().GetInvocationId()] = true
+		pageToken = resp.GetNextPageToken()
+	}
+	assert.Equal(t, iids, found)
+
+	resp, err = s.SearchInvocation(ctx, &apipb.SearchInvocationRequest{
+		Query: &apipb.InvocationQuery{Role: []string{"CI"}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(resp.GetInvocation()))
+
+	resp, err = s.SearchInvocation(ctx, &apipb.SearchInvocationRequest{
+		Query: &apipb.InvocationQuery{Status: []apipb.InvocationQuery_Status{apipb.InvocationQuery_FAILURE}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 0, len(resp.GetInvocation()))
+}
+
+func TestSearchInvocation_RequiresViewInvocations(t *testing.T) {
+	env, _ := getEnvAndCtx(t, "")
+	env.SetInvocationSearchService(invocation_search_service.NewInvocationSearchService(env, env.GetDBHandle()))
+	testUUID, err := uuid.NewRandom()
+	require.NoError(t, err)
+	streamBuild(t, env, testUUID.String())
+	s := NewAPIServer(env)
+
+	req := &apipb.SearchInvocationRequest{
+		Query: &apipb.InvocationQuery{Status: []apipb.InvocationQuery_Status{apipb.InvocationQuery_SUCCESS}},
+	}
+	for _, tc := range []struct {
+		caps     role.Capability
+		expected int
+	}{
+		{caps: role.ViewUsage, expected: 0},
+		{caps: role.ViewInvocations, expected: 1},
+	} {
+		ctx := testauth.WithAuthenticatedUserInfo(context.Background(), &testauth.TestUser{
+			UserID:        "user3",
+			GroupID:       "group1",
+			AllowedGroups: []string{"group1"},
+			GroupMemberships: []*interfaces.GroupMembership{
+				{GroupID: "group1", Role: role.Custom, CustomCapabilities: tc.caps},
+			},
+		})
+		resp, err := s.SearchInvocation(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, tc.expected, len(resp.GetInvocation()), "capabilities: %v", role.CapabilitiesToProto(tc.caps))
+	}
+}
+
+func TestSearchInvocationAuth(t *testing.T) {
+	env, ctx := getEnvAndCtx(t, "")
+	env.SetInvocationSearchService(invocation_search_service.NewInvocationSearchService(env, env.GetDBHandle()))
+	testUUID, err := uuid.NewRandom()
+	require.NoError(t, err)
+	streamBuild(t, env, testUUID.String())
+	s := NewAPIServer(env)
+	resp, err := s.SearchInvocation(ctx, &apipb.SearchInvocationRequest{})
+	require.Error(t, err)
+	require.Nil(t, resp)
+}
+
 func TestGetTarget(t *testing.T) {
 	testUUID, err := uuid.NewRandom()
 	require.NoError(t, err)
@@ -193,6 +291,109 @@ func TestGetActionAuth(t *testing.T) {
 	require.Nil(t, resp)
 }
 
+func TestGetExecution(t *testing.T) {
+	testUUID, err := uuid.NewRandom()
+	require.NoError(t, err)
+	testInvocationID := testUUID.String()
+
+	env, ctx := getEnvAndCtx(t, "user1")
+	env.SetExecutionService(execution_service.NewExecutionService(env))
+	streamBuild(t, env, testInvocationID)
+	executionID := "blobs/1111111111111111111111111111111111111111111111111111111111111111/100"
+	err = env.GetDBHandle().DB(ctx).Create(&tables.Execution{
+		ExecutionID:                     executionID,
+		InvocationID:                    testInvocationID,
+		GroupID:                         "group1",
+		Perms:                           perms.GROUP_READ,
+		Stage:                           int64(repb.ExecutionStage_COMPLETED),
+		Worker:                          "worker1",
+		ExecutorID:                      "executor1",
+		ExitCode:                        1,
+		FileDownloadCount:               3,
+		ExecutionStartTimestampUsec:     1_000_000,
+		ExecutionCompletedTimestampUsec: 3_000_000,
+	}).Error
+	require.NoError(t, err)
+
+	s := NewAPIServer(env)
+	resp, err := s.GetExecution(ctx, &apipb.GetExecutionRequest{Selector: &apipb.ExecutionSelector{InvocationId: testInvocationID}})
+	require.NoError(t, err)
+	require.Equal(t, 1, len(resp.GetExecution()))
+	e := resp.GetExecution()[0]
+	assert.Equal(t, testInvocationID, e.GetId().GetInvocationId())
+	assert.Equal(t, executionID, e.GetId().GetExecutionId())
+	assert.Equal(t, "1111111111111111111111111111111111111111111111111111111111111111", e.GetActionDigest().GetHash())
+	assert.Equal(t, repb.ExecutionStage_COMPLETED, e.GetStage())
+	assert.Equal(t, int32(1), e.GetExitCode())
+	md := e.GetExecutedActionMetadata()
+	assert.Equal(t, "worker1", md.GetWorker())
+	assert.Equal(t, "executor1", md.GetExecutorId())
+	assert.Equal(t, int64(3), md.GetIoStats().GetFileDownloadCount())
+	assert.Equal(t, int64(2_000_000), md.GetExecutionCompletedTimestamp().AsTime().Sub(md.GetExecutionStartTimestamp().AsTime()).Microseconds())
+}
+
+func TestGetExecutionPagination(t *testing.T) {
+	testUUID, err := uuid.NewRandom()
+	require.NoError(t, err)
+	testInvocationID := testUUID.String()
+
+	env, ctx := getEnvAndCtx(t, "user1")
+	env.SetExecutionService(execution_service.NewExecutionService(env))
+	streamBuild(t, env, testInvocationID)
+	const numExecutions = executionPageSize + 1
+	executions := make([]*tables.Execution, 0, numExecutions)
+	for i := 0; i < numExecutions; i++ {
+		executions = append(executions, &tables.Execution{
+			ExecutionID:  fmt.Sprintf("blobs/1111111111111111111111111111111111111111111111111111111111111111/%d", i),
+			InvocationID: testInvocationID,
+			GroupID:      "group1",
+			Perms:        perms.GROUP_READ,
+		})
+	}
+	err = env.GetDBHandle().DB(ctx).CreateInBatches(executions, 100).Error
+	require.NoError(t, err)
+
+	s := NewAPIServer(env)
+	seen := map[string]bool{}
+	req := &apipb.GetExecutionRequest{Selector: &apipb.ExecutionSelector{InvocationId: testInvocationID}}
+	pages := 0
+	for {
+		resp, err := s.GetExecution(ctx, req)
+		require.NoError(t, err)
+		pages++
+		for _, e := range resp.GetExecution() {
+			require.False(t, seen[e.GetId().GetExecutionId()], "execution %s returned twice", e.GetId().GetExecutionId())
+			seen[e.GetId().GetExecutionId()] = true
+		}
+		if resp.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = resp.GetNextPageToken()
+	}
+	require.Equal(t, 2, pages)
+	require.Len(t, seen, numExecutions)
+
+	pageToken, err := paging.EncodeOffsetLimit(&pgpb.OffsetLimit{Offset: -1, Limit: executionPageSize})
+	require.NoError(t, err)
+	req.PageToken = pageToken
+	_, err = s.GetExecution(ctx, req)
+	require.True(t, status.IsInvalidArgumentError(err), "expected InvalidArgument, got %s", err)
+}
+
+func TestGetExecutionAuth(t *testing.T) {
+	testUUID, err := uuid.NewRandom()
+	require.NoError(t, err)
+	testInvocationID := testUUID.String()
+
+	env, ctx := getEnvAndCtx(t, "")
+	env.SetExecutionService(execution_service.NewExecutionService(env))
+	streamBuild(t, env, testInvocationID)
+	s := NewAPIServer(env)
+	resp, err := s.GetExecution(ctx, &apipb.GetExecutionRequest{Selector: &apipb.ExecutionSelector{InvocationId: testInvocationID}})
+	require.Error(t, err)
+	require.Nil(t, resp)
+}
+
 func TestLog(t *testing.T) {
 	testUUID, err := uuid.NewRandom()
 	assert.NoError(t, err)
@@ -0,0 +1,217 @@
+package auditlog
+
+import (
+	"context"
+	"flag"
+	"time"
+
+	"github.com/buildbuddy-io/buildbuddy/server/environment"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/util/clientip"
+	"github.com/buildbuddy-io/buildbuddy/server/util/log"
+	"github.com/buildbuddy-io/buildbuddy/server/util/paging"
+	"github.com/buildbuddy-io/buildbuddy/server/util/perms"
+	"github.com/buildbuddy-io/buildbuddy/server/util/query_builder"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	alpb "github.com/buildbuddy-io/buildbuddy/proto/auditlog"
+	pgpb "github.com/buildbuddy-io/buildbuddy/proto/pagination"
+)
+
+var (
+	enabled = flag.Bool("app.audit_logs_enabled", false, "If true, administrative actions on group resources such as API keys and memberships are recorded in an audit log. ** Enterprise only **")
+)
+
+const (
+	// pageSize is the number of entries returned per GetLogs page.
+	pageSize = 100
+)
+
+type Logger struct {
+	env environment.Env
+	h   interfaces.DBHandle
+}
+
+// Register registers the audit logger if audit logs are enabled.
+func Register(env environment.Env) error {
+	if !*enabled {
+		return nil
+	}
+	if env.GetDBHandle() == nil {
+		return status.FailedPreconditionError("audit logs require a database to be configured")
+	}
+	env.SetAuditLogger(New(env, env.GetDBHandle()))
+	return nil
+}
+
+func New(env environment.Env, h interfaces.DBHandle) *Logger {
+	return &Logger{env: env, h: h}
+}
+
+func marshalState(m proto.Message) (string, error) {
+	if m == nil || !m.ProtoReflect().IsValid() {
+		return "", nil
+	}
+	b, err := protojson.Marshal(m)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func requestMetadata(ctx context.Context) *alpb.RequestMetadata {
+	rmd := &alpb.RequestMetadata{ClientIp: clientip.Get(ctx)}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if vals := md.Get("user-agent"); len(vals) > 0 {
+			rmd.UserAgent = vals[0]
+		}
+	}
+	return rmd
+}
+
+func (l *Logger) actor(ctx context.Context) *alpb.Actor {
+	actor := &alpb.Actor{}
+	u, err := perms.AuthenticatedUser(ctx, l.env)
+	if err != nil {
+		return actor
+	}
+	actor.UserId = u.GetUserID()
+	actor.Impersonating = u.IsImpersonating()
+	if actor.UserId != "" {
+		row := &struct{ Email string }{}
+		err := l.h.DB(ctx).Raw(`SELECT email FROM Users WHERE user_id = ?`, actor.UserId).Take(row).Error
+		if err != nil {
+			log.Warningf("Could not look up email of user %q for audit log: %s", actor.UserId, err)
+		}
+		actor.UserEmail = row.Email
+	}
+	return actor
+}
+
+func (l *Logger) LogForGroup(ctx context.Context, groupID string, action alpb.Action, resource *alpb.ResourceID, before, after proto.Message) {
+	if err := l.logForGroup(ctx, groupID, action, resource, before, after); err != nil {
+		log.Warningf("Failed to write audit log entry for %s %s %q in group %q: %s", action, resource.GetType(), resource.GetId(), groupID, err)
+	}
+}
+
+func (l *Logger) logForGroup(ctx context.Context, groupID string, action alpb.Action, resource *alpb.ResourceID, before, after proto.Message) error {
+	beforeJSON, err := marshalState(before)
+	if err != nil {
+		return err
+	}
+	afterJSON, err := marshalState(after)
+	if err != nil {
+		return err
+	}
+	pk, err := tables.PrimaryKeyForTable("AuditLogs")
+	if err != nil {
+		return err
+	}
+	actor := l.actor(ctx)
+	rmd := requestMetadata(ctx)
+	entry := &tables.AuditLog{
+		AuditLogID:        pk,
+		GroupID:           groupID,
+		EventTimeUsec:     time.Now().UnixMicro(),
+		AuthUserID:        actor.GetUserId(),
+		AuthUserEmail:     actor.GetUserEmail(),
+		AuthImpersonating: actor.GetImpersonating(),
+		ResourceType:      int32(resource.GetType()),
+		ResourceID:        resource.GetId(),
+		ResourceName:      resource.GetName(),
+		Action:            int32(action),
+		BeforeJSON:        beforeJSON,
+		AfterJSON:         afterJSON,
+		ClientIP:          rmd.GetClientIp(),
+		UserAgent:         rmd.GetUserAgent(),
+	}
+	return l.h.DB(ctx).Create(entry).Error
+}
+
+func entryToProto(e *tables.AuditLog) *alpb.Entry {
+	return &alpb.Entry{
+		EventTimeUsec: e.EventTimeUsec,
+		Actor: &alpb.Actor{
+			UserId:        e.AuthUserID,
+			UserEmail:     e.AuthUserEmail,
+			Impersonating: e.AuthImpersonating,
+		},
+		Resource: &alpb.ResourceID{
+			Type: alpb.ResourceType(e.ResourceType),
+			Id:   e.ResourceID,
+			Name: e.ResourceName,
+		},
+		Action:     alpb.Action(e.Action),
+		BeforeJson: e.BeforeJSON,
+		AfterJson:  e.AfterJSON,
+		RequestMetadata: &alpb.RequestMetadata{
+			ClientIp:  e.ClientIP,
+			UserAgent: e.UserAgent,
+		},
+	}
+}
+
+func (l *Logger) GetLogs(ctx context.Context, groupID string, req *alpb.GetAuditLogRequest) (*alpb.GetAuditLogResponse, error) {
+	if groupID == "" {
+		return nil, status.InvalidArgumentError("Group ID cannot be empty.")
+	}
+	offset := int64(0)
+	if req.GetPageToken() != "" {
+		token, err := paging.DecodeOffsetLimit(req.GetPageToken())
+		if err != nil {
+			return nil, err
+		}
+		if token.GetOffset() < 0 {
+			return nil, status.InvalidArgumentError("invalid page token")
+		}
+		offset = token.GetOffset()
+	}
+
+	q := query_builder.NewQuery(`SELECT * FROM AuditLogs`)
+	q.AddWhereClause("group_id = ?", groupID)
+	if req.GetStartTimeUsec() != 0 {
+		q.AddWhereClause("event_time_usec >= ?", req.GetStartTimeUsec())
+	}
+	if req.GetEndTimeUsec() != 0 {
+		q.AddWhereClause("event_time_usec < ?", req.GetEndTimeUsec())
+	}
+	if req.GetResourceType() != alpb.ResourceType_UNKNOWN_RESOURCE {
+		q.AddWhereClause("resource_type = ?", int32(req.GetResourceType()))
+	}
+	q.SetOrderBy("event_time_usec", false /*=ascending*/)
+	// Fetch one extra row to find out whether there is another page.
+	q.SetLimit(pageSize + 1)
+	q.SetOffset(offset)
+	qStr, qArgs := q.Build()
+
+	rows, err := l.h.DB(ctx).Raw(qStr, qArgs...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	rsp := &alpb.GetAuditLogResponse{}
+	for rows.Next() {
+		e := &tables.AuditLog{}
+		if err := l.h.DB(ctx).ScanRows(rows, e); err != nil {
+			return nil, err
+		}
+		rsp.Entry = append(rsp.Entry, entryToProto(e))
+	}
+	if len(rsp.Entry) > pageSize {
+		rsp.Entry = rsp.Entry[:pageSize]
+		next, err := paging.EncodeOffsetLimit(&pgpb.OffsetLimit{
+			Offset: offset + pageSize,
+			Limit:  pageSize,
+		})
+		if err != nil {
+			return nil, err
+		}
+		rsp.NextPageToken = next
+	}
+	return rsp, nil
+}
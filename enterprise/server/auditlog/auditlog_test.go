@@ -0,0 +1,104 @@
+package auditlog_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/auditlog"
+	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/testutil/testauth"
+	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
+	"github.com/buildbuddy-io/buildbuddy/server/util/paging"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+
+	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
+	alpb "github.com/buildbuddy-io/buildbuddy/proto/auditlog"
+	pgpb "github.com/buildbuddy-io/buildbuddy/proto/pagination"
+)
+
+func setup(t *testing.T) (*testenv.TestEnv, *auditlog.Logger, context.Context) {
+	te := testenv.GetTestEnv(t)
+	te.SetAuthenticator(testauth.NewTestAuthenticator(testauth.TestUsers("US1", "GR1", "US2", "GR2")))
+	err := te.GetDBHandle().DB(context.Background()).Create(&tables.User{UserID: "US1", Email: "user1@org1.io"}).Error
+	require.NoError(t, err)
+	ctx, err := te.GetAuthenticator().(*testauth.TestAuthenticator).WithAuthenticatedUser(context.Background(), "US1")
+	require.NoError(t, err)
+	return te, auditlog.New(te, te.GetDBHandle()), ctx
+}
+
+func TestLogForGroup(t *testing.T) {
+	_, l, ctx := setup(t)
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("X-Forwarded-For", "10.0.0.1, 1.2.3.4", "user-agent", "test-agent"))
+
+	key := &alpb.ResourceID{Type: alpb.ResourceType_GROUP_API_KEY, Id: "AK1", Name: "CI"}
+	l.LogForGroup(ctx, "GR1", alpb.Action_CREATE, key, nil, &akpb.ApiKey{Id: "AK1", Label: "CI"})
+	l.LogForGroup(ctx, "GR1", alpb.Action_UPDATE, key, &akpb.ApiKey{Id: "AK1", Label: "CI"}, &akpb.ApiKey{Id: "AK1", Label: "CI", VisibleToDevelopers: true})
+	l.LogForGroup(ctx, "GR1", alpb.Action_DELETE, &alpb.ResourceID{Type: alpb.ResourceType_INVOCATION, Id: "IN1"}, nil, nil)
+	l.LogForGroup(ctx, "GR2", alpb.Action_DELETE, &alpb.ResourceID{Type: alpb.ResourceType_INVOCATION, Id: "IN2"}, nil, nil)
+
+	rsp, err := l.GetLogs(ctx, "GR1", &alpb.GetAuditLogRequest{})
+	require.NoError(t, err)
+	require.Len(t, rsp.GetEntry(), 3)
+	require.Empty(t, rsp.GetNextPageToken())
+
+	// Entries are returned most recent first.
+	require.Equal(t, "IN1", rsp.GetEntry()[0].GetResource().GetId())
+	update := rsp.GetEntry()[1]
+	require.Equal(t, alpb.Action_UPDATE, update.GetAction())
+	require.Equal(t, "CI", update.GetResource().GetName())
+	require.Equal(t, "US1", update.GetActor().GetUserId())
+	require.Equal(t, "user1@org1.io", update.GetActor().GetUserEmail())
+	require.Equal(t, "1.2.3.4", update.GetRequestMetadata().GetClientIp())
+	require.Equal(t, "test-agent", update.GetRequestMetadata().GetUserAgent())
+	require.JSONEq(t, `{"id": "AK1", "label": "CI"}`, update.GetBeforeJson())
+	require.JSONEq(t, `{"id": "AK1", "label": "CI", "visibleToDevelopers": true}`, update.GetAfterJson())
+	create := rsp.GetEntry()[2]
+	require.Equal(t, alpb.Action_CREATE, create.GetAction())
+	require.Empty(t, create.GetBeforeJson())
+
+	rsp, err = l.GetLogs(ctx, "GR1", &alpb.GetAuditLogRequest{ResourceType: alpb.ResourceType_INVOCATION})
+	require.NoError(t, err)
+	require.Len(t, rsp.GetEntry(), 1)
+	require.Equal(t, "IN1", rsp.GetEntry()[0].GetResource().GetId())
+}
+
+func TestGetLogsPagination(t *testing.T) {
+	_, l, ctx := setup(t)
+
+	const numEntries = 150
+	for i := 0; i < numEntries; i++ {
+		resource := &alpb.ResourceID{Type: alpb.ResourceType_INVOCATION, Id: fmt.Sprintf("IN%d", i)}
+		l.LogForGroup(ctx, "GR1", alpb.Action_DELETE, resource, nil, nil)
+	}
+
+	seen := map[string]bool{}
+	req := &alpb.GetAuditLogRequest{}
+	pages := 0
+	for {
+		rsp, err := l.GetLogs(ctx, "GR1", req)
+		require.NoError(t, err)
+		pages++
+		for _, e := range rsp.GetEntry() {
+			require.False(t, seen[e.GetResource().GetId()], "entry %s returned twice", e.GetResource().GetId())
+			seen[e.GetResource().GetId()] = true
+		}
+		if rsp.GetNextPageToken() == "" {
+			break
+		}
+		req.PageToken = rsp.GetNextPageToken()
+	}
+	require.Equal(t, 2, pages)
+	require.Len(t, seen, numEntries)
+}
+
+func TestGetLogsRejectsNegativeOffset(t *testing.T) {
+	_, l, ctx := setup(t)
+
+	token, err := paging.EncodeOffsetLimit(&pgpb.OffsetLimit{Offset: -1})
+	require.NoError(t, err)
+	_, err = l.GetLogs(ctx, "GR1", &alpb.GetAuditLogRequest{PageToken: token})
+	require.True(t, status.IsInvalidArgumentError(err), "expected InvalidArgument, got %v", err)
+}
@@ -397,8 +397,13 @@ func (c *apiKeyGroupCache) Get(apiKey string) (akg interfaces.APIKeyGroup, ok bo
 }
 
 func (c *apiKeyGroupCache) Add(apiKey string, apiKeyGroup interfaces.APIKeyGroup) {
+	expiresAfter := time.Now().Add(c.ttl)
+	// Don't keep accepting the API key after it expires.
+	if usec := apiKeyGroup.GetExpiryUsec(); usec != 0 && time.UnixMicro(usec).Before(expiresAfter) {
+		expiresAfter = time.UnixMicro(usec)
+	}
 	c.mu.Lock()
-	c.lru.Add(apiKey, &apiKeyGroupCacheEntry{data: apiKeyGroup, expiresAfter: time.Now().Add(c.ttl)})
+	c.lru.Add(apiKey, &apiKeyGroupCacheEntry{data: apiKeyGroup, expiresAfter: expiresAfter})
 	c.mu.Unlock()
 }
 
@@ -660,8 +665,9 @@ func userClaims(u *tables.User, effectiveGroup string) *Claims {
 	for _, g := range u.Groups {
 		allowedGroups = append(allowedGroups, g.Group.GroupID)
 		groupMemberships = append(groupMemberships, &interfaces.GroupMembership{
-			GroupID: g.Group.GroupID,
-			Role:    role.Role(g.Role),
+			GroupID:            g.Group.GroupID,
+			Role:               role.Role(g.Role),
+			CustomCapabilities: role.Capability(g.CustomCapabilities),
 		})
 	}
 	return &Claims{
@@ -851,13 +857,47 @@ func (a *OpenIDAuthenticator) authenticateGRPCRequest(ctx context.Context, accep
 	if acceptJWT {
 		// Check if we're already authenticated from incoming headers.
 		if claims, err := a.authenticatedUser(ctx); err == nil {
-			return claims, nil
+			return a.refreshUserClaims(ctx, claims)
 		}
 	}
 
 	return nil, status.UnauthenticatedError("gRPC request is missing credentials.")
 }
 
+// refreshUserClaims re-reads the group memberships of the user that an
+// incoming JWT was issued to. JWTs stay valid for hours, so this makes sure
+// that memberships which were removed or deactivated since the JWT was issued
+// no longer grant access.
+func (a *OpenIDAuthenticator) refreshUserClaims(ctx context.Context, claims *Claims) (*Claims, error) {
+	authDB := a.env.GetAuthDB()
+	if claims.UserID == "" || authDB == nil {
+		return claims, nil
+	}
+	u, err := authDB.LookupUserFromUserID(ctx, claims.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if claims.Impersonating {
+		for _, g := range u.Groups {
+			if g.Group.GroupID == a.AdminGroupID() && role.Role(g.Role) == role.Admin {
+				return claims, nil
+			}
+		}
+		return nil, status.PermissionDeniedError("You do not have permissions to impersonate group members.")
+	}
+	eg := ""
+	for _, g := range u.Groups {
+		if g.Group.GroupID == claims.GroupID {
+			eg = claims.GroupID
+		}
+	}
+	refreshed := userClaims(u, eg)
+	claims.GroupID = refreshed.GroupID
+	claims.AllowedGroups = refreshed.AllowedGroups
+	claims.GroupMemberships = refreshed.GroupMemberships
+	return claims, nil
+}
+
 // AuthenticatedGRPCContext attempts to authenticate the gRPC request using peer info,
 // API key header, or basic auth headers.
 //
@@ -9,10 +9,13 @@ import (
 
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/testutil/enterprise_testenv"
 	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/oauth2"
+
+	grpb "github.com/buildbuddy-io/buildbuddy/proto/group"
 )
 
 const (
@@ -142,6 +145,49 @@ func TestAuthenticateHTTPRequest(t *testing.T) {
 	require.Equal(t, validUserToken, authCtx.Value(contextUserKey), "context user details should match details returned by provider")
 }
 
+func TestAuthenticateGRPCRequest_JWTOfDeactivatedMember(t *testing.T) {
+	env := enterprise_testenv.GetCustomTestEnv(t, &enterprise_testenv.Options{})
+	auth, err := newForTesting(context.Background(), env, &fakeOidcAuthenticator{})
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	err = env.GetDBHandle().DB(ctx).Create(&tables.User{UserID: userID, SubID: subID, Email: userEmail}).Error
+	require.NoError(t, err)
+	err = env.GetDBHandle().DB(ctx).Create(&tables.Group{GroupID: "GR1"}).Error
+	require.NoError(t, err)
+	err = env.GetDBHandle().DB(ctx).Create(&tables.UserGroup{
+		UserUserID:       userID,
+		GroupGroupID:     "GR1",
+		Role:             uint32(role.Developer),
+		MembershipStatus: int32(grpb.GroupMembershipStatus_MEMBER),
+	}).Error
+	require.NoError(t, err)
+
+	u, err := env.GetAuthDB().LookupUserFromUserID(ctx, userID)
+	require.NoError(t, err)
+	jwt, err := assembleJWT(ctx, userClaims(u, "GR1"))
+	require.NoError(t, err)
+	jwtCtx := auth.AuthContextFromTrustedJWT(ctx, jwt)
+
+	claims, err := auth.authenticateGRPCRequest(jwtCtx, true /*=acceptJWT*/)
+	require.NoError(t, err)
+	require.Equal(t, "GR1", claims.GetGroupID())
+	require.Equal(t, []string{"GR1"}, claims.GetAllowedGroups())
+
+	// Once the membership is deactivated, the JWT that was issued before no
+	// longer grants access to the group.
+	err = env.GetDBHandle().DB(ctx).Exec(
+		`UPDATE UserGroups SET membership_status = ? WHERE user_user_id = ?`,
+		int32(grpb.GroupMembershipStatus_DEACTIVATED), userID,
+	).Error
+	require.NoError(t, err)
+	claims, err = auth.authenticateGRPCRequest(jwtCtx, true /*=acceptJWT*/)
+	require.NoError(t, err)
+	require.Equal(t, "", claims.GetGroupID())
+	require.Empty(t, claims.GetAllowedGroups())
+	require.Empty(t, claims.GetGroupMemberships())
+}
+
 func TestParseAPIKeyFromString(t *testing.T) {
 	env := enterprise_testenv.GetCustomTestEnv(t, &enterprise_testenv.Options{})
 	auth, err := newForTesting(context.Background(), env, &fakeOidcAuthenticator{})
@@ -2,27 +2,71 @@ package authdb
 
 import (
 	"context"
+	"sync"
+	"time"
 
+	"github.com/buildbuddy-io/buildbuddy/server/environment"
 	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
 	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/util/clientip"
 	"github.com/buildbuddy-io/buildbuddy/server/util/db"
+	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 
 	grpb "github.com/buildbuddy-io/buildbuddy/proto/group"
 )
 
+const (
+	// apiKeyUsageUpdateInterval is how often the last usage of an API key is
+	// recorded, at most.
+	apiKeyUsageUpdateInterval = time.Minute
+
+	// apiKeyUsageFlushInterval is how often buffered API key usage is written
+	// to the DB.
+	apiKeyUsageFlushInterval = 10 * time.Second
+)
+
 type AuthDB struct {
 	h interfaces.DBHandle
+
+	// API key usage is buffered in memory and written to the DB in the
+	// background, so that recording it stays off the request path.
+	mu sync.Mutex
+	// Usage waiting to be written to the DB, keyed by API key ID.
+	pendingAPIKeyUsage map[string]*apiKeyUsage
+	// When the usage of each API key was last buffered, keyed by API key ID.
+	apiKeyUsageRecordedAt map[string]time.Time
+	stopFlush             chan struct{}
 }
 
-func NewAuthDB(h interfaces.DBHandle) *AuthDB {
-	return &AuthDB{h: h}
+type apiKeyUsage struct {
+	usedAt time.Time
+	ip     string
+}
+
+func NewAuthDB(env environment.Env, h interfaces.DBHandle) *AuthDB {
+	d := &AuthDB{
+		h:                     h,
+		pendingAPIKeyUsage:    make(map[string]*apiKeyUsage),
+		apiKeyUsageRecordedAt: make(map[string]time.Time),
+		stopFlush:             make(chan struct{}),
+	}
+	d.startAPIKeyUsageFlush()
+	if hc := env.GetHealthChecker(); hc != nil {
+		hc.RegisterShutdownFunction(func(ctx context.Context) error {
+			close(d.stopFlush)
+			return d.FlushAPIKeyUsage(ctx)
+		})
+	}
+	return d
 }
 
 type apiKeyGroup struct {
+	APIKeyID               string
 	GroupID                string
 	Capabilities           int32
 	UseGroupOwnedExecutors bool
+	ExpiryUsec             int64
 }
 
 func (g *apiKeyGroup) GetGroupID() string {
@@ -37,6 +81,10 @@ func (g *apiKeyGroup) GetUseGroupOwnedExecutors() bool {
 	return g.UseGroupOwnedExecutors
 }
 
+func (g *apiKeyGroup) GetExpiryUsec() int64 {
+	return g.ExpiryUsec
+}
+
 func (d *AuthDB) InsertOrUpdateUserSession(ctx context.Context, sessionID string, session *tables.Session) error {
 	session.SessionID = sessionID
 	return d.h.Transaction(ctx, func(tx *db.DB) error {
@@ -72,7 +120,7 @@ func (d *AuthDB) GetAPIKeyGroupFromAPIKey(ctx context.Context, apiKey string) (i
 	akg := &apiKeyGroup{}
 	err := d.h.TransactionWithOptions(ctx, db.Opts().WithStaleReads(), func(tx *db.DB) error {
 		existingRow := tx.Raw(`
-			SELECT ak.capabilities, g.group_id, g.use_group_owned_executors
+			SELECT ak.api_key_id, ak.capabilities, ak.expiry_usec, g.group_id, g.use_group_owned_executors
 			FROM `+"`Groups`"+` AS g, APIKeys AS ak
 			WHERE g.group_id = ak.group_id AND ak.value = ?`,
 			apiKey)
@@ -84,9 +132,77 @@ func (d *AuthDB) GetAPIKeyGroupFromAPIKey(ctx context.Context, apiKey string) (i
 		}
 		return nil, err
 	}
+	now := time.Now()
+	if akg.ExpiryUsec != 0 && akg.ExpiryUsec <= now.UnixMicro() {
+		return nil, status.UnauthenticatedErrorf("Expired API key %q", apiKey)
+	}
+	d.recordAPIKeyUsage(ctx, akg.APIKeyID, now)
 	return akg, nil
 }
 
+// recordAPIKeyUsage buffers that the API key was used by the client sending
+// the current request, unless its usage was already recorded recently. The
+// usage is written to the DB by the next flush.
+func (d *AuthDB) recordAPIKeyUsage(ctx context.Context, apiKeyID string, now time.Time) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if now.Sub(d.apiKeyUsageRecordedAt[apiKeyID]) < apiKeyUsageUpdateInterval {
+		return
+	}
+	d.apiKeyUsageRecordedAt[apiKeyID] = now
+	d.pendingAPIKeyUsage[apiKeyID] = &apiKeyUsage{usedAt: now, ip: clientip.Get(ctx)}
+}
+
+// startAPIKeyUsageFlush starts a goroutine that periodically writes buffered
+// API key usage to the DB.
+func (d *AuthDB) startAPIKeyUsageFlush() {
+	go func() {
+		ctx := context.Background()
+		for {
+			select {
+			case <-time.After(apiKeyUsageFlushInterval):
+				if err := d.FlushAPIKeyUsage(ctx); err != nil {
+					log.Warningf("Failed to record API key usage: %s", err)
+				}
+			case <-d.stopFlush:
+				return
+			}
+		}
+	}()
+}
+
+// FlushAPIKeyUsage writes buffered API key usage to the DB.
+//
+// Public for testing only; buffered usage is flushed periodically in the
+// background.
+func (d *AuthDB) FlushAPIKeyUsage(ctx context.Context) error {
+	d.mu.Lock()
+	pending := d.pendingAPIKeyUsage
+	d.pendingAPIKeyUsage = make(map[string]*apiKeyUsage)
+	// Forget keys whose usage may be recorded again, so that the map only
+	// holds recently used keys.
+	for apiKeyID, recordedAt := range d.apiKeyUsageRecordedAt {
+		if time.Since(recordedAt) >= apiKeyUsageUpdateInterval {
+			delete(d.apiKeyUsageRecordedAt, apiKeyID)
+		}
+	}
+	d.mu.Unlock()
+
+	var lastErr error
+	for apiKeyID, u := range pending {
+		// Other apps may have recorded usage of the same key recently.
+		err := d.h.DB(ctx).Exec(`
+			UPDATE APIKeys SET last_used_usec = ?, last_used_ip = ?
+			WHERE api_key_id = ? AND last_used_usec < ?`,
+			u.usedAt.UnixMicro(), u.ip, apiKeyID, u.usedAt.Add(-apiKeyUsageUpdateInterval).UnixMicro(),
+		).Error
+		if err != nil {
+			lastErr = status.InternalErrorf("failed to record usage of API key %q: %s", apiKeyID, err)
+		}
+	}
+	return lastErr
+}
+
 func (d *AuthDB) GetAPIKeyGroupFromBasicAuth(ctx context.Context, login, pass string) (interfaces.APIKeyGroup, error) {
 	akg := &apiKeyGroup{}
 	err := d.h.TransactionWithOptions(ctx, db.Opts().WithStaleReads(), func(tx *db.DB) error {
@@ -108,9 +224,19 @@ func (d *AuthDB) GetAPIKeyGroupFromBasicAuth(ctx context.Context, login, pass st
 }
 
 func (d *AuthDB) LookupUserFromSubID(ctx context.Context, subID string) (*tables.User, error) {
+	return d.lookupUser(ctx, `sub_id = ?`, subID)
+}
+
+func (d *AuthDB) LookupUserFromUserID(ctx context.Context, userID string) (*tables.User, error) {
+	return d.lookupUser(ctx, `user_id = ?`, userID)
+}
+
+// lookupUser returns the user matching the where clause along with the
+// groups they are an active member of.
+func (d *AuthDB) lookupUser(ctx context.Context, where string, arg interface{}) (*tables.User, error) {
 	user := &tables.User{}
 	err := d.h.TransactionWithOptions(ctx, db.Opts().WithStaleReads(), func(tx *db.DB) error {
-		userRow := tx.Raw(`SELECT * FROM Users WHERE sub_id = ? ORDER BY user_id ASC`, subID)
+		userRow := tx.Raw(`SELECT * FROM Users WHERE `+where+` ORDER BY user_id ASC`, arg)
 		if err := userRow.Take(user).Error; err != nil {
 			return err
 		}
@@ -125,8 +251,11 @@ func (d *AuthDB) LookupUserFromSubID(ctx context.Context, subID string) (*tables
 				g.sharing_enabled,
 				g.use_group_owned_executors,
 				g.saml_idp_metadata_url,
-				ug.role
+				ug.role,
+				COALESCE(cr.capabilities, 0)
 			FROM `+"`Groups`"+` AS g, UserGroups AS ug
+			LEFT JOIN CustomRoles AS cr
+			ON cr.role_id = ug.custom_role_id AND cr.group_id = ug.group_group_id
 			WHERE g.group_id = ug.group_group_id
 			AND ug.membership_status = ?
 			AND ug.user_user_id = ?
@@ -149,6 +278,7 @@ func (d *AuthDB) LookupUserFromSubID(ctx context.Context, subID string) (*tables
 				&gr.Group.UseGroupOwnedExecutors,
 				&gr.Group.SamlIdpMetadataUrl,
 				&gr.Role,
+				&gr.CustomCapabilities,
 			)
 			if err != nil {
 				return err
@@ -25,9 +25,11 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/metrics"
 	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/digest"
 	"github.com/buildbuddy-io/buildbuddy/server/util/alert"
+	"github.com/buildbuddy-io/buildbuddy/server/util/compression"
 	"github.com/buildbuddy-io/buildbuddy/server/util/disk"
 	"github.com/buildbuddy-io/buildbuddy/server/util/flagutil"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
+	"github.com/buildbuddy-io/buildbuddy/server/util/quota"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/buildbuddy-io/buildbuddy/server/util/statusz"
 	"github.com/cockroachdb/pebble"
@@ -62,6 +64,7 @@ var (
 	atimeBufferSizeFlag       = flag.Int("cache.pebble.atime_buffer_size", DefaultAtimeBufferSize, "Buffer up to this many atime updates in a channel before dropping atime updates")
 	minEvictionAgeFlag        = flag.Duration("cache.pebble.min_eviction_age", DefaultMinEvictionAge, "Don't evict anything unless it's been idle for at least this long")
 	forceCompaction           = flag.Bool("cache.pebble.force_compaction", false, "If set, compact the DB when it's created")
+	enableCompressionFlag     = flag.Bool("cache.pebble.enable_compression", false, "If set, CAS blobs will be stored zstd-compressed.")
 
 	// Default values for Options
 	// (It is valid for these options to be 0, so we use ptrs to indicate whether they're set.
@@ -122,12 +125,17 @@ type Options struct {
 	AtimeWriteBatchSize  int
 	AtimeBufferSize      *int
 	MinEvictionAge       *time.Duration
+
+	// If set, CAS blobs are stored zstd-compressed. Blobs that were already
+	// stored uncompressed remain readable.
+	EnableCompression bool
 }
 
 type sizeUpdate struct {
-	partID string
-	key    []byte
-	delta  int64
+	partID  string
+	groupID string
+	key     []byte
+	delta   int64
 }
 
 type accessTimeUpdate struct {
@@ -144,6 +152,7 @@ type PebbleCache struct {
 	maxSizeBytes           int64
 	blockCacheSizeBytes    int64
 	maxInlineFileSizeBytes int64
+	enableCompression      bool
 
 	atimeUpdateThreshold time.Duration
 	atimeWriteBatchSize  int
@@ -209,6 +218,7 @@ func Register(env environment.Env) error {
 		AtimeWriteBatchSize:    *atimeWriteBatchSizeFlag,
 		AtimeBufferSize:        atimeBufferSizeFlag,
 		MinEvictionAge:         minEvictionAgeFlag,
+		EnableCompression:      *enableCompressionFlag,
 	}
 	c, err := NewPebbleCache(env, opts)
 	if err != nil {
@@ -356,6 +366,7 @@ func NewPebbleCache(env environment.Env, opts *Options) (*PebbleCache, error) {
 		maxSizeBytes:           opts.MaxSizeBytes,
 		blockCacheSizeBytes:    opts.BlockCacheSizeBytes,
 		maxInlineFileSizeBytes: opts.MaxInlineFileSizeBytes,
+		enableCompression:      opts.EnableCompression,
 		atimeUpdateThreshold:   *opts.AtimeUpdateThreshold,
 		atimeWriteBatchSize:    opts.AtimeWriteBatchSize,
 		atimeBufferSize:        *opts.AtimeBufferSize,
@@ -510,7 +521,7 @@ func (p *PebbleCache) processSizeUpdates(quitChan chan struct{}) {
 			return
 		case edit := <-p.edits:
 			e := evictors[edit.partID]
-			e.updateSize(edit.key, edit.delta)
+			e.updateSize(edit.key, edit.groupID, edit.delta)
 		}
 	}
 }
@@ -764,6 +775,20 @@ func (p *PebbleCache) Statusz(ctx context.Context) string {
 	return buf
 }
 
+// groupSizeBytes returns the number of bytes stored by the specified group
+// across all partitions.
+func (p *PebbleCache) groupSizeBytes(groupID string) int64 {
+	p.statusMu.Lock()
+	evictors := p.evictors
+	p.statusMu.Unlock()
+
+	var sizeBytes int64
+	for _, e := range evictors {
+		sizeBytes += e.GroupSizeBytes(groupID)
+	}
+	return sizeBytes
+}
+
 func (p *PebbleCache) lookupGroupAndPartitionID(ctx context.Context, remoteInstanceName string) (string, string, error) {
 	auth := p.env.GetAuthenticator()
 	if auth == nil {
@@ -827,6 +852,15 @@ func (p *PebbleCache) blobDir() string {
 	return p.partitionBlobDir(p.isolation.GetPartitionId())
 }
 
+// storedSizeBytes returns the number of bytes the blob described by the given
+// metadata occupies in storage.
+func storedSizeBytes(md *rfpb.FileMetadata) int64 {
+	if md.GetCompressor() != repb.Compressor_IDENTITY {
+		return md.GetStoredSizeBytes()
+	}
+	return md.GetSizeBytes()
+}
+
 func lookupFileMetadata(iter *pebble.Iterator, fileMetadataKey []byte) (*rfpb.FileMetadata, error) {
 	fileMetadata := &rfpb.FileMetadata{}
 	if err := pebbleutil.LookupProto(iter, fileMetadataKey, fileMetadata); err != nil {
@@ -990,6 +1024,17 @@ func (p *PebbleCache) GetMulti(ctx context.Context, digests []*repb.Digest) (map
 		_, copyErr := io.Copy(buf, rc)
 		closeErr := rc.Close()
 		if copyErr != nil || closeErr != nil {
+			buf.Reset()
+			continue
+		}
+		if fileMetadata.GetCompressor() == repb.Compressor_ZSTD {
+			data, err := compression.DecompressZstd(make([]byte, 0, fileMetadata.GetSizeBytes()), buf.Bytes())
+			buf.Reset()
+			if err != nil {
+				log.Warningf("Failed to decompress %q: %s", fileMetadataKey, err)
+				continue
+			}
+			foundMap[d] = data
 			continue
 		}
 		foundMap[d] = append([]byte{}, buf.Bytes()...)
@@ -1018,13 +1063,14 @@ func (p *PebbleCache) SetMulti(ctx context.Context, kvs map[*repb.Digest][]byte)
 	return nil
 }
 
-func (p *PebbleCache) sendSizeUpdate(partID string, fileMetadataKey []byte, delta int64) {
+func (p *PebbleCache) sendSizeUpdate(partID, groupID string, fileMetadataKey []byte, delta int64) {
 	fileMetadataKeyCopy := make([]byte, len(fileMetadataKey))
 	copy(fileMetadataKeyCopy, fileMetadataKey)
 	up := &sizeUpdate{
-		partID: partID,
-		key:    fileMetadataKeyCopy,
-		delta:  delta,
+		partID:  partID,
+		groupID: groupID,
+		key:     fileMetadataKeyCopy,
+		delta:   delta,
 	}
 	p.edits <- up
 }
@@ -1073,7 +1119,7 @@ func (p *PebbleCache) deleteMetadataOnly(fileMetadataKey []byte) error {
 	if err := db.Delete(fileMetadataKey, &pebble.WriteOptions{Sync: false}); err != nil {
 		return err
 	}
-	p.sendSizeUpdate(fileMetadata.GetFileRecord().GetIsolation().GetPartitionId(), fileMetadataKey, -1*fileMetadata.GetSizeBytes())
+	p.sendSizeUpdate(fileMetadata.GetFileRecord().GetIsolation().GetPartitionId(), fileMetadata.GetFileRecord().GetIsolation().GetGroupId(), fileMetadataKey, -1*storedSizeBytes(fileMetadata))
 	return nil
 }
 
@@ -1097,7 +1143,7 @@ func (p *PebbleCache) deleteRecord(ctx context.Context, fileMetadataKey []byte)
 	if err := db.Delete(fileMetadataKey, &pebble.WriteOptions{Sync: false}); err != nil {
 		return err
 	}
-	p.sendSizeUpdate(p.isolation.GetPartitionId(), fileMetadataKey, -1*fileMetadata.GetSizeBytes())
+	p.sendSizeUpdate(p.isolation.GetPartitionId(), fileMetadata.GetFileRecord().GetIsolation().GetGroupId(), fileMetadataKey, -1*storedSizeBytes(fileMetadata))
 	if err := disk.DeleteFile(ctx, fp); err != nil {
 		return err
 	}
@@ -1121,10 +1167,34 @@ func (p *PebbleCache) Delete(ctx context.Context, d *repb.Digest) error {
 }
 
 func (p *PebbleCache) Reader(ctx context.Context, d *repb.Digest, offset, limit int64) (io.ReadCloser, error) {
-	db, err := p.leaser.DB()
+	rc, fileMetadata, err := p.storedReader(ctx, d, offset, limit)
 	if err != nil {
 		return nil, err
 	}
+	if fileMetadata.GetCompressor() == repb.Compressor_ZSTD {
+		return compression.NewZstdDecompressingReader(rc, offset, limit)
+	}
+	return rc, nil
+}
+
+// CompressedReader returns a reader over the blob's bytes as they are stored,
+// along with the compressor that they are stored with.
+func (p *PebbleCache) CompressedReader(ctx context.Context, d *repb.Digest) (io.ReadCloser, repb.Compressor_Value, error) {
+	rc, fileMetadata, err := p.storedReader(ctx, d, 0, 0)
+	if err != nil {
+		return nil, repb.Compressor_IDENTITY, err
+	}
+	return rc, fileMetadata.GetCompressor(), nil
+}
+
+// storedReader returns a reader over the stored bytes of the given blob, along
+// with the blob's metadata. If the blob is stored compressed, offset and limit
+// are ignored, since they refer to decompressed bytes.
+func (p *PebbleCache) storedReader(ctx context.Context, d *repb.Digest, offset, limit int64) (io.ReadCloser, *rfpb.FileMetadata, error) {
+	db, err := p.leaser.DB()
+	if err != nil {
+		return nil, nil, err
+	}
 	defer db.Close()
 
 	iter := db.NewIter(nil /*default iterOptions*/)
@@ -1132,19 +1202,22 @@ func (p *PebbleCache) Reader(ctx context.Context, d *repb.Digest, offset, limit
 
 	fileRecord, err := p.makeFileRecord(ctx, d)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	fileMetadataKey, err := p.fileStorer.FileMetadataKey(fileRecord)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// First, lookup the FileMetadata. If it's not found, we don't have the file.
 	fileMetadata, err := lookupFileMetadata(iter, fileMetadataKey)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
+	if fileMetadata.GetCompressor() != repb.Compressor_IDENTITY {
+		offset, limit = 0, 0
+	}
 	rc, err := p.fileStorer.NewReader(ctx, p.blobDir(), fileMetadata.GetStorageMetadata(), offset, limit)
 	if err == nil {
 		sendAtimeUpdate(p.accesses, fileMetadataKey, fileMetadata, p.atimeUpdateThreshold, p.atimeBufferSize)
@@ -1153,16 +1226,16 @@ func (p *PebbleCache) Reader(ctx context.Context, d *repb.Digest, offset, limit
 	}
 
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// Grab another lease and pass the Close function to the reader
 	// so it will be closed when the reader is.
 	db, err = p.leaser.DB()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	return pebbleutil.ReadCloserWithFunc(rc, db.Close), nil
+	return pebbleutil.ReadCloserWithFunc(rc, db.Close), fileMetadata, nil
 }
 
 type writeCloser struct {
@@ -1178,6 +1251,47 @@ func (dc *writeCloser) Close() error {
 	return dc.closeFn(dc.bytesWritten)
 }
 
+// zstdWriteCloser zstd-compresses bytes before writing them to the wrapped
+// MetadataWriteCloser, and counts the compressed bytes written.
+type zstdWriteCloser struct {
+	interfaces.MetadataWriteCloser
+	compressor      io.WriteCloser
+	compressedBytes int64
+}
+
+func newZstdWriteCloser(wcm interfaces.MetadataWriteCloser) (*zstdWriteCloser, error) {
+	zwc := &zstdWriteCloser{MetadataWriteCloser: wcm}
+	compressor, err := compression.NewZstdCompressingWriter(writerFunc(zwc.writeCompressed))
+	if err != nil {
+		return nil, err
+	}
+	zwc.compressor = compressor
+	return zwc, nil
+}
+
+type writerFunc func(p []byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+func (zwc *zstdWriteCloser) writeCompressed(p []byte) (int, error) {
+	n, err := zwc.MetadataWriteCloser.Write(p)
+	zwc.compressedBytes += int64(n)
+	return n, err
+}
+
+func (zwc *zstdWriteCloser) Write(p []byte) (int, error) {
+	return zwc.compressor.Write(p)
+}
+
+func (zwc *zstdWriteCloser) Close() error {
+	if err := zwc.compressor.Close(); err != nil {
+		return err
+	}
+	return zwc.MetadataWriteCloser.Close()
+}
+
 func (dc *writeCloser) Write(p []byte) (int, error) {
 	n, err := dc.MetadataWriteCloser.Write(p)
 	if err != nil {
@@ -1222,6 +1336,17 @@ func (p *PebbleCache) Writer(ctx context.Context, d *repb.Digest) (io.WriteClose
 		wcm = fw
 	}
 
+	// Only CAS blobs are compressed, since the digest of an AC entry does
+	// not describe the size of its contents.
+	var zwc *zstdWriteCloser
+	if p.enableCompression && fileRecord.GetIsolation().GetCacheType() == rfpb.Isolation_CAS_CACHE {
+		zwc, err = newZstdWriteCloser(wcm)
+		if err != nil {
+			return nil, err
+		}
+		wcm = zwc
+	}
+
 	// Grab another lease and pass the Close function to the writer
 	// so it will be closed when the writer is.
 	db, err = p.leaser.DB()
@@ -1238,13 +1363,30 @@ func (p *PebbleCache) Writer(ctx context.Context, d *repb.Digest) (io.WriteClose
 			LastAccessUsec:  now,
 			LastModifyUsec:  now,
 		}
+		if zwc != nil {
+			md.Compressor = repb.Compressor_ZSTD
+			md.StoredSizeBytes = zwc.compressedBytes
+		}
+		// The stored bytes quota is checked once the blob has been written,
+		// since its stored size isn't known until it has been compressed.
+		if !alreadyExists {
+			storedBytes := p.groupSizeBytes(p.isolation.GetGroupId()) + storedSizeBytes(md)
+			if err := quota.Allow(ctx, p.env, quota.StoredBytesNamespace, storedBytes); err != nil {
+				if fileMetadata := md.GetStorageMetadata().GetFileMetadata(); fileMetadata != nil {
+					if err := disk.DeleteFile(ctx, p.fileStorer.FilePath(p.blobDir(), fileMetadata)); err != nil {
+						log.Warningf("Could not delete over-quota blob %q: %s", d.GetHash(), err)
+					}
+				}
+				return err
+			}
+		}
 		protoBytes, err := proto.Marshal(md)
 		if err != nil {
 			return err
 		}
 		err = db.Set(fileMetadataKey, protoBytes, &pebble.WriteOptions{Sync: false})
 		if err == nil {
-			p.sendSizeUpdate(p.isolation.GetPartitionId(), fileMetadataKey, bytesWritten)
+			p.sendSizeUpdate(p.isolation.GetPartitionId(), p.isolation.GetGroupId(), fileMetadataKey, storedSizeBytes(md))
 			metrics.DiskCacheAddedFileSizeBytes.Observe(float64(bytesWritten))
 		}
 		return err
@@ -1322,6 +1464,9 @@ type partitionEvictor struct {
 	lastRun     time.Time
 	lastEvicted *evictionPoolEntry
 
+	// The number of blob bytes stored by each group in this partition.
+	groupSizeBytes map[string]int64
+
 	atimeBufferSize int
 	minEvictionAge  time.Duration
 }
@@ -1342,19 +1487,20 @@ func newPartitionEvictor(part disk.Partition, fileStorer filestore.Store, blobDi
 	}
 	start := time.Now()
 	log.Printf("Pebble Cache: Initializing cache partition %q...", part.ID)
-	sizeBytes, casCount, acCount, err := pe.computeSize()
+	sizeBytes, casCount, acCount, groupSizeBytes, err := pe.computeSize()
 	if err != nil {
 		return nil, err
 	}
 	pe.sizeBytes = sizeBytes
 	pe.casCount = casCount
 	pe.acCount = acCount
+	pe.groupSizeBytes = groupSizeBytes
 
 	log.Printf("Pebble Cache: Initialized cache partition %q AC: %d, CAS: %d, Size: %d [bytes] in %s", part.ID, pe.acCount, pe.casCount, pe.sizeBytes, time.Since(start))
 	return pe, nil
 }
 
-func (e *partitionEvictor) updateSize(fileMetadataKey []byte, deltaSize int64) {
+func (e *partitionEvictor) updateSize(fileMetadataKey []byte, groupID string, deltaSize int64) {
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
@@ -1371,12 +1517,22 @@ func (e *partitionEvictor) updateSize(fileMetadataKey []byte, deltaSize int64) {
 		log.Warningf("Unidentified file (not CAS or AC): %q", fileMetadataKey)
 	}
 	e.sizeBytes += deltaSize
+	e.groupSizeBytes[groupID] += deltaSize
+	if e.groupSizeBytes[groupID] <= 0 {
+		delete(e.groupSizeBytes, groupID)
+	}
+}
+
+func (e *partitionEvictor) GroupSizeBytes(groupID string) int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.groupSizeBytes[groupID]
 }
 
-func (e *partitionEvictor) computeSizeInRange(start, end []byte) (int64, int64, int64, error) {
+func (e *partitionEvictor) computeSizeInRange(start, end []byte) (int64, int64, int64, map[string]int64, error) {
 	db, err := e.dbGetter.DB()
 	if err != nil {
-		return 0, 0, 0, err
+		return 0, 0, 0, nil, err
 	}
 	defer db.Close()
 	iter := db.NewIter(&pebble.IterOptions{
@@ -1390,14 +1546,16 @@ func (e *partitionEvictor) computeSizeInRange(start, end []byte) (int64, int64,
 	acCount := int64(0)
 	blobSizeBytes := int64(0)
 	metadataSizeBytes := int64(0)
+	groupSizeBytes := make(map[string]int64, 0)
 	fileMetadata := &rfpb.FileMetadata{}
 
 	for iter.Next() {
 		if err := proto.Unmarshal(iter.Value(), fileMetadata); err != nil {
-			return 0, 0, 0, err
+			return 0, 0, 0, nil, err
 		}
-		blobSizeBytes += fileMetadata.GetSizeBytes()
+		blobSizeBytes += storedSizeBytes(fileMetadata)
 		metadataSizeBytes += int64(len(iter.Value()))
+		groupSizeBytes[fileMetadata.GetFileRecord().GetIsolation().GetGroupId()] += storedSizeBytes(fileMetadata)
 
 		// identify and count CAS vs AC files.
 		if bytes.Contains(iter.Key(), e.casPrefix) {
@@ -1409,7 +1567,7 @@ func (e *partitionEvictor) computeSizeInRange(start, end []byte) (int64, int64,
 		}
 	}
 
-	return blobSizeBytes + metadataSizeBytes, casCount, acCount, nil
+	return blobSizeBytes + metadataSizeBytes, casCount, acCount, groupSizeBytes, nil
 }
 
 func splitRange(left, right []byte, count int) ([][]byte, error) {
@@ -1434,17 +1592,18 @@ func splitRange(left, right []byte, count int) ([][]byte, error) {
 	return ranges, nil
 }
 
-func (e *partitionEvictor) computeSize() (int64, int64, int64, error) {
+func (e *partitionEvictor) computeSize() (int64, int64, int64, map[string]int64, error) {
 	mu := sync.Mutex{}
 	eg := errgroup.Group{}
 
 	totalSizeBytes := int64(0)
 	totalCasCount := int64(0)
 	totalAcCount := int64(0)
+	totalGroupSizeBytes := make(map[string]int64, 0)
 
 	goScanRange := func(start, end []byte) {
 		eg.Go(func() error {
-			sizeBytes, casCount, acCount, err := e.computeSizeInRange(start, end)
+			sizeBytes, casCount, acCount, groupSizeBytes, err := e.computeSizeInRange(start, end)
 			if err != nil {
 				return err
 			}
@@ -1453,6 +1612,9 @@ func (e *partitionEvictor) computeSize() (int64, int64, int64, error) {
 			totalSizeBytes += sizeBytes
 			totalCasCount += casCount
 			totalAcCount += acCount
+			for groupID, groupBytes := range groupSizeBytes {
+				totalGroupSizeBytes[groupID] += groupBytes
+			}
 			mu.Unlock()
 			return nil
 		})
@@ -1463,7 +1625,7 @@ func (e *partitionEvictor) computeSize() (int64, int64, int64, error) {
 	// Start scanning at 10 because crc32s do not begin with 0.
 	ranges, err := splitRange(keyPrefix(e.acPrefix, []byte("10")), keyPrefix(e.acPrefix, []byte("99")), 100)
 	if err != nil {
-		return 0, 0, 0, err
+		return 0, 0, 0, nil, err
 	}
 	for i, left := range ranges {
 		goScanRange(left, ranges[i+1])
@@ -1479,7 +1641,7 @@ func (e *partitionEvictor) computeSize() (int64, int64, int64, error) {
 	// CAS keys look like /partitionID/cas/digesthash(sha-256)
 	ranges, err = splitRange(keyPrefix(e.casPrefix, []byte("00")), keyPrefix(e.casPrefix, []byte("ff")), 160)
 	if err != nil {
-		return 0, 0, 0, err
+		return 0, 0, 0, nil, err
 	}
 	for i, left := range ranges {
 		goScanRange(left, ranges[i+1])
@@ -1492,9 +1654,9 @@ func (e *partitionEvictor) computeSize() (int64, int64, int64, error) {
 	goScanRange(keyPrefix(e.casPrefix, []byte("ff")), keyPrefix(e.casPrefix, []byte{constants.MaxByte}))
 
 	if err := eg.Wait(); err != nil {
-		return 0, 0, 0, err
+		return 0, 0, 0, nil, err
 	}
-	return totalSizeBytes, totalCasCount, totalAcCount, nil
+	return totalSizeBytes, totalCasCount, totalAcCount, totalGroupSizeBytes, nil
 }
 
 func (e *partitionEvictor) Counts() (int64, int64, int64) {
@@ -1503,6 +1665,27 @@ func (e *partitionEvictor) Counts() (int64, int64, int64) {
 	return e.sizeBytes, e.casCount, e.acCount
 }
 
+// groupUsageString returns a summary of the groups storing the most bytes in
+// this partition. Must be called with e.mu held.
+func (e *partitionEvictor) groupUsageString() string {
+	const maxGroups = 10
+	groupIDs := make([]string, 0, len(e.groupSizeBytes))
+	for groupID := range e.groupSizeBytes {
+		groupIDs = append(groupIDs, groupID)
+	}
+	sort.Slice(groupIDs, func(i, j int) bool {
+		return e.groupSizeBytes[groupIDs[i]] > e.groupSizeBytes[groupIDs[j]]
+	})
+	buf := fmt.Sprintf("Groups: %d\n", len(groupIDs))
+	for i, groupID := range groupIDs {
+		if i == maxGroups {
+			break
+		}
+		buf += fmt.Sprintf("\t%q: %d bytes\n", groupID, e.groupSizeBytes[groupID])
+	}
+	return buf
+}
+
 func (e *partitionEvictor) Statusz(ctx context.Context) string {
 	e.mu.Lock()
 	defer e.mu.Unlock()
@@ -1514,6 +1697,7 @@ func (e *partitionEvictor) Statusz(ctx context.Context) string {
 	totalCount := e.casCount + e.acCount
 	buf += fmt.Sprintf("Items: CAS: %d AC: %d (%d total)\n", e.casCount, e.acCount, totalCount)
 	buf += fmt.Sprintf("Usage: %d / %d (%2.2f%% full)\n", e.sizeBytes, maxAllowedSize, percentFull)
+	buf += e.groupUsageString()
 	buf += fmt.Sprintf("GC Last run: %s\n", e.lastRun.Format("Jan 02, 2006 15:04:05 MST"))
 	lastEvictedStr := "nil"
 	if e.lastEvicted != nil {
@@ -1651,7 +1835,7 @@ func (e *partitionEvictor) deleteFile(sample *evictionPoolEntry) error {
 
 	ageUsec := float64(time.Since(time.Unix(0, sample.timestamp)).Microseconds())
 	metrics.DiskCacheLastEvictionAgeUsec.With(prometheus.Labels{metrics.PartitionID: e.part.ID}).Set(ageUsec)
-	e.updateSize(sample.fileMetadataKey, -1*sample.fileMetadata.GetSizeBytes())
+	e.updateSize(sample.fileMetadataKey, sample.fileMetadata.GetFileRecord().GetIsolation().GetGroupId(), -1*storedSizeBytes(sample.fileMetadata))
 	return nil
 }
 
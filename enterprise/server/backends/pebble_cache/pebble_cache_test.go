@@ -23,9 +23,11 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testdigest"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testfs"
+	"github.com/buildbuddy-io/buildbuddy/server/util/compression"
 	"github.com/buildbuddy-io/buildbuddy/server/util/disk"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/quota"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/buildbuddy-io/buildbuddy/server/util/testing/flags"
 	"github.com/cockroachdb/pebble"
@@ -417,6 +419,85 @@ func TestReadWrite(t *testing.T) {
 	}
 }
 
+func TestCompression(t *testing.T) {
+	te := testenv.GetTestEnv(t)
+	te.SetAuthenticator(testauth.NewTestAuthenticator(emptyUserMap))
+	ctx := getAnonContext(t, te)
+
+	maxSizeBytes := int64(100_000_000)
+	rootDir := testfs.MakeTempDir(t)
+	opts := &pebble_cache.Options{RootDirectory: rootDir, MaxSizeBytes: maxSizeBytes, EnableCompression: true}
+	pc, err := pebble_cache.NewPebbleCache(te, opts)
+	require.NoError(t, err)
+	pc.Start()
+
+	// Use one blob that is stored inline and one that is stored in a file.
+	digestBufs := make(map[*repb.Digest][]byte)
+	digests := make([]*repb.Digest, 0)
+	for i, size := range []int64{100, 10_000} {
+		buf := bytes.Repeat([]byte{byte('a' + i)}, int(size))
+		d, err := digest.Compute(bytes.NewReader(buf))
+		require.NoError(t, err)
+		require.NoError(t, pc.Set(ctx, d, buf))
+		digestBufs[d] = buf
+		digests = append(digests, d)
+	}
+
+	found, err := pc.GetMulti(ctx, digests)
+	require.NoError(t, err)
+	for d, buf := range digestBufs {
+		require.Equal(t, buf, found[d])
+
+		md, err := pc.Metadata(ctx, d)
+		require.NoError(t, err)
+		require.Equal(t, d.GetSizeBytes(), md.SizeBytes)
+
+		r, err := pc.Reader(ctx, d, 10, 20)
+		require.NoError(t, err)
+		rbuf, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, buf[10:30], rbuf)
+
+		r, compressor, err := pc.CompressedReader(ctx, d)
+		require.NoError(t, err)
+		require.Equal(t, repb.Compressor_ZSTD, compressor)
+		compressed, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Less(t, len(compressed), len(buf))
+		decompressed, err := compression.DecompressZstd(nil, compressed)
+		require.NoError(t, err)
+		require.Equal(t, buf, decompressed)
+	}
+
+	// AC entries are stored uncompressed.
+	ac, err := pc.WithIsolation(ctx, interfaces.ActionCacheType, "")
+	require.NoError(t, err)
+	acDigest, acBuf := testdigest.NewRandomDigestBuf(t, 100)
+	require.NoError(t, ac.Set(ctx, acDigest, acBuf))
+	r, compressor, err := ac.(interfaces.CompressedCache).CompressedReader(ctx, acDigest)
+	require.NoError(t, err)
+	require.Equal(t, repb.Compressor_IDENTITY, compressor)
+	rbuf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, acBuf, rbuf)
+	require.NoError(t, pc.Stop())
+
+	// Compressed blobs should remain readable after compression has been
+	// disabled.
+	pc2, err := pebble_cache.NewPebbleCache(te, &pebble_cache.Options{RootDirectory: rootDir, MaxSizeBytes: maxSizeBytes})
+	require.NoError(t, err)
+	pc2.Start()
+	defer pc2.Stop()
+	for d, buf := range digestBufs {
+		rbuf, err := pc2.Get(ctx, d)
+		require.NoError(t, err)
+		require.Equal(t, buf, rbuf)
+	}
+}
+
 func TestSizeLimit(t *testing.T) {
 	te := testenv.GetTestEnv(t)
 	te.SetAuthenticator(testauth.NewTestAuthenticator(emptyUserMap))
@@ -460,6 +541,90 @@ func TestSizeLimit(t *testing.T) {
 	require.LessOrEqual(t, dirSize, maxSizeBytes)
 }
 
+// storedBytesQuotaManager allows up to maxStoredBytes in the stored bytes
+// namespace, and allows everything else.
+type storedBytesQuotaManager struct {
+	interfaces.QuotaManager
+	maxStoredBytes int64
+}
+
+func (qm *storedBytesQuotaManager) Allow(ctx context.Context, namespace string, quantity int64) (bool, error) {
+	if namespace != quota.StoredBytesNamespace {
+		return true, nil
+	}
+	return quantity <= qm.maxStoredBytes, nil
+}
+
+func TestStoredBytesQuota(t *testing.T) {
+	te := testenv.GetTestEnv(t)
+	te.SetAuthenticator(testauth.NewTestAuthenticator(emptyUserMap))
+	te.SetQuotaManager(&storedBytesQuotaManager{maxStoredBytes: 10_000})
+	ctx := getAnonContext(t, te)
+
+	maxSizeBytes := int64(1_000_000_000) // 1GB
+	pc, err := pebble_cache.NewPebbleCache(te, &pebble_cache.Options{RootDirectory: testfs.MakeTempDir(t), MaxSizeBytes: maxSizeBytes})
+	require.NoError(t, err)
+	pc.Start()
+	defer pc.Stop()
+
+	d, buf := testdigest.NewRandomDigestBuf(t, 4000)
+	require.NoError(t, pc.Set(ctx, d, buf))
+	written := []*repb.Digest{d}
+
+	// Size updates are applied asynchronously, so keep writing until the
+	// quota is enforced.
+	var quotaErr error
+	for i := 0; i < 100 && quotaErr == nil; i++ {
+		d, buf := testdigest.NewRandomDigestBuf(t, 4000)
+		quotaErr = pc.Set(ctx, d, buf)
+		if quotaErr == nil {
+			written = append(written, d)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	require.True(t, status.IsResourceExhaustedError(quotaErr), "expected ResourceExhausted, got %v", quotaErr)
+
+	// Writing a blob that is already stored does not use more storage.
+	require.NoError(t, pc.Set(ctx, d, buf))
+
+	// Deleting blobs frees up quota.
+	for _, d := range written {
+		require.NoError(t, pc.Delete(ctx, d))
+	}
+	require.Eventually(t, func() bool {
+		d, buf := testdigest.NewRandomDigestBuf(t, 4000)
+		return pc.Set(ctx, d, buf) == nil
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestStoredBytesQuota_Compression(t *testing.T) {
+	te := testenv.GetTestEnv(t)
+	te.SetAuthenticator(testauth.NewTestAuthenticator(emptyUserMap))
+	te.SetQuotaManager(&storedBytesQuotaManager{maxStoredBytes: 10_000})
+	ctx := getAnonContext(t, te)
+
+	opts := &pebble_cache.Options{RootDirectory: testfs.MakeTempDir(t), MaxSizeBytes: 1_000_000_000, EnableCompression: true}
+	pc, err := pebble_cache.NewPebbleCache(te, opts)
+	require.NoError(t, err)
+	pc.Start()
+	defer pc.Stop()
+
+	// The quota applies to the compressed size of blobs, so a compressible
+	// blob larger than the quota can be stored.
+	buf := bytes.Repeat([]byte("a"), 20_000)
+	d, err := digest.Compute(bytes.NewReader(buf))
+	require.NoError(t, err)
+	require.NoError(t, pc.Set(ctx, d, buf))
+
+	// An incompressible blob larger than the quota can't be stored.
+	d, buf = testdigest.NewRandomDigestBuf(t, 20_000)
+	err = pc.Set(ctx, d, buf)
+	require.True(t, status.IsResourceExhaustedError(err), "expected ResourceExhausted, got %v", err)
+	exists, err := pc.Contains(ctx, d)
+	require.NoError(t, err)
+	require.False(t, exists)
+}
+
 func TestNoEarlyEviction(t *testing.T) {
 	te := testenv.GetTestEnv(t)
 	te.SetAuthenticator(testauth.NewTestAuthenticator(emptyUserMap))
@@ -21,6 +21,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 
 	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
+	alpb "github.com/buildbuddy-io/buildbuddy/proto/auditlog"
 	grpb "github.com/buildbuddy-io/buildbuddy/proto/group"
 	telpb "github.com/buildbuddy-io/buildbuddy/proto/telemetry"
 	api_config "github.com/buildbuddy-io/buildbuddy/server/api/config"
@@ -31,6 +32,10 @@ const (
 	// Changing this will change the default label text shown on new API
 	// keys if the user leaves the label field blank.
 	defaultAPIKeyLabel = "Default"
+
+	// Prefix of the subscriber ID of users that were provisioned by a group's
+	// identity provider but have not logged in yet.
+	provisionedSubIDPrefix = "provisioned/"
 )
 
 var (
@@ -170,9 +175,12 @@ func (d *UserDB) GetAPIKeys(ctx context.Context, groupID string, checkVisibility
 		return nil, err
 	}
 
-	q := query_builder.NewQuery(`SELECT api_key_id, value, label, perms, capabilities, visible_to_developers FROM APIKeys`)
+	q := query_builder.NewQuery(`
+		SELECT api_key_id, value, label, perms, capabilities, visible_to_developers,
+			expiry_usec, last_used_usec, last_used_ip
+		FROM APIKeys`)
 	q.AddWhereClause("group_id = ?", groupID)
-	if err := authutil.AuthorizeGroupRole(u, groupID, role.Admin); err != nil && checkVisibility {
+	if err := authutil.AuthorizeGroupCapability(u, groupID, role.ManageAPIKeys); err != nil && checkVisibility {
 		q.AddWhereClause("visible_to_developers = ?", true)
 	}
 	q.SetOrderBy("label", true /*ascending*/)
@@ -195,23 +203,36 @@ func (d *UserDB) GetAPIKeys(ctx context.Context, groupID string, checkVisibility
 	return keys, nil
 }
 
-func (d *UserDB) CreateAPIKey(ctx context.Context, groupID string, label string, caps []akpb.ApiKey_Capability, visibleToDevelopers bool) (*tables.APIKey, error) {
+func validateAPIKeyExpiry(expiryUsec int64) error {
+	if expiryUsec < 0 {
+		return status.InvalidArgumentError("API key expiration time cannot be negative.")
+	}
+	if expiryUsec != 0 && expiryUsec <= time.Now().UnixMicro() {
+		return status.InvalidArgumentError("API key expiration time must be in the future.")
+	}
+	return nil
+}
+
+func (d *UserDB) CreateAPIKey(ctx context.Context, groupID string, label string, caps []akpb.ApiKey_Capability, visibleToDevelopers bool, expiryUsec int64) (*tables.APIKey, error) {
 	if groupID == "" {
 		return nil, status.InvalidArgumentError("Group ID cannot be nil.")
 	}
+	if err := validateAPIKeyExpiry(expiryUsec); err != nil {
+		return nil, err
+	}
 
-	return createAPIKey(d.h.DB(ctx), groupID, newAPIKeyToken(), label, caps, visibleToDevelopers)
+	return createAPIKey(d.h.DB(ctx), groupID, newAPIKeyToken(), label, caps, visibleToDevelopers, expiryUsec)
 }
 
-func createAPIKey(db *db.DB, groupID, value, label string, caps []akpb.ApiKey_Capability, visibleToDevelopers bool) (*tables.APIKey, error) {
+func createAPIKey(db *db.DB, groupID, value, label string, caps []akpb.ApiKey_Capability, visibleToDevelopers bool, expiryUsec int64) (*tables.APIKey, error) {
 	pk, err := tables.PrimaryKeyForTable("APIKeys")
 	if err != nil {
 		return nil, err
 	}
 	keyPerms := perms.GROUP_READ | perms.GROUP_WRITE
 	if err := db.Exec(
-		`INSERT INTO APIKeys (api_key_id, group_id, perms, capabilities, value, label, visible_to_developers) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		pk, groupID, keyPerms, capabilities.ToInt(caps), value, label, visibleToDevelopers).Error; err != nil {
+		`INSERT INTO APIKeys (api_key_id, group_id, perms, capabilities, value, label, visible_to_developers, expiry_usec) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		pk, groupID, keyPerms, capabilities.ToInt(caps), value, label, visibleToDevelopers, expiryUsec).Error; err != nil {
 		return nil, err
 	}
 	return &tables.APIKey{
@@ -222,6 +243,7 @@ func createAPIKey(db *db.DB, groupID, value, label string, caps []akpb.ApiKey_Ca
 		Perms:               keyPerms,
 		Capabilities:        capabilities.ToInt(caps),
 		VisibleToDevelopers: visibleToDevelopers,
+		ExpiryUsec:          expiryUsec,
 	}, nil
 }
 
@@ -232,12 +254,16 @@ func (d *UserDB) UpdateAPIKey(ctx context.Context, key *tables.APIKey) error {
 	if key.APIKeyID == "" {
 		return status.InvalidArgumentError("API key ID cannot be empty.")
 	}
+	if key.ExpiryUsec < 0 {
+		return status.InvalidArgumentError("API key expiration time cannot be negative.")
+	}
 
 	err := d.h.DB(ctx).Exec(
-		`UPDATE APIKeys SET label = ?, capabilities = ?, visible_to_developers = ? WHERE api_key_id = ?`,
+		`UPDATE APIKeys SET label = ?, capabilities = ?, visible_to_developers = ?, expiry_usec = ? WHERE api_key_id = ?`,
 		key.Label,
 		key.Capabilities,
 		key.VisibleToDevelopers,
+		key.ExpiryUsec,
 		key.APIKeyID,
 	).Error
 	if err != nil {
@@ -257,6 +283,189 @@ func (d *UserDB) DeleteAPIKey(ctx context.Context, apiKeyID string) error {
 	return nil
 }
 
+func (d *UserDB) RotateAPIKey(ctx context.Context, apiKeyID string, gracePeriod time.Duration, expiryUsec int64) (*tables.APIKey, error) {
+	if apiKeyID == "" {
+		return nil, status.InvalidArgumentError("API key ID cannot be empty.")
+	}
+	if gracePeriod < 0 {
+		return nil, status.InvalidArgumentError("Grace period cannot be negative.")
+	}
+	if err := validateAPIKeyExpiry(expiryUsec); err != nil {
+		return nil, err
+	}
+	var newKey *tables.APIKey
+	err := d.h.Transaction(ctx, func(tx *db.DB) error {
+		old := &tables.APIKey{}
+		if err := tx.Raw(`SELECT * FROM APIKeys WHERE api_key_id = ?`, apiKeyID).Take(old).Error; err != nil {
+			if db.IsRecordNotFound(err) {
+				return status.NotFoundError("The requested API key was not found.")
+			}
+			return err
+		}
+		// Keep the old key's expiration time if it expires before the end of
+		// the grace period anyway.
+		oldExpiryUsec := time.Now().Add(gracePeriod).UnixMicro()
+		if old.ExpiryUsec != 0 && old.ExpiryUsec < oldExpiryUsec {
+			oldExpiryUsec = old.ExpiryUsec
+		}
+		err := tx.Exec(`UPDATE APIKeys SET expiry_usec = ? WHERE api_key_id = ?`, oldExpiryUsec, apiKeyID).Error
+		if err != nil {
+			return err
+		}
+		newKey, err = createAPIKey(tx, old.GroupID, newAPIKeyToken(), old.Label, capabilities.FromInt(old.Capabilities), old.VisibleToDevelopers, expiryUsec)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return newKey, nil
+}
+
+func (d *UserDB) GetCustomRole(ctx context.Context, roleID string) (*tables.CustomRole, error) {
+	if roleID == "" {
+		return nil, status.InvalidArgumentError("Role ID cannot be empty.")
+	}
+	return getCustomRole(d.h.DB(ctx), roleID)
+}
+
+func getCustomRole(tx *db.DB, roleID string) (*tables.CustomRole, error) {
+	r := &tables.CustomRole{}
+	if err := tx.Raw(`SELECT * FROM CustomRoles WHERE role_id = ?`, roleID).Take(r).Error; err != nil {
+		if db.IsRecordNotFound(err) {
+			return nil, status.NotFoundError("The requested role was not found.")
+		}
+		return nil, err
+	}
+	return r, nil
+}
+
+// checkCustomRoleInGroup returns an error if the given role ID does not refer
+// to one of the given group's custom roles.
+func checkCustomRoleInGroup(tx *db.DB, roleID, groupID string) error {
+	if roleID == "" {
+		return status.InvalidArgumentError("A custom role ID is required when assigning a custom role.")
+	}
+	r, err := getCustomRole(tx, roleID)
+	if err != nil {
+		return err
+	}
+	if r.GroupID != groupID {
+		return status.NotFoundError("The requested role was not found.")
+	}
+	return nil
+}
+
+func (d *UserDB) GetCustomRoles(ctx context.Context, groupID string) ([]*tables.CustomRole, error) {
+	if groupID == "" {
+		return nil, status.InvalidArgumentError("Group ID cannot be empty.")
+	}
+	rows, err := d.h.DB(ctx).Raw(
+		`SELECT * FROM CustomRoles WHERE group_id = ? ORDER BY name ASC`, groupID,
+	).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := make([]*tables.CustomRole, 0)
+	for rows.Next() {
+		r := &tables.CustomRole{}
+		if err := d.h.DB(ctx).ScanRows(rows, r); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, nil
+}
+
+func validateCustomRoleName(tx *db.DB, groupID, roleID, name string) error {
+	if strings.TrimSpace(name) == "" {
+		return status.InvalidArgumentError("Role name cannot be empty.")
+	}
+	row := &struct{ Count int64 }{}
+	err := tx.Raw(
+		`SELECT COUNT(*) AS count FROM CustomRoles WHERE group_id = ? AND name = ? AND role_id != ?`,
+		groupID, name, roleID,
+	).Take(row).Error
+	if err != nil {
+		return err
+	}
+	if row.Count > 0 {
+		return status.AlreadyExistsErrorf("A role named %q already exists.", name)
+	}
+	return nil
+}
+
+func (d *UserDB) CreateCustomRole(ctx context.Context, groupID string, name string, caps role.Capability) (*tables.CustomRole, error) {
+	if groupID == "" {
+		return nil, status.InvalidArgumentError("Group ID cannot be empty.")
+	}
+	r := &tables.CustomRole{
+		GroupID:      groupID,
+		Name:         strings.TrimSpace(name),
+		Capabilities: uint32(caps & role.AllCapabilities),
+	}
+	err := d.h.Transaction(ctx, func(tx *db.DB) error {
+		if err := validateCustomRoleName(tx, groupID, "", r.Name); err != nil {
+			return err
+		}
+		pk, err := tables.PrimaryKeyForTable("CustomRoles")
+		if err != nil {
+			return err
+		}
+		r.RoleID = pk
+		return tx.Create(r).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (d *UserDB) UpdateCustomRole(ctx context.Context, r *tables.CustomRole) error {
+	if r == nil {
+		return status.InvalidArgumentError("Role cannot be nil.")
+	}
+	if r.RoleID == "" {
+		return status.InvalidArgumentError("Role ID cannot be empty.")
+	}
+	return d.h.Transaction(ctx, func(tx *db.DB) error {
+		existing, err := getCustomRole(tx, r.RoleID)
+		if err != nil {
+			return err
+		}
+		name := strings.TrimSpace(r.Name)
+		if err := validateCustomRoleName(tx, existing.GroupID, r.RoleID, name); err != nil {
+			return err
+		}
+		return tx.Exec(
+			`UPDATE CustomRoles SET name = ?, capabilities = ? WHERE role_id = ?`,
+			name, r.Capabilities&uint32(role.AllCapabilities), r.RoleID,
+		).Error
+	})
+}
+
+func (d *UserDB) DeleteCustomRole(ctx context.Context, roleID string) error {
+	if roleID == "" {
+		return status.InvalidArgumentError("Role ID cannot be empty.")
+	}
+	return d.h.Transaction(ctx, func(tx *db.DB) error {
+		row := &struct{ Count int64 }{}
+		err := tx.Raw(
+			`SELECT COUNT(*) AS count FROM UserGroups WHERE custom_role_id = ?`, roleID,
+		).Take(row).Error
+		if err != nil {
+			return err
+		}
+		// Refuse to delete roles which are still in use, rather than silently
+		// granting their members a different set of capabilities.
+		if row.Count > 0 {
+			return status.FailedPreconditionErrorf("The role is still assigned to %d group member(s).", row.Count)
+		}
+		return tx.Exec(`DELETE FROM CustomRoles WHERE role_id = ?`, roleID).Error
+	})
+}
+
 // TODO(tylerw): Remove this double read of the auth group by consolidating
 // userdb code into handlers.
 func (d *UserDB) GetAuthGroup(ctx context.Context) (*tables.Group, error) {
@@ -349,7 +558,7 @@ func (d *UserDB) InsertOrUpdateGroup(ctx context.Context, g *tables.Group) (stri
 			if err := tx.Create(&newGroup).Error; err != nil {
 				return err
 			}
-			_, err = createAPIKey(tx, groupID, newAPIKeyToken(), defaultAPIKeyLabel, defaultAPIKeyCapabilities, false /*visibleToDevelopers*/)
+			_, err = createAPIKey(tx, groupID, newAPIKeyToken(), defaultAPIKeyLabel, defaultAPIKeyCapabilities, false /*visibleToDevelopers*/, 0 /*expiryUsec*/)
 			return err
 		}
 
@@ -448,7 +657,7 @@ func (d *UserDB) GetGroupUsers(ctx context.Context, groupID string, statuses []g
 	users := make([]*grpb.GetGroupUsersResponse_GroupUser, 0)
 
 	q := query_builder.NewQuery(`
-			SELECT u.user_id, u.email, u.first_name, u.last_name, ug.membership_status, ug.role
+			SELECT u.user_id, u.email, u.first_name, u.last_name, ug.membership_status, ug.role, ug.custom_role_id
 			FROM Users AS u JOIN UserGroups AS ug`)
 	q = q.AddWhereClause(`u.user_id = ug.user_user_id AND ug.group_group_id = ?`, groupID)
 
@@ -477,7 +686,7 @@ func (d *UserDB) GetGroupUsers(ctx context.Context, groupID string, statuses []g
 		var groupRole uint32
 		err := rows.Scan(
 			&user.UserID, &user.Email, &user.FirstName, &user.LastName,
-			&groupUser.GroupMembershipStatus, &groupRole,
+			&groupUser.GroupMembershipStatus, &groupRole, &groupUser.CustomRoleId,
 		)
 		if err != nil {
 			return nil, err
@@ -490,15 +699,39 @@ func (d *UserDB) GetGroupUsers(ctx context.Context, groupID string, statuses []g
 	return users, nil
 }
 
+// groupMembershipAuditState returns a user's group membership as recorded in
+// the audit log, or nil if the user is not a member of the group.
+func groupMembershipAuditState(ug *tables.UserGroup) *grpb.GetGroupUsersResponse_GroupUser {
+	if ug == nil {
+		return nil
+	}
+	return &grpb.GetGroupUsersResponse_GroupUser{
+		GroupMembershipStatus: grpb.GroupMembershipStatus(ug.MembershipStatus),
+		Role:                  role.ToProto(role.Role(ug.Role)),
+		CustomRoleId:          ug.CustomRoleID,
+	}
+}
+
 func (d *UserDB) UpdateGroupUsers(ctx context.Context, groupID string, updates []*grpb.UpdateGroupUsersRequest_Update) error {
-	return d.h.Transaction(ctx, func(tx *db.DB) error {
+	type membershipChange struct {
+		userID        string
+		action        alpb.Action
+		before, after *grpb.GetGroupUsersResponse_GroupUser
+	}
+	var changes []*membershipChange
+	err := d.h.Transaction(ctx, func(tx *db.DB) error {
 		for _, update := range updates {
+			userID := update.GetUserId().GetId()
+			before, err := getUserGroup(tx, userID, groupID)
+			if err != nil {
+				return err
+			}
 			switch update.GetMembershipAction() {
 			case grpb.UpdateGroupUsersRequest_Update_REMOVE:
 				if err := tx.Exec(`
 						DELETE FROM UserGroups
 						WHERE user_user_id = ? AND group_group_id = ?`,
-					update.GetUserId().GetId(),
+					userID,
 					groupID).Error; err != nil {
 					return err
 				}
@@ -508,7 +741,7 @@ func (d *UserDB) UpdateGroupUsers(ctx context.Context, groupID string, updates [
 						SET membership_status = ?
 						WHERE user_user_id = ? AND group_group_id = ?`,
 					int32(grpb.GroupMembershipStatus_MEMBER),
-					update.GetUserId().GetId(),
+					userID,
 					groupID).Error; err != nil {
 					return err
 				}
@@ -518,20 +751,51 @@ func (d *UserDB) UpdateGroupUsers(ctx context.Context, groupID string, updates [
 			}
 
 			if update.Role != grpb.Group_UNKNOWN_ROLE {
+				customRoleID := ""
+				if update.Role == grpb.Group_CUSTOM_ROLE {
+					if err := checkCustomRoleInGroup(tx, update.GetCustomRoleId(), groupID); err != nil {
+						return err
+					}
+					customRoleID = update.GetCustomRoleId()
+				}
 				err := tx.Exec(`
 					UPDATE UserGroups
-					SET role = ?
+					SET role = ?, custom_role_id = ?
 					WHERE user_user_id = ? AND group_group_id = ?
-				`, role.FromProto(update.Role), update.GetUserId().GetId(), groupID,
+				`, role.FromProto(update.Role), customRoleID, userID, groupID,
 				).Error
 				if err != nil {
 					return err
 				}
 			}
 
+			after, err := getUserGroup(tx, userID, groupID)
+			if err != nil {
+				return err
+			}
+			action := alpb.Action_UPDATE
+			if after == nil {
+				action = alpb.Action_DELETE
+			}
+			changes = append(changes, &membershipChange{
+				userID: userID,
+				action: action,
+				before: groupMembershipAuditState(before),
+				after:  groupMembershipAuditState(after),
+			})
 		}
 		return nil
 	})
+	if err != nil {
+		return err
+	}
+	if al := d.env.GetAuditLogger(); al != nil {
+		for _, c := range changes {
+			resource := &alpb.ResourceID{Type: alpb.ResourceType_GROUP_MEMBERSHIP, Id: c.userID}
+			al.LogForGroup(ctx, groupID, c.action, resource, c.before, c.after)
+		}
+	}
+	return nil
 }
 
 func (d *UserDB) CreateDefaultGroup(ctx context.Context) error {
@@ -549,7 +813,7 @@ func (d *UserDB) CreateDefaultGroup(ctx context.Context) error {
 				if err := tx.Create(&c.group).Error; err != nil {
 					return err
 				}
-				if _, err := createAPIKey(tx, DefaultGroupID, c.apiKeyValue, defaultAPIKeyLabel, defaultAPIKeyCapabilities, false /*visibleToDevelopers*/); err != nil {
+				if _, err := createAPIKey(tx, DefaultGroupID, c.apiKeyValue, defaultAPIKeyLabel, defaultAPIKeyCapabilities, false /*visibleToDevelopers*/, 0 /*expiryUsec*/); err != nil {
 					return err
 				}
 				return nil
@@ -625,7 +889,7 @@ func (d *UserDB) createUser(ctx context.Context, tx *db.DB, u *tables.User) erro
 		if err := tx.Create(&sug).Error; err != nil {
 			return err
 		}
-		if _, err := createAPIKey(tx, sug.GroupID, newAPIKeyToken(), defaultAPIKeyLabel, defaultAPIKeyCapabilities, false /*visibleToDevelopers*/); err != nil {
+		if _, err := createAPIKey(tx, sug.GroupID, newAPIKeyToken(), defaultAPIKeyLabel, defaultAPIKeyCapabilities, false /*visibleToDevelopers*/, 0 /*expiryUsec*/); err != nil {
 			return err
 		}
 		groupIDs = append(groupIDs, sug.GroupID)
@@ -682,11 +946,73 @@ func (d *UserDB) createUser(ctx context.Context, tx *db.DB, u *tables.User) erro
 	return nil
 }
 
+// ProvisionedSubID returns the placeholder subscriber ID of a user that was
+// provisioned by the identity provider of the given group (e.g. over SCIM).
+// The placeholder is replaced with the user's real subscriber ID when they
+// first log in through that group's SSO.
+func ProvisionedSubID(groupID, email string) string {
+	return provisionedSubIDPrefix + groupID + "/" + strings.ToLower(email)
+}
+
+// IsProvisionedSubID returns whether the subscriber ID is the placeholder of
+// a provisioned user who has not logged in yet.
+func IsProvisionedSubID(subID string) bool {
+	return strings.HasPrefix(subID, provisionedSubIDPrefix)
+}
+
+// claimProvisionedUser links u to the account provisioned for its email
+// address by the identity provider of a group that u is logging in through.
+// Returns true and updates u to refer to the existing account if one was
+// found.
+func (d *UserDB) claimProvisionedUser(ctx context.Context, tx *db.DB, u *tables.User) (bool, error) {
+	if u.Email == "" {
+		return false, nil
+	}
+	for _, group := range u.Groups {
+		if group.Group.URLIdentifier == nil || *group.Group.URLIdentifier == "" {
+			continue
+		}
+		g, err := d.getGroupByURLIdentifier(ctx, tx, *group.Group.URLIdentifier)
+		if err != nil {
+			return false, err
+		}
+		existing := &tables.User{}
+		err = tx.Where("sub_id = ?", ProvisionedSubID(g.GroupID, u.Email)).First(existing).Error
+		if db.IsRecordNotFound(err) {
+			continue
+		}
+		if err != nil {
+			return false, err
+		}
+		err = tx.Exec(`
+			UPDATE Users
+			SET sub_id = ?, image_url = ?
+			WHERE user_id = ?
+			`, u.SubID, u.ImageURL, existing.UserID,
+		).Error
+		if err != nil {
+			return false, err
+		}
+		u.UserID = existing.UserID
+		u.FirstName = existing.FirstName
+		u.LastName = existing.LastName
+		u.Email = existing.Email
+		return true, nil
+	}
+	return false, nil
+}
+
 func (d *UserDB) InsertUser(ctx context.Context, u *tables.User) error {
 	return d.h.Transaction(ctx, func(tx *db.DB) error {
 		var existing tables.User
 		if err := tx.Where("sub_id = ?", u.SubID).First(&existing).Error; err != nil {
 			if db.IsRecordNotFound(err) {
+				// Users provisioned by their organization keep the group
+				// memberships they were provisioned with.
+				claimed, err := d.claimProvisionedUser(ctx, tx, u)
+				if err != nil || claimed {
+					return err
+				}
 				return d.createUser(ctx, tx, u)
 			}
 			return err
@@ -722,10 +1048,13 @@ func (d *UserDB) GetUser(ctx context.Context) (*tables.User, error) {
 				g.use_group_owned_executors,
 				g.saml_idp_metadata_url,
 				g.suggestion_preference,
-				ug.role
+				ug.role,
+				COALESCE(cr.capabilities, 0)
 			FROM `+"`Groups`"+` as g
 			JOIN UserGroups as ug
 			ON g.group_id = ug.group_group_id
+			LEFT JOIN CustomRoles as cr
+			ON cr.role_id = ug.custom_role_id AND cr.group_id = ug.group_group_id
 			WHERE ug.user_user_id = ? AND ug.membership_status = ?
 		`, u.GetUserID(), int32(grpb.GroupMembershipStatus_MEMBER)).Rows()
 		if err != nil {
@@ -748,6 +1077,7 @@ func (d *UserDB) GetUser(ctx context.Context) (*tables.User, error) {
 				&gr.Group.SamlIdpMetadataUrl,
 				&gr.Group.SuggestionPreference,
 				&gr.Role,
+				&gr.CustomCapabilities,
 			)
 			if err != nil {
 				return err
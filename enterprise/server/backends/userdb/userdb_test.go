@@ -3,15 +3,21 @@ package userdb_test
 import (
 	"context"
 	"testing"
+	"time"
 
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/auditlog"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/backends/authdb"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/backends/userdb"
 	"github.com/buildbuddy-io/buildbuddy/server/tables"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testauth"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/buildbuddy-io/buildbuddy/server/util/testing/flags"
 	"github.com/stretchr/testify/require"
 
+	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
+	alpb "github.com/buildbuddy-io/buildbuddy/proto/auditlog"
 	grp "github.com/buildbuddy-io/buildbuddy/proto/group"
 	grpb "github.com/buildbuddy-io/buildbuddy/proto/group"
 	uidpb "github.com/buildbuddy-io/buildbuddy/proto/user_id"
@@ -266,3 +272,197 @@ func TestUpdateGroupUsers_Role(t *testing.T) {
 	us1 := findGroupUser(t, "US1", groupUsers)
 	require.Equal(t, grpb.Group_DEVELOPER_ROLE, us1.Role, "user role should be DEVELOPER")
 }
+
+func TestUpdateGroupUsers_CustomRole(t *testing.T) {
+	env := newTestEnv(t)
+	flags.Set(t, "app.create_group_per_user", true)
+	flags.Set(t, "app.no_default_user_group", true)
+	udb := env.GetUserDB()
+	ctx := context.Background()
+
+	for _, userID := range []string{"US1", "US2"} {
+		err := udb.InsertUser(ctx, &tables.User{
+			UserID: userID,
+			SubID:  "SubID-" + userID,
+			Email:  userID + "@org.io",
+		})
+		require.NoError(t, err)
+	}
+	ctx1 := authUserCtx(ctx, env, t, "US1")
+	u, err := udb.GetUser(ctx1)
+	require.NoError(t, err)
+	us1Group := u.Groups[0].Group
+	ctx2 := authUserCtx(ctx, env, t, "US2")
+	u, err = udb.GetUser(ctx2)
+	require.NoError(t, err)
+	us2Group := u.Groups[0].Group
+
+	viewer, err := udb.CreateCustomRole(ctx1, us1Group.GroupID, "Viewer", role.ViewInvocations|role.ViewUsage)
+	require.NoError(t, err)
+	_, err = udb.CreateCustomRole(ctx1, us1Group.GroupID, "Viewer", role.ViewInvocations)
+	require.True(t, status.IsAlreadyExistsError(err), "duplicate role names should be rejected: %s", err)
+	otherRole, err := udb.CreateCustomRole(ctx2, us2Group.GroupID, "Other", role.AllCapabilities)
+	require.NoError(t, err)
+
+	// Assigning a custom role requires the role to belong to the group.
+	err = udb.UpdateGroupUsers(ctx1, us1Group.GroupID, []*grpb.UpdateGroupUsersRequest_Update{{
+		UserId:       &uidpb.UserId{Id: "US1"},
+		Role:         grpb.Group_CUSTOM_ROLE,
+		CustomRoleId: otherRole.RoleID,
+	}})
+	require.True(t, status.IsNotFoundError(err), "expected NotFound, got %s", err)
+	err = udb.UpdateGroupUsers(ctx1, us1Group.GroupID, []*grpb.UpdateGroupUsersRequest_Update{{
+		UserId: &uidpb.UserId{Id: "US1"},
+		Role:   grpb.Group_CUSTOM_ROLE,
+	}})
+	require.True(t, status.IsInvalidArgumentError(err), "expected InvalidArgument, got %s", err)
+
+	err = udb.UpdateGroupUsers(ctx1, us1Group.GroupID, []*grpb.UpdateGroupUsersRequest_Update{{
+		UserId:       &uidpb.UserId{Id: "US1"},
+		Role:         grpb.Group_CUSTOM_ROLE,
+		CustomRoleId: viewer.RoleID,
+	}})
+	require.NoError(t, err)
+
+	groupUsers, err := udb.GetGroupUsers(ctx1, us1Group.GroupID, []grp.GroupMembershipStatus{grp.GroupMembershipStatus_MEMBER})
+	require.NoError(t, err)
+	us1 := findGroupUser(t, "US1", groupUsers)
+	require.Equal(t, grpb.Group_CUSTOM_ROLE, us1.Role)
+	require.Equal(t, viewer.RoleID, us1.CustomRoleId)
+
+	u, err = udb.GetUser(ctx1)
+	require.NoError(t, err)
+	require.Len(t, u.Groups, 1)
+	caps := role.Capabilities(role.Role(u.Groups[0].Role), role.Capability(u.Groups[0].CustomCapabilities))
+	require.Equal(t, role.ViewInvocations|role.ViewUsage, caps)
+
+	// Roles which are still assigned can't be deleted, but updates are
+	// reflected in the members' capabilities.
+	err = udb.DeleteCustomRole(ctx1, viewer.RoleID)
+	require.True(t, status.IsFailedPreconditionError(err), "expected FailedPrecondition, got %s", err)
+	err = udb.UpdateCustomRole(ctx1, &tables.CustomRole{
+		RoleID:       viewer.RoleID,
+		Name:         "Read-only viewer",
+		Capabilities: uint32(role.ViewInvocations),
+	})
+	require.NoError(t, err)
+	u, err = udb.GetUser(ctx1)
+	require.NoError(t, err)
+	require.Equal(t, uint32(role.ViewInvocations), u.Groups[0].CustomCapabilities)
+
+	// Switching back to a built-in role clears the custom role.
+	err = udb.UpdateGroupUsers(ctx1, us1Group.GroupID, []*grpb.UpdateGroupUsersRequest_Update{{
+		UserId: &uidpb.UserId{Id: "US1"},
+		Role:   grpb.Group_ADMIN_ROLE,
+	}})
+	require.NoError(t, err)
+	err = udb.DeleteCustomRole(ctx1, viewer.RoleID)
+	require.NoError(t, err)
+
+	roles, err := udb.GetCustomRoles(ctx1, us1Group.GroupID)
+	require.NoError(t, err)
+	require.Empty(t, roles)
+}
+
+func TestUpdateGroupUsers_AuditLog(t *testing.T) {
+	env := newTestEnv(t)
+	flags.Set(t, "app.create_group_per_user", true)
+	flags.Set(t, "app.no_default_user_group", true)
+	al := auditlog.New(env, env.GetDBHandle())
+	env.SetAuditLogger(al)
+	udb := env.GetUserDB()
+	ctx := context.Background()
+
+	err := udb.InsertUser(ctx, &tables.User{UserID: "US1", SubID: "SubID1", Email: "user1@org1.io"})
+	require.NoError(t, err)
+	ctx1 := authUserCtx(ctx, env, t, "US1")
+	u, err := udb.GetUser(ctx1)
+	require.NoError(t, err)
+	us1Group := u.Groups[0].Group
+
+	err = udb.UpdateGroupUsers(ctx1, us1Group.GroupID, []*grpb.UpdateGroupUsersRequest_Update{{
+		UserId: &uidpb.UserId{Id: "US1"},
+		Role:   grpb.Group_DEVELOPER_ROLE,
+	}})
+	require.NoError(t, err)
+
+	rsp, err := al.GetLogs(ctx1, us1Group.GroupID, &alpb.GetAuditLogRequest{ResourceType: alpb.ResourceType_GROUP_MEMBERSHIP})
+	require.NoError(t, err)
+	require.Len(t, rsp.GetEntry(), 1)
+	e := rsp.GetEntry()[0]
+	require.Equal(t, alpb.Action_UPDATE, e.GetAction())
+	require.Equal(t, "US1", e.GetResource().GetId())
+	require.Equal(t, "US1", e.GetActor().GetUserId())
+	require.Contains(t, e.GetBeforeJson(), "ADMIN_ROLE")
+	require.Contains(t, e.GetAfterJson(), "DEVELOPER_ROLE")
+}
+
+func getAPIKey(t *testing.T, env *testenv.TestEnv, apiKeyID string) *tables.APIKey {
+	k := &tables.APIKey{}
+	err := env.GetDBHandle().DB(context.Background()).Raw(`SELECT * FROM APIKeys WHERE api_key_id = ?`, apiKeyID).Take(k).Error
+	require.NoError(t, err)
+	return k
+}
+
+func TestCreateAPIKey_Expiry(t *testing.T) {
+	env := newTestEnv(t)
+	udb := env.GetUserDB()
+	ctx := context.Background()
+	caps := []akpb.ApiKey_Capability{akpb.ApiKey_CACHE_WRITE_CAPABILITY}
+
+	_, err := udb.CreateAPIKey(ctx, "GR1", "expired", caps, false /*=visibleToDevelopers*/, time.Now().Add(-time.Hour).UnixMicro())
+	require.True(t, status.IsInvalidArgumentError(err), "expected InvalidArgument, got %v", err)
+
+	expiryUsec := time.Now().Add(time.Hour).UnixMicro()
+	k, err := udb.CreateAPIKey(ctx, "GR1", "expiring", caps, false /*=visibleToDevelopers*/, expiryUsec)
+	require.NoError(t, err)
+	require.Equal(t, expiryUsec, getAPIKey(t, env, k.APIKeyID).ExpiryUsec)
+}
+
+func TestRotateAPIKey(t *testing.T) {
+	env := newTestEnv(t)
+	udb := env.GetUserDB()
+	adb := authdb.NewAuthDB(env, env.GetDBHandle())
+	ctx := context.Background()
+	err := env.GetDBHandle().DB(ctx).Create(&tables.Group{GroupID: "GR1"}).Error
+	require.NoError(t, err)
+
+	caps := []akpb.ApiKey_Capability{akpb.ApiKey_CACHE_WRITE_CAPABILITY}
+	old, err := udb.CreateAPIKey(ctx, "GR1", "CI", caps, true /*=visibleToDevelopers*/, 0 /*=expiryUsec*/)
+	require.NoError(t, err)
+
+	start := time.Now()
+	rotated, err := udb.RotateAPIKey(ctx, old.APIKeyID, time.Hour, 0 /*=expiryUsec*/)
+	require.NoError(t, err)
+	require.NotEqual(t, old.APIKeyID, rotated.APIKeyID)
+	require.NotEqual(t, old.Value, rotated.Value)
+	require.Equal(t, "GR1", rotated.GroupID)
+	require.Equal(t, "CI", rotated.Label)
+	require.Equal(t, old.Capabilities, rotated.Capabilities)
+	require.True(t, rotated.VisibleToDevelopers)
+
+	// The old key keeps working until the end of the grace period.
+	oldExpiry := time.UnixMicro(getAPIKey(t, env, old.APIKeyID).ExpiryUsec)
+	require.WithinDuration(t, start.Add(time.Hour), oldExpiry, time.Minute)
+	_, err = adb.GetAPIKeyGroupFromAPIKey(ctx, old.Value)
+	require.NoError(t, err)
+	akg, err := adb.GetAPIKeyGroupFromAPIKey(ctx, rotated.Value)
+	require.NoError(t, err)
+	require.Equal(t, "GR1", akg.GetGroupID())
+	require.Zero(t, akg.GetExpiryUsec())
+	require.Zero(t, getAPIKey(t, env, rotated.APIKeyID).LastUsedUsec)
+	err = adb.FlushAPIKeyUsage(ctx)
+	require.NoError(t, err)
+	require.NotZero(t, getAPIKey(t, env, rotated.APIKeyID).LastUsedUsec)
+
+	// Rotating with no grace period expires the old key immediately.
+	again, err := udb.RotateAPIKey(ctx, rotated.APIKeyID, 0, 0 /*=expiryUsec*/)
+	require.NoError(t, err)
+	_, err = adb.GetAPIKeyGroupFromAPIKey(ctx, rotated.Value)
+	require.True(t, status.IsUnauthenticatedError(err), "expected Unauthenticated, got %v", err)
+	_, err = adb.GetAPIKeyGroupFromAPIKey(ctx, again.Value)
+	require.NoError(t, err)
+
+	_, err = udb.RotateAPIKey(ctx, "AK404", time.Hour, 0 /*=expiryUsec*/)
+	require.True(t, status.IsNotFoundError(err), "expected NotFound, got %v", err)
+}
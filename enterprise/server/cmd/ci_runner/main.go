@@ -20,6 +20,7 @@ import (
 
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/auth"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/build_event_publisher"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/webhooks/webhook_data"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/workflow/config"
 	"github.com/buildbuddy-io/buildbuddy/server/real_environment"
 	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/cachetools"
@@ -31,6 +32,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/util/healthcheck"
 	"github.com/buildbuddy-io/buildbuddy/server/util/lockingbuffer"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
+	"github.com/buildbuddy-io/buildbuddy/server/util/redact"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/creack/pty"
 	"github.com/google/shlex"
@@ -76,6 +78,9 @@ const (
 	// Exit code placeholder used when a command doesn't return an exit code on its own.
 	noExitCode         = -1
 	failedExitCodeName = "Failed"
+	// Exit code name used when an action is skipped because none of the
+	// changed files match its path filters.
+	skippedExitCodeName = "Skipped"
 
 	// progressFlushInterval specifies how often we should flush
 	// each Bazel command's output while it is running.
@@ -118,6 +123,7 @@ var (
 	patchDigests       = flagutil.New("patch_digest", []string{}, "Digests of patches to apply to the repo after checkout. Can be specified multiple times to apply multiple patches.")
 	recordRunMetadata  = flag.Bool("record_run_metadata", false, "Instead of running a target, extract metadata about it and report it in the build event stream.")
 	gitCleanExclude    = flagutil.New("git_clean_exclude", []string{}, "Directories to exclude from `git clean` while setting up the repo.")
+	secretEnvVars      = flagutil.New("secret_env_var", []string{}, "Names of env vars whose values are secrets, which are redacted from the action logs.")
 
 	shutdownAndExit = flag.Bool("shutdown_and_exit", false, "If set, runs bazel shutdown with the configured bazel_command, and exits. No other commands are run.")
 
@@ -213,7 +219,7 @@ func newBuildEventReporter(ctx context.Context, besBackend string, apiKey string
 		return nil, status.UnavailableErrorf("failed to initialize build event publisher: %s", err)
 	}
 	bep.Start(ctx)
-	return &buildEventReporter{apiKey: apiKey, bep: bep, log: newInvocationLog(), invocationID: iid, isWorkflow: isWorkflow}, nil
+	return &buildEventReporter{apiKey: apiKey, bep: bep, log: newInvocationLog(secretValues()), invocationID: iid, isWorkflow: isWorkflow}, nil
 }
 
 func (r *buildEventReporter) InvocationID() string {
@@ -384,6 +390,7 @@ func (r *buildEventReporter) nextProgressEvent() (*bespb.BuildEvent, error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	r.log.flushPending()
 	buf, err := r.log.ReadAll()
 	if err != nil {
 		return nil, status.WrapError(err, "failed to read action logs")
@@ -578,6 +585,16 @@ func run() error {
 		return status.InvalidArgumentError("One of --action or --bazel_sub_command must be specified.")
 	}
 
+	skip, err := ws.shouldSkipAction(ctx, action)
+	if err != nil {
+		_ = buildEventReporter.Stop(noExitCode, failedExitCodeName)
+		return err
+	}
+	if skip {
+		buildEventReporter.Printf("Skipping action %q: no changed files match its path filters.\n", action.Name)
+		return buildEventReporter.Stop(0, skippedExitCodeName)
+	}
+
 	result, err := ws.RunAction(ctx, action, buildEventReporter)
 	if err != nil {
 		return err
@@ -639,22 +656,78 @@ func (r *buildEventReporter) Printf(format string, vals ...interface{}) {
 	r.log.Printf(format, vals...)
 }
 
+// secretValues returns the values of the env vars passed via
+// --secret_env_var.
+func secretValues() []string {
+	var values []string
+	for _, name := range *secretEnvVars {
+		if v := os.Getenv(name); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
 type invocationLog struct {
 	lockingbuffer.LockingBuffer
 	writer        io.Writer
 	writeListener func()
+
+	// secrets are redacted from the log. Since a secret may be split across
+	// writes (and may itself span multiple lines), the last holdback bytes of
+	// redacted output are held in pending until more output arrives or the
+	// log is flushed, so that a partially written secret is never emitted.
+	secrets  []string
+	holdback int
+	mu       sync.Mutex // protects(pending)
+	pending  []byte
 }
 
-func newInvocationLog() *invocationLog {
-	invLog := &invocationLog{writeListener: func() {}}
+func newInvocationLog(secrets []string) *invocationLog {
+	invLog := &invocationLog{writeListener: func() {}, secrets: secrets}
+	for _, s := range secrets {
+		if len(s)-1 > invLog.holdback {
+			invLog.holdback = len(s) - 1
+		}
+	}
 	invLog.writer = io.MultiWriter(&invLog.LockingBuffer, os.Stderr)
 	return invLog
 }
 
 func (invLog *invocationLog) Write(b []byte) (int, error) {
-	n, err := invLog.writer.Write(b)
+	if len(invLog.secrets) == 0 {
+		n, err := invLog.writer.Write(b)
+		invLog.writeListener()
+		return n, err
+	}
+	invLog.mu.Lock()
+	// Redact the pending output together with the new output, then write
+	// everything except for a tail which may hold the beginning of a secret.
+	invLog.pending = []byte(redact.RedactSecrets(string(append(invLog.pending, b...)), invLog.secrets))
+	var err error
+	if n := len(invLog.pending) - invLog.holdback; n > 0 {
+		_, err = invLog.writer.Write(invLog.pending[:n])
+		invLog.pending = append([]byte{}, invLog.pending[n:]...)
+	}
+	invLog.mu.Unlock()
 	invLog.writeListener()
-	return n, err
+	if err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// flushPending writes any output held back for redaction.
+func (invLog *invocationLog) flushPending() {
+	invLog.mu.Lock()
+	defer invLog.mu.Unlock()
+	if len(invLog.pending) == 0 {
+		return
+	}
+	if _, err := invLog.writer.Write(invLog.pending); err != nil {
+		log.Warningf("Failed to write action logs: %s", err)
+	}
+	invLog.pending = nil
 }
 
 func (invLog *invocationLog) Println(vals ...interface{}) {
@@ -746,7 +819,9 @@ func (ar *actionRunner) Run(ctx context.Context, ws *workspace) error {
 	buildMetadata := &bespb.BuildMetadata{
 		Metadata: map[string]string{},
 	}
-	if ar.isWorkflow {
+	if ar.isWorkflow && *triggerEvent == webhook_data.EventName.Schedule {
+		buildMetadata.Metadata["ROLE"] = "CI_RUNNER_SCHEDULED"
+	} else if ar.isWorkflow {
 		buildMetadata.Metadata["ROLE"] = "CI_RUNNER"
 	} else {
 		buildMetadata.Metadata["ROLE"] = "HOSTED_BAZEL"
@@ -1411,6 +1486,43 @@ func (ws *workspace) sync(ctx context.Context) error {
 	return nil
 }
 
+// shouldSkipAction returns whether the action should be skipped because none of
+// the files changed by the pull request match its path filters. Push events are
+// filtered by the workflow service when the webhook payload lists the changed
+// files, and always run otherwise, so they are never skipped here.
+func (ws *workspace) shouldSkipAction(ctx context.Context, action *config.Action) (bool, error) {
+	if ws.setupError != nil || *triggerEvent != webhook_data.EventName.PullRequest || !config.HasPathFilters(action, *triggerEvent) {
+		return false, nil
+	}
+	changedFiles, err := ws.changedFiles(ctx)
+	if err != nil {
+		return false, err
+	}
+	match, err := config.MatchesChangedPaths(action, *triggerEvent, changedFiles)
+	if err != nil {
+		return false, err
+	}
+	return !match, nil
+}
+
+// changedFiles returns the files changed on the checked out branch since it
+// diverged from the target branch.
+func (ws *workspace) changedFiles(ctx context.Context) ([]string, error) {
+	targetRef := fmt.Sprintf("%s/%s", gitRemoteName(*targetRepoURL), *targetBranch)
+	var buf bytes.Buffer
+	args := []string{"diff", "--name-only", targetRef + "...HEAD"}
+	if err := runCommand(ctx, "git", args, map[string]string{} /*=env*/, "" /*=dir*/, &buf); err != nil {
+		return nil, status.UnknownErrorf("Command `git %s` failed: %s", strings.Join(args, " "), buf.String())
+	}
+	var files []string
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if f := strings.TrimSpace(line); f != "" {
+			files = append(files, f)
+		}
+	}
+	return files, nil
+}
+
 func (ws *workspace) config(ctx context.Context) error {
 	cfg := [][]string{
 		{"user.email", "ci-runner@buildbuddy.io"},
@@ -6,6 +6,7 @@ import (
 	"io/fs"
 
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/api"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/auditlog"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/auth"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/backends/authdb"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/backends/distributed"
@@ -29,6 +30,8 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/saml"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/scheduling/scheduler_server"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/scheduling/task_router"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/scim"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/secrets"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/selfauth"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/splash"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/tasksize"
@@ -36,6 +39,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/usage_service"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/webhooks/bitbucket"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/webhooks/github"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/webhooks/gitlab"
 	"github.com/buildbuddy-io/buildbuddy/server/config"
 	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
 	"github.com/buildbuddy-io/buildbuddy/server/janitor"
@@ -103,7 +107,7 @@ func configureFilesystemsOrDie(realEnv *real_environment.RealEnv) {
 // source main() entry point and the enterprise main() entry point, both of
 // which import from libmain.go.
 func convertToProdOrDie(ctx context.Context, env *real_environment.RealEnv) {
-	env.SetAuthDB(authdb.NewAuthDB(env.GetDBHandle()))
+	env.SetAuthDB(authdb.NewAuthDB(env, env.GetDBHandle()))
 	configureFilesystemsOrDie(env)
 
 	if err := auth.Register(ctx, env); err != nil {
@@ -139,11 +143,25 @@ func convertToProdOrDie(ctx context.Context, env *real_environment.RealEnv) {
 		log.Fatalf("%v", err)
 	}
 
+	if err := secrets.Register(env); err != nil {
+		log.Fatalf("%v", err)
+	}
+
+	if err := auditlog.Register(env); err != nil {
+		log.Fatalf("%v", err)
+	}
+
 	workflowService := workflow.NewWorkflowService(env)
 	env.SetWorkflowService(workflowService)
+	workflowService.StartScheduler()
+	env.GetHealthChecker().RegisterShutdownFunction(func(ctx context.Context) error {
+		workflowService.StopScheduler()
+		return workflowService.StopActionGraphs(ctx)
+	})
 	env.SetGitProviders([]interfaces.GitProvider{
 		github.NewProvider(),
 		bitbucket.NewProvider(),
+		gitlab.NewProvider(),
 	})
 
 	runnerService, err := hostedrunner.New(env)
@@ -265,5 +283,9 @@ func main() {
 		log.Fatalf("%v", err)
 	}
 
+	if err := scim.Register(realEnv); err != nil {
+		log.Fatalf("%v", err)
+	}
+
 	libmain.StartAndRunServices(realEnv) // Returns after graceful shutdown
 }
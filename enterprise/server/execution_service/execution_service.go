@@ -16,6 +16,7 @@ import (
 	"google.golang.org/protobuf/types/known/timestamppb"
 
 	espb "github.com/buildbuddy-io/buildbuddy/proto/execution_stats"
+	pgpb "github.com/buildbuddy-io/buildbuddy/proto/pagination"
 	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
 	statuspb "google.golang.org/genproto/googleapis/rpc/status"
 )
@@ -41,7 +42,7 @@ func (es *ExecutionService) queryExecutions(ctx context.Context, baseQuery *quer
 	dbh := es.env.GetDBHandle()
 	q := baseQuery
 
-	permClauses, err := perms.GetPermissionsCheckClauses(ctx, es.env, q, "e")
+	permClauses, err := perms.GetInvocationPermissionsCheckClauses(ctx, es.env, q, "e")
 	if err != nil {
 		return nil, err
 	}
@@ -108,6 +109,20 @@ func (es *ExecutionService) getInvocationExecutions(ctx context.Context, invocat
 	return e, nil
 }
 
+// getInvocationExecutionsPage returns the given page of the invocation's
+// executions, ordered by creation time.
+func (es *ExecutionService) getInvocationExecutionsPage(ctx context.Context, invocationID string, page *pgpb.OffsetLimit) ([]tables.Execution, error) {
+	q := query_builder.NewQuery(`
+		SELECT e.* FROM Executions e
+		JOIN Invocations i ON i.invocation_id = e.invocation_id`)
+	q.AddWhereClause(`e.invocation_id = ?`, invocationID)
+	// Break ties by execution ID so that the order is stable across pages.
+	q.SetOrderBy(`e.created_at_usec ASC, e.execution_id`, true /*=ascending*/)
+	q.SetLimit(page.GetLimit())
+	q.SetOffset(page.GetOffset())
+	return es.queryExecutions(ctx, q)
+}
+
 func tableExecToProto(in tables.Execution) (*espb.Execution, error) {
 	r, err := digest.ParseDownloadResourceName(in.ExecutionID)
 	if err != nil {
@@ -128,6 +143,7 @@ func tableExecToProto(in tables.Execution) (*espb.Execution, error) {
 	}
 
 	out := &espb.Execution{
+		ExecutionId:        in.ExecutionID,
 		ActionDigest:       r.GetDigest(),
 		ActionResultDigest: actionResultDigest,
 		Status: &statuspb.Status{
@@ -138,6 +154,7 @@ func tableExecToProto(in tables.Execution) (*espb.Execution, error) {
 		Stage:    repb.ExecutionStage_Value(in.Stage),
 		ExecutedActionMetadata: &repb.ExecutedActionMetadata{
 			Worker:                         in.Worker,
+			ExecutorId:                     in.ExecutorID,
 			QueuedTimestamp:                timestamppb.New(time.UnixMicro(in.QueuedTimestampUsec)),
 			WorkerStartTimestamp:           timestamppb.New(time.UnixMicro(in.WorkerStartTimestampUsec)),
 			WorkerCompletedTimestamp:       timestamppb.New(time.UnixMicro(in.WorkerCompletedTimestampUsec)),
@@ -173,14 +190,27 @@ func (es *ExecutionService) GetExecution(ctx context.Context, req *espb.GetExecu
 	if err := checkPreconditions(req); err != nil {
 		return nil, err
 	}
-	executions, err := es.getInvocationExecutions(ctx, req.GetExecutionLookup().GetInvocationId())
-	if err != nil {
-		return nil, err
+	var executions []tables.Execution
+	if page := req.GetPage(); page != nil {
+		if page.GetOffset() < 0 || page.GetLimit() <= 0 {
+			return nil, status.InvalidArgumentError("invalid page")
+		}
+		e, err := es.getInvocationExecutionsPage(ctx, req.GetExecutionLookup().GetInvocationId(), page)
+		if err != nil {
+			return nil, err
+		}
+		executions = e
+	} else {
+		e, err := es.getInvocationExecutions(ctx, req.GetExecutionLookup().GetInvocationId())
+		if err != nil {
+			return nil, err
+		}
+		executions = e
+		// Sort the executions by start time.
+		sort.Slice(executions, func(i, j int) bool {
+			return executions[i].Model.CreatedAtUsec < executions[j].Model.CreatedAtUsec
+		})
 	}
-	// Sort the executions by start time.
-	sort.Slice(executions, func(i, j int) bool {
-		return executions[i].Model.CreatedAtUsec < executions[j].Model.CreatedAtUsec
-	})
 	rsp := &espb.GetExecutionResponse{}
 	for _, execution := range executions {
 		protoExec, err := tableExecToProto(execution)
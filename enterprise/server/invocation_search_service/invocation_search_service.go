@@ -77,26 +77,6 @@ func (s *InvocationSearchService) checkPreconditions(req *inpb.SearchInvocationR
 	return nil
 }
 
-// TODO(tylerw): move this to a common place -- we'll use it a bunch.
-func addPermissionsCheckToQuery(u interfaces.UserInfo, q *query_builder.Query) {
-	o := query_builder.OrClauses{}
-	o.AddOr("(i.perms & ? != 0)", perms.OTHERS_READ)
-	groupArgs := []interface{}{
-		perms.GROUP_READ,
-	}
-	groupParams := make([]string, 0)
-	for _, g := range u.GetGroupMemberships() {
-		groupArgs = append(groupArgs, g.GroupID)
-		groupParams = append(groupParams, "?")
-	}
-	groupParamString := "(" + strings.Join(groupParams, ", ") + ")"
-	groupQueryStr := fmt.Sprintf("(i.perms & ? != 0 AND i.group_id IN %s)", groupParamString)
-	o.AddOr(groupQueryStr, groupArgs...)
-	o.AddOr("(i.perms & ? != 0 AND i.user_id = ?)", perms.OWNER_READ, u.GetUserID())
-	orQuery, orArgs := o.Build()
-	q = q.AddWhereClause("("+orQuery+")", orArgs...)
-}
-
 func (s *InvocationSearchService) QueryInvocations(ctx context.Context, req *inpb.SearchInvocationRequest) (*inpb.SearchInvocationResponse, error) {
 	if err := s.checkPreconditions(req); err != nil {
 		return nil, err
@@ -169,7 +149,9 @@ func (s *InvocationSearchService) QueryInvocations(ctx context.Context, req *inp
 	}
 
 	// Always add permissions check.
-	addPermissionsCheckToQuery(u, q)
+	if err := perms.AddInvocationPermissionsCheckToQueryWithTableAlias(ctx, s.env, q, "i"); err != nil {
+		return nil, err
+	}
 
 	sort := defaultSortParams()
 	if req.Sort != nil && req.Sort.SortField != inpb.InvocationSort_UNKNOWN_SORT_FIELD {
@@ -17,13 +17,16 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/util/alert"
 	"github.com/buildbuddy-io/buildbuddy/server/util/db"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
+	"github.com/buildbuddy-io/buildbuddy/server/util/perms"
 	"github.com/buildbuddy-io/buildbuddy/server/util/query_builder"
 	"github.com/buildbuddy-io/buildbuddy/server/util/quota"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/throttled/throttled/v2"
 	"github.com/throttled/throttled/v2/store/goredisstore.v8"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
 
+	alpb "github.com/buildbuddy-io/buildbuddy/proto/auditlog"
 	qpb "github.com/buildbuddy-io/buildbuddy/proto/quota"
 )
 
@@ -64,7 +67,8 @@ func bucketToProto(from *tables.QuotaBucket) *qpb.Bucket {
 			NumRequests: from.NumRequests,
 			Period:      durationpb.New(time.Duration(from.PeriodDurationUsec) * time.Microsecond),
 		},
-		MaxBurst: from.MaxBurst,
+		MaxBurst:    from.MaxBurst,
+		MaxQuantity: from.MaxQuantity,
 	}
 	return res
 }
@@ -76,6 +80,7 @@ func bucketToRow(namespace string, from *qpb.Bucket) *tables.QuotaBucket {
 		NumRequests:        from.GetMaxRate().GetNumRequests(),
 		PeriodDurationUsec: int64(from.GetMaxRate().GetPeriod().AsDuration() / time.Microsecond),
 		MaxBurst:           from.GetMaxBurst(),
+		MaxQuantity:        from.GetMaxQuantity(),
 	}
 	return res
 }
@@ -181,6 +186,13 @@ func validateBucket(bucket *qpb.Bucket) error {
 	if bucket.GetName() == "" {
 		return status.InvalidArgumentError("bucket.name cannot be empty")
 	}
+	if quantity := bucket.GetMaxQuantity(); quantity != 0 {
+		if quantity < 0 {
+			return status.InvalidArgumentErrorf("bucket.max_quantity(%d) must be non-negative", quantity)
+		}
+		// Buckets that limit a total quantity have no rate.
+		return nil
+	}
 	if num := bucket.GetMaxRate().GetNumRequests(); num <= 0 || num > math.MaxInt {
 		return status.InvalidArgumentErrorf("bucket.max_rate.num_requests(%d) must be positive and less than %d", num, math.MaxInt)
 	}
@@ -246,6 +258,21 @@ func createGCRABucket(env environment.Env, config *tables.QuotaBucket) (Bucket,
 	return bucket, nil
 }
 
+// totalBucket limits the total quantity, like the number of stored bytes,
+// that a key may hold at once. Callers pass the total quantity the key would
+// hold to Allow; nothing is recorded.
+type totalBucket struct {
+	config *tables.QuotaBucket
+}
+
+func (b *totalBucket) Config() tables.QuotaBucket {
+	return *b.config
+}
+
+func (b *totalBucket) Allow(ctx context.Context, key string, quantity int64) (bool, error) {
+	return quantity <= b.config.MaxQuantity, nil
+}
+
 type namespace struct {
 	name   string
 	config *namespaceConfig
@@ -284,6 +311,13 @@ func newQuotaManager(env environment.Env, ps interfaces.PubSub, bucketCreator bu
 	return qm, nil
 }
 
+func (qm *QuotaManager) createBucket(env environment.Env, config *tables.QuotaBucket) (Bucket, error) {
+	if config.MaxQuantity > 0 {
+		return &totalBucket{config: config}, nil
+	}
+	return qm.bucketCreator(env, config)
+}
+
 func (qm *QuotaManager) createNamespace(env environment.Env, name string, config *namespaceConfig) (*namespace, error) {
 	ns := &namespace{
 		name:         name,
@@ -292,7 +326,7 @@ func (qm *QuotaManager) createNamespace(env environment.Env, name string, config
 	}
 	defaultAssignedBucket := config.assignedBuckets[defaultBucketName]
 	if defaultAssignedBucket != nil {
-		defaultBucket, err := qm.bucketCreator(env, defaultAssignedBucket.bucket)
+		defaultBucket, err := qm.createBucket(env, defaultAssignedBucket.bucket)
 		if err != nil {
 			return nil, err
 		}
@@ -300,7 +334,7 @@ func (qm *QuotaManager) createNamespace(env environment.Env, name string, config
 	}
 
 	for _, assignedBucket := range config.assignedBuckets {
-		bucket, err := qm.bucketCreator(env, assignedBucket.bucket)
+		bucket, err := qm.createBucket(env, assignedBucket.bucket)
 		if err != nil {
 			return nil, err
 		}
@@ -370,12 +404,75 @@ func (qm *QuotaManager) GetNamespace(ctx context.Context, req *qpb.GetNamespaceR
 	return res, nil
 }
 
+// auditLog records a change to the quota configuration in the audit log of
+// each of the affected groups, if audit logging is enabled. Changes which don't
+// affect any particular group (e.g. to the default bucket, or to quota keys
+// that are IP addresses) are recorded in the authenticated user's group.
+func (qm *QuotaManager) auditLog(ctx context.Context, groupIDs []string, action alpb.Action, resource *alpb.ResourceID, before, after proto.Message) {
+	al := qm.env.GetAuditLogger()
+	if al == nil {
+		return
+	}
+	if len(groupIDs) == 0 {
+		u, err := perms.AuthenticatedUser(ctx, qm.env)
+		if err != nil {
+			log.Warningf("Could not record quota change in audit log: %s", err)
+			return
+		}
+		groupIDs = []string{u.GetGroupID()}
+	}
+	for _, groupID := range groupIDs {
+		al.LogForGroup(ctx, groupID, action, resource, before, after)
+	}
+}
+
+// quotaKeyGroupID returns the group ID for the given quota key, or "" if the
+// key is an IP address rather than a group ID.
+func quotaKeyGroupID(quotaKey string) string {
+	if net.ParseIP(quotaKey) != nil {
+		return ""
+	}
+	return quotaKey
+}
+
+// affectedGroupIDs returns the IDs of the groups that are assigned to the given
+// bucket in the namespace, or to any bucket in the namespace if bucketName is
+// empty.
+func affectedGroupIDs(tx *db.DB, namespace string, bucketName string) ([]string, error) {
+	q := query_builder.NewQuery(`SELECT * FROM QuotaGroups`)
+	q.AddWhereClause("namespace = ?", namespace)
+	if bucketName != "" {
+		q.AddWhereClause("bucket_name = ?", bucketName)
+	}
+	queryStr, args := q.Build()
+	var rows []*tables.QuotaGroup
+	if err := tx.Raw(queryStr, args...).Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	groupIDs := make([]string, 0, len(rows))
+	for _, row := range rows {
+		if groupID := quotaKeyGroupID(row.QuotaKey); groupID != "" {
+			groupIDs = append(groupIDs, groupID)
+		}
+	}
+	return groupIDs, nil
+}
+
+func bucketAuditResource(namespace, bucketName string) *alpb.ResourceID {
+	return &alpb.ResourceID{Type: alpb.ResourceType_QUOTA_BUCKET, Id: namespace + "/" + bucketName, Name: bucketName}
+}
+
 func (qm *QuotaManager) RemoveNamespace(ctx context.Context, req *qpb.RemoveNamespaceRequest) (*qpb.RemoveNamespaceResponse, error) {
 	if qm.env.GetDBHandle() == nil {
 		return nil, status.FailedPreconditionError("database not configured")
 	}
+	var groupIDs []string
 	err := qm.env.GetDBHandle().TransactionWithOptions(ctx, db.Opts().WithQueryName("query_manager_insert_buckets"), func(tx *db.DB) error {
 		ns := req.GetNamespace()
+		var err error
+		if groupIDs, err = affectedGroupIDs(tx, ns, ""); err != nil {
+			return err
+		}
 		if err := tx.Exec(`DELETE FROM QuotaGroups WHERE namespace = ?`, ns).Error; err != nil {
 			return err
 		}
@@ -387,6 +484,7 @@ func (qm *QuotaManager) RemoveNamespace(ctx context.Context, req *qpb.RemoveName
 	if err != nil {
 		return nil, err
 	}
+	qm.auditLog(ctx, groupIDs, alpb.Action_DELETE, &alpb.ResourceID{Type: alpb.ResourceType_QUOTA_NAMESPACE, Id: req.GetNamespace()}, nil, nil)
 	qm.notifyListeners()
 	return &qpb.RemoveNamespaceResponse{}, nil
 }
@@ -418,6 +516,7 @@ func (qm *QuotaManager) ApplyBucket(ctx context.Context, req *qpb.ApplyBucketReq
 		return nil, status.InvalidArgumentError("quota key is empty")
 	}
 
+	previousBucketName := defaultBucketName
 	dbh := qm.env.GetDBHandle()
 	err := dbh.TransactionWithOptions(ctx, db.Opts().WithQueryName("apply_bucket"), func(tx *db.DB) error {
 		row := &struct{ Count int64 }{}
@@ -446,6 +545,7 @@ func (qm *QuotaManager) ApplyBucket(ctx context.Context, req *qpb.ApplyBucketReq
 			}
 			return err
 		}
+		previousBucketName = existing.BucketName
 		if req.GetBucketName() == defaultBucketName {
 			return tx.Exec(`DELETE FROM QuotaGroups WHERE namespace = ? AND quota_key = ?`, req.GetNamespace(), quotaKey).Error
 		} else {
@@ -455,6 +555,16 @@ func (qm *QuotaManager) ApplyBucket(ctx context.Context, req *qpb.ApplyBucketReq
 	if err != nil {
 		return nil, err
 	}
+	var groupIDs []string
+	if groupID := req.GetKey().GetGroupId(); groupID != "" {
+		groupIDs = append(groupIDs, groupID)
+	}
+	qm.auditLog(
+		ctx, groupIDs, alpb.Action_UPDATE,
+		bucketAuditResource(req.GetNamespace(), req.GetBucketName()),
+		&qpb.ApplyBucketRequest{Key: req.GetKey(), Namespace: req.GetNamespace(), BucketName: previousBucketName},
+		&qpb.ApplyBucketRequest{Key: req.GetKey(), Namespace: req.GetNamespace(), BucketName: req.GetBucketName()},
+	)
 
 	qm.notifyListeners()
 
@@ -499,7 +609,24 @@ func (qm *QuotaManager) addBucket(ctx context.Context, namespace string, bucket
 	}
 	row := bucketToRow(namespace, bucket)
 
-	return qm.env.GetDBHandle().DB(ctx).Create(&row).Error
+	if err := qm.env.GetDBHandle().DB(ctx).Create(&row).Error; err != nil {
+		return err
+	}
+	qm.auditLog(ctx, nil, alpb.Action_CREATE, bucketAuditResource(namespace, bucket.GetName()), nil, bucketToProto(row))
+	return nil
+}
+
+// getBucket returns the bucket with the given name in the namespace, or nil
+// if it doesn't exist.
+func getBucket(tx *db.DB, namespace string, bucketName string) (*tables.QuotaBucket, error) {
+	row := &tables.QuotaBucket{}
+	if err := tx.Where("namespace = ? AND name = ?", namespace, bucketName).First(row).Error; err != nil {
+		if db.IsRecordNotFound(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	return row, nil
 }
 
 func (qm *QuotaManager) updateBucket(ctx context.Context, namespace string, bucket *qpb.Bucket) error {
@@ -508,26 +635,63 @@ func (qm *QuotaManager) updateBucket(ctx context.Context, namespace string, buck
 	}
 	bucketRow := bucketToRow(namespace, bucket)
 
-	db := qm.env.GetDBHandle().DB(ctx)
-	res := db.Model(bucketRow).Where("namespace = ? AND name= ?", bucketRow.Namespace, bucketRow.Name).Updates(bucketRow)
-
-	if res.Error != nil {
-		return res.Error
+	var before *tables.QuotaBucket
+	var groupIDs []string
+	dbh := qm.env.GetDBHandle()
+	err := dbh.TransactionWithOptions(ctx, db.Opts().WithQueryName("update_bucket"), func(tx *db.DB) error {
+		var err error
+		if before, err = getBucket(tx, namespace, bucket.GetName()); err != nil {
+			return err
+		}
+		if groupIDs, err = affectedGroupIDs(tx, namespace, bucket.GetName()); err != nil {
+			return err
+		}
+		res := tx.Model(bucketRow).Where("namespace = ? AND name= ?", bucketRow.Namespace, bucketRow.Name).Updates(bucketRow)
+		if res.Error != nil {
+			return res.Error
+		}
+		if res.RowsAffected == 0 {
+			return status.InvalidArgumentErrorf("bucket %q doesn't exist", bucket.GetName())
+		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
-	if res.RowsAffected == 0 {
-		return status.InvalidArgumentErrorf("bucket %q doesn't exist", bucket.GetName())
+	var beforeProto *qpb.Bucket
+	if before != nil {
+		beforeProto = bucketToProto(before)
 	}
+	qm.auditLog(ctx, groupIDs, alpb.Action_UPDATE, bucketAuditResource(namespace, bucket.GetName()), beforeProto, bucketToProto(bucketRow))
 	return nil
 }
 
 func (qm *QuotaManager) removeBucket(ctx context.Context, namespace string, bucketName string) error {
+	var before *tables.QuotaBucket
+	var groupIDs []string
 	dbh := qm.env.GetDBHandle()
-	return dbh.TransactionWithOptions(ctx, db.Opts().WithQueryName("remove_bucket"), func(tx *db.DB) error {
+	err := dbh.TransactionWithOptions(ctx, db.Opts().WithQueryName("remove_bucket"), func(tx *db.DB) error {
+		var err error
+		if before, err = getBucket(tx, namespace, bucketName); err != nil {
+			return err
+		}
+		if groupIDs, err = affectedGroupIDs(tx, namespace, bucketName); err != nil {
+			return err
+		}
 		if err := tx.Exec(`DELETE FROM QuotaGroups WHERE namespace = ? AND bucket_name = ?`, namespace, bucketName).Error; err != nil {
 			return err
 		}
 		return tx.Exec(`DELETE FROM QuotaBuckets WHERE namespace = ? AND name = ?`, namespace, bucketName).Error
 	})
+	if err != nil {
+		return err
+	}
+	var beforeProto *qpb.Bucket
+	if before != nil {
+		beforeProto = bucketToProto(before)
+	}
+	qm.auditLog(ctx, groupIDs, alpb.Action_DELETE, bucketAuditResource(namespace, bucketName), beforeProto, nil)
+	return nil
 }
 
 func (qm *QuotaManager) reloadNamespaces() error {
@@ -5,10 +5,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/auditlog"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/backends/userdb"
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
 	"github.com/buildbuddy-io/buildbuddy/server/tables"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/pubsub"
+	"github.com/buildbuddy-io/buildbuddy/server/testutil/testauth"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
 	"github.com/buildbuddy-io/buildbuddy/server/util/query_builder"
 	"github.com/google/go-cmp/cmp"
@@ -18,6 +20,7 @@ import (
 	"google.golang.org/protobuf/testing/protocmp"
 	"google.golang.org/protobuf/types/known/durationpb"
 
+	alpb "github.com/buildbuddy-io/buildbuddy/proto/auditlog"
 	qpb "github.com/buildbuddy-io/buildbuddy/proto/quota"
 )
 
@@ -150,6 +153,87 @@ func TestQuotaManagerFindBucket(t *testing.T) {
 	}
 }
 
+func TestQuotaManagerTotalBucket(t *testing.T) {
+	env := testenv.GetTestEnv(t)
+	ctx := context.Background()
+
+	db := env.GetDBHandle().DB(ctx)
+	buckets := []*tables.QuotaBucket{
+		{
+			Namespace:   "/cache/stored_bytes",
+			Name:        "default",
+			MaxQuantity: 1000,
+		},
+		{
+			Namespace:   "/cache/stored_bytes",
+			Name:        "restricted",
+			MaxQuantity: 100,
+		},
+	}
+	quotaGroup := &tables.QuotaGroup{
+		Namespace:  "/cache/stored_bytes",
+		QuotaKey:   "GR123456",
+		BucketName: "restricted",
+	}
+	result := db.Create(&buckets)
+	require.NoError(t, result.Error)
+	result = db.Create(quotaGroup)
+	require.NoError(t, result.Error)
+
+	qm, err := newQuotaManager(env, pubsub.NewTestPubSub(), createTestBucket)
+	require.NoError(t, err)
+
+	testCases := []struct {
+		name      string
+		quotaKey  string
+		quantity  int64
+		wantAllow bool
+	}{
+		{
+			name:      "under default quantity",
+			quotaKey:  "GR0000",
+			quantity:  1000,
+			wantAllow: true,
+		},
+		{
+			name:      "over default quantity",
+			quotaKey:  "GR0000",
+			quantity:  1001,
+			wantAllow: false,
+		},
+		{
+			name:      "over restricted quantity",
+			quotaKey:  "GR123456",
+			quantity:  500,
+			wantAllow: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			bucket := qm.findBucket("/cache/stored_bytes", tc.quotaKey)
+			// Total buckets are checked without a rate limiter, so
+			// repeated checks return the same result.
+			for i := 0; i < 3; i++ {
+				allow, err := bucket.Allow(ctx, tc.quotaKey, tc.quantity)
+				require.NoError(t, err)
+				assert.Equal(t, tc.wantAllow, allow)
+			}
+		})
+	}
+}
+
+func TestValidateBucket_MaxQuantity(t *testing.T) {
+	err := validateBucket(&qpb.Bucket{Name: "default", MaxQuantity: 1e9})
+	require.NoError(t, err)
+
+	err = validateBucket(&qpb.Bucket{Name: "default", MaxQuantity: -1})
+	require.Error(t, err)
+
+	err = validateBucket(&qpb.Bucket{Name: "default"})
+	require.Error(t, err)
+}
+
 func TestGetNamespace(t *testing.T) {
 	env := testenv.GetTestEnv(t)
 	ctx := context.Background()
@@ -815,3 +899,60 @@ func TestQuotaManagerApplyBucket(t *testing.T) {
 	}
 
 }
+
+func TestAuditLog_AffectedGroups(t *testing.T) {
+	env := testenv.GetTestEnv(t)
+	env.SetAuthenticator(testauth.NewTestAuthenticator(testauth.TestUsers("US1", "GR1")))
+	udb, err := userdb.NewUserDB(env, env.GetDBHandle())
+	require.NoError(t, err)
+	env.SetUserDB(udb)
+	al := auditlog.New(env, env.GetDBHandle())
+	env.SetAuditLogger(al)
+	ctx, err := env.GetAuthenticator().(*testauth.TestAuthenticator).WithAuthenticatedUser(context.Background(), "US1")
+	require.NoError(t, err)
+
+	db := env.GetDBHandle().DB(ctx)
+	require.NoError(t, db.Create(&tables.Group{GroupID: "GR2"}).Error)
+	require.NoError(t, db.Create(&tables.QuotaBucket{
+		Namespace:          "remote_execution",
+		Name:               "restricted",
+		NumRequests:        10,
+		PeriodDurationUsec: int64(time.Second / time.Microsecond),
+		MaxBurst:           12,
+	}).Error)
+
+	qm, err := newQuotaManager(env, pubsub.NewTestPubSub(), createTestBucket)
+	require.NoError(t, err)
+	_, err = qm.ApplyBucket(ctx, &qpb.ApplyBucketRequest{
+		Key:        &qpb.QuotaKey{GroupId: "GR2"},
+		Namespace:  "remote_execution",
+		BucketName: "restricted",
+	})
+	require.NoError(t, err)
+	_, err = qm.ModifyNamespace(ctx, &qpb.ModifyNamespaceRequest{
+		Namespace: "remote_execution",
+		UpdateBucket: &qpb.Bucket{
+			Name: "restricted",
+			MaxRate: &qpb.Rate{
+				NumRequests: 5,
+				Period:      durationpb.New(time.Second),
+			},
+			MaxBurst: 6,
+		},
+	})
+	require.NoError(t, err)
+
+	// Both changes are recorded in the affected group's audit log rather than
+	// in the log of the group that made the change.
+	rsp, err := al.GetLogs(ctx, "GR2", &alpb.GetAuditLogRequest{ResourceType: alpb.ResourceType_QUOTA_BUCKET})
+	require.NoError(t, err)
+	require.Len(t, rsp.GetEntry(), 2)
+	// Entries are returned newest first.
+	require.Contains(t, rsp.GetEntry()[0].GetBeforeJson(), "12")
+	require.Contains(t, rsp.GetEntry()[0].GetAfterJson(), "6")
+	require.Equal(t, "remote_execution/restricted", rsp.GetEntry()[1].GetResource().GetId())
+	require.Contains(t, rsp.GetEntry()[1].GetAfterJson(), "restricted")
+	rsp, err = al.GetLogs(ctx, "GR1", &alpb.GetAuditLogRequest{})
+	require.NoError(t, err)
+	require.Empty(t, rsp.GetEntry())
+}
@@ -50,6 +50,9 @@ var (
 	gRPCAddr            = flag.String("cache.raft.grpc_addr", "", "The address to listen for internal API traffic on. Ex. '1993'")
 	clearCacheOnStartup = flag.Bool("cache.raft.clear_cache_on_startup", false, "If set, remove all raft + cache data on start")
 	testMode            = flag.Bool("cache.raft.test_mode", false, "If set, use driver TestingOpts to split sooner / faster")
+	partitions          = flagutil.New("cache.raft.partitions", []disk.Partition{}, "The cache partitions and the maximum number of bytes of each to store on this node.")
+	partitionMappings   = flagutil.New("cache.raft.partition_mappings", []disk.PartitionMapping{}, "Mappings from groups and remote instance name prefixes to partitions.")
+	minEvictionAge      = flag.Duration("cache.raft.min_eviction_age", store.DefaultMinEvictionAge, "Don't evict anything unless it's been idle for at least this long")
 )
 
 const (
@@ -72,6 +75,10 @@ type Config struct {
 
 	Partitions        []disk.Partition
 	PartitionMappings []disk.PartitionMapping
+
+	// Files are not evicted until they have gone unread for at least this
+	// long.
+	MinEvictionAge time.Duration
 }
 
 type RaftCache struct {
@@ -115,11 +122,14 @@ func Register(env environment.Env) error {
 		return nil
 	}
 	rcConfig := &Config{
-		RootDir:       *rootDirectory,
-		ListenAddress: *listenAddr,
-		Join:          *join,
-		HTTPAddr:      *httpAddr,
-		GRPCAddr:      *gRPCAddr,
+		RootDir:           *rootDirectory,
+		ListenAddress:     *listenAddr,
+		Join:              *join,
+		HTTPAddr:          *httpAddr,
+		GRPCAddr:          *gRPCAddr,
+		Partitions:        *partitions,
+		PartitionMappings: *partitionMappings,
+		MinEvictionAge:    *minEvictionAge,
 	}
 	rc, err := NewRaftCache(env, rcConfig)
 	if err != nil {
@@ -214,7 +224,11 @@ func NewRaftCache(env environment.Env, conf *Config) (*RaftCache, error) {
 
 	rc.apiClient = client.NewAPIClient(env, rc.nodeHost.ID())
 	rc.sender = sender.New(rc.rangeCache, rc.registry, rc.apiClient)
-	rc.store = store.New(conf.RootDir, rc.nodeHost, rc.gossipManager, rc.sender, rc.registry, rc.apiClient)
+	evictionOpts := &store.EvictionOpts{
+		Partitions:     conf.Partitions,
+		MinEvictionAge: conf.MinEvictionAge,
+	}
+	rc.store = store.New(conf.RootDir, rc.nodeHost, rc.gossipManager, rc.sender, rc.registry, rc.apiClient, evictionOpts)
 	if err := rc.store.Start(rc.grpcAddress); err != nil {
 		return nil, err
 	}
@@ -532,5 +546,24 @@ func (rc *RaftCache) SetMulti(ctx context.Context, kvs map[*repb.Digest][]byte)
 }
 
 func (rc *RaftCache) Delete(ctx context.Context, d *repb.Digest) error {
-	return nil
+	fileRecord, err := rc.makeFileRecord(ctx, d)
+	if err != nil {
+		return err
+	}
+	fileMetadataKey, err := rc.fileStorer.FileMetadataKey(fileRecord)
+	if err != nil {
+		return err
+	}
+	deleteReq, err := rbuilder.NewBatchBuilder().Add(&rfpb.FileDeleteRequest{
+		FileRecord: fileRecord,
+	}).ToProto()
+	if err != nil {
+		return err
+	}
+	rsp, err := rc.sender.SyncPropose(ctx, fileMetadataKey, deleteReq)
+	if err != nil {
+		return err
+	}
+	_, err = rbuilder.NewBatchResponseFromProto(rsp).FileDeleteResponse(0)
+	return err
 }
@@ -27,6 +27,7 @@ var (
 	enableSplittingReplicas = flag.Bool("cache.raft.enable_splitting_replicas", true, "If set, allow splitting oversize replicas")
 	enableMovingReplicas    = flag.Bool("cache.raft.enable_moving_replicas", false, "If set, allow moving replicas between nodes")
 	enableReplacingReplicas = flag.Bool("cache.raft.enable_replacing_replicas", false, "If set, allow replacing dead / down replicas")
+	enableMovingLeases      = flag.Bool("cache.raft.enable_moving_leases", true, "If set, allow moving range leases off of overloaded nodes")
 )
 
 const (
@@ -43,9 +44,30 @@ const (
 	// Split replicas after they reach this size.
 	defaultMaxReplicaSizeBytes = 1e9 // 1GB
 
-	// A node must have this * idealReplicaCount number of replicas to be
+	// Split replicas after they serve this many reads + writes per second.
+	defaultMaxRangeQPS = 1000
+
+	// Don't split replicas smaller than this because of their QPS. The load
+	// on a small range is likely concentrated on a few keys, which splitting
+	// the range can't spread out.
+	defaultMinQPSSplitSizeBytes = 64 * 1e6 // 64MB
+
+	// Don't split a replica because of its QPS again within this long of
+	// splitting it, so that the effect of the last split can be observed.
+	defaultQPSSplitCooldown = 30 * time.Minute
+
+	// A node must have this * idealNodeBytes bytes of replicas to be
 	// eligible for moving a replica to another node.
 	replicaMoveThreshold = 1.05
+
+	// A node must serve this * the mean leaseholder QPS to be eligible for
+	// moving range leases to another node.
+	leaseMoveThreshold = 1.25
+
+	// A node must serve at least this many more leaseholder QPS than the
+	// mean to be eligible for moving range leases to another node. This
+	// prevents lightly loaded clusters from shuffling leases around.
+	minLeaseMoveQPS = 50
 )
 
 type Opts struct {
@@ -64,6 +86,18 @@ type Opts struct {
 	// The maximum size a replica may be before it's considered overloaded
 	// and is split.
 	MaxReplicaSizeBytes int64
+
+	// The maximum number of reads + writes per second a replica may serve
+	// before it's considered overloaded and is split.
+	MaxRangeQPS float64
+
+	// The minimum size a replica must be in order to be split because it
+	// serves more than MaxRangeQPS.
+	MinQPSSplitSizeBytes int64
+
+	// How long to wait after splitting a cluster before splitting it again
+	// because it serves more than MaxRangeQPS.
+	QPSSplitCooldown time.Duration
 }
 
 // make a replica struct that can be used as a map key because protos cannot be.
@@ -73,14 +107,34 @@ type replicaStruct struct {
 }
 
 type observation struct {
-	seen      time.Time
-	sizeBytes int64
+	seen        time.Time
+	sizeBytes   int64
+	readQPS     float64
+	writeQPS    float64
+	readBPS     float64
+	writeBPS    float64
+	leaseholder bool
+}
+
+func (o observation) qps() float64 {
+	return o.readQPS + o.writeQPS
 }
 
 type moveInstruction struct {
-	from    string
-	to      string
-	replica replicaStruct
+	from      string
+	to        string
+	replica   replicaStruct
+	sizeBytes int64
+}
+
+// leaseMove describes moving a range lease from the replica on one node to
+// the replica on another.
+type leaseMove struct {
+	clusterID    uint64
+	from         string
+	to           string
+	targetNodeID uint64
+	qps          float64
 }
 
 type uint64Set map[uint64]struct{}
@@ -127,6 +181,8 @@ type clusterMap struct {
 	nodeDescriptors map[string]*rfpb.NodeDescriptor
 	nodeReplicas    map[string]replicaSet
 	observations    map[replicaStruct]observation
+	// clusterID -> when the cluster was last split
+	lastSplits map[uint64]time.Time
 }
 
 func NewClusterMap() *clusterMap {
@@ -135,6 +191,7 @@ func NewClusterMap() *clusterMap {
 		nodeDescriptors: make(map[string]*rfpb.NodeDescriptor, 0),
 		nodeReplicas:    make(map[string]replicaSet, 0),
 		observations:    make(map[replicaStruct]observation, 0),
+		lastSplits:      make(map[uint64]time.Time, 0),
 	}
 }
 
@@ -149,7 +206,11 @@ func (cm *clusterMap) String() string {
 		replicas := make([]string, 0, len(set))
 		for rs := range set {
 			obs := cm.observations[rs]
-			replicas = append(replicas, fmt.Sprintf("c%dn%d [%2.2f MB]", rs.clusterID, rs.nodeID, float64(obs.sizeBytes)/1e6))
+			lease := ""
+			if obs.leaseholder {
+				lease = ", leaseholder"
+			}
+			replicas = append(replicas, fmt.Sprintf("c%dn%d [%2.2f MB, %2.1f QPS%s]", rs.clusterID, rs.nodeID, float64(obs.sizeBytes)/1e6, obs.qps(), lease))
 		}
 		sort.Strings(replicas)
 		nodeStrings[nhid] = replicas
@@ -221,11 +282,22 @@ func (cm *clusterMap) ObserveReplica(nhid string, ru *rfpb.ReplicaUsage) {
 
 	cm.nodeReplicas[nhid].Add(rs)
 	cm.observations[rs] = observation{
-		seen:      time.Now(),
-		sizeBytes: ru.GetEstimatedDiskBytesUsed(),
+		seen:        time.Now(),
+		sizeBytes:   ru.GetEstimatedDiskBytesUsed(),
+		readQPS:     ru.GetReadQps(),
+		writeQPS:    ru.GetWriteQps(),
+		readBPS:     ru.GetReadBytesPerSecond(),
+		writeBPS:    ru.GetWriteBytesPerSecond(),
+		leaseholder: ru.GetLeaseholder(),
 	}
 }
 
+func (cm *clusterMap) ReplicaSizeBytes(rs replicaStruct) int64 {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.observations[rs].sizeBytes
+}
+
 // DeadReplicas returns a slice of replicaStructs that:
 //   - Are members of clusters this node manages
 //   - Have not been seen in the last `timeout`
@@ -248,10 +320,20 @@ func (cm *clusterMap) DeadReplicas(myClusters uint64Set, timeout time.Duration)
 	return dead
 }
 
+// RecordSplit records that the specified cluster was just split.
+func (cm *clusterMap) RecordSplit(clusterID uint64) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+	cm.lastSplits[clusterID] = time.Now()
+}
+
 // OverloadedReplicas returns a slice of replicaStructs that:
 //   - Are members of clusters this node manages
-//   - Report sizes greater than `maxSizeBytes`
-func (cm *clusterMap) OverloadedReplicas(myClusters uint64Set, maxSizeBytes int64) replicaSet {
+//   - Report sizes greater than `opts.MaxReplicaSizeBytes`, or serve more
+//     than `opts.MaxRangeQPS` reads + writes per second while being at least
+//     `opts.MinQPSSplitSizeBytes` in size and not having been split within
+//     the last `opts.QPSSplitCooldown`
+func (cm *clusterMap) OverloadedReplicas(myClusters uint64Set, opts Opts) replicaSet {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
@@ -262,18 +344,47 @@ func (cm *clusterMap) OverloadedReplicas(myClusters uint64Set, maxSizeBytes int6
 			// for.
 			continue
 		}
-		if observation.sizeBytes > maxSizeBytes {
+		if observation.sizeBytes > opts.MaxReplicaSizeBytes {
+			overloaded.Add(rs)
+			continue
+		}
+		if observation.qps() > opts.MaxRangeQPS &&
+			observation.sizeBytes >= opts.MinQPSSplitSizeBytes &&
+			time.Since(cm.lastSplits[rs.clusterID]) > opts.QPSSplitCooldown {
 			overloaded.Add(rs)
 		}
-		// TODO(tylerw): also split if QPS > 1000.
 	}
 	return overloaded
 }
 
-func (cm *clusterMap) idealReplicaCount() float64 {
-	totalNumReplicas := len(cm.observations)
+// nodeBytes returns the total size of the replicas on the specified node.
+func (cm *clusterMap) nodeBytes(nhid string) int64 {
+	var total int64
+	for rs := range cm.nodeReplicas[nhid] {
+		total += cm.observations[rs].sizeBytes
+	}
+	return total
+}
+
+func (cm *clusterMap) idealNodeBytes() float64 {
+	var totalBytes int64
+	for _, obs := range cm.observations {
+		totalBytes += obs.sizeBytes
+	}
 	numNodes := len(cm.nodeReplicas)
-	return float64(totalNumReplicas) / float64(numNodes)
+	return float64(totalBytes) / float64(numNodes)
+}
+
+// nodeLeaseholderQPS returns the reads + writes per second served by the
+// leaseholder replicas on the specified node.
+func (cm *clusterMap) nodeLeaseholderQPS(nhid string) float64 {
+	var total float64
+	for rs := range cm.nodeReplicas[nhid] {
+		if obs := cm.observations[rs]; obs.leaseholder {
+			total += obs.qps()
+		}
+	}
+	return total
 }
 
 // used for sorting only
@@ -285,45 +396,57 @@ type replicaSize struct {
 // MoveableReplicas returns a slice of replicaStructs that:
 //   - Are members of clusters this node manages
 //   - Are not located on this node
-//   - If moved, would bring this node back within the ideal # of replicas
+//   - Are located on a node using more than the ideal # of bytes
+//   - If moved, would bring their node back within the ideal # of bytes
 //     threshold.
-func (cm *clusterMap) MoveableReplicas(myClusters, myNodes uint64Set, nhid string) replicaSet {
+func (cm *clusterMap) MoveableReplicas(myClusters, myNodes uint64Set) replicaSet {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
-	ideal := cm.idealReplicaCount()
-	numReplicas := len(cm.nodeReplicas[nhid])
-	moveThreshold := int(ideal * replicaMoveThreshold)
-	if numReplicas <= moveThreshold {
+	ideal := cm.idealNodeBytes()
+	if ideal == 0 {
 		return nil
 	}
-	excessReplicaQuota := numReplicas - int(ideal)
 
-	replicaSizes := make([]replicaSize, 0)
-	for rs, obs := range cm.observations {
-		// Skip clusters not managed by us.
-		if !myClusters.Contains(rs.clusterID) {
+	misplaced := make(replicaSet, 0)
+	for nhid, set := range cm.nodeReplicas {
+		nodeBytes := cm.nodeBytes(nhid)
+		if float64(nodeBytes) <= ideal*replicaMoveThreshold {
 			continue
 		}
-		// Skip nodes on this machine.
-		if myNodes.Contains(rs.nodeID) {
-			continue
+		excessBytes := float64(nodeBytes) - ideal
+
+		replicaSizes := make([]replicaSize, 0)
+		for rs := range set {
+			// Skip clusters not managed by us.
+			if !myClusters.Contains(rs.clusterID) {
+				continue
+			}
+			// Skip nodes on this machine.
+			if myNodes.Contains(rs.nodeID) {
+				continue
+			}
+			replicaSizes = append(replicaSizes, replicaSize{
+				replicaStruct: rs,
+				sizeBytes:     cm.observations[rs].sizeBytes,
+			})
 		}
-		replicaSizes = append(replicaSizes, replicaSize{
-			replicaStruct: rs,
-			sizeBytes:     obs.sizeBytes,
+		sort.Slice(replicaSizes, func(i, j int) bool {
+			// Sort in *descending* order of size.
+			return replicaSizes[i].sizeBytes > replicaSizes[j].sizeBytes
 		})
-	}
-	sort.Slice(replicaSizes, func(i, j int) bool {
-		// Sort in *descending* order of size.
-		return replicaSizes[i].sizeBytes > replicaSizes[j].sizeBytes
-	})
-	misplaced := make(replicaSet, excessReplicaQuota)
-	for _, replicaSize := range replicaSizes {
-		if len(misplaced) == excessReplicaQuota {
-			break
+		for _, replicaSize := range replicaSizes {
+			if excessBytes <= 0 {
+				break
+			}
+			// Moving a replica larger than the excess would just
+			// overload another node.
+			if float64(replicaSize.sizeBytes) > 2*excessBytes {
+				continue
+			}
+			misplaced.Add(replicaSize.replicaStruct)
+			excessBytes -= float64(replicaSize.sizeBytes)
 		}
-		misplaced.Add(replicaSize.replicaStruct)
 	}
 	return misplaced
 }
@@ -334,10 +457,9 @@ func (cm *clusterMap) FindHome(clusterID uint64) []string {
 
 	potentialHomes := make([]string, 0)
 
-	ideal := cm.idealReplicaCount()
+	ideal := cm.idealNodeBytes()
 	for nhid, replicaSet := range cm.nodeReplicas {
-		numReplicas := len(replicaSet)
-		if numReplicas > int(ideal) {
+		if float64(cm.nodeBytes(nhid)) > ideal {
 			continue
 		}
 		if replicaSet.ContainsCluster(clusterID) {
@@ -351,7 +473,7 @@ func (cm *clusterMap) FindHome(clusterID uint64) []string {
 	sort.Slice(potentialHomes, func(i, j int) bool {
 		iNHID := potentialHomes[i]
 		jNHID := potentialHomes[j]
-		return len(cm.nodeReplicas[iNHID]) < len(cm.nodeReplicas[jNHID])
+		return cm.nodeBytes(iNHID) < cm.nodeBytes(jNHID)
 	})
 	return potentialHomes
 }
@@ -360,43 +482,158 @@ func (cm *clusterMap) FitScore(potentialMoves ...moveInstruction) float64 {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
 
-	moveOffsets := make(map[string]int, 0)
+	moveOffsets := make(map[string]int64, 0)
 	for _, move := range potentialMoves {
 		if move.to != "" {
-			moveOffsets[move.to] += 1
+			moveOffsets[move.to] += move.sizeBytes
 		}
 		if move.from != "" {
-			moveOffsets[move.from] -= 1
+			moveOffsets[move.from] -= move.sizeBytes
 		}
 	}
 	samples := make([]float64, 0, len(cm.nodeReplicas))
-	for nhid, replicaSet := range cm.nodeReplicas {
-		count := len(replicaSet)
+	for nhid := range cm.nodeReplicas {
+		nodeBytes := cm.nodeBytes(nhid)
 		if offset, ok := moveOffsets[nhid]; ok {
-			count += offset
+			nodeBytes += offset
 		}
-		samples = append(samples, float64(count))
+		// Score in MB to keep the variance in a reasonable range.
+		samples = append(samples, float64(nodeBytes)/1e6)
 	}
 
-	vari := variance(samples, cm.idealReplicaCount())
+	vari := variance(samples, cm.idealNodeBytes()/1e6)
 	return vari
 }
 
+// LeaseMoves returns a slice of leaseMoves that:
+//   - Move leases of clusters this node manages off of this node
+//   - Move leases to the least loaded node holding a replica of the cluster
+//   - If applied, would bring this node back within the mean leaseholder
+//     QPS threshold.
+func (cm *clusterMap) LeaseMoves(myClusters uint64Set, nhid string) []leaseMove {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	numNodes := len(cm.nodeReplicas)
+	if numNodes <= 1 {
+		return nil
+	}
+	nodeQPS := make(map[string]float64, numNodes)
+	var totalQPS float64
+	for n := range cm.nodeReplicas {
+		nodeQPS[n] = cm.nodeLeaseholderQPS(n)
+		totalQPS += nodeQPS[n]
+	}
+	mean := totalQPS / float64(numNodes)
+	excessQPS := nodeQPS[nhid] - mean
+	if nodeQPS[nhid] <= mean*leaseMoveThreshold || excessQPS < minLeaseMoveQPS {
+		return nil
+	}
+
+	hotReplicas := make([]replicaStruct, 0)
+	for rs := range cm.nodeReplicas[nhid] {
+		if !myClusters.Contains(rs.clusterID) || !cm.observations[rs].leaseholder {
+			continue
+		}
+		hotReplicas = append(hotReplicas, rs)
+	}
+	sort.Slice(hotReplicas, func(i, j int) bool {
+		// Sort in *descending* order of QPS.
+		return cm.observations[hotReplicas[i]].qps() > cm.observations[hotReplicas[j]].qps()
+	})
+
+	moves := make([]leaseMove, 0)
+	for _, rs := range hotReplicas {
+		if excessQPS <= 0 {
+			break
+		}
+		qps := cm.observations[rs].qps()
+		if qps == 0 {
+			break
+		}
+		var target *leaseMove
+		for n, set := range cm.nodeReplicas {
+			if n == nhid {
+				continue
+			}
+			for other := range set {
+				if other.clusterID != rs.clusterID {
+					continue
+				}
+				// Only move the lease if the target would end
+				// up less loaded than this node.
+				if nodeQPS[n]+qps >= nodeQPS[nhid]-qps {
+					continue
+				}
+				if target == nil || nodeQPS[n] < nodeQPS[target.to] {
+					target = &leaseMove{
+						clusterID:    rs.clusterID,
+						from:         nhid,
+						to:           n,
+						targetNodeID: other.nodeID,
+						qps:          qps,
+					}
+				}
+			}
+		}
+		if target == nil {
+			continue
+		}
+		moves = append(moves, *target)
+		nodeQPS[nhid] -= qps
+		nodeQPS[target.to] += qps
+		excessQPS -= qps
+	}
+	return moves
+}
+
+// HotReplicas returns the replicas of clusters this node manages, in
+// descending order of QPS, that serve more than `minQPS` reads + writes per
+// second.
+func (cm *clusterMap) HotReplicas(myClusters uint64Set, minQPS float64) []replicaStruct {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	hot := make([]replicaStruct, 0)
+	for rs, obs := range cm.observations {
+		if !myClusters.Contains(rs.clusterID) || obs.qps() <= minQPS {
+			continue
+		}
+		hot = append(hot, rs)
+	}
+	sort.Slice(hot, func(i, j int) bool {
+		return cm.observations[hot[i]].qps() > cm.observations[hot[j]].qps()
+	})
+	return hot
+}
+
+func (cm *clusterMap) Observation(rs replicaStruct) observation {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+	return cm.observations[rs]
+}
+
 func DefaultOpts() Opts {
 	return Opts{
-		ReplicaTimeout:      defaultReplicaTimeout,
-		BroadcastPeriod:     defaultBroadcastPeriod,
-		ManagePeriod:        defaultManagePeriod,
-		MaxReplicaSizeBytes: defaultMaxReplicaSizeBytes,
+		ReplicaTimeout:       defaultReplicaTimeout,
+		BroadcastPeriod:      defaultBroadcastPeriod,
+		ManagePeriod:         defaultManagePeriod,
+		MaxReplicaSizeBytes:  defaultMaxReplicaSizeBytes,
+		MaxRangeQPS:          defaultMaxRangeQPS,
+		MinQPSSplitSizeBytes: defaultMinQPSSplitSizeBytes,
+		QPSSplitCooldown:     defaultQPSSplitCooldown,
 	}
 }
 
 func TestingOpts() Opts {
 	return Opts{
-		ReplicaTimeout:      5 * time.Second,
-		BroadcastPeriod:     2 * time.Second,
-		ManagePeriod:        5 * time.Second,
-		MaxReplicaSizeBytes: 10 * 1e6, // 10MB
+		ReplicaTimeout:       5 * time.Second,
+		BroadcastPeriod:      2 * time.Second,
+		ManagePeriod:         5 * time.Second,
+		MaxReplicaSizeBytes:  10 * 1e6, // 10MB
+		MaxRangeQPS:          defaultMaxRangeQPS,
+		MinQPSSplitSizeBytes: 1e6, // 1MB
+		QPSSplitCooldown:     30 * time.Second,
 	}
 }
 
@@ -501,11 +738,12 @@ func (d *Driver) broadcast() error {
 	// allowed UserEvent size is 9K, and broadcasting too much could cause
 	// slow rebalancing etc.
 
-	// A max ReplicaUsage should be around 8 bytes * 3 = 24 bytes. So 375
-	// replica usages should fit in a single gossip message. Use 350 as the
-	// target size so there is room for the NHID and a small margin of
-	// safety.
-	batchSize := 350
+	// Only the fields used for placement are broadcast. A max ReplicaUsage
+	// should be around 12 bytes (replica) + 10 bytes (size) + 4 * 9 bytes
+	// (load) + 2 bytes (leaseholder) = 60 bytes. So 150 replica usages
+	// should fit in a single gossip message. Use 120 as the target size so
+	// there is room for the NHID and a small margin of safety.
+	batchSize := 120
 	numReplicas := len(rsp.GetRangeReplicas())
 	gossiped := false
 	for start := 0; start < numReplicas; start += batchSize {
@@ -515,7 +753,16 @@ func (d *Driver) broadcast() error {
 			end = numReplicas
 		}
 		for _, rr := range rsp.GetRangeReplicas()[start:end] {
-			nu.ReplicaUsage = append(nu.ReplicaUsage, rr.GetReplicaUsage())
+			ru := rr.GetReplicaUsage()
+			nu.ReplicaUsage = append(nu.ReplicaUsage, &rfpb.ReplicaUsage{
+				Replica:                ru.GetReplica(),
+				EstimatedDiskBytesUsed: ru.GetEstimatedDiskBytesUsed(),
+				ReadQps:                ru.GetReadQps(),
+				WriteQps:               ru.GetWriteQps(),
+				ReadBytesPerSecond:     ru.GetReadBytesPerSecond(),
+				WriteBytesPerSecond:    ru.GetWriteBytesPerSecond(),
+				Leaseholder:            ru.GetLeaseholder(),
+			})
 		}
 		buf, err := proto.Marshal(nu)
 		if err != nil {
@@ -624,13 +871,17 @@ type clusterChanges struct {
 
 	// maybe (if it results in a better fit score), move these
 	moveableReplicas replicaSet
+
+	// move these leases off of this node
+	leaseMoves []leaseMove
 }
 
 func (d *Driver) proposeChanges(state *clusterState) *clusterChanges {
 	changes := &clusterChanges{
-		overloadedReplicas: d.clusterMap.OverloadedReplicas(state.myClusters, d.opts.MaxReplicaSizeBytes),
+		overloadedReplicas: d.clusterMap.OverloadedReplicas(state.myClusters, d.opts),
 		deadReplicas:       d.clusterMap.DeadReplicas(state.myClusters, d.opts.ReplicaTimeout),
-		moveableReplicas:   d.clusterMap.MoveableReplicas(state.myClusters, state.myNodes, state.node.GetNhid()),
+		moveableReplicas:   d.clusterMap.MoveableReplicas(state.myClusters, state.myNodes),
+		leaseMoves:         d.clusterMap.LeaseMoves(state.myClusters, state.node.GetNhid()),
 	}
 	return changes
 }
@@ -642,26 +893,30 @@ func (d *Driver) makeMoveInstructions(replicas replicaSet) []moveInstruction {
 	moves := make([]moveInstruction, 0)
 	for rs := range replicas {
 		currentLocation := d.clusterMap.LookupNodehostForReplica(rs)
+		sizeBytes := d.clusterMap.ReplicaSizeBytes(rs)
 		potentialHomes := d.clusterMap.FindHome(rs.clusterID)
 		if len(potentialHomes) == 0 {
 			continue
 		}
 		sort.Slice(potentialHomes, func(i, j int) bool {
 			iMove := moveInstruction{
-				to:   potentialHomes[i],
-				from: currentLocation.GetNhid(),
+				to:        potentialHomes[i],
+				from:      currentLocation.GetNhid(),
+				sizeBytes: sizeBytes,
 			}
 			jMove := moveInstruction{
-				to:   potentialHomes[j],
-				from: currentLocation.GetNhid(),
+				to:        potentialHomes[j],
+				from:      currentLocation.GetNhid(),
+				sizeBytes: sizeBytes,
 			}
 			// Sort in *descending* order of size.
 			return d.clusterMap.FitScore(append(moves, iMove)...) < d.clusterMap.FitScore(append(moves, jMove)...)
 		})
 		moves = append(moves, moveInstruction{
-			to:      potentialHomes[0],
-			from:    currentLocation.GetNhid(),
-			replica: rs,
+			to:        potentialHomes[0],
+			from:      currentLocation.GetNhid(),
+			replica:   rs,
+			sizeBytes: sizeBytes,
 		})
 	}
 	return moves
@@ -720,6 +975,7 @@ func (d *Driver) modifyCluster(ctx context.Context, state *clusterState, changes
 				log.Warningf("Error splitting cluster: %s", err)
 			} else {
 				log.Infof("Successfully split %+v", rd)
+				d.clusterMap.RecordSplit(clusterID)
 			}
 			time.Sleep(10 * time.Second)
 			if err := d.updateState(ctx, state); err != nil {
@@ -758,6 +1014,21 @@ func (d *Driver) modifyCluster(ctx context.Context, state *clusterState, changes
 			}
 		}
 	}
+
+	// Lease moves are applied last because this node no longer manages
+	// the clusters whose leases it gives away.
+	if *enableMovingLeases {
+		for _, move := range changes.leaseMoves {
+			log.Printf("Moving lease: %+v", move)
+			_, err := d.store.TransferLeadership(ctx, &rfpb.TransferLeadershipRequest{
+				ClusterId:    move.clusterID,
+				TargetNodeId: move.targetNodeID,
+			})
+			if err != nil {
+				log.Warningf("Error moving lease: %s", err)
+			}
+		}
+	}
 	return nil
 }
 
@@ -786,7 +1057,7 @@ func (d *Driver) manageClusters() error {
 	log.Printf("cluster map:\n%s", d.clusterMap.String())
 
 	changes := d.proposeChanges(state)
-	s := len(changes.overloadedReplicas) + len(changes.deadReplicas) + len(changes.moveableReplicas)
+	s := len(changes.overloadedReplicas) + len(changes.deadReplicas) + len(changes.moveableReplicas) + len(changes.leaseMoves)
 	if s == 0 {
 		return nil
 	}
@@ -827,6 +1098,19 @@ func (d *Driver) Statusz(ctx context.Context) string {
 			buf += fmt.Sprintf("\t c%dn%d", rep.clusterID, rep.nodeID)
 		}
 	}
+	if hot := d.clusterMap.HotReplicas(state.myClusters, d.opts.MaxRangeQPS/2); len(hot) > 0 {
+		buf += "Hot Replicas:\n"
+		for _, rep := range hot {
+			obs := d.clusterMap.Observation(rep)
+			buf += fmt.Sprintf("\t c%dn%d [%2.1f reads/s, %2.1f writes/s, %2.2f MB/s]\n", rep.clusterID, rep.nodeID, obs.readQPS, obs.writeQPS, (obs.readBPS+obs.writeBPS)/1e6)
+		}
+	}
+	if len(changes.leaseMoves) > 0 {
+		buf += "Lease Moves:\n"
+		for _, move := range changes.leaseMoves {
+			buf += fmt.Sprintf("\t c%d %q -> %q (n%d) [%2.1f QPS]\n", move.clusterID, move.from, move.to, move.targetNodeID, move.qps)
+		}
+	}
 	buf += "</pre>"
 	return buf
 }
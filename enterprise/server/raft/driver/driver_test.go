@@ -0,0 +1,106 @@
+package driver
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	rfpb "github.com/buildbuddy-io/buildbuddy/proto/raft"
+)
+
+func observe(cm *clusterMap, nhid string, clusterID, nodeID uint64, sizeBytes int64, qps float64, leaseholder bool) {
+	cm.ObserveNode(&rfpb.NodeDescriptor{Nhid: nhid})
+	cm.ObserveReplica(nhid, &rfpb.ReplicaUsage{
+		Replica:                &rfpb.ReplicaDescriptor{ClusterId: clusterID, NodeId: nodeID},
+		EstimatedDiskBytesUsed: sizeBytes,
+		ReadQps:                qps,
+		Leaseholder:            leaseholder,
+	})
+}
+
+func TestOverloadedReplicas(t *testing.T) {
+	cm := NewClusterMap()
+	observe(cm, "nhid-1", 1, 1, 500, 10, true)
+	observe(cm, "nhid-1", 2, 2, 2000, 10, true)
+	observe(cm, "nhid-1", 3, 3, 500, 2000, true)
+	observe(cm, "nhid-1", 4, 4, 2000, 2000, true)
+	// Too small to be split because of its QPS.
+	observe(cm, "nhid-1", 5, 5, 10, 2000, true)
+
+	opts := Opts{
+		MaxReplicaSizeBytes:  1000,
+		MaxRangeQPS:          1000,
+		MinQPSSplitSizeBytes: 100,
+		QPSSplitCooldown:     time.Hour,
+	}
+	myClusters := uint64Set{1: {}, 2: {}, 3: {}, 5: {}}
+	overloaded := cm.OverloadedReplicas(myClusters, opts)
+	require.Equal(t, replicaSet{
+		{clusterID: 2, nodeID: 2}: {},
+		{clusterID: 3, nodeID: 3}: {},
+	}, overloaded)
+
+	// Recently split clusters are only split again because of their size.
+	cm.RecordSplit(2)
+	cm.RecordSplit(3)
+	overloaded = cm.OverloadedReplicas(myClusters, opts)
+	require.Equal(t, replicaSet{
+		{clusterID: 2, nodeID: 2}: {},
+	}, overloaded)
+}
+
+func TestMoveableReplicasBySize(t *testing.T) {
+	cm := NewClusterMap()
+	// nhid-1 has few, large replicas and nhid-2 has many, small replicas.
+	observe(cm, "nhid-1", 1, 1, 5e6, 0, false)
+	observe(cm, "nhid-1", 2, 2, 4e6, 0, false)
+	for i := uint64(3); i < 10; i++ {
+		observe(cm, "nhid-2", i, i, 1e5, 0, false)
+	}
+	observe(cm, "nhid-3", 10, 10, 1e5, 0, true)
+
+	myClusters := make(uint64Set)
+	for i := uint64(1); i <= 10; i++ {
+		myClusters[i] = struct{}{}
+	}
+	myNodes := uint64Set{10: {}}
+
+	moveable := cm.MoveableReplicas(myClusters, myNodes)
+	require.Equal(t, replicaSet{{clusterID: 1, nodeID: 1}: {}}, moveable)
+
+	homes := cm.FindHome(1)
+	require.Equal(t, []string{"nhid-3", "nhid-2"}, homes)
+
+	move := moveInstruction{
+		from:      "nhid-1",
+		to:        "nhid-3",
+		replica:   replicaStruct{clusterID: 1, nodeID: 1},
+		sizeBytes: 5e6,
+	}
+	require.Less(t, cm.FitScore(move), cm.FitScore())
+}
+
+func TestLeaseMoves(t *testing.T) {
+	cm := NewClusterMap()
+	// Every cluster has a replica on each node, but nhid-1 holds the
+	// leases for both hot clusters.
+	for i, nhid := range []string{"nhid-1", "nhid-2", "nhid-3"} {
+		nodeOffset := uint64(i * 10)
+		observe(cm, nhid, 1, 1+nodeOffset, 1e6, 600, nhid == "nhid-1")
+		observe(cm, nhid, 2, 2+nodeOffset, 1e6, 400, nhid == "nhid-1")
+		observe(cm, nhid, 3, 3+nodeOffset, 1e6, 100, nhid == "nhid-2")
+	}
+	myClusters := uint64Set{1: {}, 2: {}}
+
+	moves := cm.LeaseMoves(myClusters, "nhid-1")
+	// Moving the hottest lease would just overload another node, so the
+	// second hottest lease is moved to the least loaded node instead.
+	require.Equal(t, 1, len(moves))
+	require.Equal(t, uint64(2), moves[0].clusterID)
+	require.Equal(t, "nhid-3", moves[0].to)
+	require.Equal(t, uint64(22), moves[0].targetNodeID)
+
+	// Leases are not moved off of nodes that are not overloaded.
+	require.Empty(t, cm.LeaseMoves(uint64Set{3: {}}, "nhid-2"))
+}
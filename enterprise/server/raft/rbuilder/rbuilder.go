@@ -78,6 +78,10 @@ func (bb *BatchBuilder) Add(m proto.Message) *BatchBuilder {
 		req.Value = &rfpb.RequestUnion_FileDelete{
 			FileDelete: value,
 		}
+	case *rfpb.UpdateAtimeRequest:
+		req.Value = &rfpb.RequestUnion_UpdateAtime{
+			UpdateAtime: value,
+		}
 	default:
 		bb.setErr(status.FailedPreconditionErrorf("BatchBuilder.Add handling for %+v not implemented.", m))
 		return bb
@@ -225,3 +229,12 @@ func (br *BatchResponse) FileDeleteResponse(n int) (*rfpb.FileDeleteResponse, er
 	u := br.cmd.GetUnion()[n]
 	return u.GetFileDelete(), br.unionError(u)
 }
+
+func (br *BatchResponse) UpdateAtimeResponse(n int) (*rfpb.UpdateAtimeResponse, error) {
+	br.checkIndex(n)
+	if br.err != nil {
+		return nil, br.err
+	}
+	u := br.cmd.GetUnion()[n]
+	return u.GetUpdateAtime(), br.unionError(u)
+}
@@ -0,0 +1,75 @@
+package replica
+
+import (
+	"math"
+	"sync"
+	"time"
+)
+
+// loadAveragingWindow is the time constant of the exponentially decaying
+// rates reported by a replica. Events older than a few windows have little
+// effect on the reported rates.
+const loadAveragingWindow = time.Minute
+
+// rateCounter estimates a per-second event rate, weighting recent events
+// more heavily than older ones.
+type rateCounter struct {
+	mu   sync.Mutex
+	rate float64
+	last time.Time
+}
+
+func (c *rateCounter) decayedRate(now time.Time) float64 {
+	if c.last.IsZero() {
+		return 0
+	}
+	elapsed := now.Sub(c.last).Seconds()
+	return c.rate * math.Exp(-elapsed/loadAveragingWindow.Seconds())
+}
+
+func (c *rateCounter) add(now time.Time, n float64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rate = c.decayedRate(now) + n/loadAveragingWindow.Seconds()
+	c.last = now
+}
+
+func (c *rateCounter) Add(n float64) {
+	c.add(time.Now(), n)
+}
+
+func (c *rateCounter) rateAt(now time.Time) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.decayedRate(now)
+}
+
+func (c *rateCounter) Rate() float64 {
+	return c.rateAt(time.Now())
+}
+
+// loadStats tracks the read and write load served by a replica.
+type loadStats struct {
+	readQPS    rateCounter
+	writeQPS   rateCounter
+	readBytes  rateCounter
+	writeBytes rateCounter
+}
+
+func (l *loadStats) recordRead(count int, sizeBytes int64) {
+	if count > 0 {
+		l.readQPS.Add(float64(count))
+	}
+	if sizeBytes > 0 {
+		l.readBytes.Add(float64(sizeBytes))
+	}
+}
+
+func (l *loadStats) recordWrite(count int, sizeBytes int64) {
+	if count > 0 {
+		l.writeQPS.Add(float64(count))
+	}
+	if sizeBytes > 0 {
+		l.writeBytes.Add(float64(sizeBytes))
+	}
+}
@@ -7,7 +7,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math/rand"
 	"path/filepath"
+	"sort"
 	"sync"
 	"time"
 
@@ -32,6 +34,20 @@ import (
 	gstatus "google.golang.org/grpc/status"
 )
 
+const (
+	// atimeUpdateThreshold is how stale a file's recorded access time must
+	// be before reading the file causes it to be updated.
+	atimeUpdateThreshold = 10 * time.Minute
+
+	// maxPendingAtimeUpdates is the maximum number of access time updates a
+	// replica will buffer between flushes. Further updates are dropped.
+	maxPendingAtimeUpdates = 100000
+
+	// maxSampleSkip is the maximum number of keys skipped between samples
+	// returned by SampleFileMetadata.
+	maxSampleSkip = 16
+)
+
 // Replicas need a reference back to the Store that holds them in order to
 // add and remove themselves, read files from peers, etc. In order to make this
 // more easily testable in a standalone fashion, IStore mocks out just the
@@ -87,6 +103,17 @@ type Replica struct {
 	mappedRange     *rangemap.Range
 
 	fileStorer filestore.Store
+
+	accessMu sync.Mutex
+	accesses map[string]*rfpb.FileRecord
+
+	sampleMu      sync.Mutex
+	sampleCursors map[string][]byte
+
+	load loadStats
+
+	usageMu   sync.Mutex
+	lastUsage *rfpb.ReplicaUsage
 }
 
 func uint64ToBytes(i uint64) []byte {
@@ -117,6 +144,21 @@ func sizeOf(key []byte, val []byte) (int64, error) {
 	return fileMetadata.GetSizeBytes() + int64(len(val)), nil
 }
 
+// partitionIDOf returns the cache partition that a file metadata key belongs
+// to. See filestore.FileMetadataKey for the key layout.
+func partitionIDOf(key []byte) string {
+	if i := bytes.IndexByte(key, '/'); i > 0 {
+		return string(key[:i])
+	}
+	return ""
+}
+
+// partitionKeyRange returns the bounds of the file metadata keys in the
+// specified partition.
+func partitionKeyRange(partitionID string) ([]byte, []byte) {
+	return []byte(partitionID + "/"), []byte(partitionID + "0")
+}
+
 func (sm *Replica) Usage() (*rfpb.ReplicaUsage, error) {
 	ru := &rfpb.ReplicaUsage{
 		Replica: &rfpb.ReplicaDescriptor{
@@ -139,17 +181,64 @@ func (sm *Replica) Usage() (*rfpb.ReplicaUsage, error) {
 	defer iter.Close()
 
 	estimatedBytesUsed := int64(0)
+	partitions := make(map[string]*rfpb.PartitionUsage)
 	for iter.First(); iter.Valid(); iter.Next() {
 		sizeBytes, err := sizeOf(iter.Key(), iter.Value())
 		if err != nil {
 			return nil, err
 		}
 		estimatedBytesUsed += sizeBytes
+		if isLocalKey(iter.Key()) {
+			continue
+		}
+		partitionID := partitionIDOf(iter.Key())
+		pu, ok := partitions[partitionID]
+		if !ok {
+			pu = &rfpb.PartitionUsage{PartitionId: partitionID}
+			partitions[partitionID] = pu
+		}
+		pu.SizeBytes += sizeBytes
+		pu.TotalCount += 1
 	}
 	ru.EstimatedDiskBytesUsed = estimatedBytesUsed
+	ru.ReadQps = sm.load.readQPS.Rate()
+	ru.WriteQps = sm.load.writeQPS.Rate()
+	ru.ReadBytesPerSecond = sm.load.readBytes.Rate()
+	ru.WriteBytesPerSecond = sm.load.writeBytes.Rate()
+	for _, pu := range partitions {
+		ru.Partitions = append(ru.Partitions, pu)
+	}
+	sort.Slice(ru.Partitions, func(i, j int) bool {
+		return ru.Partitions[i].GetPartitionId() < ru.Partitions[j].GetPartitionId()
+	})
+
+	sm.usageMu.Lock()
+	sm.lastUsage = proto.Clone(ru).(*rfpb.ReplicaUsage)
+	sm.usageMu.Unlock()
 	return ru, nil
 }
 
+// CachedUsage returns the usage computed by the last call to Usage, with
+// up-to-date load rates. Unlike Usage, it doesn't scan the replica's data, so
+// it is cheap enough to call for every replica whenever statusz is rendered.
+func (sm *Replica) CachedUsage() *rfpb.ReplicaUsage {
+	sm.usageMu.Lock()
+	ru := &rfpb.ReplicaUsage{}
+	if sm.lastUsage != nil {
+		ru = proto.Clone(sm.lastUsage).(*rfpb.ReplicaUsage)
+	}
+	sm.usageMu.Unlock()
+	ru.Replica = &rfpb.ReplicaDescriptor{
+		ClusterId: sm.clusterID,
+		NodeId:    sm.nodeID,
+	}
+	ru.ReadQps = sm.load.readQPS.Rate()
+	ru.WriteQps = sm.load.writeQPS.Rate()
+	ru.ReadBytesPerSecond = sm.load.readBytes.Rate()
+	ru.WriteBytesPerSecond = sm.load.writeBytes.Rate()
+	return ru
+}
+
 func (sm *Replica) setRange(key, val []byte) error {
 	if bytes.Compare(key, constants.LocalRangeKey) != 0 {
 		return status.FailedPreconditionErrorf("setRange called with non-range key: %s", key)
@@ -355,6 +444,39 @@ func (sm *Replica) fileWrite(wb *pebble.Batch, req *rfpb.FileWriteRequest) (*rfp
 	return &rfpb.FileWriteResponse{}, nil
 }
 
+func (sm *Replica) updateAtime(wb *pebble.Batch, req *rfpb.UpdateAtimeRequest) (*rfpb.UpdateAtimeResponse, error) {
+	iter := wb.NewIter(nil /*default iter options*/)
+	defer iter.Close()
+
+	for _, fileRecord := range req.GetFileRecord() {
+		fileMetadataKey, err := sm.fileStorer.FileMetadataKey(fileRecord)
+		if err != nil {
+			return nil, err
+		}
+		fileMetadata, err := lookupFileMetadata(iter, fileMetadataKey)
+		if status.IsNotFoundError(err) {
+			// The file was deleted, or moved to another range by a
+			// split, after it was read.
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if fileMetadata.GetLastAccessUsec() >= req.GetAccessTimeUsec() {
+			continue
+		}
+		fileMetadata.LastAccessUsec = req.GetAccessTimeUsec()
+		protoBytes, err := proto.Marshal(fileMetadata)
+		if err != nil {
+			return nil, err
+		}
+		if err := sm.rangeCheckedSet(wb, fileMetadataKey, protoBytes); err != nil {
+			return nil, err
+		}
+	}
+	return &rfpb.UpdateAtimeResponse{}, nil
+}
+
 func (sm *Replica) directWrite(wb *pebble.Batch, req *rfpb.DirectWriteRequest) (*rfpb.DirectWriteResponse, error) {
 	kv := req.GetKv()
 	return &rfpb.DirectWriteResponse{}, sm.rangeCheckedSet(wb, kv.Key, kv.Value)
@@ -908,6 +1030,12 @@ func (sm *Replica) handlePropose(wb *pebble.Batch, req *rfpb.RequestUnion) *rfpb
 			FileDelete: r,
 		}
 		rsp.Status = statusProto(err)
+	case *rfpb.RequestUnion_UpdateAtime:
+		r, err := sm.updateAtime(wb, value.UpdateAtime)
+		rsp.Value = &rfpb.ResponseUnion_UpdateAtime{
+			UpdateAtime: r,
+		}
+		rsp.Status = statusProto(err)
 	default:
 		rsp.Status = statusProto(status.UnimplementedErrorf("SyncPropose handling for %+v not implemented.", req))
 	}
@@ -976,6 +1104,12 @@ func (sm *Replica) readerWithDB(ctx context.Context, db pebble.Reader, fileRecor
 	if err != nil {
 		return nil, err
 	}
+	sm.recordAccess(fileMetadataKey, fileMetadata)
+	readSizeBytes := fileMetadata.GetSizeBytes() - offset
+	if limit > 0 && limit < readSizeBytes {
+		readSizeBytes = limit
+	}
+	sm.load.recordRead(1, readSizeBytes)
 	return sm.fileStorer.NewReader(ctx, sm.fileDir, fileMetadata.GetStorageMetadata(), offset, limit)
 }
 
@@ -1018,6 +1152,7 @@ func (sm *Replica) FindMissing(ctx context.Context, header *rfpb.Header, fileRec
 	iter := reader.NewIter(nil /*default iterOptions*/)
 	defer iter.Close()
 
+	sm.load.recordRead(len(fileRecords), 0)
 	missing := make([]*rfpb.FileRecord, 0)
 	for _, fileRecord := range fileRecords {
 		fileMetadaKey, err := sm.fileStorer.FileMetadataKey(fileRecord)
@@ -1026,7 +1161,13 @@ func (sm *Replica) FindMissing(ctx context.Context, header *rfpb.Header, fileRec
 		}
 		if !iter.SeekGE(fileMetadaKey) || bytes.Compare(iter.Key(), fileMetadaKey) != 0 {
 			missing = append(missing, fileRecord)
+			continue
 		}
+		fileMetadata := &rfpb.FileMetadata{}
+		if err := proto.Unmarshal(iter.Value(), fileMetadata); err != nil {
+			return nil, err
+		}
+		sm.recordAccess(fileMetadaKey, fileMetadata)
 	}
 	return missing, nil
 }
@@ -1096,10 +1237,13 @@ func (sm *Replica) Writer(ctx context.Context, header *rfpb.Header, fileRecord *
 	}
 	commitFn := func(bytesWritten int64) error {
 		batch := db.NewBatch()
+		now := time.Now().UnixMicro()
 		md := &rfpb.FileMetadata{
 			FileRecord:      fileRecord,
 			StorageMetadata: writeCloserMetadata.Metadata(),
 			SizeBytes:       bytesWritten,
+			LastAccessUsec:  now,
+			LastModifyUsec:  now,
 		}
 		protoBytes, err := proto.Marshal(md)
 		if err != nil {
@@ -1108,11 +1252,123 @@ func (sm *Replica) Writer(ctx context.Context, header *rfpb.Header, fileRecord *
 		if err := batch.Set(fileMetadataKey, protoBytes, nil /*ignored write options*/); err != nil {
 			return err
 		}
+		// The write itself is counted when the FileWriteRequest is
+		// applied; only count the bytes here.
+		sm.load.recordWrite(0, bytesWritten)
 		return batch.Commit(&pebble.WriteOptions{Sync: true})
 	}
 	return pebbleutil.CommittedWriterWithFunc(writeCloserMetadata, commitFn, db.Close), nil
 }
 
+// recordAccess notes that a file was read so that its access time can be
+// updated. Access times are only updated through raft, so that every replica
+// makes the same eviction decisions; the store periodically proposes the
+// recorded updates (see TakeAccessedFileRecords).
+func (sm *Replica) recordAccess(fileMetadataKey []byte, fileMetadata *rfpb.FileMetadata) {
+	if atime := fileMetadata.GetLastAccessUsec(); atime != 0 && time.Since(time.UnixMicro(atime)) < atimeUpdateThreshold {
+		return
+	}
+	sm.accessMu.Lock()
+	defer sm.accessMu.Unlock()
+	if sm.accesses == nil {
+		sm.accesses = make(map[string]*rfpb.FileRecord)
+	}
+	if len(sm.accesses) >= maxPendingAtimeUpdates {
+		return
+	}
+	sm.accesses[string(fileMetadataKey)] = fileMetadata.GetFileRecord()
+}
+
+// TakeAccessedFileRecords returns the files whose access times should be
+// updated because they were read since the last call.
+func (sm *Replica) TakeAccessedFileRecords() []*rfpb.FileRecord {
+	sm.accessMu.Lock()
+	accesses := sm.accesses
+	sm.accesses = nil
+	sm.accessMu.Unlock()
+
+	fileRecords := make([]*rfpb.FileRecord, 0, len(accesses))
+	for _, fileRecord := range accesses {
+		fileRecords = append(fileRecords, fileRecord)
+	}
+	return fileRecords
+}
+
+// SampleFileMetadata returns up to n files stored in the specified partition.
+// Each call resumes where the previous call for the partition stopped and
+// skips a random number of files between samples, so that repeated calls
+// eventually sample the whole partition. Files that have never had their
+// access time set are not returned; instead, an access time update is
+// recorded for them so that they may be sampled in the future.
+func (sm *Replica) SampleFileMetadata(partitionID string, n int) ([]*rfpb.FileMetadata, error) {
+	db, err := sm.leaser.DB()
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	start, end := partitionKeyRange(partitionID)
+	iter := db.NewIter(&pebble.IterOptions{
+		LowerBound: start,
+		UpperBound: end,
+	})
+	defer iter.Close()
+
+	sm.sampleMu.Lock()
+	defer sm.sampleMu.Unlock()
+	if sm.sampleCursors == nil {
+		sm.sampleCursors = make(map[string][]byte)
+	}
+
+	wrapped := false
+	if !iter.SeekGE(sm.sampleCursors[partitionID]) {
+		wrapped = true
+		if !iter.First() {
+			return nil, nil
+		}
+	}
+
+	samples := make([]*rfpb.FileMetadata, 0, n)
+	seen := make(map[string]struct{}, n)
+	for len(samples) < n {
+		for skip := rand.Intn(maxSampleSkip); skip > 0 && iter.Valid(); skip-- {
+			iter.Next()
+		}
+		if !iter.Valid() {
+			if wrapped {
+				break
+			}
+			wrapped = true
+			if !iter.First() {
+				break
+			}
+		}
+		if _, ok := seen[string(iter.Key())]; ok {
+			break
+		}
+		seen[string(iter.Key())] = struct{}{}
+
+		fileMetadata := &rfpb.FileMetadata{}
+		if err := proto.Unmarshal(iter.Value(), fileMetadata); err != nil {
+			return nil, err
+		}
+		if fileMetadata.GetLastAccessUsec() == 0 {
+			sm.recordAccess(iter.Key(), fileMetadata)
+		} else {
+			samples = append(samples, fileMetadata)
+		}
+		iter.Next()
+	}
+
+	var cursor []byte
+	if iter.Valid() {
+		cursor = make([]byte, len(iter.Key()))
+		copy(cursor, iter.Key())
+	}
+	sm.sampleCursors[partitionID] = cursor
+	return samples, nil
+}
+
 // Update updates the IOnDiskStateMachine instance. The input Entry slice
 // is a list of continuous proposed and committed commands from clients, they
 // are provided together as a batch so the IOnDiskStateMachine implementation
@@ -1174,6 +1430,7 @@ func (sm *Replica) Update(entries []dbsm.Entry) ([]dbsm.Entry, error) {
 			return nil, err
 		}
 		batchCmdRsp := &rfpb.BatchCmdResponse{}
+		sm.load.recordWrite(len(batchCmdReq.GetUnion()), 0)
 		for _, union := range batchCmdReq.GetUnion() {
 			// sm.log.Debugf("Update: request union: %+v", union)
 			rsp := sm.handlePropose(wb, union)
@@ -1242,6 +1499,7 @@ func (sm *Replica) Lookup(key interface{}) (interface{}, error) {
 		return nil, err
 	}
 	batchCmdRsp := &rfpb.BatchCmdResponse{}
+	sm.load.recordRead(len(batchCmdReq.GetUnion()), 0)
 	for _, req := range batchCmdReq.GetUnion() {
 		//sm.log.Debugf("Lookup: request union: %+v", req)
 		rsp := sm.handleRead(db, req)
@@ -6,6 +6,7 @@ import (
 	"io"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/raft/constants"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/raft/keys"
@@ -576,3 +577,160 @@ func TestReplicaFileWriteDelete(t *testing.T) {
 		require.True(t, status.IsNotFoundError(err), err)
 	}
 }
+
+func writeFile(ctx context.Context, t *testing.T, em *entryMaker, repl *replica.Replica, partitionID string) *rfpb.FileRecord {
+	d, buf := testdigest.NewRandomDigestBuf(t, 1000)
+	fileRecord := &rfpb.FileRecord{
+		Isolation: &rfpb.Isolation{
+			CacheType:   rfpb.Isolation_CAS_CACHE,
+			PartitionId: partitionID,
+			GroupId:     interfaces.AuthAnonymousUser,
+		},
+		Digest: d,
+	}
+	header := &rfpb.Header{RangeId: 1, Generation: 1}
+	writeCommitter, err := repl.Writer(ctx, header, fileRecord)
+	require.NoError(t, err)
+	_, err = writeCommitter.Write(buf)
+	require.NoError(t, err)
+	require.Nil(t, writeCommitter.Commit())
+	require.Nil(t, writeCommitter.Close())
+
+	entry := em.makeEntry(rbuilder.NewBatchBuilder().Add(&rfpb.FileWriteRequest{
+		FileRecord: fileRecord,
+	}))
+	_, err = repl.Update([]dbsm.Entry{entry})
+	require.NoError(t, err)
+	return fileRecord
+}
+
+func TestReplicaUsageByPartition(t *testing.T) {
+	ctx := context.Background()
+	rootDir := testfs.MakeTempDir(t)
+	repl := replica.New(rootDir, 1, 1, &fakeStore{})
+	_, err := repl.Open(make(chan struct{}))
+	require.NoError(t, err)
+
+	em := newEntryMaker(t)
+	writeDefaultRangeDescriptor(t, em, repl)
+	for i := 0; i < 3; i++ {
+		writeFile(ctx, t, em, repl, "default")
+	}
+	writeFile(ctx, t, em, repl, "other")
+
+	ru, err := repl.Usage()
+	require.NoError(t, err)
+	require.Equal(t, 2, len(ru.GetPartitions()))
+	require.Equal(t, "default", ru.GetPartitions()[0].GetPartitionId())
+	require.Equal(t, int64(3), ru.GetPartitions()[0].GetTotalCount())
+	require.Greater(t, ru.GetPartitions()[0].GetSizeBytes(), int64(3000))
+	require.Equal(t, "other", ru.GetPartitions()[1].GetPartitionId())
+	require.Equal(t, int64(1), ru.GetPartitions()[1].GetTotalCount())
+}
+
+func TestReplicaSampleFileMetadata(t *testing.T) {
+	ctx := context.Background()
+	rootDir := testfs.MakeTempDir(t)
+	repl := replica.New(rootDir, 1, 1, &fakeStore{})
+	_, err := repl.Open(make(chan struct{}))
+	require.NoError(t, err)
+
+	em := newEntryMaker(t)
+	writeDefaultRangeDescriptor(t, em, repl)
+	written := make(map[string]struct{})
+	for i := 0; i < 20; i++ {
+		fr := writeFile(ctx, t, em, repl, "default")
+		written[fr.GetDigest().GetHash()] = struct{}{}
+	}
+	writeFile(ctx, t, em, repl, "other")
+
+	sampled := make(map[string]struct{})
+	for i := 0; i < 100 && len(sampled) < len(written); i++ {
+		samples, err := repl.SampleFileMetadata("default", 5)
+		require.NoError(t, err)
+		require.LessOrEqual(t, len(samples), 5)
+		for _, md := range samples {
+			require.Equal(t, "default", md.GetFileRecord().GetIsolation().GetPartitionId())
+			require.NotZero(t, md.GetLastAccessUsec())
+			sampled[md.GetFileRecord().GetDigest().GetHash()] = struct{}{}
+		}
+	}
+	require.Equal(t, written, sampled)
+
+	samples, err := repl.SampleFileMetadata("missing", 5)
+	require.NoError(t, err)
+	require.Empty(t, samples)
+}
+
+func TestReplicaUpdateAtime(t *testing.T) {
+	ctx := context.Background()
+	rootDir := testfs.MakeTempDir(t)
+	repl := replica.New(rootDir, 1, 1, &fakeStore{})
+	_, err := repl.Open(make(chan struct{}))
+	require.NoError(t, err)
+
+	em := newEntryMaker(t)
+	writeDefaultRangeDescriptor(t, em, repl)
+	fileRecord := writeFile(ctx, t, em, repl, "default")
+
+	// The file was just written, so reading it should not require an
+	// atime update.
+	header := &rfpb.Header{RangeId: 1, Generation: 1}
+	readCloser, err := repl.Reader(ctx, header, fileRecord, 0, 0)
+	require.NoError(t, err)
+	testdigest.ReadDigestAndClose(t, readCloser)
+	require.Empty(t, repl.TakeAccessedFileRecords())
+
+	d, _ := testdigest.NewRandomDigestBuf(t, 1000)
+	missingRecord := &rfpb.FileRecord{
+		Isolation: fileRecord.GetIsolation(),
+		Digest:    d,
+	}
+	accessTimeUsec := time.Now().Add(time.Hour).UnixMicro()
+	entry := em.makeEntry(rbuilder.NewBatchBuilder().Add(&rfpb.UpdateAtimeRequest{
+		FileRecord:     []*rfpb.FileRecord{fileRecord, missingRecord},
+		AccessTimeUsec: accessTimeUsec,
+	}))
+	rsp, err := repl.Update([]dbsm.Entry{entry})
+	require.NoError(t, err)
+	_, err = rbuilder.NewBatchResponse(rsp[0].Result.Data).UpdateAtimeResponse(0)
+	require.NoError(t, err)
+
+	samples, err := repl.SampleFileMetadata("default", 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, len(samples))
+	require.Equal(t, accessTimeUsec, samples[0].GetLastAccessUsec())
+}
+
+func TestReplicaUsageLoad(t *testing.T) {
+	ctx := context.Background()
+	rootDir := testfs.MakeTempDir(t)
+	repl := replica.New(rootDir, 1, 1, &fakeStore{})
+	_, err := repl.Open(make(chan struct{}))
+	require.NoError(t, err)
+
+	em := newEntryMaker(t)
+	writeDefaultRangeDescriptor(t, em, repl)
+
+	ru, err := repl.Usage()
+	require.NoError(t, err)
+	require.Zero(t, ru.GetReadQps())
+	require.Zero(t, ru.GetReadBytesPerSecond())
+
+	fileRecord := writeFile(ctx, t, em, repl, "default")
+	ru, err = repl.Usage()
+	require.NoError(t, err)
+	require.Greater(t, ru.GetWriteQps(), 0.0)
+	require.Greater(t, ru.GetWriteBytesPerSecond(), 0.0)
+	require.Zero(t, ru.GetReadBytesPerSecond())
+
+	header := &rfpb.Header{RangeId: 1, Generation: 1}
+	readCloser, err := repl.Reader(ctx, header, fileRecord, 0, 0)
+	require.NoError(t, err)
+	testdigest.ReadDigestAndClose(t, readCloser)
+
+	ru, err = repl.Usage()
+	require.NoError(t, err)
+	require.Greater(t, ru.GetReadQps(), 0.0)
+	require.Greater(t, ru.GetReadBytesPerSecond(), 0.0)
+}
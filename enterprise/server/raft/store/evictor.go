@@ -0,0 +1,403 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/raft/client"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/raft/filestore"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/raft/rbuilder"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/raft/replica"
+	"github.com/buildbuddy-io/buildbuddy/server/metrics"
+	"github.com/buildbuddy-io/buildbuddy/server/util/disk"
+	"github.com/buildbuddy-io/buildbuddy/server/util/log"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"github.com/prometheus/client_golang/prometheus"
+
+	rfpb "github.com/buildbuddy-io/buildbuddy/proto/raft"
+)
+
+const (
+	// Eviction begins when a partition is fuller than this (by percentage)
+	// and continues until it is back under it.
+	JanitorCutoffThreshold = .90
+
+	DefaultMinEvictionAge        = 6 * time.Hour
+	DefaultEvictionCheckInterval = 1 * time.Minute
+
+	// atimeFlushInterval is how often access time updates recorded by
+	// replicas are proposed.
+	atimeFlushInterval = 10 * time.Second
+
+	// sampleN is the number of files sampled from a replica when adding new
+	// deletion candidates to the pool.
+	sampleN = 10
+
+	// samplePoolSize is the number of deletion candidates to maintain in
+	// memory at a time.
+	samplePoolSize = 100
+
+	// evictionTimeout bounds the time spent proposing a single delete.
+	evictionTimeout = 10 * time.Second
+)
+
+// EvictionOpts configures how a Store evicts files once the data it holds
+// for a partition exceeds the partition's size.
+type EvictionOpts struct {
+	// Partitions lists the cache partitions and the maximum number of bytes
+	// of each that may be stored on this node. Partitions without a
+	// MaxSizeBytes are never evicted.
+	Partitions []disk.Partition
+
+	// MinEvictionAge is how long a file must go unread before it may be
+	// evicted.
+	MinEvictionAge time.Duration
+
+	// CheckInterval is how often partition sizes are recomputed and
+	// eviction is run.
+	CheckInterval time.Duration
+}
+
+type evictionPoolEntry struct {
+	fileMetadata    *rfpb.FileMetadata
+	fileMetadataKey []byte
+	atime           time.Time
+}
+
+// partitionEvictor evicts the least recently accessed files of a partition
+// from this node. It is based off the Redis approximated LRU algorithm: a
+// pool of the oldest sampled files is maintained, and the oldest is evicted
+// each round. Deletes are proposed through raft, so every replica of the
+// file's range deletes it.
+type partitionEvictor struct {
+	part           disk.Partition
+	store          *Store
+	fileStorer     filestore.Store
+	minEvictionAge time.Duration
+
+	mu          sync.Mutex
+	sizeBytes   int64
+	count       int64
+	samplePool  []*evictionPoolEntry
+	lastEvicted *evictionPoolEntry
+}
+
+func newPartitionEvictor(part disk.Partition, store *Store, minEvictionAge time.Duration) *partitionEvictor {
+	return &partitionEvictor{
+		part:           part,
+		store:          store,
+		fileStorer:     filestore.New(true /*=isolateByGroupIDs*/),
+		minEvictionAge: minEvictionAge,
+		samplePool:     make([]*evictionPoolEntry, 0, samplePoolSize),
+	}
+}
+
+func (e *partitionEvictor) maxAllowedSize() int64 {
+	return int64(JanitorCutoffThreshold * float64(e.part.MaxSizeBytes))
+}
+
+func (e *partitionEvictor) setUsage(sizeBytes, count int64) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.sizeBytes = sizeBytes
+	e.count = count
+}
+
+func (e *partitionEvictor) Statusz(ctx context.Context) string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	buf := fmt.Sprintf("Partition %q: %d / %d bytes (%d items)\n", e.part.ID, e.sizeBytes, e.part.MaxSizeBytes, e.count)
+	if e.lastEvicted != nil {
+		buf += fmt.Sprintf("\tLast evicted item: %q age: %s\n", e.lastEvicted.fileMetadataKey, time.Since(e.lastEvicted.atime))
+	}
+	return buf
+}
+
+// refreshAtime returns an error if the sampled file is not old enough to be
+// evicted.
+func (e *partitionEvictor) refreshAtime(s *evictionPoolEntry) error {
+	atime := time.UnixMicro(s.fileMetadata.GetLastAccessUsec())
+	age := time.Since(atime)
+	if age < e.minEvictionAge {
+		return status.FailedPreconditionErrorf("File %q was not old enough: age %s", s.fileMetadataKey, age)
+	}
+	s.atime = atime
+	return nil
+}
+
+func (e *partitionEvictor) sample(replicas []*replica.Replica, k int) ([]*evictionPoolEntry, error) {
+	samples := make([]*evictionPoolEntry, 0, k*sampleN)
+	if len(replicas) == 0 {
+		return samples, nil
+	}
+	for i := 0; i < k; i++ {
+		r := replicas[rand.Intn(len(replicas))]
+		fileMetadatas, err := r.SampleFileMetadata(e.part.ID, sampleN)
+		if err != nil {
+			return nil, err
+		}
+		for _, fileMetadata := range fileMetadatas {
+			fileMetadataKey, err := e.fileStorer.FileMetadataKey(fileMetadata.GetFileRecord())
+			if err != nil {
+				return nil, err
+			}
+			s := &evictionPoolEntry{
+				fileMetadata:    fileMetadata,
+				fileMetadataKey: fileMetadataKey,
+			}
+			if err := e.refreshAtime(s); err != nil {
+				continue
+			}
+			samples = append(samples, s)
+		}
+	}
+	return samples, nil
+}
+
+func (e *partitionEvictor) resampleK(replicas []*replica.Replica, k int) error {
+	additions, err := e.sample(replicas, k)
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]struct{}, len(e.samplePool)+len(additions))
+	pool := make([]*evictionPoolEntry, 0, len(e.samplePool)+len(additions))
+	for _, s := range append(e.samplePool, additions...) {
+		if _, ok := seen[string(s.fileMetadataKey)]; ok {
+			continue
+		}
+		seen[string(s.fileMetadataKey)] = struct{}{}
+		pool = append(pool, s)
+	}
+	sort.Slice(pool, func(i, j int) bool {
+		return pool[i].atime.Before(pool[j].atime)
+	})
+	if len(pool) > samplePoolSize {
+		pool = pool[:samplePoolSize]
+	}
+	e.samplePool = pool
+	return nil
+}
+
+func (e *partitionEvictor) deleteFile(ctx context.Context, s *evictionPoolEntry) error {
+	ctx, cancel := context.WithTimeout(ctx, evictionTimeout)
+	defer cancel()
+
+	batch, err := rbuilder.NewBatchBuilder().Add(&rfpb.FileDeleteRequest{
+		FileRecord: s.fileMetadata.GetFileRecord(),
+	}).ToProto()
+	if err != nil {
+		return err
+	}
+	rsp, err := e.store.sender.SyncPropose(ctx, s.fileMetadataKey, batch)
+	if err != nil {
+		return err
+	}
+	_, err = rbuilder.NewBatchResponseFromProto(rsp).FileDeleteResponse(0)
+	if err != nil {
+		return err
+	}
+
+	sizeBytes := s.fileMetadata.GetSizeBytes()
+	lbls := prometheus.Labels{metrics.PartitionID: e.part.ID}
+	metrics.RaftEvictedBytes.With(lbls).Add(float64(sizeBytes))
+	metrics.RaftEvictionAgeUsec.With(lbls).Observe(float64(time.Since(s.atime).Microseconds()))
+
+	e.mu.Lock()
+	e.sizeBytes -= sizeBytes
+	e.count -= 1
+	e.lastEvicted = s
+	e.mu.Unlock()
+	return nil
+}
+
+// evict evicts up to count of the least recently accessed sampled files and
+// returns the number evicted.
+func (e *partitionEvictor) evict(ctx context.Context, replicas []*replica.Replica, count int) (int, error) {
+	evicted := 0
+	for n := 0; n < count; n++ {
+		// Resample every time we evict a key.
+		if err := e.resampleK(replicas, 1); err != nil {
+			return evicted, err
+		}
+		if len(e.samplePool) == 0 {
+			if err := e.resampleK(replicas, samplePoolSize); err != nil {
+				return evicted, err
+			}
+		}
+		if len(e.samplePool) == 0 {
+			// Nothing in this partition is old enough to evict.
+			break
+		}
+		s := e.samplePool[0]
+		e.samplePool = e.samplePool[1:]
+		err := e.deleteFile(ctx, s)
+		if status.IsNotFoundError(err) {
+			// Already deleted by another node.
+			continue
+		}
+		if err != nil {
+			log.Warningf("Evictor %q failed to delete %q: %s", e.part.ID, s.fileMetadataKey, err)
+			continue
+		}
+		evicted += 1
+	}
+	return evicted, nil
+}
+
+// ttl evicts files until the partition is back under its size limit.
+func (e *partitionEvictor) ttl(ctx context.Context, replicas []*replica.Replica, quitChan chan struct{}) error {
+	maxAllowedSize := e.maxAllowedSize()
+
+	// Sampled access times go stale between passes, so start each pass
+	// with an empty pool.
+	e.samplePool = e.samplePool[:0]
+	for {
+		e.mu.Lock()
+		sizeBytes := e.sizeBytes
+		count := e.count
+		e.mu.Unlock()
+
+		if sizeBytes <= maxAllowedSize {
+			return nil
+		}
+
+		select {
+		case <-quitChan:
+			return nil
+		default:
+			break
+		}
+
+		numToEvict := int(.001 * float64(count))
+		if numToEvict == 0 {
+			numToEvict = 1
+		}
+		evicted, err := e.evict(ctx, replicas, numToEvict)
+		if err != nil {
+			return err
+		}
+		if evicted == 0 {
+			return nil
+		}
+	}
+}
+
+func (s *Store) listReplicas() []*replica.Replica {
+	replicas := make([]*replica.Replica, 0)
+	s.replicas.Range(func(key, value any) bool {
+		if r, ok := value.(*replica.Replica); ok {
+			replicas = append(replicas, r)
+		}
+		return true
+	})
+	return replicas
+}
+
+// partitionUsage returns the number of bytes and files of each partition
+// stored on this node.
+func (s *Store) partitionUsage(replicas []*replica.Replica) map[string]*rfpb.PartitionUsage {
+	usage := make(map[string]*rfpb.PartitionUsage)
+	for _, r := range replicas {
+		ru, err := r.Usage()
+		if err != nil {
+			log.Warningf("Error computing usage of replica: %s", err)
+			continue
+		}
+		for _, pu := range ru.GetPartitions() {
+			total, ok := usage[pu.GetPartitionId()]
+			if !ok {
+				total = &rfpb.PartitionUsage{PartitionId: pu.GetPartitionId()}
+				usage[pu.GetPartitionId()] = total
+			}
+			total.SizeBytes += pu.GetSizeBytes()
+			total.TotalCount += pu.GetTotalCount()
+		}
+	}
+	return usage
+}
+
+// evictOnce recomputes the size of each partition and evicts files from any
+// partition that is over its limit.
+func (s *Store) evictOnce(ctx context.Context) {
+	replicas := s.listReplicas()
+	usage := s.partitionUsage(replicas)
+	for _, e := range s.evictors {
+		pu := usage[e.part.ID]
+		e.setUsage(pu.GetSizeBytes(), pu.GetTotalCount())
+		if err := e.ttl(ctx, replicas, s.quitChan); err != nil {
+			log.Warningf("Error evicting from partition %q: %s", e.part.ID, err)
+		}
+	}
+}
+
+func (s *Store) evictionLoop(checkInterval time.Duration) {
+	ctx := context.Background()
+	ticker := time.NewTicker(checkInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quitChan:
+			return
+		case <-ticker.C:
+			s.evictOnce(ctx)
+		}
+	}
+}
+
+// flushAtimeUpdates proposes access time updates for the files each replica
+// has served since the last flush.
+func (s *Store) flushAtimeUpdates(ctx context.Context) {
+	s.rangeMu.RLock()
+	openRanges := make([]*rfpb.RangeDescriptor, 0, len(s.openRanges))
+	for _, rd := range s.openRanges {
+		openRanges = append(openRanges, rd)
+	}
+	s.rangeMu.RUnlock()
+
+	now := time.Now().UnixMicro()
+	for _, rd := range openRanges {
+		if len(rd.GetReplicas()) == 0 {
+			continue
+		}
+		r, err := s.GetReplica(rd.GetRangeId())
+		if err != nil {
+			continue
+		}
+		fileRecords := r.TakeAccessedFileRecords()
+		if len(fileRecords) == 0 {
+			continue
+		}
+		batch, err := rbuilder.NewBatchBuilder().Add(&rfpb.UpdateAtimeRequest{
+			FileRecord:     fileRecords,
+			AccessTimeUsec: now,
+		}).ToProto()
+		if err != nil {
+			log.Warningf("Error building atime update: %s", err)
+			continue
+		}
+		clusterID := rd.GetReplicas()[0].GetClusterId()
+		if _, err := client.SyncProposeLocal(ctx, s.nodeHost, clusterID, batch); err != nil {
+			log.Debugf("Error updating atimes in cluster %d: %s", clusterID, err)
+		}
+	}
+}
+
+func (s *Store) atimeUpdateLoop() {
+	ticker := time.NewTicker(atimeFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.quitChan:
+			return
+		case <-ticker.C:
+			ctx, cancel := context.WithTimeout(context.Background(), atimeFlushInterval)
+			s.flushAtimeUpdates(ctx)
+			cancel()
+		}
+	}
+}
@@ -72,9 +72,16 @@ type Store struct {
 	leaderUpdatedCB listener.LeaderCB
 
 	fileStorer filestore.Store
+
+	evictors     []*partitionEvictor
+	evictionOpts *EvictionOpts
+	quitChan     chan struct{}
+	stopQuitChan sync.Once
 }
 
-func New(rootDir string, nodeHost *dragonboat.NodeHost, gossipManager *gossip.GossipManager, sender *sender.Sender, registry registry.NodeRegistry, apiClient *client.APIClient) *Store {
+// New creates a new Store. If evictionOpts is nil, the store never evicts
+// files.
+func New(rootDir string, nodeHost *dragonboat.NodeHost, gossipManager *gossip.GossipManager, sender *sender.Sender, registry registry.NodeRegistry, apiClient *client.APIClient, evictionOpts *EvictionOpts) *Store {
 	s := &Store{
 		rootDir:       rootDir,
 		nodeHost:      nodeHost,
@@ -92,6 +99,17 @@ func New(rootDir string, nodeHost *dragonboat.NodeHost, gossipManager *gossip.Go
 
 		metaRangeData: "",
 		fileStorer:    filestore.New(true /*=isolateByGroupIDs*/),
+
+		evictionOpts: evictionOpts,
+		quitChan:     make(chan struct{}),
+	}
+	if evictionOpts != nil {
+		for _, part := range evictionOpts.Partitions {
+			if part.MaxSizeBytes == 0 {
+				continue
+			}
+			s.evictors = append(s.evictors, newPartitionEvictor(part, s, evictionOpts.MinEvictionAge))
+		}
 	}
 	s.leaderUpdatedCB = listener.LeaderCB(s.onLeaderUpdated)
 	gossipManager.AddListener(s)
@@ -102,10 +120,7 @@ func New(rootDir string, nodeHost *dragonboat.NodeHost, gossipManager *gossip.Go
 }
 
 func (s *Store) replicaString(r *replica.Replica) string {
-	ru, err := r.Usage()
-	if err != nil {
-		return "UNKNOWN"
-	}
+	ru := r.CachedUsage()
 	clusterString := fmt.Sprintf("(c%dn%d)", ru.GetReplica().GetClusterId(), ru.GetReplica().GetNodeId())
 	rangeLeaseString := ""
 	if rd := s.lookupRange(ru.GetReplica().GetClusterId()); rd != nil {
@@ -117,13 +132,32 @@ func (s *Store) replicaString(r *replica.Replica) string {
 		}
 	}
 	mbUsed := ru.GetEstimatedDiskBytesUsed() / 1e6
-	return fmt.Sprintf("\t%s Usage: %dMB, Lease: %s\n", clusterString, mbUsed, rangeLeaseString)
+	loadString := fmt.Sprintf("Reads: %.1f/s (%.2f MB/s), Writes: %.1f/s (%.2f MB/s)", ru.GetReadQps(), ru.GetReadBytesPerSecond()/1e6, ru.GetWriteQps(), ru.GetWriteBytesPerSecond()/1e6)
+	return fmt.Sprintf("\t%s Usage: %dMB, %s, Lease: %s\n", clusterString, mbUsed, loadString, rangeLeaseString)
+}
+
+// loadString summarizes the load on all of this node's replicas. Reads are
+// only served by leaseholders, so the read totals are this node's share of
+// the cluster's read load.
+func (s *Store) loadString() string {
+	var readQPS, writeQPS, readBPS, writeBPS float64
+	var diskBytesUsed int64
+	for _, r := range s.listReplicas() {
+		ru := r.CachedUsage()
+		readQPS += ru.GetReadQps()
+		writeQPS += ru.GetWriteQps()
+		readBPS += ru.GetReadBytesPerSecond()
+		writeBPS += ru.GetWriteBytesPerSecond()
+		diskBytesUsed += ru.GetEstimatedDiskBytesUsed()
+	}
+	return fmt.Sprintf("Node load: Reads: %.1f/s (%.2f MB/s), Writes: %.1f/s (%.2f MB/s), Disk: %dMB\n", readQPS, readBPS/1e6, writeQPS, writeBPS/1e6, diskBytesUsed/1e6)
 }
 
 func (s *Store) Statusz(ctx context.Context) string {
 	buf := "<pre>"
 	buf += fmt.Sprintf("NHID: %s\n", s.nodeHost.ID())
 	buf += fmt.Sprintf("Liveness lease: %s\n", s.liveness)
+	buf += s.loadString()
 
 	replicaStrings := make([]string, 0)
 	s.replicas.Range(func(key, value any) bool {
@@ -137,6 +171,12 @@ func (s *Store) Statusz(ctx context.Context) string {
 	for _, replicaString := range replicaStrings {
 		buf += replicaString
 	}
+	if len(s.evictors) > 0 {
+		buf += "Eviction:\n"
+		for _, e := range s.evictors {
+			buf += e.Statusz(ctx)
+		}
+	}
 	buf += "</pre>"
 	return buf
 }
@@ -168,10 +208,22 @@ func (s *Store) Start(grpcAddress string) error {
 		s.grpcServer.Serve(lis)
 	}()
 	s.grpcAddr = grpcAddress
+
+	go s.atimeUpdateLoop()
+	if len(s.evictors) > 0 {
+		checkInterval := s.evictionOpts.CheckInterval
+		if checkInterval == 0 {
+			checkInterval = DefaultEvictionCheckInterval
+		}
+		go s.evictionLoop(checkInterval)
+	}
 	return nil
 }
 
 func (s *Store) Stop(ctx context.Context) error {
+	s.stopQuitChan.Do(func() {
+		close(s.quitChan)
+	})
 	listener.DefaultListener().UnregisterLeaderUpdatedCB(&s.leaderUpdatedCB)
 	return grpc_server.GRPCShutdown(ctx, s.grpcServer)
 }
@@ -335,6 +387,21 @@ func (s *Store) rangeIsValid(header *rfpb.Header) error {
 	return err
 }
 
+// haveLease returns true if a local replica holds a valid lease for the
+// specified range.
+func (s *Store) haveLease(rangeID uint64) bool {
+	rlIface, ok := s.leases.Load(rangeID)
+	if !ok {
+		return false
+	}
+	rl, ok := rlIface.(*rangelease.Lease)
+	if !ok {
+		alert.UnexpectedEvent("unexpected_leases_map_type_error")
+		return false
+	}
+	return rl.Valid()
+}
+
 // RangeIsActive verifies that the header is valid and the client is using
 // an up-to-date range descriptor. It also checks that a local replica owns
 // the range lease for the requested range.
@@ -344,14 +411,8 @@ func (s *Store) RangeIsActive(header *rfpb.Header) error {
 		return err
 	}
 
-	if rlIface, ok := s.leases.Load(header.GetRangeId()); ok {
-		if rl, ok := rlIface.(*rangelease.Lease); ok {
-			if rl.Valid() {
-				return nil
-			}
-		} else {
-			alert.UnexpectedEvent("unexpected_leases_map_type_error")
-		}
+	if s.haveLease(header.GetRangeId()) {
+		return nil
 	}
 
 	go s.maybeAcquireRangeLease(rd)
@@ -989,9 +1050,9 @@ func (s *Store) getConfigChangeID(ctx context.Context, clusterID uint64) (uint64
 
 // AddClusterNode adds a new node to the specified cluster if pre-reqs are met.
 // Pre-reqs are:
-//  * The request must be valid and contain all information
-//  * This node must be a member of the cluster that is being added to
-//  * The provided range descriptor must be up to date
+//   - The request must be valid and contain all information
+//   - This node must be a member of the cluster that is being added to
+//   - The provided range descriptor must be up to date
 func (s *Store) AddClusterNode(ctx context.Context, req *rfpb.AddClusterNodeRequest) (*rfpb.AddClusterNodeResponse, error) {
 	// Check the request looks valid.
 	if len(req.GetRange().GetReplicas()) == 0 {
@@ -1069,9 +1130,9 @@ func (s *Store) AddClusterNode(ctx context.Context, req *rfpb.AddClusterNodeRequ
 
 // AddClusterNode removes a new node from the specified cluster if pre-reqs are
 // met. Pre-reqs are:
-//  * The request must be valid and contain all information
-//  * This node must be a member of the cluster that is being removed from
-//  * The provided range descriptor must be up to date
+//   - The request must be valid and contain all information
+//   - This node must be a member of the cluster that is being removed from
+//   - The provided range descriptor must be up to date
 func (s *Store) RemoveClusterNode(ctx context.Context, req *rfpb.RemoveClusterNodeRequest) (*rfpb.RemoveClusterNodeResponse, error) {
 	// Check this is a range we have and the range descriptor provided is up to date
 	s.rangeMu.RLock()
@@ -1141,6 +1202,32 @@ func (s *Store) RemoveClusterNode(ctx context.Context, req *rfpb.RemoveClusterNo
 	}, nil
 }
 
+// TransferLeadership asks raft to make the specified replica the leader of
+// its cluster. Once this node is no longer the leader, it releases its range
+// lease so that the new leader can acquire it.
+func (s *Store) TransferLeadership(ctx context.Context, req *rfpb.TransferLeadershipRequest) (*rfpb.TransferLeadershipResponse, error) {
+	clusterID := req.GetClusterId()
+	rd := s.lookupRange(clusterID)
+	if rd == nil {
+		return nil, status.OutOfRangeErrorf("%s: cluster %d not found", constants.RangeNotFoundMsg, clusterID)
+	}
+	if !s.isLeader(clusterID) {
+		return nil, status.FailedPreconditionErrorf("not the leader of cluster %d", clusterID)
+	}
+	if err := s.nodeHost.RequestLeaderTransfer(clusterID, req.GetTargetNodeId()); err != nil {
+		return nil, err
+	}
+	for s.isLeader(clusterID) {
+		select {
+		case <-ctx.Done():
+			return nil, status.DeadlineExceededErrorf("cluster %d leadership was not transferred: %s", clusterID, ctx.Err())
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	s.releaseRangeLease(rd.GetRangeId())
+	return &rfpb.TransferLeadershipResponse{}, nil
+}
+
 func (s *Store) ListCluster(ctx context.Context, req *rfpb.ListClusterRequest) (*rfpb.ListClusterResponse, error) {
 	s.rangeMu.RLock()
 	openRanges := make([]*rfpb.RangeDescriptor, 0, len(s.openRanges))
@@ -1168,6 +1255,7 @@ func (s *Store) ListCluster(ctx context.Context, req *rfpb.ListClusterRequest) (
 		if replica, err := s.GetReplica(rd.GetRangeId()); err == nil {
 			usage, err := replica.Usage()
 			if err == nil {
+				usage.Leaseholder = s.haveLease(rd.GetRangeId())
 				rr.ReplicaUsage = usage
 			}
 		}
@@ -95,6 +95,10 @@ func (ts *TestingStore) NewReplica(clusterID, nodeID uint64) *replica.Replica {
 }
 
 func (sf *storeFactory) NewStore(t *testing.T) (*TestingStore, *dragonboat.NodeHost) {
+	return sf.NewStoreWithEvictionOpts(t, nil)
+}
+
+func (sf *storeFactory) NewStoreWithEvictionOpts(t *testing.T, evictionOpts *store.EvictionOpts) (*TestingStore, *dragonboat.NodeHost) {
 	nodeAddr := localAddr(t)
 	gm := newGossipManager(t, nodeAddr, sf.gossipAddrs)
 	sf.gossipAddrs = append(sf.gossipAddrs, nodeAddr)
@@ -138,7 +142,7 @@ func (sf *storeFactory) NewStore(t *testing.T) (*TestingStore, *dragonboat.NodeH
 	gm.AddListener(rc)
 	ts.Sender = sender.New(rc, reg, apiClient)
 	reg.AddNode(nodeHost.ID(), ts.RaftAddress, ts.GRPCAddress)
-	s := store.New(ts.RootDir, nodeHost, gm, ts.Sender, reg, apiClient)
+	s := store.New(ts.RootDir, nodeHost, gm, ts.Sender, reg, apiClient, evictionOpts)
 	require.NotNil(t, s)
 	s.Start(ts.GRPCAddress)
 	ts.Store = s
@@ -953,3 +957,104 @@ func TestManySplits(t *testing.T) {
 		}
 	}
 }
+
+func partitionSizeBytes(ctx context.Context, t *testing.T, ts *TestingStore, partitionID string) int64 {
+	rsp, err := ts.ListCluster(ctx, &rfpb.ListClusterRequest{})
+	require.NoError(t, err)
+	sizeBytes := int64(0)
+	for _, rr := range rsp.GetRangeReplicas() {
+		for _, pu := range rr.GetReplicaUsage().GetPartitions() {
+			if pu.GetPartitionId() == partitionID {
+				sizeBytes += pu.GetSizeBytes()
+			}
+		}
+	}
+	return sizeBytes
+}
+
+func TestEviction(t *testing.T) {
+	maxSizeBytes := int64(20_000)
+	evictionOpts := &store.EvictionOpts{
+		Partitions: []disk.Partition{
+			{ID: "evicted", MaxSizeBytes: maxSizeBytes},
+		},
+		MinEvictionAge: 0,
+		CheckInterval:  100 * time.Millisecond,
+	}
+	sf := newStoreFactory(t)
+	s1, nh1 := sf.NewStoreWithEvictionOpts(t, evictionOpts)
+	s2, nh2 := sf.NewStoreWithEvictionOpts(t, evictionOpts)
+	s3, nh3 := sf.NewStoreWithEvictionOpts(t, evictionOpts)
+	ctx := context.Background()
+
+	stores := []*TestingStore{s1, s2, s3}
+	initialMembers := map[uint64]string{
+		1: nh1.ID(),
+		2: nh2.ID(),
+		3: nh3.ID(),
+	}
+
+	rd := &rfpb.RangeDescriptor{
+		Left:       []byte{constants.MinByte},
+		Right:      []byte{constants.MaxByte},
+		RangeId:    1,
+		Generation: 1,
+		Replicas: []*rfpb.ReplicaDescriptor{
+			{ClusterId: 1, NodeId: 1},
+			{ClusterId: 1, NodeId: 2},
+			{ClusterId: 1, NodeId: 3},
+		},
+	}
+	rdBuf, err := proto.Marshal(rd)
+	require.NoError(t, err)
+	batchProto, err := rbuilder.NewBatchBuilder().Add(&rfpb.DirectWriteRequest{
+		Kv: &rfpb.KV{
+			Key:   constants.LocalRangeKey,
+			Value: rdBuf,
+		},
+	}).Add(&rfpb.DirectWriteRequest{
+		Kv: &rfpb.KV{
+			Key:   keys.RangeMetaKey(rd.GetRight()),
+			Value: rdBuf,
+		},
+	}).ToProto()
+	require.NoError(t, err)
+
+	for i, s := range stores {
+		req := &rfpb.StartClusterRequest{
+			ClusterId:     uint64(1),
+			NodeId:        uint64(i + 1),
+			InitialMember: initialMembers,
+			Batch:         batchProto,
+		}
+		_, err := s.StartCluster(ctx, req)
+		require.NoError(t, err)
+	}
+
+	// Files in partitions without a size limit are never evicted.
+	kept := make([]*rfpb.FileRecord, 0)
+	for i := 0; i < 10; i++ {
+		kept = append(kept, writeRecord(ctx, t, s1, "kept", 1000))
+	}
+	for i := 0; i < 50; i++ {
+		writeRecord(ctx, t, s1, "evicted", 1000)
+	}
+
+	maxAllowedSize := int64(store.JanitorCutoffThreshold * float64(maxSizeBytes))
+	for _, s := range stores {
+		for start := time.Now(); ; {
+			sizeBytes := partitionSizeBytes(ctx, t, s, "evicted")
+			if sizeBytes <= maxAllowedSize {
+				break
+			}
+			if time.Since(start) > 30*time.Second {
+				require.FailNowf(t, "eviction did not finish", "partition size %d > %d", sizeBytes, maxAllowedSize)
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		require.Greater(t, partitionSizeBytes(ctx, t, s, "evicted"), int64(0))
+	}
+	for _, fr := range kept {
+		readRecord(ctx, t, s3, fr)
+	}
+}
@@ -23,6 +23,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/digest"
 	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/namespace"
 	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/util/authutil"
 	"github.com/buildbuddy-io/buildbuddy/server/util/bazel_request"
 	"github.com/buildbuddy-io/buildbuddy/server/util/db"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
@@ -69,6 +70,7 @@ func fillExecutionFromActionMetadata(md *repb.ExecutedActionMetadata, execution
 	execution.CPUNanos = md.GetUsageStats().GetCpuNanos()
 	// ExecutedActionMetadata
 	execution.Worker = md.GetWorker()
+	execution.ExecutorID = md.GetExecutorId()
 	execution.QueuedTimestampUsec = md.GetQueuedTimestamp().AsTime().UnixMicro()
 	execution.WorkerStartTimestampUsec = md.GetWorkerStartTimestamp().AsTime().UnixMicro()
 	execution.WorkerCompletedTimestampUsec = md.GetWorkerCompletedTimestamp().AsTime().UnixMicro()
@@ -391,6 +393,13 @@ func (s *ExecutionServer) Dispatch(ctx context.Context, req *repb.ExecuteRequest
 	if len(platformPropOverrides) > 0 {
 		executionTask.PlatformOverrides = &repb.Platform{Properties: platformPropOverrides}
 	}
+	// Env overrides may carry secrets, so don't store them in plaintext in
+	// the scheduler.
+	if ss := s.env.GetSecretService(); ss != nil {
+		if err := platform.EncryptEnvOverrides(ss, executionTask); err != nil {
+			return "", err
+		}
+	}
 
 	executionTask.QueuedTimestamp = timestamppb.Now()
 	serializedTask, err := proto.Marshal(executionTask)
@@ -418,8 +427,20 @@ func (s *ExecutionServer) Dispatch(ctx context.Context, req *repb.ExecuteRequest
 	}
 
 	taskGroupID := interfaces.AuthAnonymousUser
+	taskUserID := ""
+	isServerAdmin := false
 	if user, err := perms.AuthenticatedUser(ctx, s.env); err == nil {
 		taskGroupID = user.GetGroupID()
+		taskUserID = user.GetUserID()
+		isServerAdmin = authutil.IsServerAdmin(user, s.env.GetAuthenticator().AdminGroupID())
+	}
+
+	// Priorities are set by clients, so only server admins may request a
+	// priority more important than the default, and only their tasks may
+	// preempt tasks of other groups.
+	priority := req.GetExecutionPolicy().GetPriority()
+	if priority < 0 && !isServerAdmin {
+		priority = 0
 	}
 
 	metrics.RemoteExecutionRequests.With(prometheus.Labels{metrics.GroupID: taskGroupID, metrics.OS: props.OS, metrics.Arch: props.Arch}).Inc()
@@ -431,14 +452,18 @@ func (s *ExecutionServer) Dispatch(ctx context.Context, req *repb.ExecuteRequest
 	}
 
 	schedulingMetadata := &scpb.SchedulingMetadata{
-		Os:                props.OS,
-		Arch:              props.Arch,
-		Pool:              props.Pool,
-		TaskSize:          taskSize,
-		MeasuredTaskSize:  measuredSize,
-		PredictedTaskSize: predictedSize,
-		ExecutorGroupId:   executorGroupID,
-		TaskGroupId:       taskGroupID,
+		Os:                    props.OS,
+		Arch:                  props.Arch,
+		Pool:                  props.Pool,
+		TaskSize:              taskSize,
+		MeasuredTaskSize:      measuredSize,
+		PredictedTaskSize:     predictedSize,
+		ExecutorGroupId:       executorGroupID,
+		TaskGroupId:           taskGroupID,
+		TaskInvocationId:      invocationID,
+		TaskUserId:            taskUserID,
+		Priority:              priority,
+		CanPreemptOtherGroups: isServerAdmin,
 	}
 	scheduleReq := &scpb.ScheduleTaskRequest{
 		TaskId:         executionID,
@@ -526,6 +551,10 @@ func (s *ExecutionServer) deletePendingExecution(ctx context.Context, executionI
 }
 
 func (s *ExecutionServer) execute(req *repb.ExecuteRequest, stream streamLike) error {
+	// Remote execution only advertises support for SHA256.
+	if df := digest.InferDigestFunction(req.GetDigestFunction(), req.GetActionDigest()); df != repb.DigestFunction_SHA256 {
+		return status.InvalidArgumentErrorf("Unsupported digest function %s for remote execution", df)
+	}
 	adInstanceDigest := digest.NewResourceName(req.GetActionDigest(), req.GetInstanceName())
 	ctx, err := prefix.AttachUserPrefixToContext(stream.Context(), s.env)
 	if err != nil {
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -112,6 +113,27 @@ func parseTimeout(timeout *durationpb.Duration, maxDuration time.Duration) (time
 	return requestDuration, nil
 }
 
+type preemptedKey struct{}
+
+// WithPreemption returns a context for executing a task along with a function
+// which preempts the task. Preempting a task cancels the context, and the task
+// is not reported as complete, so that it can be re-enqueued.
+func WithPreemption(ctx context.Context) (context.Context, func()) {
+	preempted := new(int32)
+	ctx, cancel := context.WithCancel(context.WithValue(ctx, preemptedKey{}, preempted))
+	return ctx, func() {
+		atomic.StoreInt32(preempted, 1)
+		cancel()
+	}
+}
+
+// IsPreempted returns whether the task executing with the given context was
+// preempted.
+func IsPreempted(ctx context.Context) bool {
+	preempted, ok := ctx.Value(preemptedKey{}).(*int32)
+	return ok && atomic.LoadInt32(preempted) == 1
+}
+
 // isTaskMisconfigured returns whether a task failed to execute because of a
 // configuration error that will prevent the action from executing properly,
 // even if retried.
@@ -164,6 +186,10 @@ func (s *Executor) ExecuteTaskAndStreamResults(ctx context.Context, st *repb.Sch
 
 	stateChangeFn := operation.GetStateChangeFunc(stream, taskID, adInstanceDigest)
 	finishWithErrFn := func(finalErr error) (retry bool, err error) {
+		// Preempted tasks are re-enqueued, so don't report them as complete.
+		if IsPreempted(ctx) {
+			return true, status.AbortedError("task was preempted by a higher priority task")
+		}
 		if shouldRetry(task, finalErr) {
 			return true, finalErr
 		}
@@ -269,6 +295,10 @@ func (s *Executor) ExecuteTaskAndStreamResults(ctx context.Context, st *repb.Sch
 	if cmdResult.Error != nil {
 		log.Warningf("Command execution returned error: %s", cmdResult.Error)
 	}
+	// Outputs of preempted tasks are incomplete, so don't bother uploading them.
+	if IsPreempted(ctx) {
+		return finishWithErrFn(cmdResult.Error)
+	}
 
 	md.UsageStats = cmdResult.UsageStats
 
@@ -2,6 +2,7 @@ package platform
 
 import (
 	"context"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"runtime"
@@ -9,10 +10,12 @@ import (
 	"strings"
 
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
 	"github.com/buildbuddy-io/buildbuddy/server/util/flagutil"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
 
 	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
 )
@@ -71,6 +74,8 @@ const (
 	disablePredictedTaskSizePropertyName = "debug-disable-predicted-task-size"
 	extraArgsPropertyName                = "extra-args"
 	envOverridesPropertyName             = "env-overrides"
+	envOverridesBase64PropertyName       = "env-overrides-base64"
+	envOverridesEncryptedPropertyName    = "env-overrides-encrypted"
 	podmanImageStreamingPropertyName     = "podman-enable-image-streaming"
 
 	OperatingSystemPropertyName = "OSFamily"
@@ -224,10 +229,92 @@ func ParseProperties(task *repb.ExecutionTask) *Properties {
 		DisableMeasuredTaskSize:    boolProp(m, disableMeasuredTaskSizePropertyName, false),
 		DisablePredictedTaskSize:   boolProp(m, disablePredictedTaskSizePropertyName, false),
 		ExtraArgs:                  stringListProp(m, extraArgsPropertyName),
-		EnvOverrides:               stringListProp(m, envOverridesPropertyName),
+		EnvOverrides:               append(stringListProp(m, envOverridesPropertyName), base64ListProp(m, envOverridesBase64PropertyName)...),
 	}
 }
 
+// WithEnvOverrides returns a context that passes the given env vars to the
+// action executed by an Execute request made with the context. The env vars
+// are sent as a remote header rather than as part of the Command, so that
+// secret values are not stored in the CAS, and are encrypted with
+// EncryptEnvOverrides while the task is queued.
+func WithEnvOverrides(ctx context.Context, envVars []*repb.Command_EnvironmentVariable) context.Context {
+	if len(envVars) == 0 {
+		return ctx
+	}
+	entries := make([]string, 0, len(envVars))
+	for _, e := range envVars {
+		entries = append(entries, base64.StdEncoding.EncodeToString([]byte(e.GetName()+"="+e.GetValue())))
+	}
+	return metadata.AppendToOutgoingContext(ctx, overrideHeaderPrefix+envOverridesBase64PropertyName, strings.Join(entries, ","))
+}
+
+// EncryptEnvOverrides replaces the env overrides that were passed to the task
+// via remote headers with a single property holding them encrypted by the
+// secret service, so that secret values are not stored in plaintext while the
+// task is queued. DecryptEnvOverrides restores them before the task is handed
+// to an executor.
+func EncryptEnvOverrides(ss interfaces.SecretService, task *repb.ExecutionTask) error {
+	overrides := &repb.Platform{}
+	props := make([]*repb.Platform_Property, 0, len(task.GetPlatformOverrides().GetProperties()))
+	for _, p := range task.GetPlatformOverrides().GetProperties() {
+		switch p.GetName() {
+		case envOverridesPropertyName, envOverridesBase64PropertyName:
+			overrides.Properties = append(overrides.Properties, p)
+		case envOverridesEncryptedPropertyName:
+			// Only the server may set encrypted overrides.
+		default:
+			props = append(props, p)
+		}
+	}
+	if len(overrides.GetProperties()) == 0 {
+		return nil
+	}
+	plaintext, err := proto.Marshal(overrides)
+	if err != nil {
+		return status.InternalErrorf("marshal env overrides: %s", err)
+	}
+	// The ciphertext is bound to the execution, so that it can't be copied
+	// to other tasks.
+	ciphertext, err := ss.Encrypt(plaintext, task.GetExecutionId())
+	if err != nil {
+		return err
+	}
+	task.PlatformOverrides.Properties = append(props, &repb.Platform_Property{
+		Name:  envOverridesEncryptedPropertyName,
+		Value: base64.StdEncoding.EncodeToString(ciphertext),
+	})
+	return nil
+}
+
+// DecryptEnvOverrides reverses EncryptEnvOverrides.
+func DecryptEnvOverrides(ss interfaces.SecretService, task *repb.ExecutionTask) error {
+	props := make([]*repb.Platform_Property, 0, len(task.GetPlatformOverrides().GetProperties()))
+	for _, p := range task.GetPlatformOverrides().GetProperties() {
+		if p.GetName() != envOverridesEncryptedPropertyName {
+			props = append(props, p)
+			continue
+		}
+		ciphertext, err := base64.StdEncoding.DecodeString(p.GetValue())
+		if err != nil {
+			return status.InternalErrorf("invalid encrypted env overrides: %s", err)
+		}
+		plaintext, err := ss.Decrypt(ciphertext, task.GetExecutionId())
+		if err != nil {
+			return err
+		}
+		overrides := &repb.Platform{}
+		if err := proto.Unmarshal(plaintext, overrides); err != nil {
+			return status.InternalErrorf("unmarshal env overrides: %s", err)
+		}
+		props = append(props, overrides.GetProperties()...)
+	}
+	if task.GetPlatformOverrides() != nil {
+		task.PlatformOverrides.Properties = props
+	}
+	return nil
+}
+
 // RemoteHeaderOverrides returns the platform properties that should override
 // the command's platform properties.
 func RemoteHeaderOverrides(ctx context.Context) []*repb.Platform_Property {
@@ -446,6 +533,21 @@ func stringListProp(props map[string]string, name string) []string {
 	return vals
 }
 
+// base64ListProp is like stringListProp, but each item is base64-encoded so
+// that it may contain commas and newlines.
+func base64ListProp(props map[string]string, name string) []string {
+	vals := []string{}
+	for _, item := range stringListProp(props, name) {
+		b, err := base64.StdEncoding.DecodeString(item)
+		if err != nil {
+			log.Warningf("Ignoring invalid %q platform property entry: %s", name, err)
+			continue
+		}
+		vals = append(vals, string(b))
+	}
+	return vals
+}
+
 // FindValue scans the platform properties for the given property name (ignoring
 // case) and returns the value of that property if it exists, otherwise "".
 func FindValue(platform *repb.Platform, name string) string {
@@ -1,14 +1,19 @@
 package platform
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"strings"
 	"testing"
 
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/secrets"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
 	"google.golang.org/protobuf/encoding/prototext"
+	"google.golang.org/protobuf/proto"
 
 	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
 )
@@ -414,6 +419,58 @@ func TestEnvAndArgOverrides(t *testing.T) {
 	require.Equal(t, expectedCmdText, commandText)
 }
 
+func TestEnvOverridesFromRemoteHeader(t *testing.T) {
+	ctx := WithEnvOverrides(context.Background(), []*repb.Command_EnvironmentVariable{
+		{Name: "KEY", Value: "-----BEGIN KEY-----\nabc,def\n-----END KEY-----"},
+		{Name: "TOKEN", Value: "a=b"},
+	})
+	md, ok := metadata.FromOutgoingContext(ctx)
+	require.True(t, ok)
+	ctx = metadata.NewIncomingContext(context.Background(), md)
+	task := &repb.ExecutionTask{
+		Command:           &repb.Command{},
+		PlatformOverrides: &repb.Platform{Properties: RemoteHeaderOverrides(ctx)},
+	}
+
+	platformProps := ParseProperties(task)
+
+	require.Equal(t, []string{"KEY=-----BEGIN KEY-----\nabc,def\n-----END KEY-----", "TOKEN=a=b"}, platformProps.EnvOverrides)
+}
+
+func TestEncryptEnvOverrides(t *testing.T) {
+	te := testenv.GetTestEnv(t)
+	ss, err := secrets.New(te, make([]byte, 32))
+	require.NoError(t, err)
+	ctx := WithEnvOverrides(context.Background(), []*repb.Command_EnvironmentVariable{
+		{Name: "TOKEN", Value: "s3cr3t"},
+	})
+	md, ok := metadata.FromOutgoingContext(ctx)
+	require.True(t, ok)
+	ctx = metadata.NewIncomingContext(context.Background(), md)
+	task := &repb.ExecutionTask{
+		ExecutionId:       "exec-1",
+		Command:           &repb.Command{},
+		PlatformOverrides: &repb.Platform{Properties: append(RemoteHeaderOverrides(ctx), &repb.Platform_Property{Name: "Pool", Value: "ci"})},
+	}
+
+	err = EncryptEnvOverrides(ss, task)
+	require.NoError(t, err)
+	require.Empty(t, ParseProperties(task).EnvOverrides)
+	require.Equal(t, "ci", ParseProperties(task).Pool)
+	require.NotContains(t, prototext.Format(task), base64.StdEncoding.EncodeToString([]byte("TOKEN=s3cr3t")))
+
+	// The encrypted overrides can't be used by other executions.
+	other := proto.Clone(task).(*repb.ExecutionTask)
+	other.ExecutionId = "exec-2"
+	err = DecryptEnvOverrides(ss, other)
+	require.Error(t, err)
+
+	err = DecryptEnvOverrides(ss, task)
+	require.NoError(t, err)
+	require.Equal(t, []string{"TOKEN=s3cr3t"}, ParseProperties(task).EnvOverrides)
+	require.Equal(t, "ci", ParseProperties(task).Pool)
+}
+
 type xcodeLocator struct {
 	sdks12_2    map[string]string
 	sdks12_4    map[string]string
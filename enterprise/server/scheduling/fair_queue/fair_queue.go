@@ -0,0 +1,210 @@
+// Package fair_queue implements weighted fair queuing of task reservations.
+//
+// Tasks are queued per group, and within each group per invocation (or per
+// user, for tasks without an invocation ID). Each time a task is dequeued, the
+// queue picks the group that has received the least service relative to its
+// weight, and within that group, the invocation that has received the least
+// service. This way, a group with a very large build can't starve other
+// groups, and a large invocation can't starve other invocations in the same
+// group.
+//
+// Service is tracked using "pass" values (as in stride scheduling): each time
+// a group is served, its pass advances by 1/weight. A group that becomes
+// active starts at the queue's current virtual time, so that it is served
+// promptly but doesn't get credit for the time it had nothing queued.
+package fair_queue
+
+import (
+	"sort"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/scheduling/priority_queue"
+
+	scpb "github.com/buildbuddy-io/buildbuddy/proto/scheduler"
+)
+
+// DefaultWeight is the weight of groups that don't have a configured weight.
+const DefaultWeight = 1.0
+
+// FairQueue is a weighted fair queue of task reservations. It is not safe for
+// concurrent use.
+type FairQueue struct {
+	groups map[string]*groupQueue
+	// Pass of the most recently served group.
+	virtualTime float64
+	// Incremented each time a group or tenant becomes active, and used to
+	// break ties between queues with the same pass in arrival order.
+	seq      int64
+	numTasks int
+}
+
+type groupQueue struct {
+	groupID string
+	weight  float64
+	pass    float64
+	seq     int64
+	tenants map[string]*tenantQueue
+	// Pass of the most recently served tenant in this group.
+	virtualTime float64
+	numTasks    int
+}
+
+// tenantQueue holds the tasks of a single invocation or user within a group.
+type tenantQueue struct {
+	key  string
+	pass float64
+	seq  int64
+	pq   *priority_queue.PriorityQueue
+}
+
+func New() *FairQueue {
+	return &FairQueue{groups: make(map[string]*groupQueue)}
+}
+
+// TenantKey returns the key used to share capacity fairly within the task's
+// group.
+func TenantKey(md *scpb.SchedulingMetadata) string {
+	if id := md.GetTaskInvocationId(); id != "" {
+		return id
+	}
+	return md.GetTaskUserId()
+}
+
+func weight(md *scpb.SchedulingMetadata) float64 {
+	if w := md.GetTaskGroupWeight(); w > 0 {
+		return w
+	}
+	return DefaultWeight
+}
+
+func (q *FairQueue) Push(req *scpb.EnqueueTaskReservationRequest) {
+	md := req.GetSchedulingMetadata()
+	groupID := md.GetTaskGroupId()
+	g, ok := q.groups[groupID]
+	if !ok {
+		q.seq++
+		g = &groupQueue{
+			groupID:     groupID,
+			pass:        q.virtualTime,
+			seq:         q.seq,
+			tenants:     make(map[string]*tenantQueue),
+			virtualTime: q.virtualTime,
+		}
+		q.groups[groupID] = g
+	}
+	// The most recently enqueued task determines the group's weight, so that
+	// weight changes take effect without waiting for the group to drain.
+	g.weight = weight(md)
+
+	key := TenantKey(md)
+	t, ok := g.tenants[key]
+	if !ok {
+		q.seq++
+		t = &tenantQueue{
+			key:  key,
+			pass: g.virtualTime,
+			seq:  q.seq,
+			pq:   priority_queue.NewPriorityQueue(),
+		}
+		g.tenants[key] = t
+	}
+	t.pq.Push(req)
+	g.numTasks++
+	q.numTasks++
+}
+
+// Peek returns the task that would be returned by Pop, without removing it.
+func (q *FairQueue) Peek() *scpb.EnqueueTaskReservationRequest {
+	g := q.nextGroup()
+	if g == nil {
+		return nil
+	}
+	return g.nextTenant().pq.Peek()
+}
+
+// Pop removes and returns the next task, or nil if the queue is empty.
+func (q *FairQueue) Pop() *scpb.EnqueueTaskReservationRequest {
+	g := q.nextGroup()
+	if g == nil {
+		return nil
+	}
+	t := g.nextTenant()
+	req := t.pq.Pop()
+
+	q.virtualTime = g.pass
+	g.pass += 1 / g.weight
+	g.virtualTime = t.pass
+	t.pass++
+
+	if t.pq.Len() == 0 {
+		delete(g.tenants, t.key)
+	}
+	g.numTasks--
+	if g.numTasks == 0 {
+		delete(q.groups, g.groupID)
+	}
+	q.numTasks--
+	return req
+}
+
+func (q *FairQueue) nextGroup() *groupQueue {
+	var next *groupQueue
+	for _, g := range q.groups {
+		if next == nil || g.pass < next.pass || (g.pass == next.pass && g.seq < next.seq) {
+			next = g
+		}
+	}
+	return next
+}
+
+func (g *groupQueue) nextTenant() *tenantQueue {
+	var next *tenantQueue
+	for _, t := range g.tenants {
+		if next == nil || t.pass < next.pass || (t.pass == next.pass && t.seq < next.seq) {
+			next = t
+		}
+	}
+	return next
+}
+
+// Len returns the number of tasks across all groups.
+func (q *FairQueue) Len() int {
+	return q.numTasks
+}
+
+// GroupLen returns the number of tasks queued for the given group.
+func (q *FairQueue) GroupLen(groupID string) int {
+	if g, ok := q.groups[groupID]; ok {
+		return g.numTasks
+	}
+	return 0
+}
+
+// GroupTenantCount returns the number of distinct invocations (or users) with
+// tasks queued for the given group.
+func (q *FairQueue) GroupTenantCount(groupID string) int {
+	if g, ok := q.groups[groupID]; ok {
+		return len(g.tenants)
+	}
+	return 0
+}
+
+// GetAll returns all queued tasks, ordered by group and tenant arrival.
+func (q *FairQueue) GetAll() []*scpb.EnqueueTaskReservationRequest {
+	groups := make([]*groupQueue, 0, len(q.groups))
+	for _, g := range q.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool { return groups[i].seq < groups[j].seq })
+	var reservations []*scpb.EnqueueTaskReservationRequest
+	for _, g := range groups {
+		tenants := make([]*tenantQueue, 0, len(g.tenants))
+		for _, t := range g.tenants {
+			tenants = append(tenants, t)
+		}
+		sort.Slice(tenants, func(i, j int) bool { return tenants[i].seq < tenants[j].seq })
+		for _, t := range tenants {
+			reservations = append(reservations, t.pq.GetAll()...)
+		}
+	}
+	return reservations
+}
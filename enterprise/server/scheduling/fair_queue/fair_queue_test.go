@@ -0,0 +1,100 @@
+package fair_queue_test
+
+import (
+	"testing"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/scheduling/fair_queue"
+	"github.com/stretchr/testify/require"
+
+	scpb "github.com/buildbuddy-io/buildbuddy/proto/scheduler"
+)
+
+func newReq(taskID, groupID, invocationID string, weight float64) *scpb.EnqueueTaskReservationRequest {
+	return &scpb.EnqueueTaskReservationRequest{
+		TaskId: taskID,
+		SchedulingMetadata: &scpb.SchedulingMetadata{
+			TaskGroupId:      groupID,
+			TaskInvocationId: invocationID,
+			TaskGroupWeight:  weight,
+		},
+	}
+}
+
+func popAll(q *fair_queue.FairQueue) []string {
+	var ids []string
+	for q.Len() > 0 {
+		ids = append(ids, q.Pop().GetTaskId())
+	}
+	return ids
+}
+
+func TestFairQueue_Empty(t *testing.T) {
+	q := fair_queue.New()
+
+	require.Equal(t, 0, q.Len())
+	require.Nil(t, q.Peek())
+	require.Nil(t, q.Pop())
+}
+
+func TestFairQueue_WeightedGroups(t *testing.T) {
+	q := fair_queue.New()
+	for _, id := range []string{"a1", "a2", "a3", "a4", "a5", "a6"} {
+		q.Push(newReq(id, "GA", "", 2))
+	}
+	for _, id := range []string{"b1", "b2", "b3"} {
+		q.Push(newReq(id, "GB", "", 0 /*=default weight*/))
+	}
+
+	// GA has twice the weight of GB, so it gets two tasks for each of GB's.
+	require.Equal(t, []string{"a1", "b1", "a2", "a3", "b2", "a4", "a5", "b3", "a6"}, popAll(q))
+}
+
+func TestFairQueue_InvocationsWithinGroup(t *testing.T) {
+	q := fair_queue.New()
+	// A large invocation is queued first.
+	for _, id := range []string{"big1", "big2", "big3", "big4"} {
+		q.Push(newReq(id, "G1", "IID_BIG", 0))
+	}
+	q.Push(newReq("small1", "G1", "IID_SMALL", 0))
+	q.Push(newReq("small2", "G1", "IID_SMALL", 0))
+
+	require.Equal(t, 6, q.GroupLen("G1"))
+	require.Equal(t, 2, q.GroupTenantCount("G1"))
+	require.Equal(t, []string{"big1", "small1", "big2", "small2", "big3", "big4"}, popAll(q))
+	require.Equal(t, 0, q.GroupLen("G1"))
+	require.Equal(t, 0, q.GroupTenantCount("G1"))
+}
+
+func TestFairQueue_NewGroupIsNotStarved(t *testing.T) {
+	q := fair_queue.New()
+	for i := 0; i < 100; i++ {
+		q.Push(newReq("busy", "BUSY_GROUP", "", 0))
+	}
+	for i := 0; i < 50; i++ {
+		q.Pop()
+	}
+
+	// A group that shows up later is served right away, but doesn't get credit
+	// for the time it had nothing queued.
+	q.Push(newReq("new1", "NEW_GROUP", "", 0))
+	q.Push(newReq("new2", "NEW_GROUP", "", 0))
+	q.Push(newReq("new3", "NEW_GROUP", "", 0))
+
+	var order []string
+	for i := 0; i < 6; i++ {
+		order = append(order, q.Pop().GetTaskId())
+	}
+	require.Equal(t, []string{"new1", "busy", "new2", "busy", "new3", "busy"}, order)
+}
+
+func TestFairQueue_PeekMatchesPop(t *testing.T) {
+	q := fair_queue.New()
+	q.Push(newReq("1", "G1", "IID1", 0))
+	q.Push(newReq("2", "G2", "IID2", 0))
+	q.Push(newReq("3", "G1", "IID3", 0))
+
+	for q.Len() > 0 {
+		peeked := q.Peek()
+		require.Equal(t, peeked, q.Pop())
+	}
+}
@@ -54,8 +54,10 @@ func NewPriorityQueue() *PriorityQueue {
 func (pq *PriorityQueue) Push(req *scpb.EnqueueTaskReservationRequest) {
 	pq.mu.Lock()
 	heap.Push(pq.inner, &pqItem{
-		value:      req,
-		priority:   0,
+		value: req,
+		// Lower priority values are more important in the remote execution
+		// API, so negate the value for the max-heap.
+		priority:   -int(req.GetSchedulingMetadata().GetPriority()),
 		insertTime: time.Now(),
 	})
 
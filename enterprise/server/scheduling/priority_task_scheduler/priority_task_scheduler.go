@@ -1,15 +1,15 @@
 package priority_task_scheduler
 
 import (
-	"container/list"
 	"context"
 	"flag"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/remote_execution/executor"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/remote_execution/runner"
-	"github.com/buildbuddy-io/buildbuddy/enterprise/server/scheduling/priority_queue"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/scheduling/fair_queue"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/scheduling/task_leaser"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/tasksize"
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
@@ -35,114 +35,59 @@ var (
 
 var shuttingDownLogOnce sync.Once
 
-type groupPriorityQueue struct {
-	*priority_queue.PriorityQueue
-	groupID string
-}
-
+// taskQueue shares executor capacity fairly between the groups (and the
+// invocations within each group) that have tasks queued.
 type taskQueue struct {
-	// List of *groupPriorityQueue items.
-	pqs *list.List
-	// Map to allow quick lookup of a specific *groupPriorityQueue element in the pqs list.
-	pqByGroupID map[string]*list.Element
-	// The *groupPriorityQueue element from which the next task will be obtained.
-	// Will be nil when there are no tasks remaining.
-	currentPQ *list.Element
-	// Number of tasks across all queues.
-	numTasks int
+	fq *fair_queue.FairQueue
 }
 
 func newTaskQueue() *taskQueue {
-	return &taskQueue{
-		pqs:         list.New(),
-		pqByGroupID: make(map[string]*list.Element),
-		currentPQ:   nil,
-	}
+	return &taskQueue{fq: fair_queue.New()}
 }
 
 func (t *taskQueue) GetAll() []*scpb.EnqueueTaskReservationRequest {
-	var reservations []*scpb.EnqueueTaskReservationRequest
-
-	for e := t.pqs.Front(); e != nil; e = e.Next() {
-		pq, ok := e.Value.(*groupPriorityQueue)
-		if !ok {
-			log.Error("not a *groupPriorityQueue!??!")
-			continue
-		}
-
-		reservations = append(reservations, pq.GetAll()...)
-	}
-
-	return reservations
+	return t.fq.GetAll()
 }
 
 func (t *taskQueue) Enqueue(req *scpb.EnqueueTaskReservationRequest) {
-	taskGroupID := req.GetSchedulingMetadata().GetTaskGroupId()
-	var pq *groupPriorityQueue
-	if el, ok := t.pqByGroupID[taskGroupID]; ok {
-		pq, ok = el.Value.(*groupPriorityQueue)
-		if !ok {
-			// Why would this ever happen?
-			log.Error("not a *groupPriorityQueue!??!")
-			return
-		}
-	} else {
-		pq = &groupPriorityQueue{
-			PriorityQueue: priority_queue.NewPriorityQueue(),
-			groupID:       taskGroupID,
-		}
-		el := t.pqs.PushBack(pq)
-		t.pqByGroupID[taskGroupID] = el
-		if t.currentPQ == nil {
-			t.currentPQ = el
-		}
-	}
-	pq.Push(req)
-	t.numTasks++
-	metrics.RemoteExecutionQueueLength.With(prometheus.Labels{metrics.GroupID: taskGroupID}).Set(float64(pq.Len()))
+	t.fq.Push(req)
+	t.updateMetrics(req.GetSchedulingMetadata().GetTaskGroupId())
 }
 
 func (t *taskQueue) Dequeue() *scpb.EnqueueTaskReservationRequest {
-	if t.currentPQ == nil {
+	req := t.fq.Pop()
+	if req == nil {
 		return nil
 	}
-	pqEl := t.currentPQ
-	pq, ok := pqEl.Value.(*groupPriorityQueue)
-	if !ok {
-		// Why would this ever happen?
-		log.Error("not a *groupPriorityQueue!??!")
-		return nil
-	}
-	req := pq.Pop()
-
-	t.currentPQ = t.currentPQ.Next()
-	if pq.Len() == 0 {
-		t.pqs.Remove(pqEl)
-		delete(t.pqByGroupID, pq.groupID)
-	}
-	if t.currentPQ == nil {
-		t.currentPQ = t.pqs.Front()
-	}
-	t.numTasks--
-	metrics.RemoteExecutionQueueLength.With(prometheus.Labels{metrics.GroupID: req.GetSchedulingMetadata().GetTaskGroupId()}).Set(float64(pq.Len()))
+	t.updateMetrics(req.GetSchedulingMetadata().GetTaskGroupId())
 	return req
 }
 
 func (t *taskQueue) Peek() *scpb.EnqueueTaskReservationRequest {
-	if t.currentPQ == nil {
-		return nil
-	}
-	pq, ok := t.currentPQ.Value.(*groupPriorityQueue)
-	if !ok {
-		// Why would this ever happen?
-		log.Error("not a *groupPriorityQueue!??!")
-		return nil
-	}
-	return pq.Peek()
+	return t.fq.Peek()
 }
 
 func (t *taskQueue) Len() int {
-	return t.numTasks
+	return t.fq.Len()
+}
+
+func (t *taskQueue) updateMetrics(groupID string) {
+	metrics.RemoteExecutionQueueLength.With(prometheus.Labels{metrics.GroupID: groupID}).Set(float64(t.fq.GroupLen(groupID)))
+	metrics.RemoteExecutionQueueInvocations.With(prometheus.Labels{metrics.GroupID: groupID}).Set(float64(t.fq.GroupTenantCount(groupID)))
+}
+
+// activeTask is a task which has been dequeued and is running.
+type activeTask struct {
+	reservation *scpb.EnqueueTaskReservationRequest
+	startTime   time.Time
+	// preempt cancels the task and marks it as preempted.
+	preempt func()
+	// leased is set once the task has been claimed, after which it may be
+	// preempted.
+	leased bool
+	// preempted is set once the task has been preempted. Its resources are
+	// freed once it finishes.
+	preempted bool
 }
 
 type Options struct {
@@ -162,7 +107,7 @@ type PriorityTaskScheduler struct {
 
 	mu                      sync.Mutex
 	q                       *taskQueue
-	activeTaskCancelFuncs   map[*context.CancelFunc]struct{}
+	activeTasks             map[*activeTask]struct{}
 	ramBytesCapacity        int64
 	ramBytesUsed            int64
 	cpuMillisCapacity       int64
@@ -192,7 +137,7 @@ func NewPriorityTaskScheduler(env environment.Env, exec *executor.Executor, runn
 		checkQueueSignal:        make(chan struct{}, 64),
 		rootContext:             rootContext,
 		rootCancel:              rootCancel,
-		activeTaskCancelFuncs:   make(map[*context.CancelFunc]struct{}, 0),
+		activeTasks:             make(map[*activeTask]struct{}, 0),
 		shuttingDown:            false,
 		ramBytesCapacity:        ramBytesCapacity,
 		cpuMillisCapacity:       cpuMillisCapacity,
@@ -246,7 +191,7 @@ func (q *PriorityTaskScheduler) Shutdown(ctx context.Context) error {
 	// Wait for all active tasks to finish.
 	for {
 		q.mu.Lock()
-		activeTasks := len(q.activeTaskCancelFuncs)
+		activeTasks := len(q.activeTasks)
 		q.mu.Unlock()
 		if activeTasks == 0 {
 			break
@@ -327,9 +272,9 @@ func (q *PriorityTaskScheduler) runTask(ctx context.Context, st *repb.ScheduledT
 	return false, nil
 }
 
-func (q *PriorityTaskScheduler) trackTask(res *scpb.EnqueueTaskReservationRequest, cancel *context.CancelFunc) {
-	q.activeTaskCancelFuncs[cancel] = struct{}{}
-	if size := res.GetTaskSize(); size != nil {
+func (q *PriorityTaskScheduler) trackTask(task *activeTask) {
+	q.activeTasks[task] = struct{}{}
+	if size := task.reservation.GetTaskSize(); size != nil {
 		q.ramBytesUsed += size.GetEstimatedMemoryBytes()
 		q.cpuMillisUsed += size.GetEstimatedMilliCpu()
 		metrics.RemoteExecutionAssignedRAMBytes.Set(float64(q.ramBytesUsed))
@@ -337,9 +282,9 @@ func (q *PriorityTaskScheduler) trackTask(res *scpb.EnqueueTaskReservationReques
 	}
 }
 
-func (q *PriorityTaskScheduler) untrackTask(res *scpb.EnqueueTaskReservationRequest, cancel *context.CancelFunc) {
-	delete(q.activeTaskCancelFuncs, cancel)
-	if size := res.GetTaskSize(); size != nil {
+func (q *PriorityTaskScheduler) untrackTask(task *activeTask) {
+	delete(q.activeTasks, task)
+	if size := task.reservation.GetTaskSize(); size != nil {
 		q.ramBytesUsed -= size.GetEstimatedMemoryBytes()
 		q.cpuMillisUsed -= size.GetEstimatedMilliCpu()
 		metrics.RemoteExecutionAssignedRAMBytes.Set(float64(q.ramBytesUsed))
@@ -355,12 +300,12 @@ func (q *PriorityTaskScheduler) canFitAnotherTask(res *scpb.EnqueueTaskReservati
 
 	// If we're running in exclusiveTaskScheduling mode, only ever allow one task to run at
 	// a time. Otherwise fall through to the logic below.
-	if willFit && q.exclusiveTaskScheduling && len(q.activeTaskCancelFuncs) >= 1 {
+	if willFit && q.exclusiveTaskScheduling && len(q.activeTasks) >= 1 {
 		return false
 	}
 
 	if willFit {
-		q.log.Infof("ram remaining: %d, cpu remaining: %d, tasks running: %d, q depth: %d", knownRAMremaining, knownCPUremaining, len(q.activeTaskCancelFuncs), q.q.Len())
+		q.log.Infof("ram remaining: %d, cpu remaining: %d, tasks running: %d, q depth: %d", knownRAMremaining, knownCPUremaining, len(q.activeTasks), q.q.Len())
 		if res.GetTaskSize().GetEstimatedMemoryBytes() == 0 {
 			q.log.Warningf("Scheduling another unknown size task. THIS SHOULD NOT HAPPEN! res: %+v", res)
 		} else {
@@ -370,6 +315,76 @@ func (q *PriorityTaskScheduler) canFitAnotherTask(res *scpb.EnqueueTaskReservati
 	return willFit
 }
 
+// preemptTasks preempts running tasks with a lower priority than the given
+// task, if the task's preemption policy allows it and preempting them would
+// free up enough resources for the task to run. Only tasks of the same group
+// are preempted, unless the task may preempt tasks of other groups. Tasks are
+// preempted in order of increasing importance, and then from the most recently
+// started, so that as little work as possible is lost.
+func (q *PriorityTaskScheduler) preemptTasks(res *scpb.EnqueueTaskReservationRequest) {
+	policy := res.GetSchedulingMetadata().GetPreemptionPolicy()
+	if !policy.GetEnabled() || q.exclusiveTaskScheduling {
+		return
+	}
+	minPriorityDifference := policy.GetMinPriorityDifference()
+	if minPriorityDifference < 1 {
+		minPriorityDifference = 1
+	}
+	priority := res.GetSchedulingMetadata().GetPriority()
+	groupID := res.GetSchedulingMetadata().GetTaskGroupId()
+	canPreemptOtherGroups := res.GetSchedulingMetadata().GetCanPreemptOtherGroups()
+
+	// Resources of tasks that were already preempted will be freed once they
+	// finish, so count them as available.
+	ramBytesNeeded := res.GetTaskSize().GetEstimatedMemoryBytes() - (q.ramBytesCapacity - q.ramBytesUsed)
+	cpuMillisNeeded := res.GetTaskSize().GetEstimatedMilliCpu() - (q.cpuMillisCapacity - q.cpuMillisUsed)
+	var candidates []*activeTask
+	for task := range q.activeTasks {
+		if task.preempted {
+			ramBytesNeeded -= task.reservation.GetTaskSize().GetEstimatedMemoryBytes()
+			cpuMillisNeeded -= task.reservation.GetTaskSize().GetEstimatedMilliCpu()
+			continue
+		}
+		md := task.reservation.GetSchedulingMetadata()
+		if !task.leased || md.GetPriority()-priority < minPriorityDifference || md.GetPreemptionCount() >= policy.GetMaxPreemptions() {
+			continue
+		}
+		if md.GetTaskGroupId() != groupID && !canPreemptOtherGroups {
+			continue
+		}
+		candidates = append(candidates, task)
+	}
+	if ramBytesNeeded <= 0 && cpuMillisNeeded <= 0 {
+		return
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		pi := candidates[i].reservation.GetSchedulingMetadata().GetPriority()
+		pj := candidates[j].reservation.GetSchedulingMetadata().GetPriority()
+		if pi != pj {
+			return pi > pj
+		}
+		return candidates[i].startTime.After(candidates[j].startTime)
+	})
+	var victims []*activeTask
+	for _, task := range candidates {
+		if ramBytesNeeded <= 0 && cpuMillisNeeded <= 0 {
+			break
+		}
+		victims = append(victims, task)
+		ramBytesNeeded -= task.reservation.GetTaskSize().GetEstimatedMemoryBytes()
+		cpuMillisNeeded -= task.reservation.GetTaskSize().GetEstimatedMilliCpu()
+	}
+	if ramBytesNeeded > 0 || cpuMillisNeeded > 0 {
+		return
+	}
+	for _, task := range victims {
+		q.log.Infof("Preempting task %q (priority %d) to make room for task %q (priority %d)", task.reservation.GetTaskId(), task.reservation.GetSchedulingMetadata().GetPriority(), res.GetTaskId(), priority)
+		task.preempted = true
+		task.preempt()
+		metrics.RemoteExecutionTasksPreemptedCount.Inc()
+	}
+}
+
 func (q *PriorityTaskScheduler) handleTask() {
 	q.mu.Lock()
 	defer q.mu.Unlock()
@@ -387,7 +402,11 @@ func (q *PriorityTaskScheduler) handleTask() {
 		return
 	}
 	nextTask := q.q.Peek()
-	if nextTask == nil || !q.canFitAnotherTask(nextTask) {
+	if nextTask == nil {
+		return
+	}
+	if !q.canFitAnotherTask(nextTask) {
+		q.preemptTasks(nextTask)
 		return
 	}
 	reservation := q.q.Dequeue()
@@ -396,15 +415,21 @@ func (q *PriorityTaskScheduler) handleTask() {
 		return
 	}
 	ctx, cancel := context.WithCancel(q.rootContext)
+	ctx, preempt := executor.WithPreemption(ctx)
 	ctx = tracing.ExtractProtoTraceMetadata(ctx, reservation.GetTraceMetadata())
 
-	q.trackTask(reservation, &cancel)
+	task := &activeTask{
+		reservation: reservation,
+		startTime:   time.Now(),
+		preempt:     preempt,
+	}
+	q.trackTask(task)
 
 	go func() {
 		defer cancel()
 		defer func() {
 			q.mu.Lock()
-			q.untrackTask(reservation, &cancel)
+			q.untrackTask(task)
 			q.mu.Unlock()
 			// Wake up the scheduling loop since the resources we just freed up
 			// may allow another task to become runnable.
@@ -422,6 +447,9 @@ func (q *PriorityTaskScheduler) handleTask() {
 			}
 			return
 		}
+		q.mu.Lock()
+		task.leased = true
+		q.mu.Unlock()
 
 		execTask := &repb.ExecutionTask{}
 		if err := proto.Unmarshal(serializedTask, execTask); err != nil {
@@ -434,6 +462,11 @@ func (q *PriorityTaskScheduler) handleTask() {
 			SchedulingMetadata: reservation.GetSchedulingMetadata(),
 		}
 		retry, err := q.runTask(ctx, scheduledTask)
+		if executor.IsPreempted(ctx) {
+			q.log.Infof("Task %q was preempted, re-enqueueing: %s", reservation.GetTaskId(), err)
+			taskLease.ClosePreempted(err)
+			return
+		}
 		if err != nil {
 			q.log.Errorf("Error running task %q (re-enqueue for retry: %t): %s", reservation.GetTaskId(), retry, err)
 		}
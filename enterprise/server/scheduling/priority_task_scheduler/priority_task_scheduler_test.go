@@ -1,7 +1,11 @@
 package priority_task_scheduler
 
 import (
+	"fmt"
 	"testing"
+	"time"
+
+	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 
 	scpb "github.com/buildbuddy-io/buildbuddy/proto/scheduler"
 	"github.com/stretchr/testify/require"
@@ -69,3 +73,148 @@ func TestTaskQueue_MultipleGroups(t *testing.T) {
 	require.Equal(t, "group1Task3", q.Dequeue().GetTaskId())
 	require.Nil(t, q.Dequeue())
 }
+
+func TestTaskQueue_Priority(t *testing.T) {
+	q := newTaskQueue()
+
+	for i, priority := range []int32{0, 5, -5, 0} {
+		req := newTaskReservationRequest(fmt.Sprintf("task%d", i), testGroupID1)
+		req.SchedulingMetadata.Priority = priority
+		q.Enqueue(req)
+	}
+
+	// Lower priority values are more important, and tasks with the same
+	// priority are dequeued in FIFO order.
+	require.Equal(t, "task2", q.Dequeue().GetTaskId())
+	require.Equal(t, "task0", q.Dequeue().GetTaskId())
+	require.Equal(t, "task3", q.Dequeue().GetTaskId())
+	require.Equal(t, "task1", q.Dequeue().GetTaskId())
+	require.Nil(t, q.Dequeue())
+}
+
+func newTestScheduler(ramBytesCapacity, cpuMillisCapacity int64) *PriorityTaskScheduler {
+	return &PriorityTaskScheduler{
+		log:               log.NamedSubLogger("test"),
+		q:                 newTaskQueue(),
+		activeTasks:       make(map[*activeTask]struct{}),
+		ramBytesCapacity:  ramBytesCapacity,
+		cpuMillisCapacity: cpuMillisCapacity,
+	}
+}
+
+// startTestTask tracks a running task and returns a pointer to whether it has
+// been preempted.
+func startTestTask(q *PriorityTaskScheduler, taskID string, priority int32, ramBytes int64, startTime time.Time) *bool {
+	preempted := false
+	q.trackTask(&activeTask{
+		reservation: &scpb.EnqueueTaskReservationRequest{
+			TaskId:             taskID,
+			TaskSize:           &scpb.TaskSize{EstimatedMemoryBytes: ramBytes},
+			SchedulingMetadata: &scpb.SchedulingMetadata{Priority: priority},
+		},
+		startTime: startTime,
+		preempt:   func() { preempted = true },
+		leased:    true,
+	})
+	return &preempted
+}
+
+func newPreemptingTask(priority int32, ramBytes int64, policy *scpb.PreemptionPolicy) *scpb.EnqueueTaskReservationRequest {
+	return &scpb.EnqueueTaskReservationRequest{
+		TaskId:   "preempting",
+		TaskSize: &scpb.TaskSize{EstimatedMemoryBytes: ramBytes},
+		SchedulingMetadata: &scpb.SchedulingMetadata{
+			Priority:         priority,
+			PreemptionPolicy: policy,
+		},
+	}
+}
+
+func TestPreemptTasks(t *testing.T) {
+	q := newTestScheduler(100, 1000)
+	now := time.Now()
+	important := startTestTask(q, "important", -10, 40, now.Add(-3*time.Minute))
+	older := startTestTask(q, "older", 10, 30, now.Add(-2*time.Minute))
+	newer := startTestTask(q, "newer", 10, 30, now.Add(-1*time.Minute))
+
+	policy := &scpb.PreemptionPolicy{Enabled: true, MinPriorityDifference: 1, MaxPreemptions: 3}
+	req := newPreemptingTask(0, 30, policy)
+	require.False(t, q.canFitAnotherTask(req))
+
+	q.preemptTasks(req)
+
+	// Only the most recently started of the least important tasks needs to be
+	// preempted to make room.
+	require.False(t, *important)
+	require.False(t, *older)
+	require.True(t, *newer)
+
+	// Preempting again while the preempted task is finishing is a no-op.
+	q.preemptTasks(req)
+	require.False(t, *older)
+}
+
+func TestPreemptTasks_NotEnoughResourcesFreed(t *testing.T) {
+	q := newTestScheduler(100, 1000)
+	important := startTestTask(q, "important", -10, 60, time.Now())
+	unimportant := startTestTask(q, "unimportant", 10, 40, time.Now())
+
+	// Preempting the unimportant task alone wouldn't free up enough memory, so
+	// nothing should be preempted.
+	policy := &scpb.PreemptionPolicy{Enabled: true, MinPriorityDifference: 1, MaxPreemptions: 3}
+	q.preemptTasks(newPreemptingTask(0, 50, policy))
+
+	require.False(t, *important)
+	require.False(t, *unimportant)
+}
+
+func TestPreemptTasks_RespectsPolicy(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		policy *scpb.PreemptionPolicy
+		count  int32
+	}{
+		{name: "disabled", policy: &scpb.PreemptionPolicy{}},
+		{name: "priority difference too small", policy: &scpb.PreemptionPolicy{Enabled: true, MinPriorityDifference: 20, MaxPreemptions: 3}},
+		{name: "max preemptions reached", policy: &scpb.PreemptionPolicy{Enabled: true, MinPriorityDifference: 1, MaxPreemptions: 2}, count: 2},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := newTestScheduler(100, 1000)
+			running := startTestTask(q, "running", 10, 100, time.Now())
+			for task := range q.activeTasks {
+				task.reservation.SchedulingMetadata.PreemptionCount = tc.count
+			}
+
+			q.preemptTasks(newPreemptingTask(0, 50, tc.policy))
+
+			require.False(t, *running)
+		})
+	}
+}
+
+func TestPreemptTasks_OtherGroups(t *testing.T) {
+	for _, tc := range []struct {
+		name                  string
+		canPreemptOtherGroups bool
+		wantPreempted         bool
+	}{
+		{name: "same group only", canPreemptOtherGroups: false, wantPreempted: false},
+		{name: "can preempt other groups", canPreemptOtherGroups: true, wantPreempted: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			q := newTestScheduler(100, 1000)
+			running := startTestTask(q, "running", 10, 100, time.Now())
+			for task := range q.activeTasks {
+				task.reservation.SchedulingMetadata.TaskGroupId = "GR2"
+			}
+
+			policy := &scpb.PreemptionPolicy{Enabled: true, MinPriorityDifference: 1, MaxPreemptions: 3}
+			req := newPreemptingTask(0, 50, policy)
+			req.SchedulingMetadata.TaskGroupId = "GR1"
+			req.SchedulingMetadata.CanPreemptOtherGroups = tc.canPreemptOtherGroups
+			q.preemptTasks(req)
+
+			require.Equal(t, tc.wantPreempted, *running)
+		})
+	}
+}
@@ -12,14 +12,18 @@ import (
 	"time"
 
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/remote_execution/platform"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/scheduling/fair_queue"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/tasksize"
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
 	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
 	"github.com/buildbuddy-io/buildbuddy/server/resources"
+	"github.com/buildbuddy-io/buildbuddy/server/util/authutil"
 	"github.com/buildbuddy-io/buildbuddy/server/util/background"
+	"github.com/buildbuddy-io/buildbuddy/server/util/flagutil"
 	"github.com/buildbuddy-io/buildbuddy/server/util/grpc_client"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 	"github.com/buildbuddy-io/buildbuddy/server/util/perms"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/buildbuddy-io/buildbuddy/server/util/tracing"
 	"github.com/go-redis/redis/v8"
@@ -43,8 +47,84 @@ var (
 	sharedExecutorPoolGroupID    = flag.String("remote_execution.shared_executor_pool_group_id", "", "Group ID that owns the shared executor pool.")
 	requireExecutorAuthorization = flag.Bool("remote_execution.require_executor_authorization", false, "If true, executors connecting to this server must provide a valid executor API key.")
 	removeStaleExecutors         = flag.Bool("remote_execution.remove_stale_executors", false, "If true, executors are removed if they are not heard from for a prolonged amount of time.")
+	groupWeights                 = flagutil.New("remote_execution.fair_share_group_weights", []GroupWeight{}, "Relative shares of executor capacity for groups with queued tasks. Groups that aren't listed have a weight of 1.")
+	preemptionPolicies           = flagutil.New("remote_execution.preemption_policies", []PoolPreemptionPolicy{}, "Executor pools in which tasks may preempt running tasks with a lower priority. Preemption is disabled in pools that aren't listed.")
 )
 
+// GroupWeight configures the share of executor capacity allotted to a group
+// when tasks from multiple groups are queued. A group with weight 2 gets twice
+// as many tasks dequeued as a group with weight 1.
+type GroupWeight struct {
+	GroupID string  `yaml:"group_id" json:"group_id"`
+	Weight  float64 `yaml:"weight" json:"weight"`
+}
+
+// groupWeight returns the configured fair share weight for the group.
+func groupWeight(groupID string) float64 {
+	for _, gw := range *groupWeights {
+		if gw.GroupID == groupID && gw.Weight > 0 {
+			return gw.Weight
+		}
+	}
+	return fair_queue.DefaultWeight
+}
+
+// PoolPreemptionPolicy enables preemption of running tasks in an executor
+// pool. When a task can't be run on an executor because of running tasks with a
+// lower priority, the executor cancels enough of them to make room for the task
+// and re-enqueues them.
+type PoolPreemptionPolicy struct {
+	// Pool is the name of the executor pool. The empty string matches the
+	// default pool.
+	Pool string `yaml:"pool" json:"pool"`
+	// MinPriorityDifference is how much lower a task's priority value must be
+	// than that of a running task in order to preempt it. Defaults to 1.
+	MinPriorityDifference int32 `yaml:"min_priority_difference" json:"min_priority_difference"`
+	// MaxPreemptions is the maximum number of times a task may be preempted,
+	// after which it runs to completion. Defaults to 3.
+	MaxPreemptions int32 `yaml:"max_preemptions" json:"max_preemptions"`
+}
+
+// preemptionPolicy returns the preemption policy configured for the pool.
+func preemptionPolicy(pool string) *scpb.PreemptionPolicy {
+	for _, p := range *preemptionPolicies {
+		if p.Pool != pool {
+			continue
+		}
+		policy := &scpb.PreemptionPolicy{
+			Enabled:               true,
+			MinPriorityDifference: p.MinPriorityDifference,
+			MaxPreemptions:        p.MaxPreemptions,
+		}
+		if policy.MinPriorityDifference < 1 {
+			policy.MinPriorityDifference = 1
+		}
+		if policy.MaxPreemptions <= 0 {
+			policy.MaxPreemptions = defaultMaxPreemptions
+		}
+		return policy
+	}
+	return &scpb.PreemptionPolicy{}
+}
+
+// setPreemptionPolicy sets the preemption policy of the task's pool on the
+// reservation, so that executors can decide whether the task may preempt
+// running tasks.
+func setPreemptionPolicy(req *scpb.EnqueueTaskReservationRequest) {
+	if md := req.GetSchedulingMetadata(); md != nil {
+		md.PreemptionPolicy = preemptionPolicy(md.GetPool())
+	}
+}
+
+// setGroupWeight sets the fair share weight of the task's group on the
+// reservation, so that weight changes apply to tasks that are already queued
+// when they are re-enqueued.
+func setGroupWeight(req *scpb.EnqueueTaskReservationRequest) {
+	if md := req.GetSchedulingMetadata(); md != nil {
+		md.TaskGroupWeight = groupWeight(md.GetTaskGroupId())
+	}
+}
+
 const (
 	leaseInterval    = 10 * time.Second
 	leaseGracePeriod = 10 * time.Second
@@ -69,12 +149,30 @@ const (
 	// The maximum number of times a task may be re-enqueued.
 	maxTaskAttemptCount = 5
 
+	// The default maximum number of times a task may be preempted, if not
+	// configured for the pool.
+	defaultMaxPreemptions = 3
+
 	// Number of unclaimed tasks to try to assign to a node that newly joined.
 	tasksToEnqueueOnJoin = 20
+	// Number of unclaimed tasks sampled for each task assigned to a node that
+	// newly joined. Sampling more tasks than we assign lets us pick tasks
+	// fairly across groups instead of in proportion to each group's backlog.
+	unclaimedTaskSampleFactor = 5
 
 	// Maximum task TTL in Redis.
 	taskTTL = 24 * time.Hour
 
+	// How long an executor keeps draining if it isn't removed.
+	executorDrainTTL = 24 * time.Hour
+
+	// Maximum number of queued tasks read to estimate the resources needed by
+	// the tasks queued in a pool.
+	queuedTaskSizeSampleCount = 500
+
+	// Arch of the pool returned by GetDesiredCapacity if not specified.
+	defaultArch = "amd64"
+
 	// Names of task fields in Redis task hash.
 	redisTaskProtoField       = "taskProto"
 	redisTaskMetadataField    = "schedulingMetadataProto"
@@ -287,7 +385,7 @@ func (h *executorHandle) Serve(ctx context.Context) error {
 				// Remove the executor first so that we don't try to send any work its way.
 				removeConnectedExecutor()
 				for _, taskID := range req.GetShuttingDownRequest().GetTaskId() {
-					if err := h.scheduler.reEnqueueTask(ctx, taskID, 1 /*=numReplicas*/, "executor shutting down"); err != nil {
+					if err := h.scheduler.reEnqueueTask(ctx, taskID, 1 /*=numReplicas*/, "executor shutting down", false /*=preempted*/); err != nil {
 						log.CtxWarningf(ctx, "Could not re-enqueue task reservation for executor %q going down: %s", executorID, err)
 					}
 				}
@@ -514,6 +612,9 @@ type nodePool struct {
 	key       nodePoolKey
 	// Executors that are currently connected to this instance of the scheduler server.
 	connectedExecutors []*executionNode
+	// IDs of executors that are draining, as of the last fetch. Task
+	// reservations aren't enqueued on draining executors.
+	drainingExecutors map[string]bool
 }
 
 func newNodePool(env environment.Env, key nodePoolKey) *nodePool {
@@ -525,17 +626,27 @@ func newNodePool(env environment.Env, key nodePoolKey) *nodePool {
 	return np
 }
 
-func (np *nodePool) fetchExecutionNodes(ctx context.Context) ([]*executionNode, error) {
+// fetchExecutionNodes returns the nodes in the pool that aren't draining, along
+// with the IDs of the nodes that are draining.
+func (np *nodePool) fetchExecutionNodes(ctx context.Context) ([]*executionNode, map[string]bool, error) {
 	redisExecutors, err := np.rdb.HGetAll(ctx, np.key.redisPoolKey()).Result()
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	ids := make([]string, 0, len(redisExecutors))
+	for id := range redisExecutors {
+		ids = append(ids, id)
+	}
+	draining, err := drainingExecutorIDs(ctx, np.rdb, ids)
+	if err != nil {
+		return nil, nil, err
 	}
 	var executors []*executionNode
 	for id, data := range redisExecutors {
 		node := &scpb.RegisteredExecutionNode{}
 		err := proto.Unmarshal([]byte(data), node)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 
 		if *removeStaleExecutors && time.Since(node.GetLastPingTime().AsTime()) > executorMaxRegistrationStaleness {
@@ -545,6 +656,9 @@ func (np *nodePool) fetchExecutionNodes(ctx context.Context) ([]*executionNode,
 			}
 			continue
 		}
+		if draining[id] {
+			continue
+		}
 
 		executors = append(executors, &executionNode{
 			executorID:            id,
@@ -554,7 +668,7 @@ func (np *nodePool) fetchExecutionNodes(ctx context.Context) ([]*executionNode,
 		})
 	}
 
-	return executors, nil
+	return executors, draining, nil
 }
 
 // GetNodes returns the execution nodes in this node pool, optionally filtering
@@ -564,7 +678,13 @@ func (np *nodePool) GetNodes(connectedOnly bool) []*executionNode {
 	defer np.mu.Unlock()
 
 	if connectedOnly {
-		return np.connectedExecutors
+		nodes := make([]*executionNode, 0, len(np.connectedExecutors))
+		for _, node := range np.connectedExecutors {
+			if !np.drainingExecutors[node.GetExecutorID()] {
+				nodes = append(nodes, node)
+			}
+		}
+		return nodes
 	}
 	return np.nodes
 }
@@ -575,11 +695,12 @@ func (np *nodePool) RefreshNodes(ctx context.Context) error {
 	if np.lastFetch.Unix() > time.Now().Add(-1*maxAllowedExecutionNodesStaleness).Unix() && len(np.nodes) > 0 {
 		return nil
 	}
-	nodes, err := np.fetchExecutionNodes(ctx)
+	nodes, draining, err := np.fetchExecutionNodes(ctx)
 	if err != nil {
 		return err
 	}
 	np.nodes = nodes
+	np.drainingExecutors = draining
 	np.lastFetch = time.Now()
 	return nil
 }
@@ -1031,7 +1152,7 @@ func (s *SchedulerServer) RegisterAndStreamWork(stream scpb.Scheduler_RegisterAn
 }
 
 func (s *SchedulerServer) assignWorkToNode(ctx context.Context, handle *executorHandle, nodePoolKey nodePoolKey) error {
-	tasks, err := s.sampleUnclaimedTasks(ctx, tasksToEnqueueOnJoin, nodePoolKey)
+	tasks, err := s.sampleUnclaimedTasks(ctx, tasksToEnqueueOnJoin*unclaimedTaskSampleFactor, nodePoolKey)
 	if err != nil {
 		return err
 	}
@@ -1039,14 +1160,22 @@ func (s *SchedulerServer) assignWorkToNode(ctx context.Context, handle *executor
 		return nil
 	}
 
-	var reqs []*scpb.EnqueueTaskReservationRequest
+	// Pick the tasks to assign in fair share order, so that groups with large
+	// backlogs don't take up all of the new node's capacity.
+	fq := fair_queue.New()
 	for _, task := range tasks {
 		req := &scpb.EnqueueTaskReservationRequest{
 			TaskId:             task.taskID,
 			TaskSize:           task.metadata.GetTaskSize(),
 			SchedulingMetadata: task.metadata,
 		}
-		reqs = append(reqs, req)
+		setGroupWeight(req)
+		setPreemptionPolicy(req)
+		fq.Push(req)
+	}
+	var reqs []*scpb.EnqueueTaskReservationRequest
+	for len(reqs) < tasksToEnqueueOnJoin && fq.Len() > 0 {
+		reqs = append(reqs, fq.Pop())
 	}
 
 	for _, req := range reqs {
@@ -1299,6 +1428,19 @@ func (s *SchedulerServer) LeaseTask(stream scpb.Scheduler_LeaseTaskServer) error
 		executorID = p.Addr.String()
 	}
 
+	// Stop tracking the lease once the executor is done with the task.
+	leaseTracked := false
+	defer func() {
+		if !leaseTracked {
+			return
+		}
+		ctx, cancel := background.ExtendContextForFinalization(ctx, 3*time.Second)
+		defer cancel()
+		if err := s.untrackLease(ctx, executorID, taskID); err != nil {
+			log.CtxWarningf(ctx, "Could not untrack lease of task %q by executor %q: %s", taskID, executorID, err)
+		}
+	}()
+
 	// If we've exited our event loop and the task is still claimed, then
 	// the worker did not finish properly and we should re-enqueue it.
 	defer func() {
@@ -1341,6 +1483,15 @@ func (s *SchedulerServer) LeaseTask(stream scpb.Scheduler_LeaseTaskServer) error
 		}
 		if !claimed {
 			log.CtxInfof(ctx, "LeaseTask %q claim attempt from executor %q", taskID, executorID)
+			draining, err := s.isExecutorDraining(ctx, executorID)
+			if err != nil {
+				return err
+			}
+			if draining {
+				log.CtxInfof(ctx, "LeaseTask %q rejected, executor %q is draining", taskID, executorID)
+				s.enqueueOnOtherExecutors(ctx, taskID)
+				return status.UnavailableErrorf("executor %q is draining", executorID)
+			}
 			err = s.claimTask(ctx, taskID, time.Now())
 			if err != nil {
 				return err
@@ -1354,6 +1505,12 @@ func (s *SchedulerServer) LeaseTask(stream scpb.Scheduler_LeaseTaskServer) error
 
 			log.CtxInfof(ctx, "LeaseTask task %q successfully claimed by executor %q", taskID, executorID)
 
+			if err := s.trackLease(ctx, executorID, taskID, task.metadata.GetTaskSize()); err != nil {
+				log.CtxWarningf(ctx, "Could not track lease of task %q by executor %q: %s", taskID, executorID, err)
+			} else {
+				leaseTracked = true
+			}
+
 			key := nodePoolKey{
 				os:      task.metadata.GetOs(),
 				arch:    task.metadata.GetArch(),
@@ -1371,7 +1528,12 @@ func (s *SchedulerServer) LeaseTask(stream scpb.Scheduler_LeaseTaskServer) error
 			// Prometheus: observe queue wait time.
 			ageInMillis := time.Since(task.queuedTimestamp).Milliseconds()
 			queueWaitTimeMs.Observe(float64(ageInMillis))
-			rsp.SerializedTask = task.serializedTask
+			serializedTask, err := s.decryptEnvOverrides(task.serializedTask)
+			if err != nil {
+				log.CtxErrorf(ctx, "LeaseTask %q error decrypting task: %s", taskID, err)
+				return err
+			}
+			rsp.SerializedTask = serializedTask
 		} else {
 			if _, err := s.readTask(ctx, req.GetTaskId()); status.IsNotFoundError(err) {
 				// No point re-enqueuing.
@@ -1444,6 +1606,9 @@ func (s *SchedulerServer) enqueueTaskReservations(ctx context.Context, enqueueRe
 
 	log.CtxInfof(ctx, "Enqueue task reservations for task %q with pool key %+v.", enqueueRequest.GetTaskId(), key)
 
+	setGroupWeight(enqueueRequest)
+	setPreemptionPolicy(enqueueRequest)
+
 	nodeBalancer := s.getOrCreatePool(key)
 	nodeCount, err := nodeBalancer.NodeCount(ctx, enqueueRequest.GetTaskSize())
 	if err != nil {
@@ -1624,7 +1789,29 @@ func (s *SchedulerServer) EnqueueTaskReservation(ctx context.Context, req *scpb.
 	return &scpb.EnqueueTaskReservationResponse{}, nil
 }
 
-func (s *SchedulerServer) reEnqueueTask(ctx context.Context, taskID string, numReplicas int, reason string) error {
+// recordPreemption records that the task was preempted by a higher priority
+// task. The preempted attempt doesn't count towards the task's attempts, so
+// that tasks aren't failed for being preempted too often.
+func (s *SchedulerServer) recordPreemption(ctx context.Context, taskID string, task *persistedTask) error {
+	task.metadata.PreemptionCount++
+	serializedMetadata, err := proto.Marshal(task.metadata)
+	if err != nil {
+		return status.InternalErrorf("unable to serialize scheduling metadata: %v", err)
+	}
+	key := s.redisKeyForTask(taskID)
+	if err := s.rdb.HSet(ctx, key, redisTaskMetadataField, serializedMetadata).Err(); err != nil {
+		return err
+	}
+	if task.attemptCount > 0 {
+		if err := s.rdb.HIncrBy(ctx, key, redisTaskAttempCountField, -1).Err(); err != nil {
+			return err
+		}
+		task.attemptCount--
+	}
+	return nil
+}
+
+func (s *SchedulerServer) reEnqueueTask(ctx context.Context, taskID string, numReplicas int, reason string, preempted bool) error {
 	if taskID == "" {
 		return status.FailedPreconditionError("A task_id is required")
 	}
@@ -1632,6 +1819,11 @@ func (s *SchedulerServer) reEnqueueTask(ctx context.Context, taskID string, numR
 	if err != nil {
 		return err
 	}
+	if preempted {
+		if err := s.recordPreemption(ctx, taskID, task); err != nil {
+			return err
+		}
+	}
 	if task.attemptCount >= maxTaskAttemptCount {
 		if _, err := s.deleteTask(ctx, taskID); err != nil {
 			return err
@@ -1670,7 +1862,7 @@ func (s *SchedulerServer) reEnqueueTask(ctx context.Context, taskID string, numR
 }
 
 func (s *SchedulerServer) ReEnqueueTask(ctx context.Context, req *scpb.ReEnqueueTaskRequest) (*scpb.ReEnqueueTaskResponse, error) {
-	if err := s.reEnqueueTask(ctx, req.GetTaskId(), probesPerTask, req.GetReason()); err != nil {
+	if err := s.reEnqueueTask(ctx, req.GetTaskId(), probesPerTask, req.GetReason(), req.GetPreempted()); err != nil {
 		log.CtxErrorf(ctx, "ReEnqueueTask failed for task %q: %s", req.GetTaskId(), err)
 		return nil, err
 	}
@@ -1760,8 +1952,340 @@ func (s *SchedulerServer) GetExecutionNodes(ctx context.Context, req *scpb.GetEx
 	}, nil
 }
 
+func redisKeyForDrainingExecutor(executorID string) string {
+	return "drainingExecutor/" + executorID
+}
+
+func redisKeyForExecutorLeases(executorID string) string {
+	return "executorLeases/" + executorID
+}
+
+// drainingExecutorIDs returns which of the given executors are draining.
+func drainingExecutorIDs(ctx context.Context, rdb redis.UniversalClient, executorIDs []string) (map[string]bool, error) {
+	draining := make(map[string]bool)
+	if len(executorIDs) == 0 {
+		return draining, nil
+	}
+	pipe := rdb.Pipeline()
+	cmds := make([]*redis.IntCmd, len(executorIDs))
+	for i, id := range executorIDs {
+		cmds[i] = pipe.Exists(ctx, redisKeyForDrainingExecutor(id))
+	}
+	if _, err := pipe.Exec(ctx); err != nil {
+		return nil, err
+	}
+	for i, id := range executorIDs {
+		if cmds[i].Val() == 1 {
+			draining[id] = true
+		}
+	}
+	return draining, nil
+}
+
+func (s *SchedulerServer) isExecutorDraining(ctx context.Context, executorID string) (bool, error) {
+	n, err := s.rdb.Exists(ctx, redisKeyForDrainingExecutor(executorID)).Result()
+	return n == 1, err
+}
+
+// enqueueOnOtherExecutors enqueues reservations for a task that a draining
+// executor tried to lease, so that it doesn't need to wait for an executor to
+// join the pool if the draining executor held its only reservation.
+func (s *SchedulerServer) enqueueOnOtherExecutors(ctx context.Context, taskID string) {
+	task, err := s.readTask(ctx, taskID)
+	if err != nil {
+		log.CtxWarningf(ctx, "Could not read task %q to enqueue it on other executors: %s", taskID, err)
+		return
+	}
+	enqueueRequest := &scpb.EnqueueTaskReservationRequest{
+		TaskId:             taskID,
+		TaskSize:           task.metadata.GetTaskSize(),
+		SchedulingMetadata: task.metadata,
+	}
+	opts := enqueueTaskReservationOpts{
+		numReplicas:                  probesPerTask,
+		scheduleOnConnectedExecutors: false,
+	}
+	if err := s.enqueueTaskReservations(ctx, enqueueRequest, task.serializedTask, opts); err != nil {
+		log.CtxWarningf(ctx, "Could not enqueue task %q on other executors: %s", taskID, err)
+	}
+}
+
+// trackLease records that the executor leased the task, so that drained
+// executors can be reported as idle once they finish their tasks, and so that
+// the resources used by each pool can be estimated.
+func (s *SchedulerServer) trackLease(ctx context.Context, executorID, taskID string, size *scpb.TaskSize) error {
+	b, err := proto.Marshal(size)
+	if err != nil {
+		return err
+	}
+	key := redisKeyForExecutorLeases(executorID)
+	pipe := s.rdb.TxPipeline()
+	pipe.HSet(ctx, key, taskID, b)
+	pipe.Expire(ctx, key, taskTTL)
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+func (s *SchedulerServer) untrackLease(ctx context.Context, executorID, taskID string) error {
+	return s.rdb.HDel(ctx, redisKeyForExecutorLeases(executorID), taskID).Err()
+}
+
+// authorizeExecutorManagement checks whether the given user may manage the
+// executors owned by the given group, or the shared executors if the group ID
+// is empty. Server admins may manage any executors. Otherwise, the user must
+// have the given capabilities within the owning group, or be authenticated
+// with one of its executor API keys.
+func (s *SchedulerServer) authorizeExecutorManagement(user interfaces.UserInfo, groupID string, c role.Capability) error {
+	if authutil.IsServerAdmin(user, s.env.GetAuthenticator().AdminGroupID()) {
+		return nil
+	}
+	if groupID == "" {
+		return status.PermissionDeniedError("Only server admins may manage shared executors")
+	}
+	if user.GetGroupID() == groupID && user.HasCapability(akpb.ApiKey_REGISTER_EXECUTOR_CAPABILITY) {
+		return nil
+	}
+	return authutil.AuthorizeGroupCapability(user, groupID, c)
+}
+
+// findExecutionNode returns the registration of the executor, if the
+// authenticated user may manage it.
+func (s *SchedulerServer) findExecutionNode(ctx context.Context, executorID string) (*scpb.RegisteredExecutionNode, error) {
+	user, err := perms.AuthenticatedUser(ctx, s.env)
+	if err != nil {
+		return nil, err
+	}
+	groupID := user.GetGroupID()
+	// If executor auth is not enabled, executors do not belong to any group.
+	if !s.requireExecutorAuthorization {
+		groupID = ""
+	}
+	if err := s.authorizeExecutorManagement(user, groupID, role.ManageGroup); err != nil {
+		return nil, err
+	}
+	poolKeys, err := s.rdb.SMembers(ctx, s.redisKeyForExecutorPools(groupID)).Result()
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range poolKeys {
+		data, err := s.rdb.HGet(ctx, k, executorID).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		node := &scpb.RegisteredExecutionNode{}
+		if err := proto.Unmarshal([]byte(data), node); err != nil {
+			return nil, err
+		}
+		if s.requireExecutorAuthorization {
+			if err := perms.AuthorizeWrite(&user, node.GetAcl()); err != nil {
+				return nil, err
+			}
+		}
+		return node, nil
+	}
+	return nil, status.NotFoundErrorf("executor %q not found", executorID)
+}
+
+func (s *SchedulerServer) DrainExecutor(ctx context.Context, req *scpb.DrainExecutorRequest) (*scpb.DrainExecutorResponse, error) {
+	if req.GetExecutorId() == "" {
+		return nil, status.InvalidArgumentError("executor_id is required")
+	}
+	if _, err := s.findExecutionNode(ctx, req.GetExecutorId()); err != nil {
+		return nil, err
+	}
+	drainKey := redisKeyForDrainingExecutor(req.GetExecutorId())
+	if req.GetCancel() {
+		if err := s.rdb.Del(ctx, drainKey).Err(); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := s.rdb.Set(ctx, drainKey, 1, executorDrainTTL).Err(); err != nil {
+			return nil, err
+		}
+	}
+	activeTasks, err := s.rdb.HLen(ctx, redisKeyForExecutorLeases(req.GetExecutorId())).Result()
+	if err != nil {
+		return nil, err
+	}
+	return &scpb.DrainExecutorResponse{
+		ActiveTaskCount: int32(activeTasks),
+		Idle:            !req.GetCancel() && activeTasks == 0,
+	}, nil
+}
+
+// desiredExecutorCount returns the number of executors which can each assign
+// the given resources that are needed to run tasks using the given resources.
+func desiredExecutorCount(executorMemoryBytes, executorMilliCPU, memoryBytes, milliCPU int64) int32 {
+	if memoryBytes <= 0 && milliCPU <= 0 {
+		return 0
+	}
+	if executorMemoryBytes <= 0 || executorMilliCPU <= 0 {
+		// There are no executors to tell how large they are, but at least one
+		// is needed.
+		return 1
+	}
+	n := (memoryBytes + executorMemoryBytes - 1) / executorMemoryBytes
+	if c := (milliCPU + executorMilliCPU - 1) / executorMilliCPU; c > n {
+		n = c
+	}
+	return int32(n)
+}
+
+func (s *SchedulerServer) GetDesiredCapacity(ctx context.Context, req *scpb.GetDesiredCapacityRequest) (*scpb.GetDesiredCapacityResponse, error) {
+	user, err := perms.AuthenticatedUser(ctx, s.env)
+	if err != nil {
+		return nil, err
+	}
+	key := nodePoolKey{
+		os:   strings.ToLower(req.GetOs()),
+		arch: strings.ToLower(req.GetArch()),
+		pool: strings.ToLower(req.GetPool()),
+	}
+	if key.os == "" {
+		key.os = platform.LinuxOperatingSystemName
+	}
+	if key.arch == "" {
+		key.arch = defaultArch
+	}
+	if s.enableUserOwnedExecutors {
+		key.groupID = user.GetGroupID()
+	}
+	if err := s.authorizeExecutorManagement(user, key.groupID, role.ViewExecutionNodes); err != nil {
+		return nil, err
+	}
+	rsp := &scpb.GetDesiredCapacityResponse{}
+
+	// Sum up the capacity of the executors in the pool.
+	redisExecutors, err := s.rdb.HGetAll(ctx, key.redisPoolKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	var executorIDs []string
+	nodes := make(map[string]*scpb.ExecutionNode, len(redisExecutors))
+	for id, data := range redisExecutors {
+		node := &scpb.RegisteredExecutionNode{}
+		if err := proto.Unmarshal([]byte(data), node); err != nil {
+			return nil, err
+		}
+		if *removeStaleExecutors && time.Since(node.GetLastPingTime().AsTime()) > executorMaxRegistrationStaleness {
+			continue
+		}
+		executorIDs = append(executorIDs, id)
+		nodes[id] = node.GetRegistration()
+	}
+	draining, err := drainingExecutorIDs(ctx, s.rdb, executorIDs)
+	if err != nil {
+		return nil, err
+	}
+	var drainingMemoryBytes, drainingMilliCPU int64
+	for id, node := range nodes {
+		memoryBytes := int64(float64(node.GetAssignableMemoryBytes()) * tasksize.MaxResourceCapacityRatio)
+		milliCPU := int64(float64(node.GetAssignableMilliCpu()) * tasksize.MaxResourceCapacityRatio)
+		if draining[id] {
+			rsp.DrainingExecutorCount++
+			drainingMemoryBytes += memoryBytes
+			drainingMilliCPU += milliCPU
+			continue
+		}
+		rsp.ExecutorCount++
+		rsp.AvailableMemoryBytes += memoryBytes
+		rsp.AvailableMilliCpu += milliCPU
+	}
+
+	// Sum up the sizes of the tasks leased by the executors.
+	if len(executorIDs) > 0 {
+		pipe := s.rdb.Pipeline()
+		cmds := make([]*redis.StringSliceCmd, len(executorIDs))
+		for i, id := range executorIDs {
+			cmds[i] = pipe.HVals(ctx, redisKeyForExecutorLeases(id))
+		}
+		if _, err := pipe.Exec(ctx); err != nil && err != redis.Nil {
+			return nil, err
+		}
+		for _, cmd := range cmds {
+			for _, data := range cmd.Val() {
+				size := &scpb.TaskSize{}
+				if err := proto.Unmarshal([]byte(data), size); err != nil {
+					return nil, err
+				}
+				rsp.AssignedMemoryBytes += size.GetEstimatedMemoryBytes()
+				rsp.AssignedMilliCpu += size.GetEstimatedMilliCpu()
+			}
+		}
+	}
+
+	// Estimate the sizes of the queued tasks from a sample of them.
+	queued, err := s.rdb.ZCard(ctx, key.redisUnclaimedTasksKey()).Result()
+	if err != nil {
+		return nil, err
+	}
+	if queued > 0 {
+		sampledIDs, err := s.getOrCreatePool(key).SampleUnclaimedTasks(ctx, queuedTaskSizeSampleCount)
+		if err != nil {
+			return nil, err
+		}
+		// Tasks that were cancelled may still be in the unclaimed task set,
+		// so scale the estimates by the fraction of tasks that still exist.
+		tasks, err := s.readTasks(ctx, sampledIDs)
+		if err != nil {
+			return nil, err
+		}
+		var sampledMemoryBytes, sampledMilliCPU int64
+		for _, task := range tasks {
+			sampledMemoryBytes += task.metadata.GetTaskSize().GetEstimatedMemoryBytes()
+			sampledMilliCPU += task.metadata.GetTaskSize().GetEstimatedMilliCpu()
+		}
+		if len(sampledIDs) > 0 {
+			scale := float64(queued) / float64(len(sampledIDs))
+			rsp.QueuedTaskCount = int32(float64(len(tasks)) * scale)
+			rsp.QueuedMemoryBytes = int64(float64(sampledMemoryBytes) * scale)
+			rsp.QueuedMilliCpu = int64(float64(sampledMilliCPU) * scale)
+		}
+	}
+
+	// Size the desired executors like the current ones, preferring executors
+	// that aren't draining since they are more likely to reflect the current
+	// executor configuration.
+	executorMemoryBytes, executorMilliCPU := drainingMemoryBytes, drainingMilliCPU
+	executorCount := int64(rsp.GetDrainingExecutorCount())
+	if rsp.GetExecutorCount() > 0 {
+		executorMemoryBytes, executorMilliCPU = rsp.GetAvailableMemoryBytes(), rsp.GetAvailableMilliCpu()
+		executorCount = int64(rsp.GetExecutorCount())
+	}
+	if executorCount > 0 {
+		executorMemoryBytes /= executorCount
+		executorMilliCPU /= executorCount
+	}
+	rsp.DesiredExecutorCount = desiredExecutorCount(
+		executorMemoryBytes, executorMilliCPU,
+		rsp.GetAssignedMemoryBytes()+rsp.GetQueuedMemoryBytes(),
+		rsp.GetAssignedMilliCpu()+rsp.GetQueuedMilliCpu())
+	return rsp, nil
+}
+
 // extractRoutingProps deserializes the given task and returns the properties
 // needed to route the task (command and remote instance name).
+// decryptEnvOverrides returns the serialized task with the env overrides that
+// were encrypted while the task was queued decrypted again, so that the
+// executor leasing the task can apply them.
+func (s *SchedulerServer) decryptEnvOverrides(serializedTask []byte) ([]byte, error) {
+	ss := s.env.GetSecretService()
+	if ss == nil {
+		return serializedTask, nil
+	}
+	task := &repb.ExecutionTask{}
+	if err := proto.Unmarshal(serializedTask, task); err != nil {
+		return nil, err
+	}
+	if err := platform.DecryptEnvOverrides(ss, task); err != nil {
+		return nil, err
+	}
+	return proto.Marshal(task)
+}
+
 func extractRoutingProps(serializedTask []byte) (*repb.Command, string, error) {
 	if serializedTask == nil {
 		return nil, "", nil
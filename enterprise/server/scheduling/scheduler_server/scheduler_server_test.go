@@ -7,9 +7,14 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/testutil/enterprise_testenv"
 	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testauth"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/buildbuddy-io/buildbuddy/server/util/testing/flags"
 
 	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+
+	scpb "github.com/buildbuddy-io/buildbuddy/proto/scheduler"
 )
 
 func getScheduleServer(t *testing.T, userOwnedEnabled, groupOwnedEnabled bool, user string) (*SchedulerServer, context.Context) {
@@ -118,3 +123,117 @@ func TestSchedulerServerGetGroupIDAndDefaultPoolForUserSelfHostedByDefault(t *te
 	require.Equal(t, "group1", g)
 	require.Equal(t, "", p)
 }
+
+func TestSetGroupWeight(t *testing.T) {
+	flags.Set(t, "remote_execution.fair_share_group_weights", []GroupWeight{
+		{GroupID: "group1", Weight: 3},
+	})
+
+	req := &scpb.EnqueueTaskReservationRequest{SchedulingMetadata: &scpb.SchedulingMetadata{TaskGroupId: "group1"}}
+	setGroupWeight(req)
+	require.Equal(t, 3.0, req.GetSchedulingMetadata().GetTaskGroupWeight())
+
+	req = &scpb.EnqueueTaskReservationRequest{SchedulingMetadata: &scpb.SchedulingMetadata{TaskGroupId: "group2"}}
+	setGroupWeight(req)
+	require.Equal(t, 1.0, req.GetSchedulingMetadata().GetTaskGroupWeight())
+}
+
+func TestSetPreemptionPolicy(t *testing.T) {
+	flags.Set(t, "remote_execution.preemption_policies", []PoolPreemptionPolicy{
+		{Pool: "", MinPriorityDifference: 5, MaxPreemptions: 2},
+		{Pool: "defaults"},
+	})
+
+	req := &scpb.EnqueueTaskReservationRequest{SchedulingMetadata: &scpb.SchedulingMetadata{}}
+	setPreemptionPolicy(req)
+	require.True(t, proto.Equal(&scpb.PreemptionPolicy{Enabled: true, MinPriorityDifference: 5, MaxPreemptions: 2}, req.GetSchedulingMetadata().GetPreemptionPolicy()))
+
+	req = &scpb.EnqueueTaskReservationRequest{SchedulingMetadata: &scpb.SchedulingMetadata{Pool: "defaults"}}
+	setPreemptionPolicy(req)
+	require.True(t, proto.Equal(&scpb.PreemptionPolicy{Enabled: true, MinPriorityDifference: 1, MaxPreemptions: defaultMaxPreemptions}, req.GetSchedulingMetadata().GetPreemptionPolicy()))
+
+	req = &scpb.EnqueueTaskReservationRequest{SchedulingMetadata: &scpb.SchedulingMetadata{Pool: "other"}}
+	setPreemptionPolicy(req)
+	require.False(t, req.GetSchedulingMetadata().GetPreemptionPolicy().GetEnabled())
+}
+
+func TestDesiredExecutorCount(t *testing.T) {
+	for _, tc := range []struct {
+		name                                  string
+		executorMemoryBytes, executorMilliCPU int64
+		memoryBytes, milliCPU                 int64
+		expected                              int32
+	}{
+		{name: "no tasks", executorMemoryBytes: 100, executorMilliCPU: 1000, expected: 0},
+		{name: "no executors", memoryBytes: 10, milliCPU: 100, expected: 1},
+		{name: "fits on one executor", executorMemoryBytes: 100, executorMilliCPU: 1000, memoryBytes: 100, milliCPU: 1000, expected: 1},
+		{name: "memory bound", executorMemoryBytes: 100, executorMilliCPU: 1000, memoryBytes: 250, milliCPU: 1000, expected: 3},
+		{name: "cpu bound", executorMemoryBytes: 100, executorMilliCPU: 1000, memoryBytes: 100, milliCPU: 4001, expected: 5},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			n := desiredExecutorCount(tc.executorMemoryBytes, tc.executorMilliCPU, tc.memoryBytes, tc.milliCPU)
+			require.Equal(t, tc.expected, n)
+		})
+	}
+}
+
+func TestGetNodes_SkipsDrainingExecutors(t *testing.T) {
+	np := &nodePool{
+		connectedExecutors: []*executionNode{
+			{executorID: "executor1"},
+			{executorID: "executor2"},
+			{executorID: "executor3"},
+		},
+		drainingExecutors: map[string]bool{"executor2": true},
+	}
+
+	var ids []string
+	for _, node := range np.GetNodes(true /*=connectedOnly*/) {
+		ids = append(ids, node.GetExecutorID())
+	}
+	require.Equal(t, []string{"executor1", "executor3"}, ids)
+}
+
+func TestExecutorManagement_RequiresExecutorOwnerAdmin(t *testing.T) {
+	env := enterprise_testenv.GetCustomTestEnv(t, &enterprise_testenv.Options{})
+	testUsers := testauth.TestUsers("admin2", "group2")
+	testUsers["developer1"] = &testauth.TestUser{
+		UserID:           "developer1",
+		GroupID:          "group1",
+		AllowedGroups:    []string{"group1"},
+		GroupMemberships: []*interfaces.GroupMembership{{GroupID: "group1", Role: role.Developer}},
+	}
+	testUsers["viewer1"] = &testauth.TestUser{
+		UserID:           "viewer1",
+		GroupID:          "group1",
+		AllowedGroups:    []string{"group1"},
+		GroupMemberships: []*interfaces.GroupMembership{{GroupID: "group1", Role: role.Custom, CustomCapabilities: role.ViewExecutionNodes}},
+	}
+	ta := testauth.NewTestAuthenticator(testUsers)
+	env.SetAuthenticator(ta)
+
+	// Shared executors may only be managed by server admins, not by admins of
+	// the groups using them.
+	s := &SchedulerServer{env: env}
+	ctx, err := ta.WithAuthenticatedUser(context.Background(), "admin2")
+	require.NoError(t, err)
+	_, err = s.DrainExecutor(ctx, &scpb.DrainExecutorRequest{ExecutorId: "executor1"})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %v", err)
+	_, err = s.GetDesiredCapacity(ctx, &scpb.GetDesiredCapacityRequest{})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %v", err)
+
+	// Group-owned executors may only be managed by admins of the group.
+	s = &SchedulerServer{env: env, enableUserOwnedExecutors: true, requireExecutorAuthorization: true}
+	ctx, err = ta.WithAuthenticatedUser(context.Background(), "developer1")
+	require.NoError(t, err)
+	_, err = s.DrainExecutor(ctx, &scpb.DrainExecutorRequest{ExecutorId: "executor1"})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %v", err)
+	_, err = s.GetDesiredCapacity(ctx, &scpb.GetDesiredCapacityRequest{})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %v", err)
+
+	// Viewing executors doesn't allow draining them.
+	ctx, err = ta.WithAuthenticatedUser(context.Background(), "viewer1")
+	require.NoError(t, err)
+	_, err = s.DrainExecutor(ctx, &scpb.DrainExecutorRequest{ExecutorId: "executor1"})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %v", err)
+}
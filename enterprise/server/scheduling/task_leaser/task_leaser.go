@@ -62,10 +62,11 @@ func (t *TaskLeaser) pingServer() ([]byte, error) {
 	return rsp.GetSerializedTask(), nil
 }
 
-func (t *TaskLeaser) reEnqueueTask(ctx context.Context, reason string) error {
+func (t *TaskLeaser) reEnqueueTask(ctx context.Context, reason string, preempted bool) error {
 	req := &scpb.ReEnqueueTaskRequest{
-		TaskId: t.taskID,
-		Reason: reason,
+		TaskId:    t.taskID,
+		Reason:    reason,
+		Preempted: preempted,
 	}
 	if *apiKey != "" {
 		ctx = metadata.AppendToOutgoingContext(ctx, auth.APIKeyHeader, *apiKey)
@@ -116,6 +117,17 @@ func (t *TaskLeaser) Claim(ctx context.Context) (context.Context, []byte, error)
 }
 
 func (t *TaskLeaser) Close(taskErr error, retry bool) {
+	t.close(taskErr, retry, false /*=preempted*/)
+}
+
+// ClosePreempted releases the lease on a task which was preempted by a higher
+// priority task, and re-enqueues it. Preemptions don't count towards the task's
+// attempts.
+func (t *TaskLeaser) ClosePreempted(taskErr error) {
+	t.close(taskErr, true /*=retry*/, true /*=preempted*/)
+}
+
+func (t *TaskLeaser) close(taskErr error, retry, preempted bool) {
 	t.mu.Lock()
 	defer t.mu.Unlock()
 	t.log.Infof("TaskLeaser %q Close() called with err: %v", t.taskID, taskErr)
@@ -133,7 +145,7 @@ func (t *TaskLeaser) Close(taskErr error, retry bool) {
 
 	// We can finalize the task if the execution was successful, or if it failed and we're not going to retry it.
 	// Otherwise, we should release the lease without finalizing the task so that it can be retried.
-	if taskErr == nil || !retry {
+	if (taskErr == nil || !retry) && !preempted {
 		req.Finalize = true
 	} else {
 		req.Release = true
@@ -165,7 +177,7 @@ func (t *TaskLeaser) Close(taskErr error, retry bool) {
 		if taskErr != nil {
 			reason = taskErr.Error()
 		}
-		if err := t.reEnqueueTask(context.Background(), reason); err != nil {
+		if err := t.reEnqueueTask(context.Background(), reason, preempted); err != nil {
 			t.log.Warningf("TaskLeaser %q: error re-enqueueing task: %s", t.taskID, err.Error())
 		} else {
 			t.log.Infof("TaskLeaser %q: Successfully re-enqueued.", t.taskID)
@@ -0,0 +1,1123 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/auth"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/backends/userdb"
+	"github.com/buildbuddy-io/buildbuddy/server/endpoint_urls/build_buddy_url"
+	"github.com/buildbuddy-io/buildbuddy/server/environment"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/util/db"
+	"github.com/buildbuddy-io/buildbuddy/server/util/log"
+	"github.com/buildbuddy-io/buildbuddy/server/util/perms"
+	"github.com/buildbuddy-io/buildbuddy/server/util/query_builder"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/proto"
+
+	httpfilters "github.com/buildbuddy-io/buildbuddy/server/http/filters"
+
+	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
+	alpb "github.com/buildbuddy-io/buildbuddy/proto/auditlog"
+	grpb "github.com/buildbuddy-io/buildbuddy/proto/group"
+	uidpb "github.com/buildbuddy-io/buildbuddy/proto/user_id"
+	gstatus "google.golang.org/grpc/status"
+)
+
+var (
+	enableSCIM = flag.Bool("auth.enable_scim", false, "If true, organizations can provision their users over SCIM 2.0 at /scim/v2/, authenticating with an API key that has the ORG_ADMIN capability. ** Enterprise only **")
+)
+
+const (
+	pathPrefix = "/scim/v2/"
+
+	usersResource  = "Users"
+	groupsResource = "Groups"
+
+	contentType = "application/scim+json"
+
+	userSchema         = "urn:ietf:params:scim:schemas:core:2.0:User"
+	groupSchema        = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	listResponseSchema = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	errorSchema        = "urn:ietf:params:scim:api:messages:2.0:Error"
+
+	// Names of the built-in group roles. These are the values of the "roles"
+	// user attribute and the IDs of the SCIM groups for these roles. Custom
+	// roles are referred to by their name and ID respectively.
+	adminRoleName     = "admin"
+	developerRoleName = "developer"
+
+	// maxPageSize is the maximum number of resources returned by a single
+	// list request.
+	maxPageSize = 1000
+)
+
+var (
+	// Matches the only supported filter expression: `<attribute> eq "<value>"`.
+	filterPattern = regexp.MustCompile(`^\s*([A-Za-z.]+)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*$`)
+	// Matches the path of a single group member, as used to remove members.
+	memberPathPattern = regexp.MustCompile(`^(?i:members)\[\s*(?i:value)\s+(?i:eq)\s+("(?:[^"\\]|\\.)*")\s*\]$`)
+)
+
+type name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+type multiValue struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+type meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+}
+
+type userResource struct {
+	Schemas  []string      `json:"schemas"`
+	ID       string        `json:"id,omitempty"`
+	UserName string        `json:"userName"`
+	Name     *name         `json:"name,omitempty"`
+	Emails   []*multiValue `json:"emails,omitempty"`
+	Active   *bool         `json:"active,omitempty"`
+	Roles    []*multiValue `json:"roles,omitempty"`
+	Meta     *meta         `json:"meta,omitempty"`
+}
+
+func (u *userResource) primaryEmail() string {
+	email := ""
+	for i, e := range u.Emails {
+		if e.Primary || i == 0 {
+			email = e.Value
+		}
+	}
+	return strings.TrimSpace(email)
+}
+
+// email returns the user's email address, which is their primary email if
+// set and their user name otherwise.
+func (u *userResource) email() (string, error) {
+	email := u.primaryEmail()
+	if email == "" {
+		email = strings.TrimSpace(u.UserName)
+	}
+	return validateEmail(email)
+}
+
+func validateEmail(email string) (string, error) {
+	if !strings.Contains(email, "@") {
+		return "", status.InvalidArgumentErrorf("Invalid email address %q.", email)
+	}
+	return email, nil
+}
+
+type groupResource struct {
+	Schemas     []string      `json:"schemas"`
+	ID          string        `json:"id,omitempty"`
+	DisplayName string        `json:"displayName"`
+	Members     []*multiValue `json:"members"`
+	Meta        *meta         `json:"meta,omitempty"`
+}
+
+type listResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int           `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type patchRequest struct {
+	Schemas    []string          `json:"schemas"`
+	Operations []*patchOperation `json:"Operations"`
+}
+
+type patchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+type errorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail"`
+}
+
+// member is a user who belongs to the authenticated group, either as an
+// active member or as a member deactivated by the group's identity provider.
+type member struct {
+	UserID           string
+	SubID            string
+	Email            string
+	FirstName        string
+	LastName         string
+	CreatedAtUsec    int64
+	UpdatedAtUsec    int64
+	MembershipStatus int32
+	Role             uint32
+	CustomRoleID     string
+}
+
+func (m *member) active() bool {
+	return m.MembershipStatus == int32(grpb.GroupMembershipStatus_MEMBER)
+}
+
+func (m *member) user() *tables.User {
+	return &tables.User{UserID: m.UserID, FirstName: m.FirstName, LastName: m.LastName, Email: m.Email}
+}
+
+// membershipAuditState returns the member's group membership as recorded in
+// the audit log.
+func (m *member) membershipAuditState() *grpb.GetGroupUsersResponse_GroupUser {
+	return &grpb.GetGroupUsersResponse_GroupUser{
+		GroupMembershipStatus: grpb.GroupMembershipStatus(m.MembershipStatus),
+		Role:                  role.ToProto(role.Role(m.Role)),
+		CustomRoleId:          m.CustomRoleID,
+	}
+}
+
+// userAuditState returns the member's profile and group membership as
+// recorded in the audit log.
+func (m *member) userAuditState() *grpb.GetGroupUsersResponse_GroupUser {
+	s := m.membershipAuditState()
+	s.User = m.user().ToProto()
+	return s
+}
+
+// groupRole is a role that can be assigned to the group's members. Each role
+// is exposed as a SCIM group.
+type groupRole struct {
+	id           string
+	name         string
+	role         role.Role
+	customRoleID string
+}
+
+func (r *groupRole) assignedTo(m *member) bool {
+	return role.ToProto(role.Role(m.Role)) == role.ToProto(r.role) && m.CustomRoleID == r.customRoleID
+}
+
+func (r *groupRole) update(userID string) *grpb.UpdateGroupUsersRequest_Update {
+	return &grpb.UpdateGroupUsersRequest_Update{
+		UserId:       &uidpb.UserId{Id: userID},
+		Role:         role.ToProto(r.role),
+		CustomRoleId: r.customRoleID,
+	}
+}
+
+type SCIMServer struct {
+	env environment.Env
+	h   interfaces.DBHandle
+}
+
+// Register registers the SCIM API on the HTTP mux if SCIM is enabled.
+func Register(env environment.Env) error {
+	if !*enableSCIM {
+		return nil
+	}
+	if env.GetDBHandle() == nil || env.GetUserDB() == nil {
+		return status.FailedPreconditionError("SCIM requires a database and user DB to be configured")
+	}
+	env.GetMux().Handle(pathPrefix, httpfilters.WrapExternalHandler(env, NewSCIMServer(env, env.GetDBHandle())))
+	return nil
+}
+
+func NewSCIMServer(env environment.Env, h interfaces.DBHandle) *SCIMServer {
+	return &SCIMServer{env: env, h: h}
+}
+
+func (s *SCIMServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	code, rsp, err := s.serve(r)
+	if err != nil {
+		code = httpStatus(err)
+		errRsp := &errorResponse{
+			Schemas: []string{errorSchema},
+			Status:  strconv.Itoa(code),
+			Detail:  status.Message(err),
+		}
+		if status.IsAlreadyExistsError(err) {
+			errRsp.ScimType = "uniqueness"
+		}
+		rsp = errRsp
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	if rsp == nil {
+		return
+	}
+	if err := json.NewEncoder(w).Encode(rsp); err != nil {
+		log.Warningf("Failed to write SCIM response: %s", err)
+	}
+}
+
+func httpStatus(err error) int {
+	switch gstatus.Code(err) {
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// authenticate authenticates the request's API key, which must belong to a
+// group and have the ORG_ADMIN capability. It returns the authenticated
+// context and the ID of the group whose users are managed by the request.
+func (s *SCIMServer) authenticate(r *http.Request) (context.Context, string, error) {
+	apiKey := r.Header.Get(auth.APIKeyHeader)
+	if authz := r.Header.Get("Authorization"); apiKey == "" && strings.HasPrefix(authz, "Bearer ") {
+		apiKey = strings.TrimSpace(strings.TrimPrefix(authz, "Bearer "))
+	}
+	if apiKey == "" {
+		return nil, "", status.UnauthenticatedError("An API key is required.")
+	}
+	ctx := s.env.GetAuthenticator().AuthContextFromAPIKey(r.Context(), apiKey)
+	u, err := perms.AuthenticatedUser(ctx, s.env)
+	if err != nil {
+		return nil, "", err
+	}
+	if u.GetGroupID() == "" || !u.HasCapability(akpb.ApiKey_ORG_ADMIN_CAPABILITY) {
+		return nil, "", status.PermissionDeniedError("The API key does not have the org admin capability.")
+	}
+	return ctx, u.GetGroupID(), nil
+}
+
+func (s *SCIMServer) serve(r *http.Request) (int, interface{}, error) {
+	ctx, groupID, err := s.authenticate(r)
+	if err != nil {
+		return 0, nil, err
+	}
+	parts := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, pathPrefix), "/"), "/")
+	if len(parts) > 2 {
+		return 0, nil, status.NotFoundErrorf("Unknown path %q.", r.URL.Path)
+	}
+	id := ""
+	if len(parts) == 2 {
+		id = parts[1]
+	}
+	switch parts[0] {
+	case usersResource:
+		return s.serveUsers(ctx, groupID, r, id)
+	case groupsResource:
+		return s.serveGroups(ctx, groupID, r, id)
+	default:
+		return 0, nil, status.NotFoundErrorf("Unknown resource type %q.", parts[0])
+	}
+}
+
+func decodeBody(r *http.Request, v interface{}) error {
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil {
+		return status.InvalidArgumentErrorf("Invalid request body: %s", err)
+	}
+	return nil
+}
+
+func unsupportedMethod(r *http.Request) error {
+	return status.UnimplementedErrorf("%s is not supported on %s.", r.Method, r.URL.Path)
+}
+
+func (s *SCIMServer) serveUsers(ctx context.Context, groupID string, r *http.Request, id string) (int, interface{}, error) {
+	roles, err := s.groupRoles(ctx, groupID)
+	if err != nil {
+		return 0, nil, err
+	}
+	if id == "" {
+		switch r.Method {
+		case http.MethodGet:
+			rsp, err := s.listUsers(ctx, groupID, roles, r.URL.Query())
+			return http.StatusOK, rsp, err
+		case http.MethodPost:
+			req := &userResource{}
+			if err := decodeBody(r, req); err != nil {
+				return 0, nil, err
+			}
+			m, err := s.createUser(ctx, groupID, roles, req)
+			if err != nil {
+				return 0, nil, err
+			}
+			return http.StatusCreated, userToResource(m, roles), nil
+		default:
+			return 0, nil, unsupportedMethod(r)
+		}
+	}
+
+	m, err := s.getMember(ctx, groupID, id)
+	if err != nil {
+		return 0, nil, err
+	}
+	switch r.Method {
+	case http.MethodGet:
+		return http.StatusOK, userToResource(m, roles), nil
+	case http.MethodPut:
+		req := &userResource{}
+		if err := decodeBody(r, req); err != nil {
+			return 0, nil, err
+		}
+		m, err := s.updateUser(ctx, groupID, roles, m, req)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusOK, userToResource(m, roles), nil
+	case http.MethodPatch:
+		req := &patchRequest{}
+		if err := decodeBody(r, req); err != nil {
+			return 0, nil, err
+		}
+		desired := userToResource(m, roles)
+		for _, op := range req.Operations {
+			if err := applyUserPatch(desired, op); err != nil {
+				return 0, nil, err
+			}
+		}
+		m, err := s.updateUser(ctx, groupID, roles, m, desired)
+		if err != nil {
+			return 0, nil, err
+		}
+		return http.StatusOK, userToResource(m, roles), nil
+	case http.MethodDelete:
+		return http.StatusNoContent, nil, s.deleteUser(ctx, groupID, m)
+	default:
+		return 0, nil, unsupportedMethod(r)
+	}
+}
+
+func (s *SCIMServer) serveGroups(ctx context.Context, groupID string, r *http.Request, id string) (int, interface{}, error) {
+	roles, err := s.groupRoles(ctx, groupID)
+	if err != nil {
+		return 0, nil, err
+	}
+	members, err := s.getMembers(ctx, groupID, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	if id == "" {
+		if r.Method != http.MethodGet {
+			return 0, nil, unsupportedMethod(r)
+		}
+		rsp, err := listGroups(roles, members, r.URL.Query())
+		return http.StatusOK, rsp, err
+	}
+
+	var gr *groupRole
+	for _, candidate := range roles {
+		if candidate.id == id {
+			gr = candidate
+		}
+	}
+	if gr == nil {
+		return 0, nil, status.NotFoundErrorf("Group %q not found.", id)
+	}
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPut:
+		req := &groupResource{}
+		if err := decodeBody(r, req); err != nil {
+			return 0, nil, err
+		}
+		if err := s.replaceRoleMembers(ctx, groupID, gr, members, req.Members); err != nil {
+			return 0, nil, err
+		}
+	case http.MethodPatch:
+		req := &patchRequest{}
+		if err := decodeBody(r, req); err != nil {
+			return 0, nil, err
+		}
+		for _, op := range req.Operations {
+			if err := s.applyGroupPatch(ctx, groupID, gr, members, op); err != nil {
+				return 0, nil, err
+			}
+			// Reload the members so that later operations see the effect
+			// of earlier ones.
+			if members, err = s.getMembers(ctx, groupID, nil); err != nil {
+				return 0, nil, err
+			}
+		}
+	default:
+		return 0, nil, unsupportedMethod(r)
+	}
+	if r.Method != http.MethodGet {
+		if members, err = s.getMembers(ctx, groupID, nil); err != nil {
+			return 0, nil, err
+		}
+	}
+	return http.StatusOK, roleToResource(gr, members), nil
+}
+
+// groupRoles returns the roles that can be assigned to the group's members.
+func (s *SCIMServer) groupRoles(ctx context.Context, groupID string) ([]*groupRole, error) {
+	roles := []*groupRole{
+		{id: adminRoleName, name: adminRoleName, role: role.Admin},
+		{id: developerRoleName, name: developerRoleName, role: role.Developer},
+	}
+	customRoles, err := s.env.GetUserDB().GetCustomRoles(ctx, groupID)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range customRoles {
+		roles = append(roles, &groupRole{id: r.RoleID, name: r.Name, role: role.Custom, customRoleID: r.RoleID})
+	}
+	return roles, nil
+}
+
+func defaultRole(roles []*groupRole) *groupRole {
+	for _, r := range roles {
+		if r.role == role.Default && r.customRoleID == "" {
+			return r
+		}
+	}
+	return nil
+}
+
+// resolveRole returns the role named by the primary value of a user's "roles"
+// attribute. Users without any roles are assigned the default role.
+func resolveRole(roles []*groupRole, values []*multiValue) (*groupRole, error) {
+	if len(values) == 0 {
+		return defaultRole(roles), nil
+	}
+	value := values[0].Value
+	for _, v := range values {
+		if v.Primary {
+			value = v.Value
+		}
+	}
+	for _, r := range roles {
+		if strings.EqualFold(r.name, value) || r.id == value {
+			return r, nil
+		}
+	}
+	return nil, status.InvalidArgumentErrorf("Unknown role %q.", value)
+}
+
+func formatTime(usec int64) string {
+	if usec == 0 {
+		return ""
+	}
+	return time.UnixMicro(usec).UTC().Format(time.RFC3339)
+}
+
+func location(resourceType, id string) string {
+	return build_buddy_url.WithPath(pathPrefix + resourceType + "/" + id).String()
+}
+
+func userToResource(m *member, roles []*groupRole) *userResource {
+	active := m.active()
+	u := &userResource{
+		Schemas:  []string{userSchema},
+		ID:       m.UserID,
+		UserName: m.Email,
+		Name: &name{
+			Formatted:  strings.TrimSpace(m.FirstName + " " + m.LastName),
+			GivenName:  m.FirstName,
+			FamilyName: m.LastName,
+		},
+		Emails: []*multiValue{{Value: m.Email, Type: "work", Primary: true}},
+		Active: &active,
+		Meta: &meta{
+			ResourceType: "User",
+			Created:      formatTime(m.CreatedAtUsec),
+			LastModified: formatTime(m.UpdatedAtUsec),
+			Location:     location(usersResource, m.UserID),
+		},
+	}
+	for _, r := range roles {
+		if r.assignedTo(m) {
+			u.Roles = []*multiValue{{Value: r.name, Primary: true}}
+		}
+	}
+	return u
+}
+
+func roleToResource(r *groupRole, members []*member) *groupResource {
+	g := &groupResource{
+		Schemas:     []string{groupSchema},
+		ID:          r.id,
+		DisplayName: r.name,
+		Members:     []*multiValue{},
+		Meta: &meta{
+			ResourceType: "Group",
+			Location:     location(groupsResource, r.id),
+		},
+	}
+	for _, m := range members {
+		if r.assignedTo(m) {
+			g.Members = append(g.Members, &multiValue{Value: m.UserID, Display: m.Email})
+		}
+	}
+	return g
+}
+
+// parseFilter parses a filter of the form `<attribute> eq "<value>"`, which is
+// the only kind of filter supported.
+func parseFilter(filter string) (attribute string, value string, err error) {
+	match := filterPattern.FindStringSubmatch(filter)
+	if match == nil {
+		return "", "", status.InvalidArgumentErrorf("Unsupported filter %q.", filter)
+	}
+	value, err = strconv.Unquote(match[2])
+	if err != nil {
+		return "", "", status.InvalidArgumentErrorf("Invalid filter value %s.", match[2])
+	}
+	return strings.ToLower(match[1]), value, nil
+}
+
+// page returns the requested page of a list of resources. Indexes are
+// 1-based.
+func page(resources []interface{}, params url.Values) (*listResponse, error) {
+	startIndex, count := 1, maxPageSize
+	if v := params.Get("startIndex"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, status.InvalidArgumentErrorf("Invalid startIndex %q.", v)
+		}
+		if i > 1 {
+			startIndex = i
+		}
+	}
+	if v := params.Get("count"); v != "" {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, status.InvalidArgumentErrorf("Invalid count %q.", v)
+		}
+		if i < 0 {
+			i = 0
+		}
+		if i < count {
+			count = i
+		}
+	}
+	rsp := &listResponse{
+		Schemas:      []string{listResponseSchema},
+		TotalResults: len(resources),
+		StartIndex:   startIndex,
+		Resources:    []interface{}{},
+	}
+	if start := startIndex - 1; start < len(resources) {
+		end := start + count
+		if end > len(resources) {
+			end = len(resources)
+		}
+		rsp.Resources = resources[start:end]
+	}
+	rsp.ItemsPerPage = len(rsp.Resources)
+	return rsp, nil
+}
+
+// getMembers returns the group's members, optionally narrowed down with an
+// additional where clause.
+func (s *SCIMServer) getMembers(ctx context.Context, groupID string, where func(q *query_builder.Query)) ([]*member, error) {
+	q := query_builder.NewQuery(`
+		SELECT u.user_id, u.sub_id, u.email, u.first_name, u.last_name,
+			u.created_at_usec, u.updated_at_usec,
+			ug.membership_status, ug.role, ug.custom_role_id
+		FROM Users AS u JOIN UserGroups AS ug ON u.user_id = ug.user_user_id`)
+	q.AddWhereClause("ug.group_group_id = ?", groupID)
+	q.AddWhereClause("(ug.membership_status = ? OR ug.membership_status = ?)",
+		int32(grpb.GroupMembershipStatus_MEMBER), int32(grpb.GroupMembershipStatus_DEACTIVATED))
+	if where != nil {
+		where(q)
+	}
+	q.SetOrderBy("u.user_id", true /*=ascending*/)
+	qStr, qArgs := q.Build()
+	members := make([]*member, 0)
+	if err := s.h.DB(ctx).Raw(qStr, qArgs...).Scan(&members).Error; err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+func (s *SCIMServer) getMember(ctx context.Context, groupID, userID string) (*member, error) {
+	members, err := s.getMembers(ctx, groupID, func(q *query_builder.Query) {
+		q.AddWhereClause("u.user_id = ?", userID)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(members) == 0 {
+		return nil, status.NotFoundErrorf("User %q not found.", userID)
+	}
+	return members[0], nil
+}
+
+func (s *SCIMServer) getMembersByEmail(ctx context.Context, groupID, email string) ([]*member, error) {
+	return s.getMembers(ctx, groupID, func(q *query_builder.Query) {
+		q.AddWhereClause("LOWER(u.email) = ?", strings.ToLower(email))
+	})
+}
+
+func (s *SCIMServer) listUsers(ctx context.Context, groupID string, roles []*groupRole, params url.Values) (*listResponse, error) {
+	var where func(q *query_builder.Query)
+	if filter := params.Get("filter"); filter != "" {
+		attribute, value, err := parseFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		switch attribute {
+		case "username", "emails", "emails.value":
+			where = func(q *query_builder.Query) {
+				q.AddWhereClause("LOWER(u.email) = ?", strings.ToLower(value))
+			}
+		case "id":
+			where = func(q *query_builder.Query) {
+				q.AddWhereClause("u.user_id = ?", value)
+			}
+		default:
+			return nil, status.InvalidArgumentErrorf("Filtering users by %q is not supported.", attribute)
+		}
+	}
+	members, err := s.getMembers(ctx, groupID, where)
+	if err != nil {
+		return nil, err
+	}
+	resources := make([]interface{}, 0, len(members))
+	for _, m := range members {
+		resources = append(resources, userToResource(m, roles))
+	}
+	return page(resources, params)
+}
+
+func listGroups(roles []*groupRole, members []*member, params url.Values) (*listResponse, error) {
+	displayName := ""
+	if filter := params.Get("filter"); filter != "" {
+		attribute, value, err := parseFilter(filter)
+		if err != nil {
+			return nil, err
+		}
+		if attribute != "displayname" {
+			return nil, status.InvalidArgumentErrorf("Filtering groups by %q is not supported.", attribute)
+		}
+		displayName = value
+	}
+	resources := make([]interface{}, 0, len(roles))
+	for _, r := range roles {
+		if displayName != "" && !strings.EqualFold(r.name, displayName) {
+			continue
+		}
+		resources = append(resources, roleToResource(r, members))
+	}
+	return page(resources, params)
+}
+
+func (s *SCIMServer) auditLog(ctx context.Context, groupID string, action alpb.Action, resource *alpb.ResourceID, before, after proto.Message) {
+	if al := s.env.GetAuditLogger(); al != nil {
+		al.LogForGroup(ctx, groupID, action, resource, before, after)
+	}
+}
+
+func userAuditResource(m *member) *alpb.ResourceID {
+	return &alpb.ResourceID{Type: alpb.ResourceType_USER, Id: m.UserID, Name: m.Email}
+}
+
+// getUserByEmail returns the user who has logged in to BuildBuddy with the
+// given email address, or nil if there is no such user. Users that were
+// provisioned by an identity provider but have not logged in yet are skipped.
+func (s *SCIMServer) getUserByEmail(ctx context.Context, email string) (*tables.User, error) {
+	users := make([]*tables.User, 0)
+	err := s.h.DB(ctx).Raw(
+		`SELECT * FROM Users WHERE LOWER(email) = ? ORDER BY user_id ASC`,
+		strings.ToLower(email),
+	).Scan(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if !userdb.IsProvisionedSubID(u.SubID) {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+// createUser provisions a user as a member of the group. If someone has
+// already logged in to BuildBuddy with the user's email address, their
+// existing account is added to the group. Otherwise a new user is created,
+// which is linked to the account they log in with when they first log in
+// through the group's SSO.
+func (s *SCIMServer) createUser(ctx context.Context, groupID string, roles []*groupRole, req *userResource) (*member, error) {
+	email, err := req.email()
+	if err != nil {
+		return nil, err
+	}
+	r, err := resolveRole(roles, req.Roles)
+	if err != nil {
+		return nil, err
+	}
+	existing, err := s.getMembersByEmail(ctx, groupID, email)
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 {
+		return nil, status.AlreadyExistsErrorf("User %q already exists.", email)
+	}
+	u, err := s.getUserByEmail(ctx, email)
+	if err != nil {
+		return nil, err
+	}
+	isNewUser := u == nil
+	if isNewUser {
+		pk, err := tables.PrimaryKeyForTable("Users")
+		if err != nil {
+			return nil, err
+		}
+		u = &tables.User{
+			UserID: pk,
+			SubID:  userdb.ProvisionedSubID(groupID, email),
+			Email:  email,
+		}
+		if req.Name != nil {
+			u.FirstName = req.Name.GivenName
+			u.LastName = req.Name.FamilyName
+		}
+	}
+	membershipStatus := grpb.GroupMembershipStatus_MEMBER
+	if req.Active != nil && !*req.Active {
+		membershipStatus = grpb.GroupMembershipStatus_DEACTIVATED
+	}
+	err = s.h.Transaction(ctx, func(tx *db.DB) error {
+		if isNewUser {
+			if err := tx.Create(u).Error; err != nil {
+				return err
+			}
+		} else {
+			// The existing user may have requested to join the group; the
+			// provisioned membership replaces the pending request.
+			err := tx.Exec(
+				`DELETE FROM UserGroups WHERE user_user_id = ? AND group_group_id = ?`,
+				u.UserID, groupID,
+			).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Create(&tables.UserGroup{
+			UserUserID:       u.UserID,
+			GroupGroupID:     groupID,
+			Role:             uint32(r.role),
+			CustomRoleID:     r.customRoleID,
+			MembershipStatus: int32(membershipStatus),
+		}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	m, err := s.getMember(ctx, groupID, u.UserID)
+	if err != nil {
+		return nil, err
+	}
+	s.auditLog(ctx, groupID, alpb.Action_CREATE, userAuditResource(m), nil, m.userAuditState())
+	return m, nil
+}
+
+// ownsUser returns whether the user belongs to no group other than the given
+// one, in which case the group's identity provider manages their profile.
+func (s *SCIMServer) ownsUser(ctx context.Context, groupID, userID string) (bool, error) {
+	row := &struct{ Count int64 }{}
+	err := s.h.DB(ctx).Raw(
+		`SELECT COUNT(*) AS count FROM UserGroups WHERE user_user_id = ? AND group_group_id != ?`,
+		userID, groupID,
+	).Take(row).Error
+	if err != nil {
+		return false, err
+	}
+	return row.Count == 0, nil
+}
+
+// updateUser updates a member to match the desired state. Attributes that
+// are absent from the desired state are left unchanged. Profile attributes
+// are only updated for users who belong to no other group.
+func (s *SCIMServer) updateUser(ctx context.Context, groupID string, roles []*groupRole, m *member, desired *userResource) (*member, error) {
+	update := &grpb.UpdateGroupUsersRequest_Update{UserId: &uidpb.UserId{Id: m.UserID}}
+	if desired.Roles != nil {
+		r, err := resolveRole(roles, desired.Roles)
+		if err != nil {
+			return nil, err
+		}
+		if !r.assignedTo(m) {
+			update = r.update(m.UserID)
+		}
+	}
+	deactivate := false
+	if desired.Active != nil {
+		if *desired.Active && !m.active() {
+			update.MembershipAction = grpb.UpdateGroupUsersRequest_Update_ADD
+		}
+		deactivate = !*desired.Active && m.active()
+	}
+	if update.GetRole() != grpb.Group_UNKNOWN_ROLE || update.GetMembershipAction() != grpb.UpdateGroupUsersRequest_Update_UNKNOWN_MEMBERSHIP_ACTION {
+		if err := s.env.GetUserDB().UpdateGroupUsers(ctx, groupID, []*grpb.UpdateGroupUsersRequest_Update{update}); err != nil {
+			return nil, err
+		}
+	}
+	if deactivate {
+		if err := s.deactivate(ctx, groupID, m); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.updateProfile(ctx, groupID, m, desired); err != nil {
+		return nil, err
+	}
+	return s.getMember(ctx, groupID, m.UserID)
+}
+
+// deactivate revokes a member's access to the group while keeping their
+// membership, so that it can be reactivated later.
+func (s *SCIMServer) deactivate(ctx context.Context, groupID string, m *member) error {
+	err := s.h.DB(ctx).Exec(`
+		UPDATE UserGroups
+		SET membership_status = ?
+		WHERE user_user_id = ? AND group_group_id = ?
+		`, int32(grpb.GroupMembershipStatus_DEACTIVATED), m.UserID, groupID,
+	).Error
+	if err != nil {
+		return err
+	}
+	after := m.membershipAuditState()
+	after.GroupMembershipStatus = grpb.GroupMembershipStatus_DEACTIVATED
+	resource := &alpb.ResourceID{Type: alpb.ResourceType_GROUP_MEMBERSHIP, Id: m.UserID, Name: m.Email}
+	s.auditLog(ctx, groupID, alpb.Action_UPDATE, resource, m.membershipAuditState(), after)
+	return nil
+}
+
+func (s *SCIMServer) updateProfile(ctx context.Context, groupID string, m *member, desired *userResource) error {
+	// Either the user name or the primary email may have been changed; both
+	// refer to the user's email address.
+	updated := m.user()
+	for _, email := range []string{strings.TrimSpace(desired.UserName), desired.primaryEmail()} {
+		if email == "" || strings.EqualFold(email, m.Email) {
+			continue
+		}
+		email, err := validateEmail(email)
+		if err != nil {
+			return err
+		}
+		updated.Email = email
+		break
+	}
+	if desired.Name != nil {
+		updated.FirstName = desired.Name.GivenName
+		updated.LastName = desired.Name.FamilyName
+	}
+	if updated.Email == m.Email && updated.FirstName == m.FirstName && updated.LastName == m.LastName {
+		return nil
+	}
+	owned, err := s.ownsUser(ctx, groupID, m.UserID)
+	if err != nil || !owned {
+		return err
+	}
+	if !strings.EqualFold(updated.Email, m.Email) {
+		existing, err := s.getMembersByEmail(ctx, groupID, updated.Email)
+		if err != nil {
+			return err
+		}
+		if len(existing) > 0 {
+			return status.AlreadyExistsErrorf("User %q already exists.", updated.Email)
+		}
+	}
+	// Users who haven't logged in yet are identified by their email address
+	// until they do.
+	subID := m.SubID
+	if subID == userdb.ProvisionedSubID(groupID, m.Email) {
+		subID = userdb.ProvisionedSubID(groupID, updated.Email)
+	}
+	err = s.h.DB(ctx).Exec(`
+		UPDATE Users
+		SET email = ?, first_name = ?, last_name = ?, sub_id = ?
+		WHERE user_id = ?
+		`, updated.Email, updated.FirstName, updated.LastName, subID, m.UserID,
+	).Error
+	if err != nil {
+		return err
+	}
+	s.auditLog(ctx, groupID, alpb.Action_UPDATE, userAuditResource(m), m.user().ToProto(), updated.ToProto())
+	return nil
+}
+
+// deleteUser removes a member from the group. The user's account is deleted
+// as well if they belong to no other group.
+func (s *SCIMServer) deleteUser(ctx context.Context, groupID string, m *member) error {
+	owned, err := s.ownsUser(ctx, groupID, m.UserID)
+	if err != nil {
+		return err
+	}
+	remove := &grpb.UpdateGroupUsersRequest_Update{
+		UserId:           &uidpb.UserId{Id: m.UserID},
+		MembershipAction: grpb.UpdateGroupUsersRequest_Update_REMOVE,
+	}
+	if err := s.env.GetUserDB().UpdateGroupUsers(ctx, groupID, []*grpb.UpdateGroupUsersRequest_Update{remove}); err != nil {
+		return err
+	}
+	if !owned {
+		return nil
+	}
+	if err := s.env.GetUserDB().DeleteUser(ctx, m.UserID); err != nil {
+		return err
+	}
+	s.auditLog(ctx, groupID, alpb.Action_DELETE, userAuditResource(m), m.userAuditState(), nil)
+	return nil
+}
+
+// replaceRoleMembers assigns the role to exactly the given members. Members
+// who previously had the role are reverted to the default role.
+func (s *SCIMServer) replaceRoleMembers(ctx context.Context, groupID string, r *groupRole, members []*member, values []*multiValue) error {
+	remove := make([]string, 0)
+	for _, m := range members {
+		if r.assignedTo(m) {
+			remove = append(remove, m.UserID)
+		}
+	}
+	add := make([]string, 0, len(values))
+	for _, v := range values {
+		add = append(add, v.Value)
+	}
+	return s.updateRoleMembers(ctx, groupID, r, members, add, remove)
+}
+
+// updateRoleMembers assigns the role to the added members and reverts the
+// removed members to the default role.
+func (s *SCIMServer) updateRoleMembers(ctx context.Context, groupID string, r *groupRole, members []*member, add, remove []string) error {
+	byID := make(map[string]*member, len(members))
+	for _, m := range members {
+		byID[m.UserID] = m
+	}
+	roles, err := s.groupRoles(ctx, groupID)
+	if err != nil {
+		return err
+	}
+	updates := make(map[string]*grpb.UpdateGroupUsersRequest_Update)
+	for _, userID := range remove {
+		if m, ok := byID[userID]; ok && r.assignedTo(m) {
+			updates[userID] = defaultRole(roles).update(userID)
+		}
+	}
+	for _, userID := range add {
+		m, ok := byID[userID]
+		if !ok {
+			return status.NotFoundErrorf("User %q not found.", userID)
+		}
+		if r.assignedTo(m) {
+			delete(updates, userID)
+			continue
+		}
+		updates[userID] = r.update(userID)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	req := make([]*grpb.UpdateGroupUsersRequest_Update, 0, len(updates))
+	for _, u := range updates {
+		req = append(req, u)
+	}
+	return s.env.GetUserDB().UpdateGroupUsers(ctx, groupID, req)
+}
+
+func (s *SCIMServer) applyGroupPatch(ctx context.Context, groupID string, r *groupRole, members []*member, op *patchOperation) error {
+	var values []*multiValue
+	if len(op.Value) > 0 && string(op.Value) != "null" {
+		if err := json.Unmarshal(op.Value, &values); err != nil {
+			return status.InvalidArgumentErrorf("Invalid members value: %s", err)
+		}
+	}
+	userIDs := make([]string, 0, len(values))
+	for _, v := range values {
+		userIDs = append(userIDs, v.Value)
+	}
+	if match := memberPathPattern.FindStringSubmatch(op.Path); match != nil {
+		userID, err := strconv.Unquote(match[1])
+		if err != nil {
+			return status.InvalidArgumentErrorf("Invalid path %q.", op.Path)
+		}
+		if !strings.EqualFold(op.Op, "remove") {
+			return status.InvalidArgumentErrorf("Unsupported operation %q on path %q.", op.Op, op.Path)
+		}
+		return s.updateRoleMembers(ctx, groupID, r, members, nil, []string{userID})
+	}
+	if !strings.EqualFold(op.Path, "members") {
+		return status.InvalidArgumentErrorf("Only the members of a group can be modified.")
+	}
+	switch strings.ToLower(op.Op) {
+	case "add":
+		return s.updateRoleMembers(ctx, groupID, r, members, userIDs, nil)
+	case "replace":
+		return s.replaceRoleMembers(ctx, groupID, r, members, values)
+	case "remove":
+		if values == nil {
+			return s.replaceRoleMembers(ctx, groupID, r, members, nil)
+		}
+		return s.updateRoleMembers(ctx, groupID, r, members, nil, userIDs)
+	default:
+		return status.InvalidArgumentErrorf("Unsupported operation %q.", op.Op)
+	}
+}
+
+// applyUserPatch applies a PATCH operation to the given user resource.
+func applyUserPatch(u *userResource, op *patchOperation) error {
+	path := strings.ToLower(op.Path)
+	switch strings.ToLower(op.Op) {
+	case "add", "replace":
+	case "remove":
+		if path != "roles" {
+			return status.InvalidArgumentErrorf("Attribute %q cannot be removed.", op.Path)
+		}
+		u.Roles = []*multiValue{}
+		return nil
+	default:
+		return status.InvalidArgumentErrorf("Unsupported operation %q.", op.Op)
+	}
+	if u.Name == nil {
+		u.Name = &name{}
+	}
+	var target interface{}
+	switch path {
+	case "":
+		target = u
+	case "username":
+		target = &u.UserName
+	case "active":
+		target = &u.Active
+	case "name":
+		target = u.Name
+	case "name.givenname":
+		target = &u.Name.GivenName
+	case "name.familyname":
+		target = &u.Name.FamilyName
+	case "emails":
+		target = &u.Emails
+	case "roles":
+		target = &u.Roles
+	default:
+		return status.InvalidArgumentErrorf("Unsupported attribute %q.", op.Path)
+	}
+	if err := json.Unmarshal(op.Value, target); err != nil {
+		return status.InvalidArgumentErrorf("Invalid value for attribute %q: %s", op.Path, err)
+	}
+	return nil
+}
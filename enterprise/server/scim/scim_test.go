@@ -0,0 +1,241 @@
+package scim_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/backends/userdb"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/scim"
+	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/testutil/testauth"
+	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
+	"github.com/stretchr/testify/require"
+
+	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
+	grpb "github.com/buildbuddy-io/buildbuddy/proto/group"
+)
+
+const (
+	// API key of the test user with the org admin capability.
+	adminKey = "US1"
+	// API key of the test user without the org admin capability.
+	developerKey = "US2"
+)
+
+func setup(t *testing.T) (*testenv.TestEnv, http.Handler) {
+	te := testenv.GetTestEnv(t)
+	users := testauth.TestUsers("US1", "GR1", "US2", "GR1")
+	users["US1"].(*testauth.TestUser).Capabilities = []akpb.ApiKey_Capability{akpb.ApiKey_ORG_ADMIN_CAPABILITY}
+	te.SetAuthenticator(testauth.NewTestAuthenticator(users))
+	udb, err := userdb.NewUserDB(te, te.GetDBHandle())
+	require.NoError(t, err)
+	te.SetUserDB(udb)
+	slug := "org1"
+	err = te.GetDBHandle().DB(context.Background()).Create(&tables.Group{GroupID: "GR1", URLIdentifier: &slug}).Error
+	require.NoError(t, err)
+	return te, scim.NewSCIMServer(te, te.GetDBHandle())
+}
+
+func do(t *testing.T, h http.Handler, apiKey, method, path, body string) (int, map[string]interface{}) {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	if apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+apiKey)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	rsp := map[string]interface{}{}
+	if rec.Body.Len() > 0 {
+		require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &rsp), rec.Body.String())
+	}
+	return rec.Code, rsp
+}
+
+func resources(rsp map[string]interface{}) []map[string]interface{} {
+	var out []map[string]interface{}
+	for _, r := range rsp["Resources"].([]interface{}) {
+		out = append(out, r.(map[string]interface{}))
+	}
+	return out
+}
+
+func userRole(user map[string]interface{}) string {
+	roles, ok := user["roles"].([]interface{})
+	if !ok || len(roles) == 0 {
+		return ""
+	}
+	return roles[0].(map[string]interface{})["value"].(string)
+}
+
+func groupMembers(t *testing.T, te *testenv.TestEnv, statuses ...grpb.GroupMembershipStatus) []string {
+	users, err := te.GetUserDB().GetGroupUsers(context.Background(), "GR1", statuses)
+	require.NoError(t, err)
+	var ids []string
+	for _, u := range users {
+		ids = append(ids, u.GetUser().GetUserId().GetId())
+	}
+	return ids
+}
+
+func TestAuthentication(t *testing.T) {
+	_, h := setup(t)
+
+	code, _ := do(t, h, "", http.MethodGet, "/scim/v2/Users", "")
+	require.Equal(t, http.StatusUnauthorized, code)
+
+	code, rsp := do(t, h, developerKey, http.MethodGet, "/scim/v2/Users", "")
+	require.Equal(t, http.StatusForbidden, code)
+	require.Equal(t, "403", rsp["status"])
+
+	code, _ = do(t, h, adminKey, http.MethodGet, "/scim/v2/Users", "")
+	require.Equal(t, http.StatusOK, code)
+}
+
+func TestUserLifecycle(t *testing.T) {
+	te, h := setup(t)
+
+	code, user := do(t, h, adminKey, http.MethodPost, "/scim/v2/Users", `{
+		"schemas": ["urn:ietf:params:scim:schemas:core:2.0:User"],
+		"userName": "alice@org1.io",
+		"name": {"givenName": "Alice", "familyName": "Smith"},
+		"emails": [{"value": "alice@org1.io", "primary": true}],
+		"roles": [{"value": "admin"}],
+		"active": true
+	}`)
+	require.Equal(t, http.StatusCreated, code, user)
+	id := user["id"].(string)
+	require.Equal(t, "alice@org1.io", user["userName"])
+	require.Equal(t, true, user["active"])
+	require.Equal(t, "admin", userRole(user))
+	require.Contains(t, groupMembers(t, te, grpb.GroupMembershipStatus_MEMBER), id)
+
+	// Creating the same user again conflicts.
+	code, rsp := do(t, h, adminKey, http.MethodPost, "/scim/v2/Users", `{"userName": "Alice@org1.io"}`)
+	require.Equal(t, http.StatusConflict, code)
+	require.Equal(t, "uniqueness", rsp["scimType"])
+
+	code, rsp = do(t, h, adminKey, http.MethodGet, `/scim/v2/Users?filter=userName+eq+%22ALICE@org1.io%22`, "")
+	require.Equal(t, http.StatusOK, code)
+	require.EqualValues(t, 1, rsp["totalResults"])
+	require.Equal(t, id, resources(rsp)[0]["id"])
+
+	// Deactivating the user revokes their membership but keeps them listed.
+	code, user = do(t, h, adminKey, http.MethodPatch, "/scim/v2/Users/"+id, `{
+		"schemas": ["urn:ietf:params:scim:api:messages:2.0:PatchOp"],
+		"Operations": [{"op": "replace", "value": {"active": false}}]
+	}`)
+	require.Equal(t, http.StatusOK, code, user)
+	require.Equal(t, false, user["active"])
+	require.Equal(t, "admin", userRole(user))
+	require.NotContains(t, groupMembers(t, te, grpb.GroupMembershipStatus_MEMBER), id)
+	require.Contains(t, groupMembers(t, te, grpb.GroupMembershipStatus_DEACTIVATED), id)
+
+	// Reactivate the user and update their profile and role.
+	code, user = do(t, h, adminKey, http.MethodPut, "/scim/v2/Users/"+id, `{
+		"userName": "alice.smith@org1.io",
+		"name": {"givenName": "Alice", "familyName": "Jones"},
+		"roles": [{"value": "developer", "primary": true}],
+		"active": true
+	}`)
+	require.Equal(t, http.StatusOK, code, user)
+	require.Equal(t, true, user["active"])
+	require.Equal(t, "alice.smith@org1.io", user["userName"])
+	require.Equal(t, "Jones", user["name"].(map[string]interface{})["familyName"])
+	require.Equal(t, "developer", userRole(user))
+	require.Contains(t, groupMembers(t, te, grpb.GroupMembershipStatus_MEMBER), id)
+
+	code, _ = do(t, h, adminKey, http.MethodDelete, "/scim/v2/Users/"+id, "")
+	require.Equal(t, http.StatusNoContent, code)
+	code, _ = do(t, h, adminKey, http.MethodGet, "/scim/v2/Users/"+id, "")
+	require.Equal(t, http.StatusNotFound, code)
+	row := &struct{ Count int64 }{}
+	err := te.GetDBHandle().DB(context.Background()).Raw(`SELECT COUNT(*) AS count FROM Users WHERE user_id = ?`, id).Take(row).Error
+	require.NoError(t, err)
+	require.EqualValues(t, 0, row.Count)
+}
+
+func TestProvisionedUserIsClaimedOnFirstLogin(t *testing.T) {
+	te, h := setup(t)
+
+	code, user := do(t, h, adminKey, http.MethodPost, "/scim/v2/Users", `{"userName": "bob@org1.io", "roles": [{"value": "admin"}]}`)
+	require.Equal(t, http.StatusCreated, code, user)
+
+	slug := "org1"
+	tu := &tables.User{
+		UserID: "US100",
+		SubID:  "https://idp.org1.io/bob",
+		Email:  "Bob@org1.io",
+		Groups: []*tables.GroupRole{{Group: tables.Group{URLIdentifier: &slug}}},
+	}
+	err := te.GetUserDB().InsertUser(context.Background(), tu)
+	require.NoError(t, err)
+	require.Equal(t, user["id"], tu.UserID)
+
+	// The user keeps the role they were provisioned with.
+	code, user = do(t, h, adminKey, http.MethodGet, "/scim/v2/Users/"+tu.UserID, "")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, "admin", userRole(user))
+	row := &tables.User{}
+	err = te.GetDBHandle().DB(context.Background()).Raw(`SELECT * FROM Users WHERE user_id = ?`, tu.UserID).Take(row).Error
+	require.NoError(t, err)
+	require.Equal(t, "https://idp.org1.io/bob", row.SubID)
+}
+
+func TestExistingUserIsAddedToGroup(t *testing.T) {
+	te, h := setup(t)
+
+	existing := &tables.User{UserID: "US200", SubID: "https://accounts.google.com/dave", Email: "dave@org1.io"}
+	err := te.GetDBHandle().DB(context.Background()).Create(existing).Error
+	require.NoError(t, err)
+
+	code, user := do(t, h, adminKey, http.MethodPost, "/scim/v2/Users", `{"userName": "Dave@org1.io"}`)
+	require.Equal(t, http.StatusCreated, code, user)
+	require.Equal(t, existing.UserID, user["id"])
+	require.Contains(t, groupMembers(t, te, grpb.GroupMembershipStatus_MEMBER), existing.UserID)
+
+	row := &struct{ Count int64 }{}
+	err = te.GetDBHandle().DB(context.Background()).Raw(`SELECT COUNT(*) AS count FROM Users WHERE LOWER(email) = ?`, "dave@org1.io").Take(row).Error
+	require.NoError(t, err)
+	require.EqualValues(t, 1, row.Count)
+}
+
+func TestGroupsAssignRoles(t *testing.T) {
+	te, h := setup(t)
+	ctx := context.Background()
+	cr, err := te.GetUserDB().CreateCustomRole(ctx, "GR1", "Release Managers", role.ViewInvocations|role.ManageWorkflows)
+	require.NoError(t, err)
+
+	_, user := do(t, h, adminKey, http.MethodPost, "/scim/v2/Users", `{"userName": "carol@org1.io"}`)
+	id := user["id"].(string)
+	require.Equal(t, "developer", userRole(user))
+
+	code, rsp := do(t, h, adminKey, http.MethodGet, `/scim/v2/Groups?filter=displayName+eq+%22release+managers%22`, "")
+	require.Equal(t, http.StatusOK, code)
+	require.EqualValues(t, 1, rsp["totalResults"])
+	require.Equal(t, cr.RoleID, resources(rsp)[0]["id"])
+
+	code, group := do(t, h, adminKey, http.MethodPatch, "/scim/v2/Groups/"+cr.RoleID, `{
+		"Operations": [{"op": "add", "path": "members", "value": [{"value": "`+id+`"}]}]
+	}`)
+	require.Equal(t, http.StatusOK, code, group)
+	require.Len(t, group["members"], 1)
+	_, user = do(t, h, adminKey, http.MethodGet, "/scim/v2/Users/"+id, "")
+	require.Equal(t, "Release Managers", userRole(user))
+
+	code, group = do(t, h, adminKey, http.MethodPatch, "/scim/v2/Groups/"+cr.RoleID, `{
+		"Operations": [{"op": "remove", "path": "members[value eq \"`+id+`\"]"}]
+	}`)
+	require.Equal(t, http.StatusOK, code, group)
+	require.Empty(t, group["members"])
+	_, user = do(t, h, adminKey, http.MethodGet, "/scim/v2/Users/"+id, "")
+	require.Equal(t, "developer", userRole(user))
+
+	code, _ = do(t, h, adminKey, http.MethodPatch, "/scim/v2/Groups/admin", `{
+		"Operations": [{"op": "add", "path": "members", "value": [{"value": "US404"}]}]
+	}`)
+	require.Equal(t, http.StatusNotFound, code)
+}
@@ -0,0 +1,251 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"flag"
+	"regexp"
+	"sort"
+
+	"github.com/buildbuddy-io/buildbuddy/server/environment"
+	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/util/authutil"
+	"github.com/buildbuddy-io/buildbuddy/server/util/db"
+	"github.com/buildbuddy-io/buildbuddy/server/util/perms"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+
+	ctxpb "github.com/buildbuddy-io/buildbuddy/proto/context"
+	skpb "github.com/buildbuddy-io/buildbuddy/proto/secrets"
+)
+
+var (
+	encryptionKey = flag.String("app.secrets_encryption_key", "", "Base64-encoded 32-byte key used to encrypt secrets at rest with AES-256-GCM. Secrets are disabled if unset. ** Enterprise only **")
+
+	// secretNameRegexp matches valid secret names. Names are restricted so that
+	// they can be referenced from workflow configs.
+	secretNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+)
+
+const (
+	// maxSecretNameLength is the maximum length of a secret name.
+	maxSecretNameLength = 255
+
+	// maxSecretValueSizeBytes is the maximum size of a secret value.
+	maxSecretValueSizeBytes = 64 * 1024
+)
+
+type secretService struct {
+	env  environment.Env
+	aead cipher.AEAD
+}
+
+// Register registers the secret service if an encryption key is configured.
+func Register(env environment.Env) error {
+	if *encryptionKey == "" {
+		return nil
+	}
+	key, err := base64.StdEncoding.DecodeString(*encryptionKey)
+	if err != nil {
+		return status.InvalidArgumentErrorf("invalid app.secrets_encryption_key: %s", err)
+	}
+	ss, err := New(env, key)
+	if err != nil {
+		return err
+	}
+	env.SetSecretService(ss)
+	return nil
+}
+
+// New returns a secret service which encrypts secrets with the given 32-byte
+// key.
+func New(env environment.Env, key []byte) (*secretService, error) {
+	if len(key) != 32 {
+		return nil, status.InvalidArgumentErrorf("secrets encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &secretService{env: env, aead: aead}, nil
+}
+
+// authorizeAdmin returns the selected group ID if the authenticated user is
+// allowed to manage the group's secrets.
+func (s *secretService) authorizeAdmin(ctx context.Context, reqCtx *ctxpb.RequestContext) (string, error) {
+	groupID, err := perms.AuthenticateSelectedGroupID(ctx, s.env, reqCtx)
+	if err != nil {
+		return "", err
+	}
+	u, err := perms.AuthenticatedUser(ctx, s.env)
+	if err != nil {
+		return "", err
+	}
+	if err := authutil.AuthorizeGroupCapability(u, groupID, role.ManageSecrets); err != nil {
+		return "", err
+	}
+	return groupID, nil
+}
+
+func (s *secretService) ListSecrets(ctx context.Context, req *skpb.ListSecretsRequest) (*skpb.ListSecretsResponse, error) {
+	groupID, err := s.authorizeAdmin(ctx, req.GetRequestContext())
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.env.GetDBHandle().DB(ctx).Raw(
+		`SELECT name, updated_at_usec FROM Secrets WHERE group_id = ? ORDER BY name ASC`, groupID,
+	).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	rsp := &skpb.ListSecretsResponse{}
+	for rows.Next() {
+		md := &skpb.SecretMetadata{}
+		if err := rows.Scan(&md.Name, &md.UpdatedAtUsec); err != nil {
+			return nil, err
+		}
+		rsp.Secret = append(rsp.Secret, md)
+	}
+	return rsp, nil
+}
+
+func (s *secretService) CreateSecret(ctx context.Context, req *skpb.CreateSecretRequest) (*skpb.CreateSecretResponse, error) {
+	groupID, err := s.authorizeAdmin(ctx, req.GetRequestContext())
+	if err != nil {
+		return nil, err
+	}
+	if err := validateName(req.GetName()); err != nil {
+		return nil, err
+	}
+	if req.GetValue() == "" {
+		return nil, status.InvalidArgumentError("secret value is required")
+	}
+	if len(req.GetValue()) > maxSecretValueSizeBytes {
+		return nil, status.InvalidArgumentErrorf("secret value must be at most %d bytes", maxSecretValueSizeBytes)
+	}
+	ciphertext, err := s.encrypt(groupID, req.GetName(), req.GetValue())
+	if err != nil {
+		return nil, err
+	}
+	err = s.env.GetDBHandle().Transaction(ctx, func(tx *db.DB) error {
+		if err := tx.Exec(`DELETE FROM Secrets WHERE group_id = ? AND name = ?`, groupID, req.GetName()).Error; err != nil {
+			return err
+		}
+		return tx.Create(&tables.Secret{GroupID: groupID, Name: req.GetName(), Value: ciphertext}).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &skpb.CreateSecretResponse{}, nil
+}
+
+func (s *secretService) DeleteSecret(ctx context.Context, req *skpb.DeleteSecretRequest) (*skpb.DeleteSecretResponse, error) {
+	groupID, err := s.authorizeAdmin(ctx, req.GetRequestContext())
+	if err != nil {
+		return nil, err
+	}
+	result := s.env.GetDBHandle().DB(ctx).Exec(`DELETE FROM Secrets WHERE group_id = ? AND name = ?`, groupID, req.GetName())
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, status.NotFoundErrorf("secret %q not found", req.GetName())
+	}
+	return &skpb.DeleteSecretResponse{}, nil
+}
+
+func (s *secretService) GetSecrets(ctx context.Context, groupID string, names []string) (map[string]string, error) {
+	values := make(map[string]string, len(names))
+	if len(names) == 0 {
+		return values, nil
+	}
+	var rows []*tables.Secret
+	err := s.env.GetDBHandle().DB(ctx).Raw(
+		`SELECT * FROM Secrets WHERE group_id = ? AND name IN ?`, groupID, names,
+	).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, row := range rows {
+		value, err := s.decrypt(groupID, row.Name, row.Value)
+		if err != nil {
+			return nil, err
+		}
+		values[row.Name] = value
+	}
+	var missing []string
+	for _, name := range names {
+		if _, ok := values[name]; !ok {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return nil, status.NotFoundErrorf("secrets not found: %v", missing)
+	}
+	return values, nil
+}
+
+func validateName(name string) error {
+	if name == "" {
+		return status.InvalidArgumentError("secret name is required")
+	}
+	if len(name) > maxSecretNameLength {
+		return status.InvalidArgumentErrorf("secret name must be at most %d characters", maxSecretNameLength)
+	}
+	if !secretNameRegexp.MatchString(name) {
+		return status.InvalidArgumentErrorf("invalid secret name %q: names may only contain letters, digits, and underscores, and may not start with a digit", name)
+	}
+	return nil
+}
+
+// Encrypt returns the nonce followed by the AES-GCM ciphertext of the
+// plaintext. The additional data is authenticated but not encrypted, and must
+// be passed to Decrypt again.
+func (s *secretService) Encrypt(plaintext []byte, additionalData string) ([]byte, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(nonce, nonce, plaintext, []byte(additionalData)), nil
+}
+
+// Decrypt returns the plaintext of a ciphertext returned by Encrypt.
+func (s *secretService) Decrypt(ciphertext []byte, additionalData string) ([]byte, error) {
+	if len(ciphertext) < s.aead.NonceSize() {
+		return nil, status.InternalError("invalid ciphertext")
+	}
+	nonce, sealed := ciphertext[:s.aead.NonceSize()], ciphertext[s.aead.NonceSize():]
+	plaintext, err := s.aead.Open(nil, nonce, sealed, []byte(additionalData))
+	if err != nil {
+		return nil, status.InternalErrorf("failed to decrypt: %s", err)
+	}
+	return plaintext, nil
+}
+
+// encrypt encrypts the value of a secret. The group ID and secret name are
+// authenticated as additional data, so that ciphertexts can't be copied to
+// other secrets.
+func (s *secretService) encrypt(groupID, name, value string) ([]byte, error) {
+	return s.Encrypt([]byte(value), additionalData(groupID, name))
+}
+
+func (s *secretService) decrypt(groupID, name string, ciphertext []byte) (string, error) {
+	plaintext, err := s.Decrypt(ciphertext, additionalData(groupID, name))
+	if err != nil {
+		return "", status.InternalErrorf("failed to decrypt secret %q: %s", name, status.Message(err))
+	}
+	return string(plaintext), nil
+}
+
+func additionalData(groupID, name string) string {
+	return groupID + "/" + name
+}
@@ -0,0 +1,140 @@
+package secrets_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/secrets"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/testutil/testauth"
+	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	skpb "github.com/buildbuddy-io/buildbuddy/proto/secrets"
+)
+
+var testKey = []byte("0123456789abcdef0123456789abcdef")
+
+func setup(t *testing.T) (*testenv.TestEnv, interfaces.SecretService) {
+	te := testenv.GetTestEnv(t)
+	users := testauth.TestUsers("US1", "GR1", "US2", "GR2", "US3", "GR1")
+	users["US3"].(*testauth.TestUser).GroupMemberships[0].Role = role.Developer
+	te.SetAuthenticator(testauth.NewTestAuthenticator(users))
+	ss, err := secrets.New(te, testKey)
+	require.NoError(t, err)
+	return te, ss
+}
+
+func authContext(te *testenv.TestEnv, userID string) context.Context {
+	return te.GetAuthenticator().(*testauth.TestAuthenticator).AuthContextFromAPIKey(context.Background(), userID)
+}
+
+func listSecretNames(t *testing.T, ctx context.Context, ss interfaces.SecretService, userID, groupID string) []string {
+	rsp, err := ss.ListSecrets(ctx, &skpb.ListSecretsRequest{RequestContext: testauth.RequestContext(userID, groupID)})
+	require.NoError(t, err)
+	names := []string{}
+	for _, md := range rsp.GetSecret() {
+		names = append(names, md.GetName())
+	}
+	return names
+}
+
+func TestCreateListGetDelete(t *testing.T) {
+	te, ss := setup(t)
+	ctx := authContext(te, "US1")
+	reqCtx := testauth.RequestContext("US1", "GR1")
+
+	for _, req := range []*skpb.CreateSecretRequest{
+		{RequestContext: reqCtx, Name: "TOKEN", Value: "old-token"},
+		{RequestContext: reqCtx, Name: "TOKEN", Value: "new-token"},
+		{RequestContext: reqCtx, Name: "PASSWORD", Value: "hunter2"},
+	} {
+		_, err := ss.CreateSecret(ctx, req)
+		require.NoError(t, err)
+	}
+
+	assert.Equal(t, []string{"PASSWORD", "TOKEN"}, listSecretNames(t, ctx, ss, "US1", "GR1"))
+
+	values, err := ss.GetSecrets(context.Background(), "GR1", []string{"TOKEN", "PASSWORD"})
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"TOKEN": "new-token", "PASSWORD": "hunter2"}, values)
+
+	_, err = ss.DeleteSecret(ctx, &skpb.DeleteSecretRequest{RequestContext: reqCtx, Name: "PASSWORD"})
+	require.NoError(t, err)
+	_, err = ss.DeleteSecret(ctx, &skpb.DeleteSecretRequest{RequestContext: reqCtx, Name: "PASSWORD"})
+	assert.True(t, status.IsNotFoundError(err), "expected NotFound, got %v", err)
+
+	assert.Equal(t, []string{"TOKEN"}, listSecretNames(t, ctx, ss, "US1", "GR1"))
+	_, err = ss.GetSecrets(context.Background(), "GR1", []string{"TOKEN", "PASSWORD"})
+	assert.True(t, status.IsNotFoundError(err), "expected NotFound, got %v", err)
+}
+
+func TestSecretsAreEncryptedAtRest(t *testing.T) {
+	te, ss := setup(t)
+	ctx := authContext(te, "US1")
+
+	_, err := ss.CreateSecret(ctx, &skpb.CreateSecretRequest{
+		RequestContext: testauth.RequestContext("US1", "GR1"),
+		Name:           "TOKEN",
+		Value:          "plaintext-value",
+	})
+	require.NoError(t, err)
+
+	row := &tables.Secret{}
+	err = te.GetDBHandle().DB(ctx).Raw(`SELECT * FROM Secrets WHERE group_id = ? AND name = ?`, "GR1", "TOKEN").Take(row).Error
+	require.NoError(t, err)
+	assert.NotContains(t, string(row.Value), "plaintext-value")
+
+	// A ciphertext copied to another group can't be decrypted.
+	err = te.GetDBHandle().DB(ctx).Create(&tables.Secret{GroupID: "GR2", Name: "TOKEN", Value: row.Value}).Error
+	require.NoError(t, err)
+	_, err = ss.GetSecrets(context.Background(), "GR2", []string{"TOKEN"})
+	require.Error(t, err)
+}
+
+func TestSecretsAreGroupScoped(t *testing.T) {
+	te, ss := setup(t)
+
+	_, err := ss.CreateSecret(authContext(te, "US1"), &skpb.CreateSecretRequest{
+		RequestContext: testauth.RequestContext("US1", "GR1"),
+		Name:           "TOKEN",
+		Value:          "secret",
+	})
+	require.NoError(t, err)
+
+	assert.Empty(t, listSecretNames(t, authContext(te, "US2"), ss, "US2", "GR2"))
+
+	_, err = ss.ListSecrets(authContext(te, "US2"), &skpb.ListSecretsRequest{RequestContext: testauth.RequestContext("US2", "GR1")})
+	require.Error(t, err)
+}
+
+func TestNonAdminsCannotManageSecrets(t *testing.T) {
+	te, ss := setup(t)
+	ctx := authContext(te, "US3")
+	reqCtx := testauth.RequestContext("US3", "GR1")
+
+	_, err := ss.ListSecrets(ctx, &skpb.ListSecretsRequest{RequestContext: reqCtx})
+	assert.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %v", err)
+	_, err = ss.CreateSecret(ctx, &skpb.CreateSecretRequest{RequestContext: reqCtx, Name: "TOKEN", Value: "secret"})
+	assert.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %v", err)
+	_, err = ss.DeleteSecret(ctx, &skpb.DeleteSecretRequest{RequestContext: reqCtx, Name: "TOKEN"})
+	assert.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %v", err)
+}
+
+func TestCreateSecret_InvalidName(t *testing.T) {
+	te, ss := setup(t)
+	ctx := authContext(te, "US1")
+
+	for _, name := range []string{"", "1TOKEN", "MY-TOKEN", "MY TOKEN"} {
+		_, err := ss.CreateSecret(ctx, &skpb.CreateSecretRequest{
+			RequestContext: testauth.RequestContext("US1", "GR1"),
+			Name:           name,
+			Value:          "secret",
+		})
+		assert.True(t, status.IsInvalidArgumentError(err), "name %q: expected InvalidArgument, got %v", name, err)
+	}
+}
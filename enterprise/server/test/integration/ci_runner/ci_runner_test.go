@@ -85,6 +85,21 @@ actions:
 `,
 	}
 
+	workspaceContentsWithSecretPrinter = map[string]string{
+		"WORKSPACE":       `workspace(name = "test")`,
+		"BUILD":           `sh_binary(name = "print_secret", srcs = ["print_secret.sh"])`,
+		"print_secret.sh": `printf 'secret: {{%s}}\n' "$DEPLOY_TOKEN"`,
+		"buildbuddy.yaml": `
+actions:
+  - name: "Print secret"
+    triggers:
+      pull_request: { branches: [ master ] }
+      push: { branches: [ master ] }
+    bazel_commands:
+      - run //:print_secret
+`,
+	}
+
 	invocationIDPattern = regexp.MustCompile(`Invocation URL:\s+.*?/invocation/([a-f0-9-]+)`)
 )
 
@@ -511,6 +526,35 @@ func TestRunAction_RespectsArgs(t *testing.T) {
 	assert.Contains(t, result.Output, "args: {{ Hello world }}")
 }
 
+func TestRunAction_RedactsMultilineSecrets(t *testing.T) {
+	wsPath := testfs.MakeTempDir(t)
+	repoPath, headCommitSHA := makeGitRepo(t, workspaceContentsWithSecretPrinter)
+
+	runnerFlags := []string{
+		"--workflow_id=test-workflow",
+		"--action_name=Print secret",
+		"--trigger_event=push",
+		"--pushed_repo_url=file://" + repoPath,
+		"--pushed_branch=master",
+		"--commit_sha=" + headCommitSHA,
+		"--target_repo_url=file://" + repoPath,
+		"--target_branch=master",
+		"--secret_env_var=DEPLOY_TOKEN",
+	}
+	// Start the app so the runner can use it as the BES backend.
+	app := buildbuddy.Run(t)
+	runnerFlags = append(runnerFlags, app.BESBazelFlags()...)
+
+	secret := "-----BEGIN KEY-----\nc2VjcmV0\n-----END KEY-----"
+	result := invokeRunner(t, runnerFlags, []string{"DEPLOY_TOKEN=" + secret}, wsPath)
+
+	checkRunnerResult(t, result)
+
+	runnerInvocation := singleInvocation(t, app, result)
+	assert.Contains(t, runnerInvocation.ConsoleBuffer, "secret: {{<REDACTED>}}")
+	assert.NotContains(t, runnerInvocation.ConsoleBuffer, "c2VjcmV0")
+}
+
 func TestGitCleanExclude(t *testing.T) {
 	wsPath := testfs.MakeTempDir(t)
 
@@ -25,6 +25,12 @@ var (
 	eventsToReceive = []string{"push", "pull_request", "pull_request_review"}
 )
 
+const (
+	// maxPushEventCommits is the maximum number of commits that GitHub
+	// includes in push event payloads.
+	maxPushEventCommits = 2048
+)
+
 type githubGitProvider struct{}
 
 func NewProvider() interfaces.GitProvider {
@@ -133,6 +139,7 @@ func (*githubGitProvider) ParseWebhookData(r *http.Request) (*interfaces.Webhook
 			TargetRepoURL:      v["Repo.CloneURL"],
 			TargetBranch:       branch,
 			IsTargetRepoPublic: v["Repo.Private"] == "false",
+			ChangedFiles:       pushEventChangedFiles(event),
 		}, nil
 
 	case *gh.PullRequestEvent:
@@ -164,6 +171,21 @@ func (*githubGitProvider) ParseWebhookData(r *http.Request) (*interfaces.Webhook
 	}
 }
 
+// pushEventChangedFiles returns the files changed by the commits in a push
+// event, or nil if the payload doesn't list all of the pushed commits. Pushes
+// that list no commits at all, such as pushes creating a branch from an
+// existing commit, are also treated as having unknown changed files.
+func pushEventChangedFiles(event *gh.PushEvent) []string {
+	if len(event.Commits) == 0 || len(event.Commits) >= maxPushEventCommits {
+		return nil
+	}
+	var fileLists [][]string
+	for _, c := range event.Commits {
+		fileLists = append(fileLists, c.Added, c.Modified, c.Removed)
+	}
+	return webhook_data.ChangedFiles(fileLists...)
+}
+
 // parsePullRequestOrReview extracts WebhookData from a pull_request or
 // pull_request_review event.
 func parsePullRequestOrReview(event interface{}) (*interfaces.WebhookData, error) {
@@ -2,6 +2,7 @@ package github_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"net/http"
 	"testing"
 
@@ -9,6 +10,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/webhooks/github/test_data"
 	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func webhookRequest(t *testing.T, eventType string, payload []byte) *http.Request {
@@ -34,9 +36,25 @@ func TestParseRequest_ValidPushEvent_Success(t *testing.T) {
 		SHA:           "258044d28288d5f6f1c5928b0e22580296fec666",
 		TargetRepoURL: "https://github.com/test/hello_bb_ci.git",
 		TargetBranch:  "main",
+		ChangedFiles:  []string{"BUILD"},
 	}, data)
 }
 
+func TestParseRequest_PushEventWithoutCommits_ChangedFilesUnknown(t *testing.T) {
+	event := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(test_data.PushEvent, &event))
+	event["commits"] = []interface{}{}
+	payload, err := json.Marshal(event)
+	require.NoError(t, err)
+	req := webhookRequest(t, "push", payload)
+
+	data, err := github.NewProvider().ParseWebhookData(req)
+
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	assert.Nil(t, data.ChangedFiles)
+}
+
 func TestParseRequest_ValidPullRequestEvent_Success(t *testing.T) {
 	req := webhookRequest(t, "pull_request", test_data.PullRequestEvent)
 
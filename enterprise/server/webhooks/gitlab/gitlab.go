@@ -0,0 +1,400 @@
+package gitlab
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/util/fieldgetter"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/webhooks/webhook_data"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/buildbuddy-io/buildbuddy/server/util/flagutil"
+	"github.com/buildbuddy-io/buildbuddy/server/util/log"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+
+	gitutil "github.com/buildbuddy-io/buildbuddy/server/util/git"
+)
+
+var (
+	hosts = flagutil.New("remote_execution.workflows_gitlab_hosts", []string{"gitlab.com"}, "Hostnames of GitLab instances (including self-hosted ones) whose repos can be linked to workflows.")
+)
+
+const (
+	// eventHeader is the header containing the GitLab event name.
+	eventHeader = "X-Gitlab-Event"
+
+	pushHookEvent         = "Push Hook"
+	mergeRequestHookEvent = "Merge Request Hook"
+
+	// publicVisibilityLevel is the visibility_level of public projects.
+	// See https://docs.gitlab.com/ee/api/projects.html#project-visibility-level
+	publicVisibilityLevel = 20
+
+	// developerAccessLevel is the minimum access level of project members that
+	// are allowed to push to the project.
+	// See https://docs.gitlab.com/ee/api/members.html#valid-access-levels
+	developerAccessLevel = 30
+
+	// deletedRefSHA is the "after" SHA of push events that delete a branch.
+	deletedRefSHA = "0000000000000000000000000000000000000000"
+)
+
+type gitlabGitProvider struct {
+	client *http.Client
+}
+
+func NewProvider() interfaces.GitProvider {
+	return &gitlabGitProvider{client: http.DefaultClient}
+}
+
+func (*gitlabGitProvider) MatchRepoURL(u *url.URL) bool {
+	for _, host := range *hosts {
+		if u.Host == host {
+			return true
+		}
+	}
+	return false
+}
+
+func (*gitlabGitProvider) MatchWebhookRequest(r *http.Request) bool {
+	return r.Header.Get(eventHeader) != ""
+}
+
+func (*gitlabGitProvider) ParseWebhookData(r *http.Request) (*interfaces.WebhookData, error) {
+	switch eventName := r.Header.Get(eventHeader); eventName {
+	case pushHookEvent:
+		payload := &PushEventPayload{}
+		if err := unmarshalBody(r, payload); err != nil {
+			return nil, status.InvalidArgumentErrorf("failed to unmarshal push event payload: %s", err)
+		}
+		// Ignore branch deletion events.
+		if payload.After == deletedRefSHA {
+			return nil, nil
+		}
+		v, err := fieldgetter.ExtractValues(
+			payload,
+			"After",
+			"Ref",
+			"Project.GitHTTPURL",
+			"Project.VisibilityLevel",
+		)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasPrefix(v["Ref"], "refs/heads/") {
+			log.Debugf("Ignoring non-branch push event (ref %q)", v["Ref"])
+			return nil, nil
+		}
+		branch := strings.TrimPrefix(v["Ref"], "refs/heads/")
+		return &interfaces.WebhookData{
+			EventName:          webhook_data.EventName.Push,
+			PushedRepoURL:      v["Project.GitHTTPURL"],
+			PushedBranch:       branch,
+			SHA:                v["After"],
+			TargetRepoURL:      v["Project.GitHTTPURL"],
+			TargetBranch:       branch,
+			IsTargetRepoPublic: v["Project.VisibilityLevel"] == fmt.Sprint(publicVisibilityLevel),
+			ChangedFiles:       pushEventChangedFiles(payload),
+		}, nil
+
+	case mergeRequestHookEvent:
+		payload := &MergeRequestEventPayload{}
+		if err := unmarshalBody(r, payload); err != nil {
+			return nil, status.InvalidArgumentErrorf("failed to unmarshal merge request event payload: %s", err)
+		}
+		// Run workflows when the MR is opened, pushed to, or reopened, and
+		// when its target branch changes, to match the GitHub provider.
+		attrs := payload.ObjectAttributes
+		if attrs == nil {
+			return nil, status.InvalidArgumentError("merge request event payload is missing object_attributes")
+		}
+		isPushedTo := attrs.Action == "update" && attrs.OldRev != ""
+		targetBranchChanged := attrs.Action == "update" && payload.Changes != nil && payload.Changes.TargetBranch != nil
+		if !(isPushedTo || targetBranchChanged || attrs.Action == "open" || attrs.Action == "reopen") {
+			return nil, nil
+		}
+		v, err := fieldgetter.ExtractValues(
+			payload,
+			"ObjectAttributes.Source.GitHTTPURL",
+			"ObjectAttributes.SourceBranch",
+			"ObjectAttributes.LastCommit.ID",
+			"ObjectAttributes.Target.GitHTTPURL",
+			"ObjectAttributes.Target.VisibilityLevel",
+			"ObjectAttributes.TargetBranch",
+			"User.Username",
+		)
+		if err != nil {
+			return nil, err
+		}
+		return &interfaces.WebhookData{
+			EventName:          webhook_data.EventName.PullRequest,
+			PushedRepoURL:      v["ObjectAttributes.Source.GitHTTPURL"],
+			PushedBranch:       v["ObjectAttributes.SourceBranch"],
+			SHA:                v["ObjectAttributes.LastCommit.ID"],
+			TargetRepoURL:      v["ObjectAttributes.Target.GitHTTPURL"],
+			TargetBranch:       v["ObjectAttributes.TargetBranch"],
+			IsTargetRepoPublic: v["ObjectAttributes.Target.VisibilityLevel"] == fmt.Sprint(publicVisibilityLevel),
+			// GitLab only includes the user that triggered the event, which
+			// is the author when the MR is opened or pushed to.
+			PullRequestAuthor: v["User.Username"],
+		}, nil
+
+	default:
+		log.Debugf("Ignoring webhook event: %s", eventName)
+		return nil, nil
+	}
+}
+
+// pushEventChangedFiles returns the files changed by the commits in a push
+// event, or nil if the payload doesn't list all of the pushed commits. Pushes
+// that list no commits at all, such as pushes creating a branch from an
+// existing commit, are also treated as having unknown changed files.
+func pushEventChangedFiles(payload *PushEventPayload) []string {
+	if len(payload.Commits) == 0 || payload.TotalCommitsCount > len(payload.Commits) {
+		return nil
+	}
+	var fileLists [][]string
+	for _, c := range payload.Commits {
+		fileLists = append(fileLists, c.Added, c.Modified, c.Removed)
+	}
+	return webhook_data.ChangedFiles(fileLists...)
+}
+
+// RegisterWebhook registers the given webhook to the project and returns the
+// ID of the registered webhook.
+func (p *gitlabGitProvider) RegisterWebhook(ctx context.Context, accessToken, repoURL, webhookURL string) (string, error) {
+	projectURL, err := projectAPIURL(repoURL)
+	if err != nil {
+		return "", err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"url":                   webhookURL,
+		"push_events":           true,
+		"merge_requests_events": true,
+	})
+	if err != nil {
+		return "", err
+	}
+	hook := &struct {
+		ID int64 `json:"id"`
+	}{}
+	if err := p.do(ctx, accessToken, "POST", projectURL+"/hooks", bytes.NewReader(body), hook); err != nil {
+		return "", err
+	}
+	if hook.ID == 0 {
+		return "", status.UnknownError("GitLab returned invalid response from hooks API (missing id field).")
+	}
+	return fmt.Sprintf("%d", hook.ID), nil
+}
+
+// UnregisterWebhook removes the webhook from the project.
+func (p *gitlabGitProvider) UnregisterWebhook(ctx context.Context, accessToken, repoURL, webhookID string) error {
+	projectURL, err := projectAPIURL(repoURL)
+	if err != nil {
+		return err
+	}
+	return p.do(ctx, accessToken, "DELETE", projectURL+"/hooks/"+url.PathEscape(webhookID), nil, nil)
+}
+
+func (p *gitlabGitProvider) GetFileContents(ctx context.Context, accessToken, repoURL, filePath, ref string) ([]byte, error) {
+	projectURL, err := projectAPIURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	u := projectURL + "/repository/files/" + url.PathEscape(filePath) + "/raw"
+	if ref != "" {
+		u += "?ref=" + url.QueryEscape(ref)
+	}
+	var b []byte
+	if err := p.do(ctx, accessToken, "GET", u, nil, &b); err != nil {
+		if status.IsNotFoundError(err) {
+			return nil, status.NotFoundErrorf("%s: not found in %s", filePath, repoURL)
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// IsTrusted returns whether the user is a member of the project (including
+// inherited group membership) with at least developer access.
+func (p *gitlabGitProvider) IsTrusted(ctx context.Context, accessToken, repoURL, user string) (bool, error) {
+	projectURL, err := projectAPIURL(repoURL)
+	if err != nil {
+		return false, err
+	}
+	apiURL, err := apiBaseURL(repoURL)
+	if err != nil {
+		return false, err
+	}
+	users := []*User{}
+	if err := p.do(ctx, accessToken, "GET", apiURL+"/users?username="+url.QueryEscape(user), nil, &users); err != nil {
+		return false, status.InternalErrorf("failed to look up GitLab user %s: %s", user, err)
+	}
+	if len(users) == 0 {
+		return false, nil
+	}
+	member := &struct {
+		AccessLevel int `json:"access_level"`
+	}{}
+	err = p.do(ctx, accessToken, "GET", fmt.Sprintf("%s/members/all/%d", projectURL, users[0].ID), nil, member)
+	if status.IsNotFoundError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, status.InternalErrorf("failed to determine whether %s is a member of %s: %s", user, repoURL, err)
+	}
+	return member.AccessLevel >= developerAccessLevel, nil
+}
+
+// do makes a GitLab API request and decodes the JSON response into out. If
+// out is a *[]byte, the raw response body is stored instead.
+func (p *gitlabGitProvider) do(ctx context.Context, accessToken, method, u string, body io.Reader, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	rsp, err := p.client.Do(req)
+	if err != nil {
+		return status.UnavailableErrorf("GitLab request failed: %s", err)
+	}
+	defer rsp.Body.Close()
+	b, err := io.ReadAll(rsp.Body)
+	if err != nil {
+		return status.UnavailableErrorf("failed to read GitLab response: %s", err)
+	}
+	if rsp.StatusCode == http.StatusNotFound {
+		return status.NotFoundErrorf("GitLab %s %s: not found", method, req.URL.Path)
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode >= 300 {
+		return gitLabErrorToStatus(rsp.StatusCode, b)
+	}
+	switch out := out.(type) {
+	case nil:
+		return nil
+	case *[]byte:
+		*out = b
+		return nil
+	default:
+		if err := json.Unmarshal(b, out); err != nil {
+			return status.UnknownErrorf("failed to parse GitLab response: %s", err)
+		}
+		return nil
+	}
+}
+
+func gitLabErrorToStatus(statusCode int, body []byte) error {
+	msg := strings.TrimSpace(string(body))
+	switch statusCode {
+	case http.StatusUnauthorized:
+		return status.UnauthenticatedErrorf("GitLab: %s", msg)
+	case http.StatusForbidden:
+		return status.PermissionDeniedErrorf("GitLab: %s", msg)
+	default:
+		return status.InternalErrorf("GitLab returned HTTP %d: %s", statusCode, msg)
+	}
+}
+
+// apiBaseURL returns the base URL of the GitLab API serving the given repo,
+// e.g. "https://gitlab.com/api/v4".
+func apiBaseURL(repoURL string) (string, error) {
+	u, err := gitutil.NormalizeRepoURL(repoURL)
+	if err != nil {
+		return "", status.WrapError(err, "Failed to parse GitLab URL")
+	}
+	return fmt.Sprintf("%s://%s/api/v4", u.Scheme, u.Host), nil
+}
+
+// projectAPIURL returns the API URL of the project for the given repo URL. For
+// example, "https://gitlab.com/group/subgroup/repo.git" maps to
+// "https://gitlab.com/api/v4/projects/group%2Fsubgroup%2Frepo".
+func projectAPIURL(repoURL string) (string, error) {
+	apiURL, err := apiBaseURL(repoURL)
+	if err != nil {
+		return "", err
+	}
+	projectPath, err := gitutil.OwnerRepoFromRepoURL(repoURL)
+	if err != nil {
+		return "", status.WrapError(err, "Failed to parse project path from GitLab URL")
+	}
+	if !strings.Contains(projectPath, "/") {
+		return "", status.InvalidArgumentErrorf("Invalid GitLab project path %q", projectPath)
+	}
+	return apiURL + "/projects/" + url.PathEscape(projectPath), nil
+}
+
+func unmarshalBody(r *http.Request, payload interface{}) error {
+	b, err := io.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, payload)
+}
+
+// PushEventPayload represents a subset of GitLab's push event schema.
+// See https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html#push-events
+type PushEventPayload struct {
+	Ref     string    `json:"ref"`
+	After   string    `json:"after"`
+	Project *Project  `json:"project"`
+	Commits []*Commit `json:"commits"`
+	// TotalCommitsCount is the number of pushed commits. The commits list is
+	// limited to the 20 most recent ones.
+	TotalCommitsCount int `json:"total_commits_count"`
+}
+
+// MergeRequestEventPayload represents a subset of GitLab's merge request
+// event schema.
+// See https://docs.gitlab.com/ee/user/project/integrations/webhook_events.html#merge-request-events
+type MergeRequestEventPayload struct {
+	User             *User                   `json:"user"`
+	Project          *Project                `json:"project"`
+	ObjectAttributes *MergeRequestAttributes `json:"object_attributes"`
+	Changes          *MergeRequestChanges    `json:"changes"`
+}
+type MergeRequestAttributes struct {
+	// Action is the action that triggered the event, e.g. "open" or "update".
+	Action string `json:"action"`
+	// OldRev is set on "update" events that pushed new commits.
+	OldRev       string   `json:"oldrev"`
+	SourceBranch string   `json:"source_branch"`
+	TargetBranch string   `json:"target_branch"`
+	Source       *Project `json:"source"`
+	Target       *Project `json:"target"`
+	LastCommit   *Commit  `json:"last_commit"`
+}
+type MergeRequestChanges struct {
+	TargetBranch *json.RawMessage `json:"target_branch"`
+}
+
+// Project represents a subset of GitLab's project schema, which is a common
+// entity used in multiple webhook events.
+type Project struct {
+	GitHTTPURL      string `json:"git_http_url"`
+	WebURL          string `json:"web_url"`
+	VisibilityLevel int    `json:"visibility_level"`
+}
+
+// User represents a subset of GitLab's user schema, used both in webhook
+// events and in API responses.
+type User struct {
+	ID       int64  `json:"id"`
+	Username string `json:"username"`
+}
+
+// Commit represents a subset of GitLab's commit schema. The changed file
+// lists are only included in push events.
+type Commit struct {
+	ID       string   `json:"id"`
+	Added    []string `json:"added"`
+	Modified []string `json:"modified"`
+	Removed  []string `json:"removed"`
+}
@@ -0,0 +1,107 @@
+package gitlab_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/webhooks/gitlab"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/webhooks/gitlab/test_data"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func webhookRequest(t *testing.T, eventType string, payload []byte) *http.Request {
+	req, err := http.NewRequest("POST", "https://buildbuddy.io/webhooks/foo", bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("X-Gitlab-Event", eventType)
+	req.Header.Add("Content-Type", "application/json")
+	return req
+}
+
+func TestParseRequest_ValidPushEvent_Success(t *testing.T) {
+	req := webhookRequest(t, "Push Hook", test_data.PushEvent)
+
+	data, err := gitlab.NewProvider().ParseWebhookData(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &interfaces.WebhookData{
+		EventName:     "push",
+		PushedRepoURL: "https://gitlab.com/test/hello_bb_ci.git",
+		PushedBranch:  "main",
+		SHA:           "8e1c1f5e0d2b5a7c3f4e9d6b2a1c0e9f8d7c6b5a",
+		TargetRepoURL: "https://gitlab.com/test/hello_bb_ci.git",
+		TargetBranch:  "main",
+		ChangedFiles:  []string{"BUILD"},
+	}, data)
+}
+
+func TestParseRequest_PushEventWithoutCommits_ChangedFilesUnknown(t *testing.T) {
+	event := map[string]interface{}{}
+	require.NoError(t, json.Unmarshal(test_data.PushEvent, &event))
+	event["commits"] = []interface{}{}
+	event["total_commits_count"] = 0
+	payload, err := json.Marshal(event)
+	require.NoError(t, err)
+	req := webhookRequest(t, "Push Hook", payload)
+
+	data, err := gitlab.NewProvider().ParseWebhookData(req)
+
+	require.NoError(t, err)
+	require.NotNil(t, data)
+	assert.Nil(t, data.ChangedFiles)
+}
+
+func TestParseRequest_BranchDeletionPushEvent_Ignored(t *testing.T) {
+	payload := bytes.ReplaceAll(
+		test_data.PushEvent,
+		[]byte(`"after": "8e1c1f5e0d2b5a7c3f4e9d6b2a1c0e9f8d7c6b5a"`),
+		[]byte(`"after": "0000000000000000000000000000000000000000"`))
+	req := webhookRequest(t, "Push Hook", payload)
+
+	data, err := gitlab.NewProvider().ParseWebhookData(req)
+
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestParseRequest_ValidMergeRequestEvent_Success(t *testing.T) {
+	req := webhookRequest(t, "Merge Request Hook", test_data.MergeRequestEvent)
+
+	data, err := gitlab.NewProvider().ParseWebhookData(req)
+
+	assert.NoError(t, err)
+	assert.Equal(t, &interfaces.WebhookData{
+		EventName:          "pull_request",
+		PushedRepoURL:      "https://gitlab.com/test2/bb-workflows-test.git",
+		PushedBranch:       "pr-test",
+		SHA:                "5c3e1f0a9b8d7c6e5f4a3b2c1d0e9f8a7b6c5d4e",
+		TargetRepoURL:      "https://gitlab.com/test/bb-workflows-test.git",
+		IsTargetRepoPublic: true,
+		TargetBranch:       "main",
+		PullRequestAuthor:  "test2",
+	}, data)
+}
+
+func TestParseRequest_MergeRequestUpdateWithoutNewCommits_Ignored(t *testing.T) {
+	payload := bytes.ReplaceAll(test_data.MergeRequestEvent, []byte(`"action": "open"`), []byte(`"action": "update"`))
+	req := webhookRequest(t, "Merge Request Hook", payload)
+
+	data, err := gitlab.NewProvider().ParseWebhookData(req)
+
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+}
+
+func TestParseRequest_InvalidEvent_Error(t *testing.T) {
+	req := webhookRequest(t, "Push Hook", []byte{})
+
+	data, err := gitlab.NewProvider().ParseWebhookData(req)
+
+	assert.Error(t, err)
+	assert.Nil(t, data)
+}
@@ -1,14 +1,41 @@
 package webhook_data
 
+import (
+	"sort"
+)
+
 var (
 	// EventName holds canonical webhook event name constants.
 	EventName struct {
 		Push        string
 		PullRequest string
+		// Schedule is not a webhook event, but is used for actions that are
+		// started by a schedule trigger rather than by a webhook.
+		Schedule string
 	}
 )
 
 func init() {
 	EventName.Push = "push"
 	EventName.PullRequest = "pull_request"
+	EventName.Schedule = "schedule"
+}
+
+// ChangedFiles returns the sorted union of the given lists of changed file
+// paths, such as the files added, modified and removed by each pushed commit.
+// The result is non-nil even if no files changed.
+func ChangedFiles(fileLists ...[]string) []string {
+	seen := map[string]struct{}{}
+	files := make([]string, 0)
+	for _, list := range fileLists {
+		for _, f := range list {
+			if _, ok := seen[f]; ok {
+				continue
+			}
+			seen[f] = struct{}{}
+			files = append(files, f)
+		}
+	}
+	sort.Strings(files)
+	return files
 }
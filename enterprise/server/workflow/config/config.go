@@ -1,9 +1,17 @@
 package config
 
 import (
+	"fmt"
 	"io"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/webhooks/webhook_data"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"github.com/gobwas/glob"
+	"github.com/gorhill/cronexpr"
 	"gopkg.in/yaml.v2"
 )
 
@@ -17,6 +25,14 @@ const (
 	FilePath = "buildbuddy.yaml"
 )
 
+var (
+	envVarNameRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// secretRefRegexp matches env values which reference a group secret, like
+	// "${{ secrets.NAME }}".
+	secretRefRegexp = regexp.MustCompile(`^\$\{\{\s*secrets\.([A-Za-z_][A-Za-z0-9_]*)\s*\}\}$`)
+)
+
 type BuildBuddyConfig struct {
 	Actions []*Action `yaml:"actions"`
 }
@@ -29,18 +45,41 @@ type Action struct {
 	GitCleanExclude   []string  `yaml:"git_clean_exclude"`
 	BazelWorkspaceDir string    `yaml:"bazel_workspace_dir"`
 	BazelCommands     []string  `yaml:"bazel_commands"`
+	Needs             []string  `yaml:"needs"`
+	Matrix            *Matrix   `yaml:"matrix"`
+	// Env maps environment variable names to values. A value of the form
+	// "${{ secrets.NAME }}" is replaced with the value of the group's secret.
+	Env map[string]string `yaml:"env"`
+}
+
+// Matrix expands an action into one action per combination of the listed
+// values. Empty lists leave the corresponding action field unchanged.
+type Matrix struct {
+	OS         []string `yaml:"os"`
+	Arch       []string `yaml:"arch"`
+	BazelFlags []string `yaml:"bazel_flags"`
 }
 
 type Triggers struct {
 	Push        *PushTrigger        `yaml:"push"`
 	PullRequest *PullRequestTrigger `yaml:"pull_request"`
+	Schedule    []*ScheduleTrigger  `yaml:"schedule"`
 }
 
 type PushTrigger struct {
-	Branches []string `yaml:"branches"`
+	Branches    []string `yaml:"branches"`
+	Paths       []string `yaml:"paths"`
+	PathsIgnore []string `yaml:"paths_ignore"`
 }
 
 type PullRequestTrigger struct {
+	Branches    []string `yaml:"branches"`
+	Paths       []string `yaml:"paths"`
+	PathsIgnore []string `yaml:"paths_ignore"`
+}
+
+type ScheduleTrigger struct {
+	Cron     string   `yaml:"cron"`
 	Branches []string `yaml:"branches"`
 }
 
@@ -53,9 +92,206 @@ func NewConfig(r io.Reader) (*BuildBuddyConfig, error) {
 	if err := yaml.Unmarshal(byt, cfg); err != nil {
 		return nil, err
 	}
+	for _, action := range cfg.Actions {
+		if err := validateEnv(action); err != nil {
+			return nil, err
+		}
+	}
+	cfg.Actions = expandMatrices(cfg.Actions)
+	if err := validateNames(cfg.Actions); err != nil {
+		return nil, err
+	}
+	if err := validateNeeds(cfg.Actions); err != nil {
+		return nil, err
+	}
 	return cfg, nil
 }
 
+// expandMatrices replaces each action that has a matrix with one action per
+// matrix combination, and updates the needs of other actions to refer to all
+// of the expanded actions.
+func expandMatrices(actions []*Action) []*Action {
+	expandedNames := map[string][]string{}
+	var out []*Action
+	for _, action := range actions {
+		if action.Matrix == nil {
+			out = append(out, action)
+			continue
+		}
+		for _, expanded := range expandMatrix(action) {
+			expandedNames[action.Name] = append(expandedNames[action.Name], expanded.Name)
+			out = append(out, expanded)
+		}
+	}
+	for _, action := range out {
+		var needs []string
+		for _, n := range action.Needs {
+			if names, ok := expandedNames[n]; ok {
+				needs = append(needs, names...)
+			} else {
+				needs = append(needs, n)
+			}
+		}
+		action.Needs = needs
+	}
+	return out
+}
+
+func expandMatrix(action *Action) []*Action {
+	m := action.Matrix
+	osValues := valuesOrDefault(m.OS, action.OS)
+	archValues := valuesOrDefault(m.Arch, action.Arch)
+	flagValues := valuesOrDefault(m.BazelFlags, "")
+	var out []*Action
+	for _, os := range osValues {
+		for _, arch := range archValues {
+			for _, flags := range flagValues {
+				expanded := *action
+				expanded.Matrix = nil
+				expanded.OS = os
+				expanded.Arch = arch
+				var labels []string
+				if len(m.OS) > 0 {
+					labels = append(labels, os)
+				}
+				if len(m.Arch) > 0 {
+					labels = append(labels, arch)
+				}
+				if len(m.BazelFlags) > 0 {
+					labels = append(labels, flags)
+					expanded.BazelCommands = nil
+					for _, cmd := range action.BazelCommands {
+						expanded.BazelCommands = append(expanded.BazelCommands, cmd+" "+flags)
+					}
+				}
+				if len(labels) > 0 {
+					expanded.Name = fmt.Sprintf("%s (%s)", action.Name, strings.Join(labels, ", "))
+				}
+				out = append(out, &expanded)
+			}
+		}
+	}
+	return out
+}
+
+func valuesOrDefault(values []string, defaultValue string) []string {
+	if len(values) == 0 {
+		return []string{defaultValue}
+	}
+	return values
+}
+
+// validateNames returns an error if multiple actions have the same name, e.g.
+// because a matrix has repeated values.
+func validateNames(actions []*Action) error {
+	names := make(map[string]bool, len(actions))
+	for _, action := range actions {
+		if names[action.Name] {
+			return status.InvalidArgumentErrorf("multiple actions are named %q", action.Name)
+		}
+		names[action.Name] = true
+	}
+	return nil
+}
+
+// validateNeeds returns an error if any action needs an unknown action, or if
+// the needs form a cycle.
+func validateNeeds(actions []*Action) error {
+	byName := make(map[string]*Action, len(actions))
+	for _, action := range actions {
+		byName[action.Name] = action
+	}
+	for _, action := range actions {
+		for _, n := range action.Needs {
+			if _, ok := byName[n]; !ok {
+				return status.InvalidArgumentErrorf("action %q needs unknown action %q", action.Name, n)
+			}
+		}
+	}
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int, len(actions))
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case visiting:
+			return status.InvalidArgumentErrorf("action %q depends on itself through its needs", name)
+		case visited:
+			return nil
+		}
+		state[name] = visiting
+		for _, n := range byName[name].Needs {
+			if err := visit(n); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		return nil
+	}
+	for _, action := range actions {
+		if err := visit(action.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validateEnv returns an error if any of the action's env var names are
+// invalid, or if any value references a secret other than as its entire value.
+func validateEnv(action *Action) error {
+	for name, value := range action.Env {
+		if !envVarNameRegexp.MatchString(name) {
+			return status.InvalidArgumentErrorf("action %q: invalid env var name %q", action.Name, name)
+		}
+		if !secretRefRegexp.MatchString(value) && strings.Contains(value, "${{") {
+			return status.InvalidArgumentErrorf("action %q: env var %q must either be a literal value or reference a single secret, like \"${{ secrets.NAME }}\"", action.Name, name)
+		}
+	}
+	return nil
+}
+
+// SecretNames returns the sorted names of the secrets referenced by the
+// action's env.
+func SecretNames(action *Action) []string {
+	var names []string
+	seen := map[string]bool{}
+	for _, value := range action.Env {
+		m := secretRefRegexp.FindStringSubmatch(value)
+		if m == nil || seen[m[1]] {
+			continue
+		}
+		seen[m[1]] = true
+		names = append(names, m[1])
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ResolveEnv returns the action's env with secret references replaced by the
+// given secret values. Env vars which reference secrets that aren't in the
+// given map are omitted. The second return value lists the names of the env
+// vars whose values are secrets, in sorted order.
+func ResolveEnv(action *Action, secrets map[string]string) (env map[string]string, secretEnvVars []string) {
+	env = make(map[string]string, len(action.Env))
+	for name, value := range action.Env {
+		m := secretRefRegexp.FindStringSubmatch(value)
+		if m == nil {
+			env[name] = value
+			continue
+		}
+		secret, ok := secrets[m[1]]
+		if !ok {
+			continue
+		}
+		env[name] = secret
+		secretEnvVars = append(secretEnvVars, name)
+	}
+	sort.Strings(secretEnvVars)
+	return env, secretEnvVars
+}
+
 // GetDefault returns the default workflow config, which tests all targets
 // when pushing any branch.
 func GetDefault() *BuildBuddyConfig {
@@ -96,6 +332,115 @@ func MatchesAnyTrigger(action *Action, event, branch string) bool {
 	return false
 }
 
+// HasScheduleTriggers returns whether any action in the config has schedule
+// triggers.
+func HasScheduleTriggers(cfg *BuildBuddyConfig) bool {
+	for _, action := range cfg.Actions {
+		if action.Triggers != nil && len(action.Triggers.Schedule) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// ScheduledBranches returns the branches on which the action should run at the
+// given time, according to its schedule triggers. Cron expressions are
+// evaluated in UTC with minute granularity, so t should be the start of a
+// minute.
+func ScheduledBranches(action *Action, t time.Time) ([]string, error) {
+	if action.Triggers == nil {
+		return nil, nil
+	}
+	t = t.UTC()
+	var branches []string
+	seen := map[string]bool{}
+	for _, s := range action.Triggers.Schedule {
+		expr, err := cronexpr.Parse(s.Cron)
+		if err != nil {
+			return nil, status.InvalidArgumentErrorf("invalid cron expression %q: %s", s.Cron, err)
+		}
+		if !expr.Next(t.Add(-time.Second)).Equal(t) {
+			continue
+		}
+		for _, b := range s.Branches {
+			if !seen[b] {
+				seen[b] = true
+				branches = append(branches, b)
+			}
+		}
+	}
+	return branches, nil
+}
+
+// HasPathFilters returns whether the action's trigger for the given event
+// filters on the paths of changed files.
+func HasPathFilters(action *Action, event string) bool {
+	paths, pathsIgnore := pathFilters(action, event)
+	return len(paths) > 0 || len(pathsIgnore) > 0
+}
+
+// MatchesChangedPaths returns whether any of the changed files satisfies the
+// path filters of the action's trigger for the given event. A file satisfies
+// the filters if it matches any of the "paths" patterns (or "paths" is empty)
+// and none of the "paths_ignore" patterns. If the trigger has no path filters,
+// the action always matches.
+func MatchesChangedPaths(action *Action, event string, changedFiles []string) (bool, error) {
+	paths, pathsIgnore := pathFilters(action, event)
+	if len(paths) == 0 && len(pathsIgnore) == 0 {
+		return true, nil
+	}
+	include, err := compileGlobs(paths)
+	if err != nil {
+		return false, err
+	}
+	exclude, err := compileGlobs(pathsIgnore)
+	if err != nil {
+		return false, err
+	}
+	for _, f := range changedFiles {
+		if (len(include) == 0 || matchesAnyGlob(include, f)) && !matchesAnyGlob(exclude, f) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func pathFilters(action *Action, event string) (paths, pathsIgnore []string) {
+	if action.Triggers == nil {
+		return nil, nil
+	}
+	if pushCfg := action.Triggers.Push; pushCfg != nil && event == webhook_data.EventName.Push {
+		return pushCfg.Paths, pushCfg.PathsIgnore
+	}
+	if prCfg := action.Triggers.PullRequest; prCfg != nil && event == webhook_data.EventName.PullRequest {
+		return prCfg.Paths, prCfg.PathsIgnore
+	}
+	return nil, nil
+}
+
+// compileGlobs compiles path patterns in which "*" matches within a single
+// path component and "**" matches across path components.
+func compileGlobs(patterns []string) ([]glob.Glob, error) {
+	globs := make([]glob.Glob, 0, len(patterns))
+	for _, p := range patterns {
+		g, err := glob.Compile(p, '/')
+		if err != nil {
+			return nil, status.InvalidArgumentErrorf("invalid path pattern %q: %s", p, err)
+		}
+		globs = append(globs, g)
+	}
+	return globs, nil
+}
+
+func matchesAnyGlob(globs []glob.Glob, path string) bool {
+	for _, g := range globs {
+		if g.Match(path) {
+			return true
+		}
+	}
+	return false
+}
+
 func matchesAnyBranch(branches []string, branch string) bool {
 	for _, b := range branches {
 		if b == "*" {
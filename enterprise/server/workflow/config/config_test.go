@@ -2,12 +2,15 @@ package config_test
 
 import (
 	"bytes"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/workflow/config"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/workflow/config/test_data"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWorkflowConf_Parse_BasicConfig_Valid(t *testing.T) {
@@ -57,3 +60,216 @@ func TestMatchesAnyTrigger_SupportsBasicWildcard(t *testing.T) {
 		assert.Equal(t, testCase.shouldMatch, match, "expected match(%q, %q) => %v", testCase.branchName, testCase.pattern, testCase.shouldMatch)
 	}
 }
+
+func TestWorkflowConf_Parse_PathFilters_Valid(t *testing.T) {
+	conf, err := config.NewConfig(bytes.NewReader(test_data.PathFiltersYaml))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config.Triggers{
+		Push: &config.PushTrigger{
+			Branches: []string{"main"},
+			Paths:    []string{"docs/**"},
+		},
+		PullRequest: &config.PullRequestTrigger{
+			Branches:    []string{"main"},
+			PathsIgnore: []string{"**.md"},
+		},
+	}, conf.Actions[0].Triggers)
+}
+
+func TestMatchesChangedPaths(t *testing.T) {
+	for _, testCase := range []struct {
+		paths, pathsIgnore []string
+		changedFiles       []string
+		shouldMatch        bool
+	}{
+		{nil, nil, []string{"foo/bar.go"}, true},
+		{nil, nil, nil, true},
+		{[]string{"foo/**"}, nil, []string{"foo/bar/baz.go"}, true},
+		{[]string{"foo/*"}, nil, []string{"foo/bar/baz.go"}, false},
+		{[]string{"foo/*"}, nil, []string{"foo/bar.go"}, true},
+		{[]string{"foo/**"}, nil, []string{"docs/README.md"}, false},
+		{[]string{"foo/**"}, nil, nil, false},
+		{[]string{"foo/**", "*.bzl"}, nil, []string{"docs/README.md", "deps.bzl"}, true},
+		{nil, []string{"**.md"}, []string{"docs/README.md"}, false},
+		{nil, []string{"**.md"}, []string{"docs/README.md", "foo/bar.go"}, true},
+		{[]string{"docs/**"}, []string{"docs/**.md"}, []string{"docs/README.md"}, false},
+		{[]string{"docs/**"}, []string{"docs/**.md"}, []string{"docs/BUILD"}, true},
+	} {
+		action := &config.Action{
+			Triggers: &config.Triggers{
+				PullRequest: &config.PullRequestTrigger{
+					Branches:    []string{"*"},
+					Paths:       testCase.paths,
+					PathsIgnore: testCase.pathsIgnore,
+				},
+			},
+		}
+
+		match, err := config.MatchesChangedPaths(action, "pull_request", testCase.changedFiles)
+
+		assert.NoError(t, err)
+		assert.Equal(t, testCase.shouldMatch, match, "paths=%q, paths_ignore=%q, changed files=%q", testCase.paths, testCase.pathsIgnore, testCase.changedFiles)
+	}
+}
+
+func TestMatchesChangedPaths_InvalidPattern_Error(t *testing.T) {
+	action := &config.Action{
+		Triggers: &config.Triggers{
+			Push: &config.PushTrigger{Branches: []string{"*"}, Paths: []string{"foo/[bar"}},
+		},
+	}
+
+	_, err := config.MatchesChangedPaths(action, "push", []string{"foo/bar"})
+
+	assert.Error(t, err)
+}
+
+func TestWorkflowConf_Parse_Schedule_Valid(t *testing.T) {
+	conf, err := config.NewConfig(bytes.NewReader(test_data.ScheduleYaml))
+
+	assert.NoError(t, err)
+	assert.Equal(t, &config.Triggers{
+		Schedule: []*config.ScheduleTrigger{
+			{Cron: "0 3 * * *", Branches: []string{"main"}},
+			{Cron: "0 12 * * 6", Branches: []string{"main", "release"}},
+		},
+	}, conf.Actions[0].Triggers)
+}
+
+func TestScheduledBranches(t *testing.T) {
+	conf, err := config.NewConfig(bytes.NewReader(test_data.ScheduleYaml))
+	require.NoError(t, err)
+	action := conf.Actions[0]
+
+	for _, testCase := range []struct {
+		time     string
+		branches []string
+	}{
+		// Friday
+		{"2022-07-01T03:00:00Z", []string{"main"}},
+		{"2022-07-01T03:01:00Z", nil},
+		{"2022-07-01T12:00:00Z", nil},
+		// Saturday
+		{"2022-07-02T03:00:00Z", []string{"main"}},
+		{"2022-07-02T12:00:00Z", []string{"main", "release"}},
+	} {
+		tick, err := time.Parse(time.RFC3339, testCase.time)
+		require.NoError(t, err)
+
+		branches, err := config.ScheduledBranches(action, tick)
+
+		require.NoError(t, err)
+		assert.Equal(t, testCase.branches, branches, "time: %s", testCase.time)
+	}
+}
+
+func TestScheduledBranches_InvalidCron_Error(t *testing.T) {
+	action := &config.Action{
+		Triggers: &config.Triggers{
+			Schedule: []*config.ScheduleTrigger{{Cron: "not a cron", Branches: []string{"main"}}},
+		},
+	}
+
+	_, err := config.ScheduledBranches(action, time.Unix(0, 0))
+
+	assert.Error(t, err)
+}
+
+func TestWorkflowConf_Parse_Matrix_ExpandsActions(t *testing.T) {
+	conf, err := config.NewConfig(bytes.NewReader(test_data.MatrixYaml))
+	require.NoError(t, err)
+
+	var names []string
+	for _, a := range conf.Actions {
+		names = append(names, a.Name)
+	}
+	expandedNames := []string{
+		"Test (linux, --config=opt)",
+		"Test (linux, --config=dbg)",
+		"Test (darwin, --config=opt)",
+		"Test (darwin, --config=dbg)",
+	}
+	assert.Equal(t, append(expandedNames, "Deploy"), names)
+	assert.Equal(t, "darwin", conf.Actions[3].OS)
+	assert.Equal(t, []string{"test //... --config=dbg"}, conf.Actions[3].BazelCommands)
+	assert.Nil(t, conf.Actions[3].Matrix)
+	assert.Equal(t, expandedNames, conf.Actions[4].Needs)
+}
+
+func TestWorkflowConf_Parse_InvalidNeeds_Error(t *testing.T) {
+	for _, yaml := range []string{
+		`
+actions:
+  - name: "A"
+    needs: ["B"]
+`,
+		`
+actions:
+  - name: "A"
+    needs: ["B"]
+  - name: "B"
+    needs: ["A"]
+`,
+	} {
+		_, err := config.NewConfig(strings.NewReader(yaml))
+
+		assert.Error(t, err, "config: %s", yaml)
+	}
+}
+
+func TestWorkflowConf_Parse_DuplicateNames_Error(t *testing.T) {
+	for _, yaml := range []string{
+		`
+actions:
+  - name: "A"
+  - name: "A"
+`,
+		`
+actions:
+  - name: "Test"
+    matrix:
+      os: ["linux", "linux"]
+`,
+	} {
+		_, err := config.NewConfig(strings.NewReader(yaml))
+
+		assert.Error(t, err, "config: %s", yaml)
+	}
+}
+
+func TestWorkflowConf_Parse_Env_Valid(t *testing.T) {
+	conf, err := config.NewConfig(bytes.NewReader(test_data.EnvYaml))
+	require.NoError(t, err)
+
+	action := conf.Actions[0]
+	assert.Equal(t, []string{"DEPLOY_TOKEN", "NPM_TOKEN"}, config.SecretNames(action))
+
+	env, secretEnvVars := config.ResolveEnv(action, map[string]string{"DEPLOY_TOKEN": "s3cr3t"})
+	assert.Equal(t, map[string]string{
+		"DEPLOY_ENV":   "production",
+		"DEPLOY_TOKEN": "s3cr3t",
+	}, env)
+	assert.Equal(t, []string{"DEPLOY_TOKEN"}, secretEnvVars)
+}
+
+func TestWorkflowConf_Parse_InvalidEnv_Error(t *testing.T) {
+	for _, yaml := range []string{
+		`
+actions:
+  - name: "A"
+    env:
+      1INVALID: value
+`,
+		`
+actions:
+  - name: "A"
+    env:
+      TOKEN: "Bearer ${{ secrets.TOKEN }}"
+`,
+	} {
+		_, err := config.NewConfig(strings.NewReader(yaml))
+
+		assert.Error(t, err, "config: %s", yaml)
+	}
+}
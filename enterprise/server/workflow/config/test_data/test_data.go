@@ -4,3 +4,15 @@ import _ "embed"
 
 //go:embed basic.yaml
 var BasicYaml []byte
+
+//go:embed env.yaml
+var EnvYaml []byte
+
+//go:embed matrix.yaml
+var MatrixYaml []byte
+
+//go:embed path_filters.yaml
+var PathFiltersYaml []byte
+
+//go:embed schedule.yaml
+var ScheduleYaml []byte
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/remote_execution/operation"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/workflow/config"
+	"github.com/buildbuddy-io/buildbuddy/server/backends/github"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/util/log"
+	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+
+	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
+)
+
+const (
+	// combinedStatusContext is the commit status context used to report the
+	// combined result of actions that depend on each other.
+	combinedStatusContext = "BuildBuddy Workflows"
+
+	// maxActionGraphDuration is how long to wait for all of the actions in an
+	// action graph to finish before giving up on the remaining actions.
+	maxActionGraphDuration = 12 * time.Hour
+
+	// actionGraphStatusTimeout bounds the time spent reporting the final
+	// statuses of an action graph, which happens after its context is done.
+	actionGraphStatusTimeout = 30 * time.Second
+)
+
+// actionGraphs tracks the action graphs run by this app. Their orchestration
+// state is only kept in memory, so they are cancelled when the app shuts down.
+type actionGraphs struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func newActionGraphs() *actionGraphs {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &actionGraphs{ctx: ctx, cancel: cancel}
+}
+
+// startActionGraph runs the action graph in the background. See
+// runActionGraph.
+func (ws *workflowService) startActionGraph(apiKey *tables.APIKey, wf *tables.Workflow, wd *interfaces.WebhookData, isTrusted bool, actions []*config.Action) {
+	ws.actionGraphs.wg.Add(1)
+	go func() {
+		defer ws.actionGraphs.wg.Done()
+		ws.runActionGraph(ws.actionGraphs.ctx, apiKey, wf, wd, isTrusted, actions)
+	}()
+}
+
+// StopActionGraphs cancels the action graphs that are still running, and waits
+// until they have reported the status of their remaining actions or the
+// context is done.
+func (ws *workflowService) StopActionGraphs(ctx context.Context) error {
+	ws.actionGraphs.cancel()
+	done := make(chan struct{})
+	go func() {
+		ws.actionGraphs.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// hasNeeds returns whether any of the actions needs another one of the
+// actions.
+func hasNeeds(actions []*config.Action) bool {
+	names := make(map[string]bool, len(actions))
+	for _, action := range actions {
+		names[action.Name] = true
+	}
+	for _, action := range actions {
+		for _, n := range action.Needs {
+			if names[n] {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// actionGraphNode tracks the result of an action in an action graph.
+type actionGraphNode struct {
+	done      chan struct{}
+	succeeded bool
+}
+
+// runActionGraph runs the actions in the order given by their needs. Each
+// action starts once all of the actions it needs have succeeded, and is skipped
+// if any of them fails. Needed actions which aren't among the given actions
+// (e.g. because they aren't triggered by the event) are ignored. Once all of
+// the actions have finished, a combined status is reported.
+//
+// The graph is only tracked in memory by the app that received the webhook.
+// If ctx is done before an action could be started, e.g. because the graph
+// timed out or the app is shutting down, the action is reported as cancelled
+// so that its commit status doesn't stay pending. Actions that were already
+// started keep running and report their own status. If the app crashes, no
+// status is reported for actions that weren't started yet.
+func (ws *workflowService) runActionGraph(ctx context.Context, apiKey *tables.APIKey, wf *tables.Workflow, wd *interfaces.WebhookData, isTrusted bool, actions []*config.Action) {
+	ctx, cancel := context.WithTimeout(ctx, maxActionGraphDuration)
+	defer cancel()
+
+	if err := ws.createStatus(ctx, wf, wd, combinedStatusContext, fmt.Sprintf("Running %d actions", len(actions)), github.PendingState); err != nil {
+		log.Warningf("Failed to create workflow %s (%s) combined status: %s", wf.WorkflowID, wf.RepoURL, err)
+	}
+
+	// Nodes are indexed like actions. Action names are unique within a valid
+	// config, but the graph doesn't rely on it.
+	nodes := make([]*actionGraphNode, len(actions))
+	nodeByName := make(map[string]*actionGraphNode, len(actions))
+	for i, action := range actions {
+		nodes[i] = &actionGraphNode{done: make(chan struct{})}
+		if _, ok := nodeByName[action.Name]; !ok {
+			nodeByName[action.Name] = nodes[i]
+		}
+	}
+	var wg sync.WaitGroup
+	for i, action := range actions {
+		action := action
+		node := nodes[i]
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer close(node.done)
+			for _, n := range action.Needs {
+				needed, ok := nodeByName[n]
+				if !ok {
+					continue
+				}
+				select {
+				case <-needed.done:
+				case <-ctx.Done():
+					ws.reportCancelledAction(wf, wd, action.Name, ctx.Err())
+					return
+				}
+				if !needed.succeeded {
+					log.Infof("Skipping workflow action %s (%s) %q (needed action %q did not succeed)", wf.WorkflowID, wf.RepoURL, action.Name, n)
+					if err := ws.createStatus(ctx, wf, wd, action.Name, fmt.Sprintf("Skipped: needed action %q did not succeed", n), github.FailureState); err != nil {
+						log.Warningf("Failed to create workflow %s (%s) action status: %s", wf.WorkflowID, wf.RepoURL, err)
+					}
+					return
+				}
+			}
+			if err := ctx.Err(); err != nil {
+				ws.reportCancelledAction(wf, wd, action.Name, err)
+				return
+			}
+			executionID, err := ws.startAction(ctx, apiKey, wf, wd, isTrusted, action)
+			if err != nil {
+				return
+			}
+			succeeded, err := ws.waitForExecutionSuccess(ctx, apiKey, executionID)
+			if err != nil {
+				log.Warningf("Failed to wait for workflow %s (%s) action %q: %s", wf.WorkflowID, wf.RepoURL, action.Name, err)
+				return
+			}
+			node.succeeded = succeeded
+		}()
+	}
+	wg.Wait()
+
+	failed := 0
+	for _, node := range nodes {
+		if !node.succeeded {
+			failed++
+		}
+	}
+	state := github.SuccessState
+	description := fmt.Sprintf("All %d actions succeeded", len(actions))
+	if failed > 0 {
+		state = github.FailureState
+		description = fmt.Sprintf("%d of %d actions did not succeed", failed, len(actions))
+	}
+	// ctx may be done by now, so report the combined status with a fresh one.
+	statusCtx, cancelStatus := context.WithTimeout(context.Background(), actionGraphStatusTimeout)
+	defer cancelStatus()
+	if err := ws.createStatus(statusCtx, wf, wd, combinedStatusContext, description, state); err != nil {
+		log.Warningf("Failed to create workflow %s (%s) combined status: %s", wf.WorkflowID, wf.RepoURL, err)
+	}
+}
+
+// reportCancelledAction reports an action in an action graph that won't be
+// started because the graph's context is done.
+func (ws *workflowService) reportCancelledAction(wf *tables.Workflow, wd *interfaces.WebhookData, actionName string, cause error) {
+	log.Infof("Cancelling workflow action %s (%s) %q: %s", wf.WorkflowID, wf.RepoURL, actionName, cause)
+	description := "Cancelled: the server stopped before needed actions finished"
+	if cause == context.DeadlineExceeded {
+		description = fmt.Sprintf("Cancelled: needed actions did not finish within %s", maxActionGraphDuration)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), actionGraphStatusTimeout)
+	defer cancel()
+	if err := ws.createStatus(ctx, wf, wd, actionName, description, github.ErrorState); err != nil {
+		log.Warningf("Failed to create workflow %s (%s) action status: %s", wf.WorkflowID, wf.RepoURL, err)
+	}
+}
+
+// waitForExecutionSuccess waits for the CI runner execution to complete, and
+// returns whether the action ran successfully.
+func (ws *workflowService) waitForExecutionSuccess(ctx context.Context, apiKey *tables.APIKey, executionID string) (bool, error) {
+	ctx = ws.env.GetAuthenticator().AuthContextFromAPIKey(ctx, apiKey.Value)
+	ctx, err := prefix.AttachUserPrefixToContext(ctx, ws.env)
+	if err != nil {
+		return false, err
+	}
+	stream, err := ws.env.GetRemoteExecutionClient().WaitExecution(ctx, &repb.WaitExecutionRequest{
+		Name: executionID,
+	})
+	if err != nil {
+		return false, err
+	}
+	for {
+		op, err := stream.Recv()
+		if err == io.EOF {
+			return false, status.UnavailableErrorf("execution %q stream ended before the execution completed", executionID)
+		}
+		if err != nil {
+			return false, err
+		}
+		if operation.ExtractStage(op) != repb.ExecutionStage_COMPLETED {
+			continue
+		}
+		rsp := operation.ExtractExecuteResponse(op)
+		if rsp == nil {
+			return false, status.InternalErrorf("execution %q completed without a response", executionID)
+		}
+		return rsp.GetStatus().GetCode() == 0 && rsp.GetResult().GetExitCode() == 0, nil
+	}
+}
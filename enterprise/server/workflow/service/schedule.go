@@ -0,0 +1,248 @@
+package service
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/util/redisutil"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/webhooks/webhook_data"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/workflow/config"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/buildbuddy-io/buildbuddy/server/tables"
+	"github.com/buildbuddy-io/buildbuddy/server/util/log"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+
+	remote_execution_config "github.com/buildbuddy-io/buildbuddy/enterprise/server/remote_execution/config"
+	gitutil "github.com/buildbuddy-io/buildbuddy/server/util/git"
+	guuid "github.com/google/uuid"
+)
+
+var (
+	scheduleConfigTTL = flag.Duration("remote_execution.workflows_schedule_config_ttl", 10*time.Minute, "How long to cache each workflow's buildbuddy.yaml when checking for scheduled actions. Changes to schedule triggers take up to this long to take effect.")
+)
+
+const (
+	// scheduleTickInterval is the granularity of schedule triggers.
+	scheduleTickInterval = 1 * time.Minute
+
+	// scheduleLockKeyPrefix is the prefix of the Redis keys used to make sure
+	// that each scheduled action is started by only one app per tick.
+	scheduleLockKeyPrefix = "lock.workflowSchedule/"
+
+	// scheduleLockExpiry is how long the lock for a scheduled run is held. The
+	// lock is never released, so that apps which process the same tick later
+	// (e.g. due to clock skew) don't start the action again.
+	scheduleLockExpiry = 1 * time.Hour
+)
+
+// scheduledConfig is a workflow config cached by the scheduler.
+type scheduledConfig struct {
+	cfg       *config.BuildBuddyConfig
+	fetchedAt time.Time
+}
+
+type scheduler struct {
+	mu      sync.Mutex
+	configs map[string]*scheduledConfig
+	stop    chan struct{}
+}
+
+// StartScheduler starts a background job which runs workflow actions with
+// schedule triggers. Each app runs the job; a Redis lock ensures that each
+// action is only started once per tick.
+func (ws *workflowService) StartScheduler() {
+	if !remote_execution_config.RemoteExecutionEnabled() {
+		return
+	}
+	ws.scheduler.stop = make(chan struct{})
+	go func() {
+		lastTick := time.Now().Truncate(scheduleTickInterval)
+		for {
+			nextTick := lastTick.Add(scheduleTickInterval)
+			select {
+			case <-ws.scheduler.stop:
+				return
+			case <-time.After(time.Until(nextTick)):
+			}
+			// Process every tick since the last one, in case the previous run
+			// took longer than the tick interval.
+			for tick := nextTick; !tick.After(time.Now()); tick = tick.Add(scheduleTickInterval) {
+				ctx, cancel := context.WithTimeout(context.Background(), scheduleTickInterval)
+				if err := ws.RunScheduledWorkflows(ctx, tick); err != nil {
+					log.Warningf("Failed to run scheduled workflows: %s", err)
+				}
+				cancel()
+				lastTick = tick
+			}
+		}
+	}()
+}
+
+// StopScheduler stops the job started by StartScheduler.
+func (ws *workflowService) StopScheduler() {
+	if ws.scheduler.stop != nil {
+		close(ws.scheduler.stop)
+	}
+}
+
+// RunScheduledWorkflows starts the workflow actions whose schedule triggers
+// fire at the given tick, which must be the start of a minute.
+func (ws *workflowService) RunScheduledWorkflows(ctx context.Context, tick time.Time) error {
+	if err := ws.checkStartWorkflowPreconditions(ctx); err != nil {
+		return err
+	}
+	var workflows []*tables.Workflow
+	err := ws.env.GetDBHandle().DB(ctx).Raw(
+		`SELECT * FROM Workflows WHERE has_schedule = ? ORDER BY created_at_usec ASC`, true,
+	).Find(&workflows).Error
+	if err != nil {
+		return err
+	}
+	ws.evictScheduledConfigs(workflows)
+	for _, wf := range workflows {
+		if err := ws.runScheduledWorkflow(ctx, wf, tick); err != nil {
+			log.Warningf("Failed to run scheduled actions for workflow %s (%s): %s", wf.WorkflowID, wf.RepoURL, err)
+		}
+	}
+	return nil
+}
+
+func (ws *workflowService) runScheduledWorkflow(ctx context.Context, wf *tables.Workflow, tick time.Time) error {
+	cfg, err := ws.scheduledWorkflowConfig(ctx, wf)
+	if err != nil {
+		return err
+	}
+	if !config.HasScheduleTriggers(cfg) {
+		// Stop checking the workflow until a push adds a schedule, and fetch
+		// its config again when it does.
+		ws.scheduler.mu.Lock()
+		delete(ws.scheduler.configs, wf.WorkflowID)
+		ws.scheduler.mu.Unlock()
+		return ws.setHasSchedule(ctx, wf, false)
+	}
+	var apiKey *tables.APIKey
+	for _, action := range cfg.Actions {
+		branches, err := config.ScheduledBranches(action, tick)
+		if err != nil {
+			log.Warningf("Skipping scheduled workflow action %s (%s) %q: %s", wf.WorkflowID, wf.RepoURL, action.Name, err)
+			continue
+		}
+		for _, branch := range branches {
+			acquired, err := ws.acquireScheduleLock(ctx, wf, action, branch, tick)
+			if err != nil {
+				return err
+			}
+			if !acquired {
+				continue
+			}
+			if apiKey == nil {
+				apiKey, err = ws.apiKeyForWorkflow(ctx, wf)
+				if err != nil {
+					return err
+				}
+			}
+			wd := &interfaces.WebhookData{
+				EventName:     webhook_data.EventName.Schedule,
+				PushedRepoURL: wf.RepoURL,
+				PushedBranch:  branch,
+				TargetRepoURL: wf.RepoURL,
+				TargetBranch:  branch,
+			}
+			invocationUUID, err := guuid.NewRandom()
+			if err != nil {
+				return err
+			}
+			// Scheduled runs only check out branches of the workflow's own
+			// repo, so they are trusted.
+			isTrusted := true
+			if _, err := ws.executeWorkflow(ctx, apiKey, wf, wd, isTrusted, action, invocationUUID.String(), nil /*=extraCIRunnerArgs*/); err != nil {
+				log.Warningf("Failed to execute scheduled workflow %s (%s) action %q on branch %q: %s", wf.WorkflowID, wf.RepoURL, action.Name, branch, err)
+			}
+		}
+	}
+	return nil
+}
+
+// scheduledWorkflowConfig returns the workflow config from the default branch
+// of the workflow's repo, caching it for up to
+// --remote_execution.workflows_schedule_config_ttl.
+func (ws *workflowService) scheduledWorkflowConfig(ctx context.Context, wf *tables.Workflow) (*config.BuildBuddyConfig, error) {
+	ws.scheduler.mu.Lock()
+	cached := ws.scheduler.configs[wf.WorkflowID]
+	ws.scheduler.mu.Unlock()
+	if cached != nil && time.Since(cached.fetchedAt) < *scheduleConfigTTL {
+		return cached.cfg, nil
+	}
+	repoURL, err := gitutil.ParseRepoURL(wf.RepoURL)
+	if err != nil {
+		return nil, err
+	}
+	gitProvider, err := ws.providerForRepo(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	// An empty SHA fetches the config from the default branch.
+	wd := &interfaces.WebhookData{PushedRepoURL: wf.RepoURL}
+	cfg, err := ws.fetchWorkflowConfig(ctx, gitProvider, wf, wd)
+	if err != nil {
+		return nil, err
+	}
+	ws.scheduler.mu.Lock()
+	ws.scheduler.configs[wf.WorkflowID] = &scheduledConfig{cfg: cfg, fetchedAt: time.Now()}
+	ws.scheduler.mu.Unlock()
+	return cfg, nil
+}
+
+// evictScheduledConfigs drops the cached configs of workflows that are no
+// longer checked by the scheduler, e.g. because they were deleted.
+func (ws *workflowService) evictScheduledConfigs(scheduled []*tables.Workflow) {
+	ids := make(map[string]bool, len(scheduled))
+	for _, wf := range scheduled {
+		ids[wf.WorkflowID] = true
+	}
+	ws.scheduler.mu.Lock()
+	defer ws.scheduler.mu.Unlock()
+	for id := range ws.scheduler.configs {
+		if !ids[id] {
+			delete(ws.scheduler.configs, id)
+		}
+	}
+}
+
+// setHasSchedule records whether the workflow's config has schedule
+// triggers, which determines whether the scheduler checks the workflow.
+func (ws *workflowService) setHasSchedule(ctx context.Context, wf *tables.Workflow, hasSchedule bool) error {
+	if wf.HasSchedule == hasSchedule {
+		return nil
+	}
+	err := ws.env.GetDBHandle().DB(ctx).Exec(
+		`UPDATE Workflows SET has_schedule = ? WHERE workflow_id = ?`, hasSchedule, wf.WorkflowID,
+	).Error
+	if err != nil {
+		return err
+	}
+	wf.HasSchedule = hasSchedule
+	return nil
+}
+
+// acquireScheduleLock returns whether this app should start the scheduled run
+// of the action on the given branch at the given tick. If Redis is not
+// configured, there is assumed to be a single app.
+func (ws *workflowService) acquireScheduleLock(ctx context.Context, wf *tables.Workflow, action *config.Action, branch string, tick time.Time) (bool, error) {
+	rdb := ws.env.GetDefaultRedisClient()
+	if rdb == nil {
+		return true, nil
+	}
+	key := fmt.Sprintf("%s%s/%s/%s/%d", scheduleLockKeyPrefix, wf.WorkflowID, action.Name, branch, tick.Unix())
+	err := redisutil.NewWeakLock(rdb, key, scheduleLockExpiry).Lock(ctx)
+	if status.IsResourceExhaustedError(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
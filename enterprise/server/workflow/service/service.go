@@ -11,6 +11,7 @@ import (
 	"net/url"
 	"os"
 	"regexp"
+	"sort"
 	"strings"
 	"time"
 
@@ -70,6 +71,15 @@ var (
 	// run at a commit because it is untrusted. An approving review at the
 	// commit will allow the action to run.
 	ApprovalRequired = status.PermissionDeniedErrorf("approval required")
+
+	// reservedEnvVars are env vars set by the workflow service, which can't be
+	// overridden by the env configured for an action.
+	reservedEnvVars = map[string]bool{
+		"BUILDBUDDY_API_KEY": true,
+		"REPO_USER":          true,
+		"REPO_TOKEN":         true,
+		"WORKDIR_OVERRIDE":   true,
+	}
 )
 
 // getWebhookID returns a string that can be used to uniquely identify a webhook.
@@ -102,12 +112,16 @@ func instanceName(wf *tables.Workflow, wd *interfaces.WebhookData, workflowActio
 }
 
 type workflowService struct {
-	env environment.Env
+	env          environment.Env
+	scheduler    scheduler
+	actionGraphs *actionGraphs
 }
 
 func NewWorkflowService(env environment.Env) *workflowService {
 	return &workflowService{
-		env: env,
+		env:          env,
+		scheduler:    scheduler{configs: make(map[string]*scheduledConfig)},
+		actionGraphs: newActionGraphs(),
 	}
 }
 
@@ -627,16 +641,17 @@ func (ws *workflowService) readWorkflowForWebhook(ctx context.Context, webhookID
 }
 
 // Creates an action that executes the CI runner for the given workflow and params.
-// Returns the digest of the action as well as the invocation ID that the CI runner
-// will assign to the workflow invocation.
-func (ws *workflowService) createActionForWorkflow(ctx context.Context, wf *tables.Workflow, wd *interfaces.WebhookData, isTrusted bool, ak *tables.APIKey, instanceName string, workflowAction *config.Action, invocationID string, extraArgs []string) (*repb.Digest, error) {
+// Returns the digest of the action, along with the env vars whose values are
+// secrets. Secrets are not part of the action, which is stored in the CAS, and
+// must be passed to the execution using platform.WithEnvOverrides.
+func (ws *workflowService) createActionForWorkflow(ctx context.Context, wf *tables.Workflow, wd *interfaces.WebhookData, isTrusted bool, ak *tables.APIKey, instanceName string, workflowAction *config.Action, invocationID string, extraArgs []string) (*repb.Digest, []*repb.Command_EnvironmentVariable, error) {
 	cache := ws.env.GetCache()
 	if cache == nil {
-		return nil, status.UnavailableError("No cache configured.")
+		return nil, nil, status.UnavailableError("No cache configured.")
 	}
 	inputRootDigest, err := digest.ComputeForMessage(&repb.Directory{})
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	envVars := []*repb.Command_EnvironmentVariable{}
 	if isTrusted {
@@ -663,8 +678,13 @@ func (ws *workflowService) createActionForWorkflow(ctx context.Context, wf *tabl
 		}
 	}
 	if os == platform.DarwinOperatingSystemName && !isTrusted {
-		return nil, ApprovalRequired
+		return nil, nil, ApprovalRequired
+	}
+	actionEnvVars, secretEnvVars, err := ws.actionEnvVars(ctx, wf, isTrusted, workflowAction)
+	if err != nil {
+		return nil, nil, err
 	}
+	envVars = append(envVars, actionEnvVars...)
 	// Make the "outer" workflow invocation public if the target repo is public,
 	// so that workflow commit status details can be seen by contributors.
 	visibility := ""
@@ -718,9 +738,12 @@ func (ws *workflowService) createActionForWorkflow(ctx context.Context, wf *tabl
 	for _, path := range workflowAction.GitCleanExclude {
 		cmd.Arguments = append(cmd.Arguments, "--git_clean_exclude="+path)
 	}
+	for _, e := range secretEnvVars {
+		cmd.Arguments = append(cmd.Arguments, "--secret_env_var="+e.GetName())
+	}
 	cmdDigest, err := cachetools.UploadProtoToCAS(ctx, cache, instanceName, cmd)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	action := &repb.Action{
 		CommandDigest:   cmdDigest,
@@ -728,7 +751,51 @@ func (ws *workflowService) createActionForWorkflow(ctx context.Context, wf *tabl
 		DoNotCache:      true,
 	}
 	actionDigest, err := cachetools.UploadProtoToCAS(ctx, cache, instanceName, action)
-	return actionDigest, err
+	if err != nil {
+		return nil, nil, err
+	}
+	return actionDigest, secretEnvVars, nil
+}
+
+// actionEnvVars returns the env vars configured for the workflow action,
+// separating out the env vars whose values are secrets. Secrets are only
+// resolved for trusted runs; for untrusted runs, env vars which reference
+// secrets are omitted.
+func (ws *workflowService) actionEnvVars(ctx context.Context, wf *tables.Workflow, isTrusted bool, action *config.Action) (envVars, secretEnvVars []*repb.Command_EnvironmentVariable, err error) {
+	for name := range action.Env {
+		if reservedEnvVars[name] {
+			return nil, nil, status.InvalidArgumentErrorf("env var %q is reserved and can't be set by action %q", name, action.Name)
+		}
+	}
+	var secrets map[string]string
+	if names := config.SecretNames(action); isTrusted && len(names) > 0 {
+		ss := ws.env.GetSecretService()
+		if ss == nil {
+			return nil, nil, status.FailedPreconditionErrorf("action %q references secrets, but secrets are not enabled", action.Name)
+		}
+		secrets, err = ss.GetSecrets(ctx, wf.GroupID, names)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+	env, secretNames := config.ResolveEnv(action, secrets)
+	secretEnv := make(map[string]string, len(secretNames))
+	for _, name := range secretNames {
+		secretEnv[name] = env[name]
+		delete(env, name)
+	}
+	return sortedEnvVars(env), sortedEnvVars(secretEnv), nil
+}
+
+func sortedEnvVars(env map[string]string) []*repb.Command_EnvironmentVariable {
+	envVars := make([]*repb.Command_EnvironmentVariable, 0, len(env))
+	for name, value := range env {
+		envVars = append(envVars, &repb.Command_EnvironmentVariable{Name: name, Value: value})
+	}
+	sort.Slice(envVars, func(i, j int) bool {
+		return envVars[i].GetName() < envVars[j].GetName()
+	})
+	return envVars
 }
 
 func (ws *workflowService) WorkflowsPoolName() string {
@@ -880,32 +947,75 @@ func (ws *workflowService) startWorkflow(webhookID string, r *http.Request) erro
 	if err != nil {
 		return err
 	}
+	// Let the scheduler know about schedules added to the config.
+	if config.HasScheduleTriggers(cfg) {
+		if err := ws.setHasSchedule(ctx, wf, true); err != nil {
+			log.Warningf("Failed to enable the schedule of workflow %s (%s): %s", wf.WorkflowID, wf.RepoURL, err)
+		}
+	}
+	var actions []*config.Action
 	for _, action := range cfg.Actions {
 		if !config.MatchesAnyTrigger(action, wd.EventName, wd.TargetBranch) {
 			continue
 		}
-		invocationUUID, err := guuid.NewRandom()
-		if err != nil {
-			return err
-		}
-		invocationID := invocationUUID.String()
-		_, err = ws.executeWorkflow(ctx, apiKey, wf, wd, isTrusted, action, invocationID, nil /*=extraCIRunnerArgs*/)
-		if err != nil {
-			if err == ApprovalRequired {
-				log.Infof("Skipping workflow action %s (%s) %q (requires approval)", wf.WorkflowID, wf.RepoURL, action.Name)
-				if err := ws.createApprovalRequiredStatus(ctx, wf, wd, action.Name); err != nil {
+		// If the webhook payload lists the changed files, apply the path
+		// filters here. Otherwise, the CI runner applies them to pull requests
+		// using git diff, and pushes always run since their changes are
+		// unknown.
+		if wd.ChangedFiles != nil {
+			match, err := config.MatchesChangedPaths(action, wd.EventName, wd.ChangedFiles)
+			if err != nil {
+				log.Warningf("Skipping workflow action %s (%s) %q: %s", wf.WorkflowID, wf.RepoURL, action.Name, err)
+				continue
+			}
+			if !match {
+				log.Infof("Skipping workflow action %s (%s) %q (no changed files match its path filters)", wf.WorkflowID, wf.RepoURL, action.Name)
+				if err := ws.createSkippedStatus(ctx, wf, wd, action.Name); err != nil {
 					log.Warningf("Failed to create workflow %s (%s) action status: %s", wf.WorkflowID, wf.RepoURL, err)
 				}
 				continue
 			}
-			// TODO: Create a UI for these errors instead of just logging on the
-			// server.
-			log.Warningf("Failed to execute workflow %s (%s) action %q: %s", wf.WorkflowID, wf.RepoURL, action.Name, err)
 		}
+		actions = append(actions, action)
+	}
+	if hasNeeds(actions) {
+		// Downstream actions can only be started once the actions they need
+		// have finished, so orchestrate the actions in the background.
+		ws.startActionGraph(apiKey, wf, wd, isTrusted, actions)
+		return nil
+	}
+	for _, action := range actions {
+		ws.startAction(ctx, apiKey, wf, wd, isTrusted, action)
 	}
 	return nil
 }
 
+// startAction starts a CI runner execution for the action and returns the
+// execution ID. Errors are logged, and reported as a commit status if the
+// action requires approval.
+func (ws *workflowService) startAction(ctx context.Context, apiKey *tables.APIKey, wf *tables.Workflow, wd *interfaces.WebhookData, isTrusted bool, action *config.Action) (string, error) {
+	invocationUUID, err := guuid.NewRandom()
+	if err != nil {
+		return "", err
+	}
+	invocationID := invocationUUID.String()
+	executionID, err := ws.executeWorkflow(ctx, apiKey, wf, wd, isTrusted, action, invocationID, nil /*=extraCIRunnerArgs*/)
+	if err != nil {
+		if err == ApprovalRequired {
+			log.Infof("Skipping workflow action %s (%s) %q (requires approval)", wf.WorkflowID, wf.RepoURL, action.Name)
+			if err := ws.createApprovalRequiredStatus(ctx, wf, wd, action.Name); err != nil {
+				log.Warningf("Failed to create workflow %s (%s) action status: %s", wf.WorkflowID, wf.RepoURL, err)
+			}
+			return "", err
+		}
+		// TODO: Create a UI for these errors instead of just logging on the
+		// server.
+		log.Warningf("Failed to execute workflow %s (%s) action %q: %s", wf.WorkflowID, wf.RepoURL, action.Name, err)
+		return "", err
+	}
+	return executionID, nil
+}
+
 // starts a CI runner execution and returns the execution ID.
 func (ws *workflowService) executeWorkflow(ctx context.Context, key *tables.APIKey, wf *tables.Workflow, wd *interfaces.WebhookData, isTrusted bool, workflowAction *config.Action, invocationID string, extraCIRunnerArgs []string) (string, error) {
 	ctx = ws.env.GetAuthenticator().AuthContextFromAPIKey(ctx, key.Value)
@@ -914,7 +1024,7 @@ func (ws *workflowService) executeWorkflow(ctx context.Context, key *tables.APIK
 		return "", err
 	}
 	in := instanceName(wf, wd, workflowAction.Name, workflowAction.GitCleanExclude)
-	ad, err := ws.createActionForWorkflow(ctx, wf, wd, isTrusted, key, in, workflowAction, invocationID, extraCIRunnerArgs)
+	ad, secretEnvVars, err := ws.createActionForWorkflow(ctx, wf, wd, isTrusted, key, in, workflowAction, invocationID, extraCIRunnerArgs)
 	if err != nil {
 		return "", err
 	}
@@ -923,6 +1033,7 @@ func (ws *workflowService) executeWorkflow(ctx context.Context, key *tables.APIK
 	if err != nil {
 		return "", err
 	}
+	execCtx = platform.WithEnvOverrides(execCtx, secretEnvVars)
 	execCtx, cancelRPC := context.WithCancel(execCtx)
 	// Note that we use this to cancel the operation update stream from the Execute RPC, not the execution itself.
 	defer cancelRPC()
@@ -957,6 +1068,27 @@ func (ws *workflowService) createApprovalRequiredStatus(ctx context.Context, wf
 	return ghc.CreateStatus(ctx, ownerRepo, wd.SHA, status)
 }
 
+// createSkippedStatus reports an action that was skipped because of its path
+// filters, so that required checks don't block the commit.
+func (ws *workflowService) createSkippedStatus(ctx context.Context, wf *tables.Workflow, wd *interfaces.WebhookData, actionName string) error {
+	return ws.createStatus(ctx, wf, wd, actionName, "Skipped: no changed files match paths", github.SuccessState)
+}
+
+// createStatus reports a commit status with the given context for the
+// webhook's commit. Statuses are only reported for GitHub repos.
+func (ws *workflowService) createStatus(ctx context.Context, wf *tables.Workflow, wd *interfaces.WebhookData, statusContext, description string, state github.State) error {
+	if !isGitHubURL(wd.TargetRepoURL) {
+		return nil
+	}
+	status := github.NewGithubStatusPayload(statusContext, build_buddy_url.String(), description, state)
+	ownerRepo, err := gitutil.OwnerRepoFromRepoURL(wd.TargetRepoURL)
+	if err != nil {
+		return err
+	}
+	ghc := github.NewGithubClient(ws.env, wf.AccessToken)
+	return ghc.CreateStatus(ctx, ownerRepo, wd.SHA, status)
+}
+
 func isGitHubURL(s string) bool {
 	u, err := url.Parse(s)
 	if err != nil {
@@ -6,9 +6,14 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/backends/userdb"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/remote_execution/operation"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/remote_execution/platform"
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/secrets"
 	"github.com/buildbuddy-io/buildbuddy/enterprise/server/testutil/testgit"
 	"github.com/buildbuddy-io/buildbuddy/server/backends/repo_downloader"
 	"github.com/buildbuddy-io/buildbuddy/server/buildbuddy_server"
@@ -34,6 +39,7 @@ import (
 	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
 	bbspb "github.com/buildbuddy-io/buildbuddy/proto/buildbuddy_service"
 	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
+	skpb "github.com/buildbuddy-io/buildbuddy/proto/secrets"
 	wfpb "github.com/buildbuddy-io/buildbuddy/proto/workflow"
 	bspb "google.golang.org/genproto/googleapis/bytestream"
 )
@@ -60,7 +66,7 @@ func newTestEnv(t *testing.T) *testenv.TestEnv {
 		"GROUP1",
 		"Default",
 		[]akpb.ApiKey_Capability{akpb.ApiKey_CACHE_WRITE_CAPABILITY},
-		true /*=visibleToDevelopers*/)
+		true /*=visibleToDevelopers*/, 0 /*=expiryUsec*/)
 	require.NoError(t, err)
 	tu := testauth.TestUsers(
 		"USER1", "GROUP1",
@@ -151,17 +157,48 @@ func envVars(cmd *repb.Command) map[string]string {
 
 type fakeExecutionClient struct {
 	repb.ExecutionClient
+	mu              sync.Mutex
 	ExecuteRequests []*repb.ExecuteRequest
+	// ExecuteMetadata holds the outgoing metadata of each Execute request.
+	ExecuteMetadata []metadata.MD
+	// ExitCode is the exit code of every execution, as observed via
+	// WaitExecution.
+	ExitCode int32
 }
 
 func (c *fakeExecutionClient) Execute(ctx context.Context, req *repb.ExecuteRequest, opts ...grpc.CallOption) (repb.Execution_ExecuteClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 	c.ExecuteRequests = append(c.ExecuteRequests, req)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	c.ExecuteMetadata = append(c.ExecuteMetadata, md)
 	return &fakeExecuteStream{}, nil
 }
 
-type fakeExecuteStream struct{ grpc.ClientStream }
+func (c *fakeExecutionClient) WaitExecution(ctx context.Context, req *repb.WaitExecutionRequest, opts ...grpc.CallOption) (repb.Execution_WaitExecutionClient, error) {
+	rsp := &repb.ExecuteResponse{Result: &repb.ActionResult{ExitCode: c.ExitCode}}
+	op, err := operation.Assemble(repb.ExecutionStage_COMPLETED, req.GetName(), digest.NewResourceName(&repb.Digest{}, ""), rsp)
+	if err != nil {
+		return nil, err
+	}
+	return &fakeExecuteStream{op: op}, nil
+}
+
+func (c *fakeExecutionClient) numExecuteRequests() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.ExecuteRequests)
+}
 
-func (*fakeExecuteStream) Recv() (*longrunning.Operation, error) {
+type fakeExecuteStream struct {
+	grpc.ClientStream
+	op *longrunning.Operation
+}
+
+func (s *fakeExecuteStream) Recv() (*longrunning.Operation, error) {
+	if s.op != nil {
+		return s.op, nil
+	}
 	return &longrunning.Operation{Name: "fake-operation-name"}, nil
 }
 
@@ -538,3 +575,246 @@ func TestWebhook_TrustedPush_StartsTrustedWorkflow(t *testing.T) {
 	env := envVars(exec.Command)
 	assert.Contains(t, env, "BUILDBUDDY_API_KEY", "trusted workflow should have BUILDBUDDY_API_KEY env var")
 }
+
+func TestRunScheduledWorkflows_StartsScheduledActions(t *testing.T) {
+	ctx := context.Background()
+	te := newTestEnv(t)
+	execClient := &fakeExecutionClient{}
+	te.SetRemoteExecutionClient(execClient)
+	ws := workflow.NewWorkflowService(te)
+	flags.Set(t, "app.build_buddy_url", *testhttp.StartServer(t, ws))
+	flags.Set(t, "remote_execution.enable_remote_exec", true)
+	provider := setupFakeGitProvider(t, te)
+	repoURL := makeTempRepo(t)
+	clientConn := runBBServer(ctx, te, t)
+	bbClient := bbspb.NewBuildBuddyServiceClient(clientConn)
+	req := &wfpb.CreateWorkflowRequest{
+		RequestContext: testauth.RequestContext("USER1", "GROUP1"),
+		GitRepo:        &wfpb.CreateWorkflowRequest_GitRepo{RepoUrl: repoURL},
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, testauth.APIKeyHeader, "USER1")
+	_, err := bbClient.CreateWorkflow(ctx, req)
+	require.NoError(t, err)
+	provider.FileContents = map[string]string{"buildbuddy.yaml": `
+actions:
+  - name: "Nightly"
+    triggers: { schedule: [ { cron: "0 3 * * *", branches: [ "main" ] } ] }
+    bazel_commands: [ "test //..." ]
+`}
+
+	err = ws.RunScheduledWorkflows(ctx, time.Date(2022, 7, 1, 2, 59, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Empty(t, execClient.ExecuteRequests, "expected no workflow executions before the scheduled time")
+
+	err = ws.RunScheduledWorkflows(ctx, time.Date(2022, 7, 1, 3, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, execClient.ExecuteRequests, 1, "expected one workflow execution to be started")
+	exec := getExecution(t, ctx, te, execClient.ExecuteRequests[0])
+	assert.Contains(t, exec.Command.GetArguments(), "--trigger_event=schedule")
+	assert.Contains(t, exec.Command.GetArguments(), "--pushed_branch=main")
+	assert.Contains(t, envVars(exec.Command), "BUILDBUDDY_API_KEY", "scheduled workflow should be trusted")
+}
+
+func TestRunScheduledWorkflows_OnlyChecksWorkflowsWithSchedules(t *testing.T) {
+	ctx := context.Background()
+	te := newTestEnv(t)
+	execClient := &fakeExecutionClient{}
+	te.SetRemoteExecutionClient(execClient)
+	ws := workflow.NewWorkflowService(te)
+	flags.Set(t, "app.build_buddy_url", *testhttp.StartServer(t, ws))
+	flags.Set(t, "remote_execution.enable_remote_exec", true)
+	provider := setupFakeGitProvider(t, te)
+	repoURL := makeTempRepo(t)
+	clientConn := runBBServer(ctx, te, t)
+	bbClient := bbspb.NewBuildBuddyServiceClient(clientConn)
+	req := &wfpb.CreateWorkflowRequest{
+		RequestContext: testauth.RequestContext("USER1", "GROUP1"),
+		GitRepo:        &wfpb.CreateWorkflowRequest_GitRepo{RepoUrl: repoURL},
+	}
+	ctx = metadata.AppendToOutgoingContext(ctx, testauth.APIKeyHeader, "USER1")
+	wfRes, err := bbClient.CreateWorkflow(ctx, req)
+	require.NoError(t, err)
+	hasSchedule := func() bool {
+		wf := &tables.Workflow{}
+		err := te.GetDBHandle().DB(ctx).Raw(`SELECT * FROM Workflows WHERE workflow_id = ?`, wfRes.GetId()).Take(wf).Error
+		require.NoError(t, err)
+		return wf.HasSchedule
+	}
+	require.True(t, hasSchedule(), "new workflows should be checked by the scheduler")
+
+	// Once the scheduler finds that the config has no schedules, it stops
+	// checking the workflow.
+	provider.FileContents = map[string]string{"buildbuddy.yaml": configWithLinuxWorkflow}
+	err = ws.RunScheduledWorkflows(ctx, time.Date(2022, 7, 1, 2, 59, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.False(t, hasSchedule())
+
+	// A push of a config with a schedule makes the scheduler check the
+	// workflow again.
+	provider.WebhookData = &interfaces.WebhookData{
+		EventName:     "push",
+		TargetRepoURL: "https://github.com/acme-inc/acme",
+		TargetBranch:  "feature",
+		PushedRepoURL: "https://github.com/acme-inc/acme",
+		PushedBranch:  "feature",
+		SHA:           "c04d68571cb519e095772c865847007ed3e7fea9",
+	}
+	provider.FileContents = map[string]string{"buildbuddy.yaml": `
+actions:
+  - name: "Nightly"
+    triggers: { schedule: [ { cron: "0 3 * * *", branches: [ "main" ] } ] }
+    bazel_commands: [ "test //..." ]
+`}
+	pingWebhook(t, wfRes.GetWebhookUrl())
+	require.True(t, hasSchedule())
+	require.Empty(t, execClient.ExecuteRequests)
+
+	err = ws.RunScheduledWorkflows(ctx, time.Date(2022, 7, 1, 3, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Len(t, execClient.ExecuteRequests, 1, "expected the scheduled action to be started")
+}
+
+const configWithDependentActions = `
+actions:
+  - name: "Test"
+    triggers: { push: { branches: [ "*" ] } }
+    bazel_commands: [ "test //..." ]
+  - name: "Deploy"
+    triggers: { push: { branches: [ "*" ] } }
+    needs: [ "Test" ]
+    bazel_commands: [ "run //deploy" ]
+`
+
+func TestWebhook_ActionWithNeeds(t *testing.T) {
+	for _, testCase := range []struct {
+		name               string
+		upstreamExitCode   int32
+		expectedExecutions int
+	}{
+		{"UpstreamSucceeds_RunsDownstream", 0, 2},
+		{"UpstreamFails_SkipsDownstream", 1, 1},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+			te := newTestEnv(t)
+			execClient := &fakeExecutionClient{ExitCode: testCase.upstreamExitCode}
+			te.SetRemoteExecutionClient(execClient)
+			ws := te.GetWorkflowService()
+			flags.Set(t, "app.build_buddy_url", *testhttp.StartServer(t, ws))
+			flags.Set(t, "remote_execution.enable_remote_exec", true)
+			provider := setupFakeGitProvider(t, te)
+			repoURL := makeTempRepo(t)
+			clientConn := runBBServer(ctx, te, t)
+			bbClient := bbspb.NewBuildBuddyServiceClient(clientConn)
+			req := &wfpb.CreateWorkflowRequest{
+				RequestContext: testauth.RequestContext("USER1", "GROUP1"),
+				GitRepo:        &wfpb.CreateWorkflowRequest_GitRepo{RepoUrl: repoURL},
+			}
+			ctx = metadata.AppendToOutgoingContext(ctx, testauth.APIKeyHeader, "USER1")
+			wfRes, err := bbClient.CreateWorkflow(ctx, req)
+			require.NoError(t, err)
+			provider.WebhookData = &interfaces.WebhookData{
+				EventName:     "push",
+				TargetRepoURL: "https://github.com/acme-inc/acme",
+				TargetBranch:  "main",
+				PushedRepoURL: "https://github.com/acme-inc/acme",
+				PushedBranch:  "main",
+				SHA:           "c04d68571cb519e095772c865847007ed3e7fea9",
+			}
+			provider.FileContents = map[string]string{"buildbuddy.yaml": configWithDependentActions}
+
+			pingWebhook(t, wfRes.GetWebhookUrl())
+
+			require.Eventually(t, func() bool {
+				return execClient.numExecuteRequests() == testCase.expectedExecutions
+			}, 5*time.Second, 10*time.Millisecond)
+			// Make sure no further actions are started.
+			time.Sleep(100 * time.Millisecond)
+			require.Equal(t, testCase.expectedExecutions, execClient.numExecuteRequests())
+			exec := getExecution(t, ctx, te, execClient.ExecuteRequests[0])
+			assert.Contains(t, exec.Command.GetArguments(), "--action_name=Test")
+		})
+	}
+}
+
+const configWithEnv = `
+actions:
+  - name: "Deploy"
+    triggers: { pull_request: { branches: [ "*" ] }, push: { branches: [ "*" ] } }
+    env:
+      DEPLOY_ENV: production
+      DEPLOY_TOKEN: ${{ secrets.DEPLOY_TOKEN }}
+    bazel_commands: [ "run //deploy" ]
+`
+
+func TestWebhook_ActionEnv_ResolvesSecretsOnlyForTrustedRuns(t *testing.T) {
+	for _, testCase := range []struct {
+		name              string
+		pullRequestAuthor string
+		trusted           bool
+	}{
+		{"Trusted", "acme-inc-user-1", true},
+		{"Untrusted", "external-user-1", false},
+	} {
+		t.Run(testCase.name, func(t *testing.T) {
+			ctx := context.Background()
+			te := newTestEnv(t)
+			ss, err := secrets.New(te, []byte("0123456789abcdef0123456789abcdef"))
+			require.NoError(t, err)
+			te.SetSecretService(ss)
+			execClient := &fakeExecutionClient{}
+			te.SetRemoteExecutionClient(execClient)
+			ws := te.GetWorkflowService()
+			flags.Set(t, "app.build_buddy_url", *testhttp.StartServer(t, ws))
+			flags.Set(t, "remote_execution.enable_remote_exec", true)
+			provider := setupFakeGitProvider(t, te)
+			repoURL := makeTempRepo(t)
+			clientConn := runBBServer(ctx, te, t)
+			bbClient := bbspb.NewBuildBuddyServiceClient(clientConn)
+			ctx = metadata.AppendToOutgoingContext(ctx, testauth.APIKeyHeader, "USER1")
+			_, err = bbClient.CreateSecret(ctx, &skpb.CreateSecretRequest{
+				RequestContext: testauth.RequestContext("USER1", "GROUP1"),
+				Name:           "DEPLOY_TOKEN",
+				Value:          "s3cr3t",
+			})
+			require.NoError(t, err)
+			wfRes, err := bbClient.CreateWorkflow(ctx, &wfpb.CreateWorkflowRequest{
+				RequestContext: testauth.RequestContext("USER1", "GROUP1"),
+				GitRepo:        &wfpb.CreateWorkflowRequest_GitRepo{RepoUrl: repoURL},
+			})
+			require.NoError(t, err)
+			provider.TrustedUsers = []string{"acme-inc-user-1"}
+			provider.WebhookData = &interfaces.WebhookData{
+				EventName:         "pull_request",
+				TargetRepoURL:     "https://github.com/acme-inc/acme",
+				TargetBranch:      "main",
+				PushedRepoURL:     "https://github.com/untrusteduser/acme",
+				PushedBranch:      "feature",
+				SHA:               "c04d68571cb519e095772c865847007ed3e7fea9",
+				PullRequestAuthor: testCase.pullRequestAuthor,
+			}
+			provider.FileContents = map[string]string{"buildbuddy.yaml": configWithEnv}
+
+			pingWebhook(t, wfRes.GetWebhookUrl())
+
+			require.Len(t, execClient.ExecuteRequests, 1, "expected one workflow execution to be started")
+			exec := getExecution(t, ctx, te, execClient.ExecuteRequests[0])
+			env := envVars(exec.Command)
+			assert.Equal(t, "production", env["DEPLOY_ENV"])
+			assert.NotContains(t, env, "DEPLOY_TOKEN", "secrets should not be stored in the command")
+			// Secrets are passed to the execution as platform overrides, which
+			// are not stored in the CAS.
+			execCtx := metadata.NewIncomingContext(ctx, execClient.ExecuteMetadata[0])
+			props := platform.ParseProperties(&repb.ExecutionTask{
+				PlatformOverrides: &repb.Platform{Properties: platform.RemoteHeaderOverrides(execCtx)},
+			})
+			if testCase.trusted {
+				assert.Equal(t, []string{"DEPLOY_TOKEN=s3cr3t"}, props.EnvOverrides)
+				assert.Contains(t, exec.Command.GetArguments(), "--secret_env_var=DEPLOY_TOKEN")
+			} else {
+				assert.Empty(t, props.EnvOverrides, "untrusted workflow should not have secret env vars")
+				assert.NotContains(t, exec.Command.GetArguments(), "--secret_env_var=DEPLOY_TOKEN")
+			}
+		})
+	}
+}
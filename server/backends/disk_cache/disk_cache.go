@@ -21,11 +21,13 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/metrics"
 	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/digest"
 	"github.com/buildbuddy-io/buildbuddy/server/util/alert"
+	"github.com/buildbuddy-io/buildbuddy/server/util/compression"
 	"github.com/buildbuddy-io/buildbuddy/server/util/disk"
 	"github.com/buildbuddy-io/buildbuddy/server/util/flagutil"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 	"github.com/buildbuddy-io/buildbuddy/server/util/lru"
 	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/quota"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/buildbuddy-io/buildbuddy/server/util/statusz"
 	"github.com/prometheus/client_golang/prometheus"
@@ -49,6 +51,10 @@ const (
 	PartitionDirectoryPrefix = "PT"
 	HashPrefixDirPrefixLen   = 4
 	V2Dir                    = "v2"
+
+	// zstdFileSuffix is appended to the names of files that are stored
+	// zstd-compressed.
+	zstdFileSuffix = ".zstd"
 )
 
 var (
@@ -59,6 +65,7 @@ var (
 
 	migrateDiskCacheToV2AndExit = flag.Bool("migrate_disk_cache_to_v2_and_exit", false, "If true, attempt to migrate disk cache to v2 layout.")
 	enableLiveUpdates           = flag.Bool("cache.disk.enable_live_updates", false, "If set, enable live updates of disk cache adds / removes")
+	enableCompressionFlag       = flag.Bool("cache.disk.enable_compression", false, "If set, CAS blobs will be stored zstd-compressed on disk.")
 )
 
 type Options struct {
@@ -66,6 +73,7 @@ type Options struct {
 	Partitions        []disk.Partition
 	PartitionMappings []disk.PartitionMapping
 	UseV2Layout       bool
+	EnableCompression bool
 }
 
 // MigrateToV2Layout restructures the files under the root directory to conform to the "v2" layout.
@@ -173,6 +181,7 @@ func Register(env environment.Env) error {
 		Partitions:        *partitionsFlag,
 		PartitionMappings: *partitionMappingsFlag,
 		UseV2Layout:       *useV2LayoutFlag,
+		EnableCompression: *enableCompressionFlag,
 	}
 	c, err := NewDiskCache(env, dc, cache_config.MaxSizeBytes())
 	if err != nil {
@@ -222,10 +231,11 @@ func NewDiskCache(env environment.Env, opts *Options, defaultMaxSizeBytes int64)
 			rootDir = filepath.Join(rootDir, PartitionDirectoryPrefix+pc.ID)
 		}
 
-		p, err := newPartition(pc.ID, rootDir, pc.MaxSizeBytes, opts.UseV2Layout, c.addChan, c.removeChan)
+		p, err := newPartition(pc.ID, rootDir, pc.MaxSizeBytes, opts.UseV2Layout, opts.EnableCompression, c.addChan, c.removeChan)
 		if err != nil {
 			return nil, err
 		}
+		p.checkStoredBytesQuota = c.checkStoredBytesQuota
 		partitions[pc.ID] = p
 		if pc.ID == DefaultPartitionID {
 			defaultPartition = p
@@ -236,10 +246,11 @@ func NewDiskCache(env environment.Env, opts *Options, defaultMaxSizeBytes int64)
 		if opts.UseV2Layout {
 			rootDir = filepath.Join(rootDir, V2Dir, PartitionDirectoryPrefix+DefaultPartitionID)
 		}
-		p, err := newPartition(DefaultPartitionID, rootDir, defaultMaxSizeBytes, opts.UseV2Layout, c.addChan, c.removeChan)
+		p, err := newPartition(DefaultPartitionID, rootDir, defaultMaxSizeBytes, opts.UseV2Layout, opts.EnableCompression, c.addChan, c.removeChan)
 		if err != nil {
 			return nil, err
 		}
+		p.checkStoredBytesQuota = c.checkStoredBytesQuota
 		defaultPartition = p
 		partitions[DefaultPartitionID] = p
 	}
@@ -269,6 +280,17 @@ func (c *DiskCache) LiveUpdatesChan() (<-chan *rfpb.FileMetadata, <-chan *rfpb.F
 	return c.addChan, c.removeChan
 }
 
+// checkStoredBytesQuota returns a ResourceExhausted error if storing another
+// sizeBytes bytes under the given user prefix would exceed the stored bytes
+// quota of the user's group.
+func (c *DiskCache) checkStoredBytesQuota(ctx context.Context, userPrefix string, sizeBytes int64) error {
+	var storedBytes int64
+	for _, p := range c.partitions {
+		storedBytes += p.userSizeBytes(userPrefix)
+	}
+	return quota.Allow(ctx, c.env, quota.StoredBytesNamespace, storedBytes+sizeBytes)
+}
+
 func (c *DiskCache) getPartition(ctx context.Context, remoteInstanceName string) (*partition, error) {
 	auth := c.env.GetAuthenticator()
 	if auth == nil {
@@ -337,8 +359,15 @@ func (c *DiskCache) Metadata(ctx context.Context, d *repb.Digest) (*interfaces.C
 	lastUseNanos := getLastUseNanos(fileInfo)
 	lastModifyNanos := getLastModifyTimeNanos(fileInfo)
 
+	// Compressed records only store their on-disk size, but only CAS
+	// entries are compressed, so the digest size is the original size.
+	sizeBytes := lruRecord.sizeBytes
+	if lruRecord.compressor != repb.Compressor_IDENTITY {
+		sizeBytes = d.GetSizeBytes()
+	}
+
 	return &interfaces.CacheMetadata{
-		SizeBytes:          lruRecord.sizeBytes,
+		SizeBytes:          sizeBytes,
 		LastAccessTimeUsec: lastUseNanos / 1000,
 		LastModifyTimeUsec: lastModifyNanos / 1000,
 	}, nil
@@ -376,6 +405,10 @@ func (c *DiskCache) Writer(ctx context.Context, d *repb.Digest) (io.WriteCloser,
 	return c.partition.writer(ctx, c.cacheType, c.remoteInstanceName, d)
 }
 
+func (c *DiskCache) CompressedReader(ctx context.Context, d *repb.Digest) (io.ReadCloser, repb.Compressor_Value, error) {
+	return c.partition.compressedReader(ctx, c.cacheType, c.remoteInstanceName, d)
+}
+
 func (c *DiskCache) WaitUntilMapped() {
 	for _, p := range c.partitions {
 		p.WaitUntilMapped()
@@ -389,6 +422,7 @@ func (c *DiskCache) WaitUntilMapped() {
 type partition struct {
 	id               string
 	useV2Layout      bool
+	compress         bool
 	mu               sync.RWMutex
 	rootDir          string
 	maxSizeBytes     int64
@@ -401,12 +435,19 @@ type partition struct {
 	internedStrings  map[string]string
 	addChan          chan *rfpb.FileMetadata
 	removeChan       chan *rfpb.FileMetadata
+
+	// The number of bytes stored under each user prefix, as stored on disk.
+	userSizes map[string]int64
+	// checkStoredBytesQuota checks whether another sizeBytes bytes may be
+	// stored under the given user prefix.
+	checkStoredBytesQuota func(ctx context.Context, userPrefix string, sizeBytes int64) error
 }
 
-func newPartition(id string, rootDir string, maxSizeBytes int64, useV2Layout bool, addChan, removeChan chan *rfpb.FileMetadata) (*partition, error) {
+func newPartition(id string, rootDir string, maxSizeBytes int64, useV2Layout bool, compress bool, addChan, removeChan chan *rfpb.FileMetadata) (*partition, error) {
 	p := &partition{
 		id:               id,
 		useV2Layout:      useV2Layout,
+		compress:         compress,
 		maxSizeBytes:     maxSizeBytes,
 		rootDir:          rootDir,
 		fileChannel:      make(chan *fileRecord),
@@ -414,6 +455,7 @@ func newPartition(id string, rootDir string, maxSizeBytes int64, useV2Layout boo
 		doneAsyncLoading: make(chan struct{}),
 		addChan:          addChan,
 		removeChan:       removeChan,
+		userSizes:        make(map[string]int64, 0),
 	}
 	l, err := lru.NewLRU(&lru.Config{MaxSize: maxSizeBytes, OnEvict: p.evictFn, SizeFn: sizeFn})
 	if err != nil {
@@ -427,10 +469,13 @@ func newPartition(id string, rootDir string, maxSizeBytes int64, useV2Layout boo
 	return p, nil
 }
 
-// fileRecord is the data struct we store in the LRU cache
+// fileRecord is the data struct we store in the LRU cache. Records are keyed
+// in the LRU by the path of their uncompressed file, regardless of how they
+// are stored, and sizeBytes is the size of the file as stored on disk.
 type fileRecord struct {
-	key       *fileKey
-	sizeBytes int64
+	key        *fileKey
+	sizeBytes  int64
+	compressor repb.Compressor_Value
 }
 
 // timestampedFileRecord is a wrapper for fileRecord that contains additional metadata that does not need to be
@@ -440,8 +485,18 @@ type timestampedFileRecord struct {
 	lastUseNanos int64
 }
 
+// FullPath returns the path of the file backing this record on disk.
 func (fr *fileRecord) FullPath() string {
-	return fr.key.FullPath()
+	return storedPath(fr.key.FullPath(), fr.compressor)
+}
+
+// storedPath returns the path a file is stored at when it is compressed with
+// the given compressor.
+func storedPath(fullPath string, compressor repb.Compressor_Value) string {
+	if compressor == repb.Compressor_ZSTD {
+		return fullPath + zstdFileSuffix
+	}
+	return fullPath
 }
 
 func sizeFn(value interface{}) int64 {
@@ -482,15 +537,17 @@ func getLastModifyTimeNanos(info os.FileInfo) int64 {
 	return time.Unix(ts.Sec, ts.Nsec).UnixNano()
 }
 
-func makeRecord(key *fileKey, sizeBytes int64) *fileRecord {
+func makeRecord(key *fileKey, sizeBytes int64, compressor repb.Compressor_Value) *fileRecord {
 	return &fileRecord{
-		key:       key,
-		sizeBytes: sizeBytes,
+		key:        key,
+		sizeBytes:  sizeBytes,
+		compressor: compressor,
 	}
 }
 
 func (p *partition) evictFn(value interface{}) {
 	if v, ok := value.(*fileRecord); ok {
+		p.addUserSize(v, -v.sizeBytes)
 		i, err := os.Stat(v.FullPath())
 		if err == nil {
 			lastUse := time.Unix(0, getLastUseNanos(i))
@@ -501,6 +558,38 @@ func (p *partition) evictFn(value interface{}) {
 	}
 }
 
+// addUserSize adds delta to the number of bytes stored under the record's user
+// prefix.
+// NB: Callers are responsible for locking the partition.
+func (p *partition) addUserSize(record *fileRecord, delta int64) {
+	userPrefix := record.key.userPrefix
+	p.userSizes[userPrefix] += delta
+	if p.userSizes[userPrefix] <= 0 {
+		delete(p.userSizes, userPrefix)
+	}
+}
+
+// userSizeBytes returns the number of bytes stored under the given user
+// prefix.
+func (p *partition) userSizeBytes(userPrefix string) int64 {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.userSizes[userPrefix]
+}
+
+// trackRecord accounts for the size of a record about to be added to the LRU
+// under the given key. If the record replaces an existing record in place,
+// the existing record is not evicted, so its size is untracked here.
+// NB: Callers are responsible for locking the partition.
+func (p *partition) trackRecord(key string, record *fileRecord) {
+	if v, ok := p.lru.Peek(key); ok {
+		if existing, ok := v.(*fileRecord); ok {
+			p.addUserSize(existing, -existing.sizeBytes)
+		}
+	}
+	p.addUserSize(record, record.sizeBytes)
+}
+
 func (p *partition) internString(s string) string {
 	p.stringLock.RLock()
 	v, ok := p.internedStrings[s]
@@ -516,11 +605,12 @@ func (p *partition) internString(s string) string {
 
 func (p *partition) makeTimestampedRecordFromPathAndFileInfo(fullPath string, info os.FileInfo) (*timestampedFileRecord, error) {
 	fk := &fileKey{}
-	if err := fk.FromPartitionAndPath(p, fullPath); err != nil {
+	compressor, err := fk.FromPartitionAndPath(p, fullPath)
+	if err != nil {
 		return nil, err
 	}
 	return &timestampedFileRecord{
-		fileRecord:   makeRecord(fk, info.Size()),
+		fileRecord:   makeRecord(fk, info.Size(), compressor),
 		lastUseNanos: getLastUseNanos(info),
 	}, nil
 }
@@ -642,7 +732,8 @@ func (p *partition) initializeCache() error {
 		// Populate our LRU with everything we scanned from disk, until the LRU reaches capacity.
 		for _, timestampedRecord := range timestampedRecords {
 			record := timestampedRecord.fileRecord
-			if added := p.lru.PushBack(record.FullPath(), record); !added {
+			p.trackRecord(record.key.FullPath(), record)
+			if added := p.lru.PushBack(record.key.FullPath(), record); !added {
 				break
 			}
 			p.liveAdd(record)
@@ -667,7 +758,7 @@ func (p *partition) initializeCache() error {
 	return nil
 }
 
-func parseFilePath(rootDir, fullPath string, useV2Layout bool) (cacheType interfaces.CacheType, userPrefix, remoteInstanceName string, digestBytes []byte, err error) {
+func parseFilePath(rootDir, fullPath string, useV2Layout bool) (cacheType interfaces.CacheType, userPrefix, remoteInstanceName string, digestBytes []byte, compressor repb.Compressor_Value, err error) {
 	p := strings.TrimPrefix(fullPath, rootDir+"/")
 	parts := strings.Split(p, "/")
 
@@ -686,7 +777,12 @@ func parseFilePath(rootDir, fullPath string, useV2Layout bool) (cacheType interf
 
 	// pull digest off the end
 	if len(parts) > 0 {
-		db, decodeErr := hex.DecodeString(parts[len(parts)-1])
+		hash := parts[len(parts)-1]
+		if strings.HasSuffix(hash, zstdFileSuffix) {
+			hash = strings.TrimSuffix(hash, zstdFileSuffix)
+			compressor = repb.Compressor_ZSTD
+		}
+		db, decodeErr := hex.DecodeString(hash)
 		if decodeErr != nil {
 			err = parseError()
 			return
@@ -765,7 +861,7 @@ func ScanDiskDirectory(scanDir string) <-chan *rfpb.FileMetadata {
 			log.Debugf("Skipping 0 length file: %q", path)
 			return nil
 		}
-		cacheType, _, remoteInstanceName, digestBytes, err := parseFilePath(rootDir, path, useV2Layout)
+		cacheType, _, remoteInstanceName, digestBytes, compressor, err := parseFilePath(rootDir, path, useV2Layout)
 		if err != nil {
 			log.Debugf("Skipping unparsable file: %s", err)
 			return nil
@@ -799,6 +895,10 @@ func ScanDiskDirectory(scanDir string) <-chan *rfpb.FileMetadata {
 			LastAccessUsec: getLastUseNanos(info),
 			LastModifyUsec: getLastModifyTimeNanos(info),
 		}
+		if compressor != repb.Compressor_IDENTITY {
+			fm.Compressor = compressor
+			fm.StoredSizeBytes = info.Size()
+		}
 		scanned <- fm
 		return nil
 	}
@@ -819,12 +919,14 @@ type fileKey struct {
 	digestBytes        []byte
 }
 
-func (fk *fileKey) FromPartitionAndPath(part *partition, fullPath string) error {
+// FromPartitionAndPath populates the key from the path of a file in the given
+// partition, and returns the compressor the file is stored with.
+func (fk *fileKey) FromPartitionAndPath(part *partition, fullPath string) (repb.Compressor_Value, error) {
 	fk.part = part
 
-	cacheType, userPrefix, remoteInstanceName, digestBytes, err := parseFilePath(fk.part.rootDir, fullPath, fk.part.useV2Layout)
+	cacheType, userPrefix, remoteInstanceName, digestBytes, compressor, err := parseFilePath(fk.part.rootDir, fullPath, fk.part.useV2Layout)
 	if err != nil {
-		return err
+		return repb.Compressor_IDENTITY, err
 	}
 
 	fk.userPrefix = fk.part.internString(userPrefix)
@@ -832,7 +934,7 @@ func (fk *fileKey) FromPartitionAndPath(part *partition, fullPath string) error
 	fk.cacheType = cacheType
 	fk.remoteInstanceName = fk.part.internString(remoteInstanceName)
 
-	return nil
+	return compressor, nil
 }
 
 func (fk *fileKey) FullPath() string {
@@ -873,7 +975,16 @@ func (p *partition) key(ctx context.Context, cacheType interfaces.CacheType, rem
 }
 
 func (p *partition) lruAdd(record *fileRecord) {
-	p.lru.Add(record.FullPath(), record)
+	k := record.key.FullPath()
+	// If the file was previously stored with a different compressor, evict
+	// the old copy so that it does not linger on disk.
+	if v, ok := p.lru.Get(k); ok {
+		if existing, ok := v.(*fileRecord); ok && existing.compressor != record.compressor {
+			p.lru.Remove(k)
+		}
+	}
+	p.trackRecord(k, record)
+	p.lru.Add(k, record)
 	p.liveAdd(record)
 }
 
@@ -883,13 +994,17 @@ func (p *partition) addFileToLRUIfExists(key *fileKey) *fileRecord {
 	if p.diskIsMapped {
 		return nil
 	}
-	info, err := os.Stat(key.FullPath())
-	if err == nil {
+	for _, compressor := range []repb.Compressor_Value{repb.Compressor_IDENTITY, repb.Compressor_ZSTD} {
+		fullPath := storedPath(key.FullPath(), compressor)
+		info, err := os.Stat(fullPath)
+		if err != nil {
+			continue
+		}
 		if info.Size() == 0 {
-			log.Debugf("Skipping 0 length file: %q", key.FullPath())
+			log.Debugf("Skipping 0 length file: %q", fullPath)
 			return nil
 		}
-		record := makeRecord(key, info.Size())
+		record := makeRecord(key, info.Size(), compressor)
 		p.fileChannel <- record
 		p.lruAdd(record)
 		return record
@@ -897,6 +1012,34 @@ func (p *partition) addFileToLRUIfExists(key *fileKey) *fileRecord {
 	return nil
 }
 
+// storedFile returns the path that the file for the given key is stored at,
+// along with the compressor it is stored with, and marks the file as used.
+func (p *partition) storedFile(key *fileKey) (string, repb.Compressor_Value) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var record *fileRecord
+	if v, ok := p.lru.Get(key.FullPath()); ok {
+		record, _ = v.(*fileRecord)
+	} else {
+		record = p.addFileToLRUIfExists(key)
+	}
+	if record == nil {
+		return key.FullPath(), repb.Compressor_IDENTITY
+	}
+	return record.FullPath(), record.compressor
+}
+
+// compressorFor returns the compressor that new files of the given cache type
+// should be stored with.
+func (p *partition) compressorFor(cacheType interfaces.CacheType) repb.Compressor_Value {
+	// AC entries are small and are not content addressed, so their size
+	// can't be recovered from the digest; only compress CAS entries.
+	if p.compress && cacheType == interfaces.CASCacheType {
+		return repb.Compressor_ZSTD
+	}
+	return repb.Compressor_IDENTITY
+}
+
 func (p *partition) lruGet(ctx context.Context, cacheType interfaces.CacheType, remoteInstanceName string, d *repb.Digest) (*fileRecord, error) {
 	k, err := p.key(ctx, cacheType, remoteInstanceName, d)
 	if err != nil {
@@ -972,18 +1115,20 @@ func (p *partition) get(ctx context.Context, cacheType interfaces.CacheType, rem
 	if err != nil {
 		return nil, err
 	}
-	buf, err := disk.ReadFile(ctx, k.FullPath())
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	fullPath, compressor := p.storedFile(k)
+	buf, err := disk.ReadFile(ctx, fullPath)
 	if err != nil {
+		p.mu.Lock()
 		p.lru.Remove(k.FullPath()) // remove it just in case
+		p.mu.Unlock()
 		return nil, status.NotFoundErrorf("DiskCache missing file: %s", err)
 	}
-
-	if p.lru.Contains(k.FullPath()) {
-		p.lru.Get(k.FullPath()) // mark the file as used.
-	} else if !p.diskIsMapped {
-		p.addFileToLRUIfExists(k)
+	if compressor == repb.Compressor_ZSTD {
+		decompressed, err := compression.DecompressZstd(make([]byte, 0, d.GetSizeBytes()), buf)
+		if err != nil {
+			return nil, status.InternalErrorf("DiskCache failed to decompress %q: %s", fullPath, err)
+		}
+		return decompressed, nil
 	}
 	return buf, nil
 }
@@ -1031,6 +1176,10 @@ func (p *partition) makeFileMetadata(fr *fileRecord) (*rfpb.FileMetadata, error)
 	}
 	isolation.RemoteInstanceName = fr.key.remoteInstanceName
 	isolation.PartitionId = fr.key.part.id
+	storedSizeBytes := int64(0)
+	if fr.compressor != repb.Compressor_IDENTITY {
+		storedSizeBytes = fr.sizeBytes
+	}
 	return &rfpb.FileMetadata{
 		FileRecord: &rfpb.FileRecord{
 			Isolation: isolation,
@@ -1044,7 +1193,9 @@ func (p *partition) makeFileMetadata(fr *fileRecord) (*rfpb.FileMetadata, error)
 				Filename: fr.FullPath(),
 			},
 		},
-		SizeBytes: fr.sizeBytes,
+		SizeBytes:       fr.sizeBytes,
+		Compressor:      fr.compressor,
+		StoredSizeBytes: storedSizeBytes,
 	}, nil
 }
 
@@ -1076,12 +1227,25 @@ func (p *partition) set(ctx context.Context, cacheType interfaces.CacheType, rem
 	if err != nil {
 		return err
 	}
-	n, err := disk.WriteFile(ctx, k.FullPath(), data)
+	p.mu.Lock()
+	checkQuota := p.isNewFile(k)
+	p.mu.Unlock()
+
+	compressor := p.compressorFor(cacheType)
+	if compressor == repb.Compressor_ZSTD {
+		data = compression.CompressZstd(nil, data)
+	}
+	n, err := disk.WriteFile(ctx, storedPath(k.FullPath(), compressor), data)
 	if err != nil {
 		// If we had an error writing the file, just return that.
 		return err
 	}
-	record := makeRecord(k, int64(n))
+	record := makeRecord(k, int64(n), compressor)
+	if checkQuota {
+		if err := p.enforceStoredBytesQuota(ctx, record); err != nil {
+			return err
+		}
+	}
 
 	p.mu.Lock()
 	defer p.mu.Unlock()
@@ -1089,6 +1253,29 @@ func (p *partition) set(ctx context.Context, cacheType interfaces.CacheType, rem
 	return err
 }
 
+// isNewFile returns whether storing the file for the given key will use more
+// storage. Until the disk is mapped, the sizes stored by each user are not
+// known, so no files are considered new.
+// NB: Callers are responsible for locking the partition.
+func (p *partition) isNewFile(k *fileKey) bool {
+	return p.diskIsMapped && !p.lru.Contains(k.FullPath())
+}
+
+// enforceStoredBytesQuota checks whether the newly written file for the given
+// record may be stored, and deletes the file if not. The quota is checked
+// once the file has been written, since its stored size isn't known until
+// then.
+func (p *partition) enforceStoredBytesQuota(ctx context.Context, record *fileRecord) error {
+	err := p.checkStoredBytesQuota(ctx, record.key.userPrefix, record.sizeBytes)
+	if err == nil {
+		return nil
+	}
+	if err := disk.DeleteFile(ctx, record.FullPath()); err != nil {
+		log.Warningf("Could not delete over-quota file %q: %s", record.FullPath(), err)
+	}
+	return err
+}
+
 func (p *partition) setMulti(ctx context.Context, cacheType interfaces.CacheType, remoteInstanceName string, kvs map[*repb.Digest][]byte) error {
 	for d, data := range kvs {
 		if err := p.set(ctx, cacheType, remoteInstanceName, d, data); err != nil {
@@ -1117,19 +1304,84 @@ func (p *partition) reader(ctx context.Context, cacheType interfaces.CacheType,
 	if err != nil {
 		return nil, err
 	}
+	fullPath, compressor := p.storedFile(k)
+	if compressor == repb.Compressor_ZSTD {
+		// Offsets refer to the decompressed bytes, so the whole file
+		// must be read and decompressed.
+		r, err := p.openFile(ctx, k, fullPath, 0, 0)
+		if err != nil {
+			return nil, err
+		}
+		return compression.NewZstdDecompressingReader(r, offset, limit)
+	}
 	// Can't specify length because this might be ActionCache
-	r, err := disk.FileReader(ctx, k.FullPath(), offset, limit)
-	p.mu.Lock()
-	defer p.mu.Unlock()
+	return p.openFile(ctx, k, fullPath, offset, limit)
+}
+
+func (p *partition) compressedReader(ctx context.Context, cacheType interfaces.CacheType, remoteInstanceName string, d *repb.Digest) (io.ReadCloser, repb.Compressor_Value, error) {
+	k, err := p.key(ctx, cacheType, remoteInstanceName, d)
+	if err != nil {
+		return nil, repb.Compressor_IDENTITY, err
+	}
+	fullPath, compressor := p.storedFile(k)
+	r, err := p.openFile(ctx, k, fullPath, 0, 0)
 	if err != nil {
+		return nil, repb.Compressor_IDENTITY, err
+	}
+	return r, compressor, nil
+}
+
+func (p *partition) openFile(ctx context.Context, k *fileKey, fullPath string, offset, limit int64) (io.ReadCloser, error) {
+	r, err := disk.FileReader(ctx, fullPath, offset, limit)
+	if err != nil {
+		p.mu.Lock()
 		p.lru.Remove(k.FullPath()) // remove it just in case
+		p.mu.Unlock()
 		return nil, status.NotFoundErrorf("DiskCache missing file: %s", err)
-	} else {
-		p.lru.Get(k.FullPath()) // mark the file as used.
 	}
 	return r, nil
 }
 
+// countingWriter counts the bytes written to the wrapped writer.
+type countingWriter struct {
+	io.Writer
+	bytesWritten int64
+}
+
+func (c *countingWriter) Write(data []byte) (int, error) {
+	n, err := c.Writer.Write(data)
+	c.bytesWritten += int64(n)
+	return n, err
+}
+
+// compressedWriteCloser zstd-compresses bytes before writing them to the
+// wrapped file.
+type compressedWriteCloser struct {
+	io.WriteCloser
+	file   io.WriteCloser
+	stored *countingWriter
+}
+
+func newCompressedWriteCloser(file io.WriteCloser) (*compressedWriteCloser, error) {
+	stored := &countingWriter{Writer: file}
+	compressor, err := compression.NewZstdCompressingWriter(stored)
+	if err != nil {
+		return nil, err
+	}
+	return &compressedWriteCloser{
+		WriteCloser: compressor,
+		file:        file,
+		stored:      stored,
+	}, nil
+}
+
+func (c *compressedWriteCloser) Close() error {
+	if err := c.WriteCloser.Close(); err != nil {
+		return err
+	}
+	return c.file.Close()
+}
+
 type dbCloseFn func(totalBytesWritten int64) error
 type checkOversizeFn func(n int) error
 type dbWriteOnClose struct {
@@ -1159,6 +1411,7 @@ func (p *partition) writer(ctx context.Context, cacheType interfaces.CacheType,
 
 	p.mu.Lock()
 	alreadyExists := p.diskIsMapped && p.lru.Contains(k.FullPath())
+	checkQuota := p.isNewFile(k)
 	p.mu.Unlock()
 
 	if alreadyExists {
@@ -1166,14 +1419,33 @@ func (p *partition) writer(ctx context.Context, cacheType interfaces.CacheType,
 		metrics.DiskCacheDuplicateWritesBytes.Add(float64(d.GetSizeBytes()))
 	}
 
-	writeCloser, err := disk.FileWriter(ctx, k.FullPath())
+	compressor := p.compressorFor(cacheType)
+	writeCloser, err := disk.FileWriter(ctx, storedPath(k.FullPath(), compressor))
 	if err != nil {
 		return nil, err
 	}
+	var cwc *compressedWriteCloser
+	if compressor == repb.Compressor_ZSTD {
+		cwc, err = newCompressedWriteCloser(writeCloser)
+		if err != nil {
+			return nil, err
+		}
+		writeCloser = cwc
+	}
 	return &dbWriteOnClose{
 		WriteCloser: writeCloser,
 		closeFn: func(totalBytesWritten int64) error {
-			record := makeRecord(k, totalBytesWritten)
+			// The LRU accounts for the size of files as they are
+			// stored on disk.
+			if cwc != nil {
+				totalBytesWritten = cwc.stored.bytesWritten
+			}
+			record := makeRecord(k, totalBytesWritten, compressor)
+			if checkQuota {
+				if err := p.enforceStoredBytesQuota(ctx, record); err != nil {
+					return err
+				}
+			}
 
 			p.mu.Lock()
 			defer p.mu.Unlock()
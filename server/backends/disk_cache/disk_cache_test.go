@@ -17,8 +17,10 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testdigest"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testfs"
+	"github.com/buildbuddy-io/buildbuddy/server/util/compression"
 	"github.com/buildbuddy-io/buildbuddy/server/util/disk"
 	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/quota"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/stretchr/testify/require"
 	"golang.org/x/sync/errgroup"
@@ -348,6 +350,147 @@ func TestSizeLimit(t *testing.T) {
 	}
 }
 
+func compressibleDigestBuf(t *testing.T, sizeBytes int64, fill byte) (*repb.Digest, []byte) {
+	buf := bytes.Repeat([]byte{fill}, int(sizeBytes))
+	d, err := digest.Compute(bytes.NewReader(buf))
+	require.NoError(t, err)
+	return d, buf
+}
+
+func TestCompression(t *testing.T) {
+	// The blobs below would not all fit in the cache uncompressed.
+	maxSizeBytes := int64(1000)
+	rootDir := testfs.MakeTempDir(t)
+	te := getTestEnv(t, emptyUserMap)
+	ctx := getAnonContext(t, te)
+	opts := &disk_cache.Options{RootDirectory: rootDir, EnableCompression: true}
+
+	dc, err := disk_cache.NewDiskCache(te, opts, maxSizeBytes)
+	require.NoError(t, err)
+	dc.WaitUntilMapped()
+
+	digestBufs := make(map[*repb.Digest][]byte)
+	for i := 0; i < 3; i++ {
+		d, buf := compressibleDigestBuf(t, 4000, byte('a'+i))
+		digestBufs[d] = buf
+		if i%2 == 0 {
+			require.NoError(t, dc.Set(ctx, d, buf))
+			continue
+		}
+		wc, err := dc.Writer(ctx, d)
+		require.NoError(t, err)
+		_, err = wc.Write(buf)
+		require.NoError(t, err)
+		require.NoError(t, wc.Close())
+	}
+
+	for d, buf := range digestBufs {
+		rbuf, err := dc.Get(ctx, d)
+		require.NoError(t, err)
+		require.Equal(t, buf, rbuf)
+
+		md, err := dc.Metadata(ctx, d)
+		require.NoError(t, err)
+		require.Equal(t, d.GetSizeBytes(), md.SizeBytes)
+
+		r, err := dc.Reader(ctx, d, 10, 20)
+		require.NoError(t, err)
+		rbuf, err = io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Equal(t, buf[10:30], rbuf)
+
+		r, compressor, err := dc.CompressedReader(ctx, d)
+		require.NoError(t, err)
+		require.Equal(t, repb.Compressor_ZSTD, compressor)
+		compressed, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.Less(t, len(compressed), len(buf))
+		decompressed, err := compression.DecompressZstd(nil, compressed)
+		require.NoError(t, err)
+		require.Equal(t, buf, decompressed)
+	}
+
+	// AC entries are stored uncompressed.
+	ac, err := dc.WithIsolation(ctx, interfaces.ActionCacheType, "")
+	require.NoError(t, err)
+	acDigest, acBuf := testdigest.NewRandomDigestBuf(t, 100)
+	require.NoError(t, ac.Set(ctx, acDigest, acBuf))
+	r, compressor, err := ac.(interfaces.CompressedCache).CompressedReader(ctx, acDigest)
+	require.NoError(t, err)
+	require.Equal(t, repb.Compressor_IDENTITY, compressor)
+	rbuf, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+	require.Equal(t, acBuf, rbuf)
+
+	// Compressed files should be found again after a restart, even if
+	// compression has since been disabled.
+	dc2, err := disk_cache.NewDiskCache(te, &disk_cache.Options{RootDirectory: rootDir}, maxSizeBytes)
+	require.NoError(t, err)
+	dc2.WaitUntilMapped()
+	for d, buf := range digestBufs {
+		rbuf, err := dc2.Get(ctx, d)
+		require.NoError(t, err)
+		require.Equal(t, buf, rbuf)
+	}
+}
+
+// storedBytesQuotaManager allows up to maxStoredBytes in the stored bytes
+// namespace, and allows everything else.
+type storedBytesQuotaManager struct {
+	interfaces.QuotaManager
+	maxStoredBytes int64
+}
+
+func (qm *storedBytesQuotaManager) Allow(ctx context.Context, namespace string, quantity int64) (bool, error) {
+	if namespace != quota.StoredBytesNamespace {
+		return true, nil
+	}
+	return quantity <= qm.maxStoredBytes, nil
+}
+
+func TestStoredBytesQuota(t *testing.T) {
+	rootDir := testfs.MakeTempDir(t)
+	te := getTestEnv(t, emptyUserMap)
+	te.SetQuotaManager(&storedBytesQuotaManager{maxStoredBytes: 10_000})
+	ctx := getAnonContext(t, te)
+	opts := &disk_cache.Options{RootDirectory: rootDir, EnableCompression: true}
+
+	dc, err := disk_cache.NewDiskCache(te, opts, 1_000_000_000)
+	require.NoError(t, err)
+	dc.WaitUntilMapped()
+
+	// The quota applies to the compressed size of blobs, so a compressible
+	// blob larger than the quota can be stored.
+	d, buf := compressibleDigestBuf(t, 20_000, 'a')
+	require.NoError(t, dc.Set(ctx, d, buf))
+
+	// Incompressible blobs are stored until the quota is reached.
+	stored, storedBuf := testdigest.NewRandomDigestBuf(t, 6000)
+	require.NoError(t, dc.Set(ctx, stored, storedBuf))
+	d, buf = testdigest.NewRandomDigestBuf(t, 6000)
+	wc, err := dc.Writer(ctx, d)
+	require.NoError(t, err)
+	_, err = wc.Write(buf)
+	require.NoError(t, err)
+	err = wc.Close()
+	require.True(t, status.IsResourceExhaustedError(err), "expected ResourceExhausted, got %v", err)
+	exists, err := dc.Contains(ctx, d)
+	require.NoError(t, err)
+	require.False(t, exists)
+	err = dc.Set(ctx, d, buf)
+	require.True(t, status.IsResourceExhaustedError(err), "expected ResourceExhausted, got %v", err)
+
+	// Writing a blob that is already stored does not use more storage.
+	require.NoError(t, dc.Set(ctx, stored, storedBuf))
+
+	// Deleting blobs frees up quota.
+	require.NoError(t, dc.Delete(ctx, stored))
+	require.NoError(t, dc.Set(ctx, d, buf))
+}
+
 func TestLRU(t *testing.T) {
 	maxSizeBytes := int64(1000) // 1000 bytes
 	rootDir := testfs.MakeTempDir(t)
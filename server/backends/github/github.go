@@ -23,6 +23,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/util/role"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 
+	alpb "github.com/buildbuddy-io/buildbuddy/proto/auditlog"
 	httpfilters "github.com/buildbuddy-io/buildbuddy/server/http/filters"
 	burl "github.com/buildbuddy-io/buildbuddy/server/util/url"
 )
@@ -202,7 +203,7 @@ func (c *GithubClient) Link(w http.ResponseWriter, r *http.Request) {
 	// Restore group ID from cookie.
 	groupID := getCookie(r, groupIDCookieName)
 	if groupID != "" {
-		if err := authutil.AuthorizeGroupRole(u, groupID, role.Admin); err != nil {
+		if err := authutil.AuthorizeGroupCapability(u, groupID, role.ManageGroup); err != nil {
 			redirectWithError(w, r, status.WrapError(err, "Failed to link GitHub account"))
 			return
 		}
@@ -213,6 +214,9 @@ func (c *GithubClient) Link(w http.ResponseWriter, r *http.Request) {
 			redirectWithError(w, r, status.PermissionDeniedErrorf("Error linking github account to group: %v", err))
 			return
 		}
+		if al := c.env.GetAuditLogger(); al != nil {
+			al.LogForGroup(r.Context(), groupID, alpb.Action_LINK, &alpb.ResourceID{Type: alpb.ResourceType_GITHUB_ACCOUNT}, nil, nil)
+		}
 	}
 
 	// Restore user ID from cookie.
@@ -168,7 +168,7 @@ func (d *InvocationDB) LookupInvocation(ctx context.Context, invocationID string
 		if err != nil {
 			return nil, err
 		}
-		if err := perms.AuthorizeRead(&u, getACL(ti)); err != nil {
+		if err := perms.AuthorizeInvocationRead(&u, getACL(ti)); err != nil {
 			return nil, err
 		}
 	}
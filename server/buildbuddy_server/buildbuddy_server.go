@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/buildbuddy-io/buildbuddy/proto/resource"
 	"github.com/buildbuddy-io/buildbuddy/server/build_event_protocol/build_event_handler"
@@ -23,6 +24,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/role_filter"
 	"github.com/buildbuddy-io/buildbuddy/server/tables"
 	"github.com/buildbuddy-io/buildbuddy/server/target"
+	"github.com/buildbuddy-io/buildbuddy/server/util/authutil"
 	"github.com/buildbuddy-io/buildbuddy/server/util/capabilities"
 	"github.com/buildbuddy-io/buildbuddy/server/util/flagutil"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
@@ -33,6 +35,7 @@ import (
 
 	remote_execution_config "github.com/buildbuddy-io/buildbuddy/enterprise/server/remote_execution/config"
 	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
+	alpb "github.com/buildbuddy-io/buildbuddy/proto/auditlog"
 	bzpb "github.com/buildbuddy-io/buildbuddy/proto/bazel_config"
 	capb "github.com/buildbuddy-io/buildbuddy/proto/cache"
 	elpb "github.com/buildbuddy-io/buildbuddy/proto/eventlog"
@@ -43,6 +46,7 @@ import (
 	qpb "github.com/buildbuddy-io/buildbuddy/proto/quota"
 	rnpb "github.com/buildbuddy-io/buildbuddy/proto/runner"
 	scpb "github.com/buildbuddy-io/buildbuddy/proto/scheduler"
+	skpb "github.com/buildbuddy-io/buildbuddy/proto/secrets"
 	trpb "github.com/buildbuddy-io/buildbuddy/proto/target"
 	usagepb "github.com/buildbuddy-io/buildbuddy/proto/usage"
 	uspb "github.com/buildbuddy-io/buildbuddy/proto/user"
@@ -51,6 +55,7 @@ import (
 	requestcontext "github.com/buildbuddy-io/buildbuddy/server/util/request_context"
 	gcodes "google.golang.org/grpc/codes"
 	gstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 )
 
 var (
@@ -60,6 +65,10 @@ var (
 const (
 	bytestreamProtocolPrefix  = "bytestream://"
 	actioncacheProtocolPrefix = "actioncache://"
+
+	// defaultAPIKeyRotationGracePeriod is how long a rotated API key keeps
+	// working if the rotate request doesn't specify a grace period.
+	defaultAPIKeyRotationGracePeriod = 24 * time.Hour
 )
 
 type BuildBuddyServer struct {
@@ -138,9 +147,14 @@ func (s *BuildBuddyServer) DeleteInvocation(ctx context.Context, req *inpb.Delet
 	}
 
 	db := s.env.GetInvocationDB()
+	in, err := db.LookupInvocation(ctx, req.GetInvocationId())
+	if err != nil {
+		return nil, err
+	}
 	if err := db.DeleteInvocationWithPermsCheck(ctx, &authenticatedUser, req.GetInvocationId()); err != nil {
 		return nil, err
 	}
+	s.auditLog(ctx, in.GroupID, alpb.Action_DELETE, &alpb.ResourceID{Type: alpb.ResourceType_INVOCATION, Id: req.GetInvocationId()}, nil, nil)
 
 	return &inpb.DeleteInvocationResponse{}, nil
 }
@@ -184,11 +198,17 @@ func makeGroups(groupRoles []*tables.GroupRole) []*grpb.Group {
 			SharingEnabled:         g.SharingEnabled,
 			UseGroupOwnedExecutors: g.UseGroupOwnedExecutors != nil && *g.UseGroupOwnedExecutors,
 			SuggestionPreference:   g.SuggestionPreference,
+			Capability:             role.CapabilitiesToProto(role.Capabilities(role.Role(gr.Role), role.Capability(gr.CustomCapabilities))),
 		})
 	}
 	return r
 }
 
+// groupAuditState returns the group's settings as recorded in the audit log.
+func groupAuditState(g *tables.Group) *grpb.Group {
+	return makeGroups([]*tables.GroupRole{{Group: *g}})[0]
+}
+
 func (s *BuildBuddyServer) GetUser(ctx context.Context, req *uspb.GetUserRequest) (*uspb.GetUserResponse, error) {
 	userDB := s.env.GetUserDB()
 	if userDB == nil {
@@ -218,18 +238,13 @@ func (s *BuildBuddyServer) getUser(ctx context.Context, req *uspb.GetUserRequest
 	}
 
 	selectedGroupID := ""
-	selectedGroupRole := role.None
+	selectedGroupCapabilities := role.Capability(0)
 	if g := selectedGroup(req.GetRequestContext().GetGroupId(), tu.Groups); g != nil {
 		selectedGroupID = g.Group.GroupID
-		selectedGroupRole = role.Role(g.Role)
-	}
-	allowedRPCs := role_filter.RoleIndependentRPCs
-	if selectedGroupRole&role.Admin > 0 {
-		allowedRPCs = append(allowedRPCs, role_filter.GroupAdminOnlyRPCs...)
-	}
-	if selectedGroupRole&(role.Admin|role.Developer) > 0 {
-		allowedRPCs = append(allowedRPCs, role_filter.GroupDeveloperRPCs...)
+		selectedGroupCapabilities = role.Capabilities(role.Role(g.Role), role.Capability(g.CustomCapabilities))
 	}
+	allowedRPCs := append([]string{}, role_filter.RoleIndependentRPCs...)
+	allowedRPCs = append(allowedRPCs, role_filter.GroupRPCsAllowedBy(selectedGroupCapabilities)...)
 	if serverAdminGID := s.env.GetAuthenticator().AdminGroupID(); serverAdminGID != "" {
 		for _, gr := range tu.Groups {
 			if gr.Group.GroupID == serverAdminGID && gr.Role == uint32(role.Admin) {
@@ -289,7 +304,7 @@ func (s *BuildBuddyServer) GetGroup(ctx context.Context, req *grpb.GetGroupReque
 }
 
 func (s *BuildBuddyServer) GetGroupUsers(ctx context.Context, req *grpb.GetGroupUsersRequest) (*grpb.GetGroupUsersResponse, error) {
-	if err := perms.AuthorizeGroupAccess(ctx, s.env, req.GetGroupId()); err != nil {
+	if err := s.authorizeGroupCapability(ctx, req.GetGroupId(), role.ManageGroup); err != nil {
 		return nil, err
 	}
 	userDB := s.env.GetUserDB()
@@ -306,19 +321,184 @@ func (s *BuildBuddyServer) GetGroupUsers(ctx context.Context, req *grpb.GetGroup
 }
 
 func (s *BuildBuddyServer) UpdateGroupUsers(ctx context.Context, req *grpb.UpdateGroupUsersRequest) (*grpb.UpdateGroupUsersResponse, error) {
-	if err := perms.AuthorizeGroupAccess(ctx, s.env, req.GetGroupId()); err != nil {
+	if err := s.authorizeGroupCapability(ctx, req.GetGroupId(), role.ManageGroup); err != nil {
 		return nil, err
 	}
 	userDB := s.env.GetUserDB()
 	if userDB == nil {
 		return nil, status.UnimplementedError("Not Implemented")
 	}
+	for _, update := range req.GetUpdate() {
+		if update.GetRole() == grpb.Group_UNKNOWN_ROLE {
+			continue
+		}
+		caps := role.Capabilities(role.FromProto(update.GetRole()), 0)
+		if update.GetRole() == grpb.Group_CUSTOM_ROLE {
+			r, err := userDB.GetCustomRole(ctx, update.GetCustomRoleId())
+			if err != nil {
+				return nil, err
+			}
+			if r.GroupID != req.GetGroupId() {
+				return nil, status.NotFoundError("The requested role was not found.")
+			}
+			caps = role.Capabilities(role.Custom, role.Capability(r.Capabilities))
+		}
+		if err := s.authorizeCapabilityGrant(ctx, req.GetGroupId(), caps); err != nil {
+			return nil, err
+		}
+	}
 	if err := userDB.UpdateGroupUsers(ctx, req.GetGroupId(), req.GetUpdate()); err != nil {
 		return nil, err
 	}
 	return &grpb.UpdateGroupUsersResponse{}, nil
 }
 
+// authorizeGroupCapability checks that the authenticated user has been granted
+// the given capabilities within the given group, which need not be the group
+// selected in the UI.
+func (s *BuildBuddyServer) authorizeGroupCapability(ctx context.Context, groupID string, c role.Capability) error {
+	if err := perms.AuthorizeGroupAccess(ctx, s.env, groupID); err != nil {
+		return err
+	}
+	u, err := perms.AuthenticatedUser(ctx, s.env)
+	if err != nil {
+		return err
+	}
+	return authutil.AuthorizeGroupCapability(u, groupID, c)
+}
+
+// authorizeCapabilityGrant checks that the authenticated user has been granted
+// all of the given capabilities within the given group, so that users cannot
+// grant anyone more access than they have themselves.
+func (s *BuildBuddyServer) authorizeCapabilityGrant(ctx context.Context, groupID string, c role.Capability) error {
+	u, err := perms.AuthenticatedUser(ctx, s.env)
+	if err != nil {
+		return err
+	}
+	if c&^authutil.GroupCapabilities(u, groupID) != 0 {
+		return status.PermissionDeniedError("You cannot grant capabilities that you do not have within this organization")
+	}
+	return nil
+}
+
+// auditLog records an administrative action on one of the group's resources,
+// if audit logging is enabled.
+func (s *BuildBuddyServer) auditLog(ctx context.Context, groupID string, action alpb.Action, resource *alpb.ResourceID, before, after proto.Message) {
+	if al := s.env.GetAuditLogger(); al != nil {
+		al.LogForGroup(ctx, groupID, action, resource, before, after)
+	}
+}
+
+func customRoleToProto(r *tables.CustomRole) *grpb.CustomRole {
+	return &grpb.CustomRole{
+		Id:         r.RoleID,
+		Name:       r.Name,
+		Capability: role.CapabilitiesToProto(role.Capability(r.Capabilities)),
+	}
+}
+
+func (s *BuildBuddyServer) GetCustomRoles(ctx context.Context, req *grpb.GetCustomRolesRequest) (*grpb.GetCustomRolesResponse, error) {
+	userDB := s.env.GetUserDB()
+	if userDB == nil {
+		return nil, status.UnimplementedError("Not Implemented")
+	}
+	if err := s.authorizeGroupCapability(ctx, req.GetGroupId(), role.ManageGroup); err != nil {
+		return nil, err
+	}
+	roles, err := userDB.GetCustomRoles(ctx, req.GetGroupId())
+	if err != nil {
+		return nil, err
+	}
+	rsp := &grpb.GetCustomRolesResponse{
+		Role: make([]*grpb.CustomRole, 0, len(roles)),
+	}
+	for _, r := range roles {
+		rsp.Role = append(rsp.Role, customRoleToProto(r))
+	}
+	return rsp, nil
+}
+
+func (s *BuildBuddyServer) CreateCustomRole(ctx context.Context, req *grpb.CreateCustomRoleRequest) (*grpb.CreateCustomRoleResponse, error) {
+	userDB := s.env.GetUserDB()
+	if userDB == nil {
+		return nil, status.UnimplementedError("Not Implemented")
+	}
+	if err := s.authorizeGroupCapability(ctx, req.GetGroupId(), role.ManageGroup); err != nil {
+		return nil, err
+	}
+	caps := role.CapabilitiesFromProto(req.GetCapability())
+	if err := s.authorizeCapabilityGrant(ctx, req.GetGroupId(), caps); err != nil {
+		return nil, err
+	}
+	r, err := userDB.CreateCustomRole(ctx, req.GetGroupId(), req.GetName(), caps)
+	if err != nil {
+		return nil, err
+	}
+	s.auditLog(ctx, r.GroupID, alpb.Action_CREATE, &alpb.ResourceID{Type: alpb.ResourceType_CUSTOM_ROLE, Id: r.RoleID, Name: r.Name}, nil, customRoleToProto(r))
+	return &grpb.CreateCustomRoleResponse{Role: customRoleToProto(r)}, nil
+}
+
+// authorizeCustomRoleWrite returns the requested role if the user can manage
+// the group that owns it.
+func (s *BuildBuddyServer) authorizeCustomRoleWrite(ctx context.Context, roleID string) (*tables.CustomRole, error) {
+	if roleID == "" {
+		return nil, status.InvalidArgumentError("Role ID is required")
+	}
+	userDB := s.env.GetUserDB()
+	if userDB == nil {
+		return nil, status.UnimplementedError("Not Implemented")
+	}
+	r, err := userDB.GetCustomRole(ctx, roleID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeGroupCapability(ctx, r.GroupID, role.ManageGroup); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (s *BuildBuddyServer) UpdateCustomRole(ctx context.Context, req *grpb.UpdateCustomRoleRequest) (*grpb.UpdateCustomRoleResponse, error) {
+	userDB := s.env.GetUserDB()
+	if userDB == nil {
+		return nil, status.UnimplementedError("Not Implemented")
+	}
+	existing, err := s.authorizeCustomRoleWrite(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	caps := role.CapabilitiesFromProto(req.GetCapability())
+	if err := s.authorizeCapabilityGrant(ctx, existing.GroupID, caps); err != nil {
+		return nil, err
+	}
+	r := &tables.CustomRole{
+		RoleID:       req.GetId(),
+		Name:         req.GetName(),
+		Capabilities: uint32(caps),
+	}
+	if err := userDB.UpdateCustomRole(ctx, r); err != nil {
+		return nil, err
+	}
+	s.auditLog(ctx, existing.GroupID, alpb.Action_UPDATE, &alpb.ResourceID{Type: alpb.ResourceType_CUSTOM_ROLE, Id: r.RoleID, Name: r.Name}, customRoleToProto(existing), customRoleToProto(r))
+	return &grpb.UpdateCustomRoleResponse{}, nil
+}
+
+func (s *BuildBuddyServer) DeleteCustomRole(ctx context.Context, req *grpb.DeleteCustomRoleRequest) (*grpb.DeleteCustomRoleResponse, error) {
+	userDB := s.env.GetUserDB()
+	if userDB == nil {
+		return nil, status.UnimplementedError("Not Implemented")
+	}
+	existing, err := s.authorizeCustomRoleWrite(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err := userDB.DeleteCustomRole(ctx, req.GetId()); err != nil {
+		return nil, err
+	}
+	s.auditLog(ctx, existing.GroupID, alpb.Action_DELETE, &alpb.ResourceID{Type: alpb.ResourceType_CUSTOM_ROLE, Id: existing.RoleID, Name: existing.Name}, customRoleToProto(existing), nil)
+	return &grpb.DeleteCustomRoleResponse{}, nil
+}
+
 func (s *BuildBuddyServer) CreateGroup(ctx context.Context, req *grpb.CreateGroupRequest) (*grpb.CreateGroupResponse, error) {
 	userDB := s.env.GetUserDB()
 	if userDB == nil {
@@ -402,6 +582,7 @@ func (s *BuildBuddyServer) UpdateGroup(ctx context.Context, req *grpb.UpdateGrou
 			return nil, err
 		}
 	}
+	before := groupAuditState(group)
 	group.Name = req.GetName()
 	if urlIdentifier != "" {
 		group.URLIdentifier = &urlIdentifier
@@ -425,6 +606,7 @@ func (s *BuildBuddyServer) UpdateGroup(ctx context.Context, req *grpb.UpdateGrou
 	if _, err := userDB.InsertOrUpdateGroup(ctx, group); err != nil {
 		return nil, err
 	}
+	s.auditLog(ctx, group.GroupID, alpb.Action_UPDATE, &alpb.ResourceID{Type: alpb.ResourceType_GROUP, Id: group.GroupID, Name: group.Name}, before, groupAuditState(group))
 	return &grpb.UpdateGroupResponse{}, nil
 }
 
@@ -471,21 +653,35 @@ func (s *BuildBuddyServer) GetApiKeys(ctx context.Context, req *akpb.GetApiKeysR
 	if err != nil {
 		return nil, err
 	}
+	// The values of keys that can administer the group's users are only
+	// visible to users who could have granted those keys.
+	canManageGroup := s.authorizeGroupCapability(ctx, groupID, role.ManageGroup) == nil
 	rsp := &akpb.GetApiKeysResponse{
 		ApiKey: make([]*akpb.ApiKey, 0, len(tableKeys)),
 	}
 	for _, k := range tableKeys {
-		rsp.ApiKey = append(rsp.ApiKey, &akpb.ApiKey{
-			Id:                  k.APIKeyID,
-			Value:               k.Value,
-			Label:               k.Label,
-			Capability:          capabilities.FromInt(k.Capabilities),
-			VisibleToDevelopers: k.VisibleToDevelopers,
-		})
+		key := apiKeyToProto(k)
+		if !canManageGroup && k.Capabilities&int32(akpb.ApiKey_ORG_ADMIN_CAPABILITY) != 0 {
+			key.Value = ""
+		}
+		rsp.ApiKey = append(rsp.ApiKey, key)
 	}
 	return rsp, nil
 }
 
+func apiKeyToProto(k *tables.APIKey) *akpb.ApiKey {
+	return &akpb.ApiKey{
+		Id:                  k.APIKeyID,
+		Value:               k.Value,
+		Label:               k.Label,
+		Capability:          capabilities.FromInt(k.Capabilities),
+		VisibleToDevelopers: k.VisibleToDevelopers,
+		ExpiryUsec:          k.ExpiryUsec,
+		LastUsedUsec:        k.LastUsedUsec,
+		LastUsedIp:          k.LastUsedIP,
+	}
+}
+
 func (s *BuildBuddyServer) CreateApiKey(ctx context.Context, req *akpb.CreateApiKeyRequest) (*akpb.CreateApiKeyResponse, error) {
 	userDB := s.env.GetUserDB()
 	if userDB == nil {
@@ -495,19 +691,15 @@ func (s *BuildBuddyServer) CreateApiKey(ctx context.Context, req *akpb.CreateApi
 	if err := perms.AuthorizeGroupAccess(ctx, s.env, groupID); err != nil {
 		return nil, err
 	}
-	k, err := userDB.CreateAPIKey(ctx, groupID, req.GetLabel(), req.GetCapability(), req.GetVisibleToDevelopers())
+	if err := s.authorizeAPIKeyCapabilities(ctx, groupID, req.GetCapability()); err != nil {
+		return nil, err
+	}
+	k, err := userDB.CreateAPIKey(ctx, groupID, req.GetLabel(), req.GetCapability(), req.GetVisibleToDevelopers(), req.GetExpiryUsec())
 	if err != nil {
 		return nil, err
 	}
-	return &akpb.CreateApiKeyResponse{
-		ApiKey: &akpb.ApiKey{
-			Id:                  k.APIKeyID,
-			Value:               k.Value,
-			Label:               k.Label,
-			Capability:          capabilities.FromInt(k.Capabilities),
-			VisibleToDevelopers: k.VisibleToDevelopers,
-		},
-	}, nil
+	s.auditLog(ctx, groupID, alpb.Action_CREATE, apiKeyAuditResource(k), nil, apiKeyAuditState(k))
+	return &akpb.CreateApiKeyResponse{ApiKey: apiKeyToProto(k)}, nil
 }
 
 func (s *BuildBuddyServer) authorizeInvocationWrite(ctx context.Context, invocationID string) error {
@@ -529,25 +721,57 @@ func (s *BuildBuddyServer) authorizeInvocationWrite(ctx context.Context, invocat
 	return perms.AuthorizeWrite(&authenticatedUser, acl)
 }
 
-func (s *BuildBuddyServer) authorizeAPIKeyWrite(ctx context.Context, apiKeyID string) error {
+// authorizeAPIKeyWrite returns the requested API key if the user belongs to
+// the group that owns it.
+func (s *BuildBuddyServer) authorizeAPIKeyWrite(ctx context.Context, apiKeyID string) (*tables.APIKey, error) {
 	if apiKeyID == "" {
-		return status.InvalidArgumentError("API key ID is required")
+		return nil, status.InvalidArgumentError("API key ID is required")
 	}
 	user, err := perms.AuthenticatedUser(ctx, s.env)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	userDB := s.env.GetUserDB()
 	if userDB == nil {
-		return status.UnimplementedError("Not Implemented")
+		return nil, status.UnimplementedError("Not Implemented")
 	}
 	// Check that the user belongs to the group that owns the requested API key.
 	key, err := userDB.GetAPIKey(ctx, apiKeyID)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	acl := perms.ToACLProto( /* userID= */ nil, key.GroupID, key.Perms)
-	return perms.AuthorizeWrite(&user, acl)
+	if err := perms.AuthorizeWrite(&user, acl); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// authorizeAPIKeyCapabilities checks that the authenticated user is allowed to
+// grant the given capabilities to one of the group's API keys. Keys that can
+// administer the group's users may only be granted by users who can manage the
+// group.
+func (s *BuildBuddyServer) authorizeAPIKeyCapabilities(ctx context.Context, groupID string, caps []akpb.ApiKey_Capability) error {
+	if capabilities.ToInt(caps)&int32(akpb.ApiKey_ORG_ADMIN_CAPABILITY) == 0 {
+		return nil
+	}
+	return s.authorizeGroupCapability(ctx, groupID, role.ManageGroup)
+}
+
+func apiKeyAuditResource(k *tables.APIKey) *alpb.ResourceID {
+	return &alpb.ResourceID{Type: alpb.ResourceType_GROUP_API_KEY, Id: k.APIKeyID, Name: k.Label}
+}
+
+// apiKeyAuditState returns the API key as recorded in the audit log. The key
+// value is omitted.
+func apiKeyAuditState(k *tables.APIKey) *akpb.ApiKey {
+	return &akpb.ApiKey{
+		Id:                  k.APIKeyID,
+		Label:               k.Label,
+		Capability:          capabilities.FromInt(k.Capabilities),
+		VisibleToDevelopers: k.VisibleToDevelopers,
+		ExpiryUsec:          k.ExpiryUsec,
+	}
 }
 
 func (s *BuildBuddyServer) UpdateApiKey(ctx context.Context, req *akpb.UpdateApiKeyRequest) (*akpb.UpdateApiKeyResponse, error) {
@@ -555,7 +779,11 @@ func (s *BuildBuddyServer) UpdateApiKey(ctx context.Context, req *akpb.UpdateApi
 	if userDB == nil {
 		return nil, status.UnimplementedError("Not Implemented")
 	}
-	if err := s.authorizeAPIKeyWrite(ctx, req.GetId()); err != nil {
+	existing, err := s.authorizeAPIKeyWrite(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeAPIKeyCapabilities(ctx, existing.GroupID, req.GetCapability()); err != nil {
 		return nil, err
 	}
 	tk := &tables.APIKey{
@@ -563,10 +791,12 @@ func (s *BuildBuddyServer) UpdateApiKey(ctx context.Context, req *akpb.UpdateApi
 		Label:               req.GetLabel(),
 		Capabilities:        capabilities.ToInt(req.GetCapability()),
 		VisibleToDevelopers: req.GetVisibleToDevelopers(),
+		ExpiryUsec:          req.GetExpiryUsec(),
 	}
 	if err := userDB.UpdateAPIKey(ctx, tk); err != nil {
 		return nil, err
 	}
+	s.auditLog(ctx, existing.GroupID, alpb.Action_UPDATE, apiKeyAuditResource(tk), apiKeyAuditState(existing), apiKeyAuditState(tk))
 	return &akpb.UpdateApiKeyResponse{}, nil
 }
 
@@ -575,15 +805,50 @@ func (s *BuildBuddyServer) DeleteApiKey(ctx context.Context, req *akpb.DeleteApi
 	if userDB == nil {
 		return nil, status.UnimplementedError("Not Implemented")
 	}
-	if err := s.authorizeAPIKeyWrite(ctx, req.GetId()); err != nil {
+	existing, err := s.authorizeAPIKeyWrite(ctx, req.GetId())
+	if err != nil {
 		return nil, err
 	}
 	if err := userDB.DeleteAPIKey(ctx, req.GetId()); err != nil {
 		return nil, err
 	}
+	s.auditLog(ctx, existing.GroupID, alpb.Action_DELETE, apiKeyAuditResource(existing), apiKeyAuditState(existing), nil)
 	return &akpb.DeleteApiKeyResponse{}, nil
 }
 
+func (s *BuildBuddyServer) RotateApiKey(ctx context.Context, req *akpb.RotateApiKeyRequest) (*akpb.RotateApiKeyResponse, error) {
+	userDB := s.env.GetUserDB()
+	if userDB == nil {
+		return nil, status.UnimplementedError("Not Implemented")
+	}
+	existing, err := s.authorizeAPIKeyWrite(ctx, req.GetId())
+	if err != nil {
+		return nil, err
+	}
+	// The new key is granted the same capabilities as the existing key.
+	if err := s.authorizeAPIKeyCapabilities(ctx, existing.GroupID, capabilities.FromInt(existing.Capabilities)); err != nil {
+		return nil, err
+	}
+	if req.GetGracePeriodUsec() < 0 {
+		return nil, status.InvalidArgumentError("Grace period cannot be negative.")
+	}
+	gracePeriod := defaultAPIKeyRotationGracePeriod
+	if req.GetGracePeriodUsec() != 0 {
+		gracePeriod = time.Duration(req.GetGracePeriodUsec()) * time.Microsecond
+	}
+	k, err := userDB.RotateAPIKey(ctx, req.GetId(), gracePeriod, req.GetExpiryUsec())
+	if err != nil {
+		return nil, err
+	}
+	s.auditLog(ctx, existing.GroupID, alpb.Action_CREATE, apiKeyAuditResource(k), nil, apiKeyAuditState(k))
+	if rotated, err := userDB.GetAPIKey(ctx, req.GetId()); err == nil {
+		s.auditLog(ctx, existing.GroupID, alpb.Action_UPDATE, apiKeyAuditResource(rotated), apiKeyAuditState(existing), apiKeyAuditState(rotated))
+	} else {
+		log.Warningf("Could not look up rotated API key %q for audit log: %s", req.GetId(), err)
+	}
+	return &akpb.RotateApiKeyResponse{ApiKey: apiKeyToProto(k)}, nil
+}
+
 func selectedGroup(preferredGroupID string, groupRoles []*tables.GroupRole) *tables.GroupRole {
 	if preferredGroupID != "" {
 		for _, gr := range groupRoles {
@@ -871,6 +1136,7 @@ func (s *BuildBuddyServer) UnlinkGitHubAccount(ctx context.Context, req *ghpb.Un
 	if err := udb.DeleteGroupGitHubToken(ctx, u.GetGroupID()); err != nil {
 		return nil, err
 	}
+	s.auditLog(ctx, u.GetGroupID(), alpb.Action_UNLINK, &alpb.ResourceID{Type: alpb.ResourceType_GITHUB_ACCOUNT}, nil, nil)
 	return res, nil
 }
 
@@ -888,6 +1154,42 @@ func (s *BuildBuddyServer) GetUsage(ctx context.Context, req *usagepb.GetUsageRe
 	return nil, status.UnimplementedError("Not implemented")
 }
 
+func (s *BuildBuddyServer) ListSecrets(ctx context.Context, req *skpb.ListSecretsRequest) (*skpb.ListSecretsResponse, error) {
+	if ss := s.env.GetSecretService(); ss != nil {
+		return ss.ListSecrets(ctx, req)
+	}
+	return nil, status.UnimplementedError("Not implemented")
+}
+
+func (s *BuildBuddyServer) CreateSecret(ctx context.Context, req *skpb.CreateSecretRequest) (*skpb.CreateSecretResponse, error) {
+	if ss := s.env.GetSecretService(); ss != nil {
+		return ss.CreateSecret(ctx, req)
+	}
+	return nil, status.UnimplementedError("Not implemented")
+}
+
+func (s *BuildBuddyServer) DeleteSecret(ctx context.Context, req *skpb.DeleteSecretRequest) (*skpb.DeleteSecretResponse, error) {
+	if ss := s.env.GetSecretService(); ss != nil {
+		return ss.DeleteSecret(ctx, req)
+	}
+	return nil, status.UnimplementedError("Not implemented")
+}
+
+func (s *BuildBuddyServer) GetAuditLog(ctx context.Context, req *alpb.GetAuditLogRequest) (*alpb.GetAuditLogResponse, error) {
+	al := s.env.GetAuditLogger()
+	if al == nil {
+		return nil, status.UnimplementedError("Audit logs are not enabled")
+	}
+	groupID, err := perms.AuthenticateSelectedGroupID(ctx, s.env, req.GetRequestContext())
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorizeGroupCapability(ctx, groupID, role.ViewAuditLog); err != nil {
+		return nil, err
+	}
+	return al.GetLogs(ctx, groupID, req)
+}
+
 func (s *BuildBuddyServer) GetCacheMetadata(ctx context.Context, req *capb.GetCacheMetadataRequest) (*capb.GetCacheMetadataResponse, error) {
 	ctx, err := prefix.AttachUserPrefixToContext(ctx, s.env)
 	if err != nil {
@@ -4,19 +4,26 @@ import (
 	"context"
 	"testing"
 
+	"github.com/buildbuddy-io/buildbuddy/enterprise/server/backends/userdb"
 	"github.com/buildbuddy-io/buildbuddy/server/build_event_protocol/build_event_handler"
 	"github.com/buildbuddy-io/buildbuddy/server/buildbuddy_server"
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
 	"github.com/buildbuddy-io/buildbuddy/server/tables"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testauth"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/require"
 	"google.golang.org/protobuf/types/known/anypb"
 
 	"github.com/buildbuddy-io/buildbuddy/proto/acl"
+	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
 	"github.com/buildbuddy-io/buildbuddy/proto/build_event_stream"
 	bepb "github.com/buildbuddy-io/buildbuddy/proto/build_events"
+	elpb "github.com/buildbuddy-io/buildbuddy/proto/eventlog"
+	grpb "github.com/buildbuddy-io/buildbuddy/proto/group"
 	inpb "github.com/buildbuddy-io/buildbuddy/proto/invocation"
 	pepb "github.com/buildbuddy-io/buildbuddy/proto/publish_build_event"
 	"github.com/buildbuddy-io/buildbuddy/proto/user_id"
@@ -200,3 +207,142 @@ func TestDeleteInvocation(t *testing.T) {
 	)
 	require.Error(t, err)
 }
+
+func customRoleUser(userID, groupID string, caps role.Capability) *testauth.TestUser {
+	return &testauth.TestUser{
+		UserID:        userID,
+		GroupID:       groupID,
+		AllowedGroups: []string{groupID},
+		GroupMemberships: []*interfaces.GroupMembership{
+			{GroupID: groupID, Role: role.Custom, CustomCapabilities: caps},
+		},
+	}
+}
+
+func TestGetInvocation_RequiresViewInvocations(t *testing.T) {
+	te := testenv.GetTestEnv(t)
+	auth := testauth.NewTestAuthenticator(testauth.TestUsers(user1, group1))
+	te.SetAuthenticator(auth)
+
+	iid, err := createInvocationForTesting(te, user1)
+	require.NoError(t, err)
+
+	server, err := buildbuddy_server.NewBuildBuddyServer(te, nil)
+	require.NoError(t, err)
+
+	req := &inpb.GetInvocationRequest{
+		RequestContext: testauth.RequestContext("USER3", group1),
+		Lookup:         &inpb.InvocationLookup{InvocationId: iid},
+	}
+	ctx := testauth.WithAuthenticatedUserInfo(context.Background(), customRoleUser("USER3", group1, role.ViewUsage))
+	_, err = server.GetInvocation(ctx, req)
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %s", err)
+	_, err = server.GetEventLogChunk(ctx, &elpb.GetEventLogChunkRequest{InvocationId: iid})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %s", err)
+
+	ctx = testauth.WithAuthenticatedUserInfo(context.Background(), customRoleUser("USER3", group1, role.ViewInvocations))
+	rsp, err := server.GetInvocation(ctx, req)
+	require.NoError(t, err)
+	require.Equal(t, iid, rsp.Invocation[0].InvocationId)
+}
+
+func TestUpdateGroupUsers_CannotGrantExtraCapabilities(t *testing.T) {
+	te := testenv.GetTestEnv(t)
+	auth := testauth.NewTestAuthenticator(testauth.TestUsers(user1, group1))
+	te.SetAuthenticator(auth)
+	udb, err := userdb.NewUserDB(te, te.GetDBHandle())
+	require.NoError(t, err)
+	te.SetUserDB(udb)
+
+	server, err := buildbuddy_server.NewBuildBuddyServer(te, nil)
+	require.NoError(t, err)
+
+	managerCaps := role.ManageGroup | role.ViewInvocations
+	ctx := testauth.WithAuthenticatedUserInfo(context.Background(), customRoleUser("USER3", group1, managerCaps))
+
+	_, err = server.UpdateGroupUsers(ctx, &grpb.UpdateGroupUsersRequest{
+		GroupId: group1,
+		Update: []*grpb.UpdateGroupUsersRequest_Update{{
+			UserId: &user_id.UserId{Id: "USER3"},
+			Role:   grpb.Group_ADMIN_ROLE,
+		}},
+	})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %s", err)
+
+	_, err = server.CreateCustomRole(ctx, &grpb.CreateCustomRoleRequest{
+		GroupId:    group1,
+		Name:       "Secrets",
+		Capability: []grpb.Capability{grpb.Capability_MANAGE_SECRETS},
+	})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %s", err)
+
+	crsp, err := server.CreateCustomRole(ctx, &grpb.CreateCustomRoleRequest{
+		GroupId:    group1,
+		Name:       "Viewer",
+		Capability: []grpb.Capability{grpb.Capability_VIEW_INVOCATIONS},
+	})
+	require.NoError(t, err)
+	_, err = server.UpdateCustomRole(ctx, &grpb.UpdateCustomRoleRequest{
+		Id:         crsp.GetRole().GetId(),
+		Name:       "Viewer",
+		Capability: []grpb.Capability{grpb.Capability_VIEW_INVOCATIONS, grpb.Capability_MANAGE_API_KEYS},
+	})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %s", err)
+
+	// Roles granted by the group admin can't be assigned by the manager if they
+	// exceed the manager's own capabilities.
+	adminCtx := te.GetAuthenticator().AuthContextFromAPIKey(context.Background(), user1)
+	arsp, err := server.CreateCustomRole(adminCtx, &grpb.CreateCustomRoleRequest{
+		GroupId:    group1,
+		Name:       "Key manager",
+		Capability: []grpb.Capability{grpb.Capability_MANAGE_API_KEYS},
+	})
+	require.NoError(t, err)
+	_, err = server.UpdateGroupUsers(ctx, &grpb.UpdateGroupUsersRequest{
+		GroupId: group1,
+		Update: []*grpb.UpdateGroupUsersRequest_Update{{
+			UserId:       &user_id.UserId{Id: "USER3"},
+			Role:         grpb.Group_CUSTOM_ROLE,
+			CustomRoleId: arsp.GetRole().GetId(),
+		}},
+	})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %s", err)
+}
+
+func TestOrgAdminAPIKeys_RequireManageGroup(t *testing.T) {
+	te := testenv.GetTestEnv(t)
+	auth := testauth.NewTestAuthenticator(testauth.TestUsers(user1, group1))
+	te.SetAuthenticator(auth)
+	udb, err := userdb.NewUserDB(te, te.GetDBHandle())
+	require.NoError(t, err)
+	te.SetUserDB(udb)
+
+	server, err := buildbuddy_server.NewBuildBuddyServer(te, nil)
+	require.NoError(t, err)
+
+	adminCtx := te.GetAuthenticator().AuthContextFromAPIKey(context.Background(), user1)
+	crsp, err := server.CreateApiKey(adminCtx, &akpb.CreateApiKeyRequest{
+		GroupId:    group1,
+		Label:      "scim",
+		Capability: []akpb.ApiKey_Capability{akpb.ApiKey_ORG_ADMIN_CAPABILITY},
+	})
+	require.NoError(t, err)
+	orgAdminKey := crsp.GetApiKey()
+
+	rsp, err := server.GetApiKeys(adminCtx, &akpb.GetApiKeysRequest{GroupId: group1})
+	require.NoError(t, err)
+	require.Len(t, rsp.GetApiKey(), 1)
+	require.Equal(t, orgAdminKey.GetValue(), rsp.GetApiKey()[0].GetValue())
+
+	ctx := testauth.WithAuthenticatedUserInfo(context.Background(), customRoleUser("USER3", group1, role.ViewAPIKeys|role.ManageAPIKeys))
+	rsp, err = server.GetApiKeys(ctx, &akpb.GetApiKeysRequest{GroupId: group1})
+	require.NoError(t, err)
+	require.Len(t, rsp.GetApiKey(), 1)
+	require.Equal(t, orgAdminKey.GetId(), rsp.GetApiKey()[0].GetId())
+	require.Empty(t, rsp.GetApiKey()[0].GetValue())
+
+	_, err = server.RotateApiKey(ctx, &akpb.RotateApiKeyRequest{Id: orgAdminKey.GetId()})
+	require.True(t, status.IsPermissionDeniedError(err), "expected PermissionDenied, got %s", err)
+	_, err = server.RotateApiKey(adminCtx, &akpb.RotateApiKeyRequest{Id: orgAdminKey.GetId()})
+	require.NoError(t, err)
+}
@@ -95,6 +95,10 @@ type Env interface {
 	GetGitProviders() interfaces.GitProviders
 	GetUsageService() interfaces.UsageService
 	SetUsageService(interfaces.UsageService)
+	GetSecretService() interfaces.SecretService
+	SetSecretService(interfaces.SecretService)
+	GetAuditLogger() interfaces.AuditLogger
+	SetAuditLogger(interfaces.AuditLogger)
 	GetUsageTracker() interfaces.UsageTracker
 	SetUsageTracker(interfaces.UsageTracker)
 	GetXcodeLocator() interfaces.XcodeLocator
@@ -12,11 +12,13 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/util/alert"
 	"github.com/buildbuddy-io/buildbuddy/server/util/role"
 	"google.golang.org/grpc/credentials"
+	"google.golang.org/protobuf/proto"
 	"gorm.io/gorm"
 
 	aclpb "github.com/buildbuddy-io/buildbuddy/proto/acl"
 	apipb "github.com/buildbuddy-io/buildbuddy/proto/api/v1"
 	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
+	alpb "github.com/buildbuddy-io/buildbuddy/proto/auditlog"
 	bbspb "github.com/buildbuddy-io/buildbuddy/proto/buildbuddy_service"
 	espb "github.com/buildbuddy-io/buildbuddy/proto/execution_stats"
 	grpb "github.com/buildbuddy-io/buildbuddy/proto/group"
@@ -28,6 +30,7 @@ import (
 	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
 	rnpb "github.com/buildbuddy-io/buildbuddy/proto/runner"
 	scpb "github.com/buildbuddy-io/buildbuddy/proto/scheduler"
+	skpb "github.com/buildbuddy-io/buildbuddy/proto/secrets"
 	telpb "github.com/buildbuddy-io/buildbuddy/proto/telemetry"
 	usagepb "github.com/buildbuddy-io/buildbuddy/proto/usage"
 	wfpb "github.com/buildbuddy-io/buildbuddy/proto/workflow"
@@ -60,6 +63,9 @@ type BasicAuthToken interface {
 type GroupMembership struct {
 	GroupID string    `json:"group_id"`
 	Role    role.Role `json:"role"`
+	// CustomCapabilities are the capabilities of the user's custom role, if
+	// Role is role.Custom.
+	CustomCapabilities role.Capability `json:"custom_capabilities,omitempty"`
 }
 
 type UserInfo interface {
@@ -250,6 +256,14 @@ type Cache interface {
 	Writer(ctx context.Context, d *repb.Digest) (io.WriteCloser, error)
 }
 
+// CompressedCache is implemented by caches that may store blobs compressed at
+// rest.
+type CompressedCache interface {
+	// CompressedReader returns a reader over the blob's bytes exactly as they
+	// are stored, along with the compressor that was used to store them.
+	CompressedReader(ctx context.Context, d *repb.Digest) (io.ReadCloser, repb.Compressor_Value, error)
+}
+
 type TxRunner func(tx *gorm.DB) error
 
 type DBOptions interface {
@@ -301,6 +315,9 @@ type APIKeyGroup interface {
 	GetCapabilities() int32
 	GetGroupID() string
 	GetUseGroupOwnedExecutors() bool
+	// GetExpiryUsec returns the time after which the API key can no longer be
+	// used, in microseconds since the Unix epoch, or zero if it never expires.
+	GetExpiryUsec() int64
 }
 
 type AuthDB interface {
@@ -310,6 +327,7 @@ type AuthDB interface {
 	GetAPIKeyGroupFromAPIKey(ctx context.Context, apiKey string) (APIKeyGroup, error)
 	GetAPIKeyGroupFromBasicAuth(ctx context.Context, login, pass string) (APIKeyGroup, error)
 	LookupUserFromSubID(ctx context.Context, subID string) (*tables.User, error)
+	LookupUserFromUserID(ctx context.Context, userID string) (*tables.User, error)
 }
 
 type UserDB interface {
@@ -344,12 +362,22 @@ type UserDB interface {
 	UpdateGroupUsers(ctx context.Context, groupID string, updates []*grpb.UpdateGroupUsersRequest_Update) error
 	DeleteGroupGitHubToken(ctx context.Context, groupID string) error
 
+	// Custom roles API
+	GetCustomRole(ctx context.Context, roleID string) (*tables.CustomRole, error)
+	GetCustomRoles(ctx context.Context, groupID string) ([]*tables.CustomRole, error)
+	CreateCustomRole(ctx context.Context, groupID string, name string, capabilities role.Capability) (*tables.CustomRole, error)
+	UpdateCustomRole(ctx context.Context, r *tables.CustomRole) error
+	DeleteCustomRole(ctx context.Context, roleID string) error
+
 	// API Keys API
 	GetAPIKey(ctx context.Context, apiKeyID string) (*tables.APIKey, error)
 	GetAPIKeys(ctx context.Context, groupID string, checkVisibility bool) ([]*tables.APIKey, error)
-	CreateAPIKey(ctx context.Context, groupID string, label string, capabilities []akpb.ApiKey_Capability, visibleToDevelopers bool) (*tables.APIKey, error)
+	CreateAPIKey(ctx context.Context, groupID string, label string, capabilities []akpb.ApiKey_Capability, visibleToDevelopers bool, expiryUsec int64) (*tables.APIKey, error)
 	UpdateAPIKey(ctx context.Context, key *tables.APIKey) error
 	DeleteAPIKey(ctx context.Context, apiKeyID string) error
+	// RotateAPIKey creates a replacement for the given API key and expires the
+	// old key after the given grace period.
+	RotateAPIKey(ctx context.Context, apiKeyID string, gracePeriod time.Duration, expiryUsec int64) (*tables.APIKey, error)
 }
 
 // A webhook can be called when a build is completed.
@@ -373,6 +401,40 @@ type UsageService interface {
 	GetUsage(ctx context.Context, req *usagepb.GetUsageRequest) (*usagepb.GetUsageResponse, error)
 }
 
+// SecretService manages encrypted, group-scoped secrets which can be exposed
+// to workflow actions.
+type SecretService interface {
+	ListSecrets(ctx context.Context, req *skpb.ListSecretsRequest) (*skpb.ListSecretsResponse, error)
+	CreateSecret(ctx context.Context, req *skpb.CreateSecretRequest) (*skpb.CreateSecretResponse, error)
+	DeleteSecret(ctx context.Context, req *skpb.DeleteSecretRequest) (*skpb.DeleteSecretResponse, error)
+
+	// GetSecrets returns the decrypted values of the group's secrets with the
+	// given names. It returns a NotFound error if any of the secrets doesn't
+	// exist. Callers are responsible for authorizing access to the group.
+	GetSecrets(ctx context.Context, groupID string, names []string) (map[string]string, error)
+
+	// Encrypt and Decrypt protect sensitive values that are stored outside of
+	// the secrets table with the secrets encryption key. The additional data
+	// is authenticated but not encrypted, and must match when decrypting.
+	Encrypt(plaintext []byte, additionalData string) ([]byte, error)
+	Decrypt(ciphertext []byte, additionalData string) ([]byte, error)
+}
+
+// AuditLogger records administrative actions performed on resources owned by
+// a group, such as API keys and group memberships.
+type AuditLogger interface {
+	// LogForGroup records that the authenticated principal performed the given
+	// action on a resource owned by the given group. before and after describe
+	// the state of the resource around the action and may be nil. Failures are
+	// logged rather than returned so that callers need not handle them.
+	LogForGroup(ctx context.Context, groupID string, action alpb.Action, resource *alpb.ResourceID, before, after proto.Message)
+
+	// GetLogs returns a page of the given group's audit log entries, most
+	// recent first. Callers are responsible for authorizing access to the
+	// group.
+	GetLogs(ctx context.Context, groupID string, req *alpb.GetAuditLogRequest) (*alpb.GetAuditLogResponse, error)
+}
+
 type UsageTracker interface {
 	// Increment adds the given usage counts to the current collection period
 	// for the authenticated group ID. It is safe for concurrent access.
@@ -487,6 +549,13 @@ type WebhookData struct {
 	// request, if applicable.
 	// Ex: "acmedev123"
 	PullRequestApprover string
+
+	// ChangedFiles are the repo-relative paths of the files added, modified or
+	// removed by the event, if the webhook payload lists them. It is nil if
+	// the changed files are unknown, in which case they are computed by the CI
+	// runner if needed.
+	// Ex: ["server/main.go", "docs/README.md"]
+	ChangedFiles []string
 }
 
 type SplashPrinter interface {
@@ -518,6 +587,8 @@ type SchedulerService interface {
 	EnqueueTaskReservation(ctx context.Context, req *scpb.EnqueueTaskReservationRequest) (*scpb.EnqueueTaskReservationResponse, error)
 	ReEnqueueTask(ctx context.Context, req *scpb.ReEnqueueTaskRequest) (*scpb.ReEnqueueTaskResponse, error)
 	GetExecutionNodes(ctx context.Context, req *scpb.GetExecutionNodesRequest) (*scpb.GetExecutionNodesResponse, error)
+	GetDesiredCapacity(ctx context.Context, req *scpb.GetDesiredCapacityRequest) (*scpb.GetDesiredCapacityResponse, error)
+	DrainExecutor(ctx context.Context, req *scpb.DrainExecutorRequest) (*scpb.DrainExecutorResponse, error)
 	GetGroupIDAndDefaultPoolForUser(ctx context.Context, os string, useSelfHosted bool) (string, string, error)
 }
 
@@ -814,6 +885,10 @@ type LRU interface {
 	// Returns a boolean indicating if the value is present in the LRU.
 	Contains(key interface{}) bool
 
+	// Gets a value from the LRU without updating its recency, returns a
+	// boolean indicating if the value was present.
+	Peek(key interface{}) (interface{}, bool)
+
 	// Removes a value from the LRU, releasing resources associated with
 	// that value. Returns a boolean indicating if the value was sucessfully
 	// removed.
@@ -854,6 +929,9 @@ type QuotaManager interface {
 	// limit inside the namespace.
 	// If the rate limit has not been exceeded, the underlying storage is updated
 	// by the supplied quantity.
+	// In namespaces that limit a total quantity rather than a rate, like
+	// stored bytes, quantity is the total the user would hold and nothing is
+	// recorded.
 	Allow(ctx context.Context, namespace string, quantity int64) (bool, error)
 
 	GetNamespace(ctx context.Context, req *qpb.GetNamespaceRequest) (*qpb.GetNamespaceResponse, error)
@@ -37,6 +37,10 @@ const (
 	/// Cache event type: `hit`, `miss`, or `upload`.
 	CacheEventTypeLabel = "cache_event_type"
 
+	/// Compressor used to transfer a blob: `identity` for uncompressed
+	/// transfers, or `zstd`.
+	CompressorLabel = "compressor"
+
 	/// Process exit code of an executed action.
 	ExitCodeLabel = "exit_code"
 
@@ -350,6 +354,28 @@ var (
 	/// )
 	/// ```
 
+	CacheTransferredSizeBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: bbNamespace,
+		Subsystem: "remote_cache",
+		Name:      "transferred_size_bytes",
+		Help:      "Number of bytes sent over the network for cache uploads and downloads. For compressed transfers, this is the compressed size; compare with `download_size_bytes` and `upload_size_bytes` to measure compression savings.",
+	}, []string{
+		CacheTypeLabel,
+		CacheEventTypeLabel,
+		CompressorLabel,
+	})
+
+	/// #### Examples
+	///
+	/// ```promql
+	/// # Fraction of CAS download bytes saved by compression
+	/// 1 - (
+	///   sum(rate(buildbuddy_remote_cache_transferred_size_bytes{cache_type="cas",cache_event_type="hit"}[5m]))
+	///   /
+	///   sum(rate(buildbuddy_remote_cache_download_size_bytes_sum{cache_type="cas"}[5m]))
+	/// )
+	/// ```
+
 	DiskCacheLastEvictionAgeUsec = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: bbNamespace,
 		Subsystem: "remote_cache",
@@ -403,6 +429,32 @@ var (
 	/// sum(buildbuddy_remote_cache_duplicate_writes_bytes)
 	/// ```
 
+	RaftEvictedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: bbNamespace,
+		Subsystem: "remote_cache",
+		Name:      "raft_evicted_bytes",
+		Help:      "Number of bytes evicted from the raft cache, in **bytes**.",
+	}, []string{
+		PartitionID,
+	})
+
+	/// #### Examples
+	///
+	/// ```promql
+	/// # Eviction rate of the raft cache, by partition
+	/// sum(rate(buildbuddy_remote_cache_raft_evicted_bytes[5m])) by (partition_id)
+	/// ```
+
+	RaftEvictionAgeUsec = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: bbNamespace,
+		Subsystem: "remote_cache",
+		Name:      "raft_eviction_age_usec",
+		Help:      "Time since items evicted from the raft cache were last accessed, in **microseconds**.",
+		Buckets:   durationUsecBuckets(1*time.Minute, 30*day, 2),
+	}, []string{
+		PartitionID,
+	})
+
 	/// ## Remote execution metrics
 
 	RemoteExecutionCount = promauto.NewCounterVec(prometheus.CounterOpts{
@@ -430,6 +482,13 @@ var (
 		Help:      "Number of tasks started remotely, but not necessarily completed. Includes retry attempts of the same task.",
 	})
 
+	RemoteExecutionTasksPreemptedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: bbNamespace,
+		Subsystem: "remote_execution",
+		Name:      "tasks_preempted_count",
+		Help:      "Number of running tasks cancelled and re-enqueued to make room for higher priority tasks.",
+	})
+
 	RemoteExecutionExecutedActionMetadataDurationsUsec = promauto.NewHistogramVec(prometheus.HistogramOpts{
 		Namespace: bbNamespace,
 		Subsystem: "remote_execution",
@@ -549,6 +608,22 @@ var (
 	/// quantile(0.5, buildbuddy_remote_execution_queue_length)
 	/// ```
 
+	RemoteExecutionQueueInvocations = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: bbNamespace,
+		Subsystem: "remote_execution",
+		Name:      "queue_invocations",
+		Help:      "Number of invocations with actions currently waiting in the executor queue. Executor capacity is shared fairly between these invocations within each group.",
+	}, []string{
+		GroupID,
+	})
+
+	/// #### Examples
+	///
+	/// ```promql
+	/// # Groups with the most queued invocations across all executors
+	/// topk(10, sum(buildbuddy_remote_execution_queue_invocations) by (group_id))
+	/// ```
+
 	RemoteExecutionTasksExecuting = promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace: bbNamespace,
 		Subsystem: "remote_execution",
@@ -56,6 +56,8 @@ type RealEnv struct {
 	invocationSearchService          interfaces.InvocationSearchService
 	invocationStatService            interfaces.InvocationStatService
 	usageService                     interfaces.UsageService
+	secretService                    interfaces.SecretService
+	auditLogger                      interfaces.AuditLogger
 	usageTracker                     interfaces.UsageTracker
 	splashPrinter                    interfaces.SplashPrinter
 	actionCacheClient                repb.ActionCacheClient
@@ -181,6 +183,19 @@ func (r *RealEnv) SetUsageService(s interfaces.UsageService) {
 	r.usageService = s
 }
 
+func (r *RealEnv) GetSecretService() interfaces.SecretService {
+	return r.secretService
+}
+func (r *RealEnv) SetSecretService(s interfaces.SecretService) {
+	r.secretService = s
+}
+func (r *RealEnv) GetAuditLogger() interfaces.AuditLogger {
+	return r.auditLogger
+}
+func (r *RealEnv) SetAuditLogger(l interfaces.AuditLogger) {
+	r.auditLogger = l
+}
+
 func (r *RealEnv) GetUsageTracker() interfaces.UsageTracker {
 	return r.usageTracker
 }
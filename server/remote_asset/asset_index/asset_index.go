@@ -0,0 +1,154 @@
+// Package asset_index maintains the mapping from remote assets (URIs plus
+// qualifiers) to content stored in the CAS. Entries are stored in the action
+// cache, keyed by a hash of the asset type, URI and qualifiers, so they are
+// isolated per instance name and per group just like any other cache entry.
+package asset_index
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/digest"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/namespace"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	capb "github.com/buildbuddy-io/buildbuddy/proto/cache"
+	rapb "github.com/buildbuddy-io/buildbuddy/proto/remote_asset"
+	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
+)
+
+type AssetType int
+
+const (
+	BlobAsset AssetType = iota
+	DirectoryAsset
+)
+
+func (t AssetType) String() string {
+	switch t {
+	case BlobAsset:
+		return "blob"
+	case DirectoryAsset:
+		return "directory"
+	default:
+		return "unknown"
+	}
+}
+
+// Match is a cached asset that satisfied a lookup.
+type Match struct {
+	URI      string
+	Digest   *repb.Digest
+	ExpireAt *timestamppb.Timestamp
+}
+
+// Key returns the action cache digest under which the asset with the given
+// type, URI and qualifiers is stored. Qualifier order does not matter.
+func Key(assetType AssetType, uri string, qualifiers []*rapb.Qualifier) (*repb.Digest, error) {
+	sorted := make([]*rapb.Qualifier, len(qualifiers))
+	copy(sorted, qualifiers)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if sorted[i].GetName() != sorted[j].GetName() {
+			return sorted[i].GetName() < sorted[j].GetName()
+		}
+		return sorted[i].GetValue() < sorted[j].GetValue()
+	})
+
+	// Every field is length-prefixed so that distinct (uri, qualifiers) sets
+	// can never serialize to the same key material.
+	buf := &bytes.Buffer{}
+	writeField := func(s string) {
+		fmt.Fprintf(buf, "%d:%s", len(s), s)
+	}
+	writeField("remote_asset")
+	writeField(assetType.String())
+	writeField(uri)
+	for _, q := range sorted {
+		writeField(q.GetName())
+		writeField(q.GetValue())
+	}
+	return digest.Compute(bytes.NewReader(buf.Bytes()))
+}
+
+// Store records that each of the given URIs, together with the given
+// qualifiers, resolves to the given digest. If expireAt is non-nil, the entry
+// will not be returned by Lookup after that time.
+func Store(ctx context.Context, cache interfaces.Cache, instanceName string, assetType AssetType, uris []string, qualifiers []*rapb.Qualifier, d *repb.Digest, expireAt *timestamppb.Timestamp) error {
+	if len(uris) == 0 {
+		return status.InvalidArgumentError("At least one URI is required")
+	}
+	ac, err := namespace.ActionCache(ctx, cache, instanceName)
+	if err != nil {
+		return err
+	}
+	entry := &capb.RemoteAssetEntry{
+		Digest:        d,
+		InsertionTime: timestamppb.Now(),
+		ExpireAt:      expireAt,
+	}
+	buf, err := proto.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	kvs := make(map[*repb.Digest][]byte, len(uris))
+	for _, uri := range uris {
+		if uri == "" {
+			return status.InvalidArgumentError("URIs must not be empty")
+		}
+		k, err := Key(assetType, uri, qualifiers)
+		if err != nil {
+			return err
+		}
+		kvs[k] = buf
+	}
+	return ac.SetMulti(ctx, kvs)
+}
+
+// Lookup returns the first of the given URIs which, together with the given
+// qualifiers, has an unexpired entry that was written no earlier than
+// oldestContentAccepted. Returns a NotFound error if there is no such entry.
+//
+// Callers are responsible for verifying that the returned digest is still
+// present in the CAS.
+func Lookup(ctx context.Context, cache interfaces.Cache, instanceName string, assetType AssetType, uris []string, qualifiers []*rapb.Qualifier, oldestContentAccepted *timestamppb.Timestamp) (*Match, error) {
+	ac, err := namespace.ActionCache(ctx, cache, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, uri := range uris {
+		k, err := Key(assetType, uri, qualifiers)
+		if err != nil {
+			return nil, err
+		}
+		buf, err := ac.Get(ctx, k)
+		if err != nil {
+			if status.IsNotFoundError(err) {
+				continue
+			}
+			return nil, err
+		}
+		entry := &capb.RemoteAssetEntry{}
+		if err := proto.Unmarshal(buf, entry); err != nil {
+			return nil, status.InternalErrorf("Corrupt remote asset entry for %q: %s", uri, err)
+		}
+		if entry.GetExpireAt() != nil && !entry.GetExpireAt().AsTime().After(now) {
+			continue
+		}
+		if oldestContentAccepted != nil && entry.GetInsertionTime().AsTime().Before(oldestContentAccepted.AsTime()) {
+			continue
+		}
+		return &Match{
+			URI:      uri,
+			Digest:   entry.GetDigest(),
+			ExpireAt: entry.GetExpireAt(),
+		}, nil
+	}
+	return nil, status.NotFoundError("No matching remote asset found")
+}
@@ -0,0 +1,287 @@
+package fetch_server
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"flag"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+)
+
+var (
+	maxExtractedArchiveSizeBytes = flag.Int64("remote_asset.max_extracted_archive_size_bytes", 10_000_000_000, "The maximum total size of the files extracted from an archive fetched with FetchDirectory.")
+	maxExtractedArchiveEntries   = flag.Int("remote_asset.max_extracted_archive_entries", 1_000_000, "The maximum number of entries in an archive fetched with FetchDirectory.")
+)
+
+// maxSymlinkTargetLength is the longest symlink target accepted in a zip
+// archive, which stores symlink targets as file contents.
+const maxSymlinkTargetLength = 4096
+
+type archiveType int
+
+const (
+	unknownArchive archiveType = iota
+	tarArchive
+	tarGzArchive
+	zipArchive
+)
+
+// archiveTypeFor determines the format of an archive from the resource_type
+// qualifier, if present, or else from the extension of the URI path.
+func archiveTypeFor(uri, resourceType string) archiveType {
+	switch resourceType {
+	case "application/x-tar":
+		return tarArchive
+	case "application/gzip", "application/x-gzip", "application/x-compressed-tar":
+		return tarGzArchive
+	case "application/zip", "application/x-zip-compressed":
+		return zipArchive
+	}
+	path := uri
+	if u, err := url.Parse(uri); err == nil {
+		path = u.Path
+	}
+	switch {
+	case strings.HasSuffix(path, ".tar"):
+		return tarArchive
+	case strings.HasSuffix(path, ".tar.gz"), strings.HasSuffix(path, ".tgz"):
+		return tarGzArchive
+	case strings.HasSuffix(path, ".zip"), strings.HasSuffix(path, ".jar"), strings.HasSuffix(path, ".war"):
+		return zipArchive
+	}
+	return unknownArchive
+}
+
+// extractArchive extracts the archive at archivePath into destDir, which must
+// already exist.
+func extractArchive(archivePath string, t archiveType, destDir string) error {
+	e := &extractor{
+		root:        destDir,
+		bytesLeft:   *maxExtractedArchiveSizeBytes,
+		entriesLeft: *maxExtractedArchiveEntries,
+	}
+	switch t {
+	case tarArchive, tarGzArchive:
+		f, err := os.Open(archivePath)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		var r io.Reader = f
+		if t == tarGzArchive {
+			gzr, err := gzip.NewReader(f)
+			if err != nil {
+				return status.InvalidArgumentErrorf("invalid gzip archive: %s", err)
+			}
+			defer gzr.Close()
+			r = gzr
+		}
+		return e.extractTar(r)
+	case zipArchive:
+		return e.extractZip(archivePath)
+	default:
+		return status.InvalidArgumentError("unsupported archive type; supported types are tar, tar.gz, and zip")
+	}
+}
+
+// extractor extracts a single archive, keeping track of how much more data
+// the archive may expand to so that archive bombs are rejected early.
+type extractor struct {
+	root        string
+	bytesLeft   int64
+	entriesLeft int
+}
+
+func (e *extractor) addEntry() error {
+	e.entriesLeft--
+	if e.entriesLeft < 0 {
+		return status.ResourceExhaustedErrorf("archive has more than %d entries", *maxExtractedArchiveEntries)
+	}
+	return nil
+}
+
+// safePath returns the location under root that the archive entry with the
+// given name should be extracted to. It rejects entries that would escape
+// root, either directly (via absolute paths or "..") or indirectly by writing
+// to or through a previously extracted symlink.
+func safePath(root, name string) (string, error) {
+	clean := filepath.Clean(filepath.FromSlash(strings.TrimPrefix(name, "./")))
+	if clean == "." {
+		return root, nil
+	}
+	if filepath.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, ".."+string(filepath.Separator)) {
+		return "", status.InvalidArgumentErrorf("archive entry %q is outside of the archive root", name)
+	}
+	p := root
+	for _, part := range strings.Split(clean, string(filepath.Separator)) {
+		p = filepath.Join(p, part)
+		info, err := os.Lstat(p)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", status.InvalidArgumentErrorf("archive entry %q is located at or under a symlink", name)
+		}
+	}
+	return filepath.Join(root, clean), nil
+}
+
+func (e *extractor) writeFile(path string, r io.Reader, mode os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	// Archives may contain the same file more than once, in which case the
+	// last entry wins. safePath has already made sure that path is not a
+	// symlink.
+	if info, err := os.Lstat(path); err == nil {
+		if !info.Mode().IsRegular() {
+			return status.InvalidArgumentErrorf("archive entry %q overwrites a non-regular file", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	// Make sure we can always read the file back when uploading it. O_EXCL
+	// and O_NOFOLLOW guarantee that we never write through a symlink.
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY|syscall.O_NOFOLLOW, mode.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, io.LimitReader(r, e.bytesLeft+1))
+	e.bytesLeft -= n
+	if err == nil && e.bytesLeft < 0 {
+		err = status.ResourceExhaustedErrorf("archive expands to more than %d bytes", *maxExtractedArchiveSizeBytes)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeSymlink(path, target string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.Symlink(target, path)
+}
+
+// writeHardlink stores a copy of the earlier archive entry named linkname at
+// path. The earlier entry must be a regular file within the archive root.
+func (e *extractor) writeHardlink(path, name, linkname string) error {
+	src, err := safePath(e.root, linkname)
+	if err != nil {
+		return err
+	}
+	info, err := os.Lstat(src)
+	if err != nil || !info.Mode().IsRegular() {
+		return status.InvalidArgumentErrorf("archive entry %q links to missing or non-regular entry %q", name, linkname)
+	}
+	f, err := os.OpenFile(src, os.O_RDONLY|syscall.O_NOFOLLOW, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return e.writeFile(path, f, info.Mode())
+}
+
+func (e *extractor) extractTar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return status.InvalidArgumentErrorf("invalid tar archive: %s", err)
+		}
+		if err := e.addEntry(); err != nil {
+			return err
+		}
+		path, err := safePath(e.root, hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			if err := e.writeFile(path, tr, hdr.FileInfo().Mode()); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := writeSymlink(path, hdr.Linkname); err != nil {
+				return err
+			}
+		case tar.TypeLink:
+			// Hard links refer to an earlier entry in the archive; store a
+			// copy of its contents.
+			if err := e.writeHardlink(path, hdr.Name, hdr.Linkname); err != nil {
+				return err
+			}
+		default:
+			// Device files, FIFOs etc. have no REAPI representation.
+			continue
+		}
+	}
+}
+
+func (e *extractor) extractZip(archivePath string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return status.InvalidArgumentErrorf("invalid zip archive: %s", err)
+	}
+	defer zr.Close()
+	for _, zf := range zr.File {
+		if err := e.addEntry(); err != nil {
+			return err
+		}
+		path, err := safePath(e.root, zf.Name)
+		if err != nil {
+			return err
+		}
+		mode := zf.Mode()
+		if mode.IsDir() {
+			if err := os.MkdirAll(path, 0755); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := zf.Open()
+		if err != nil {
+			return status.InvalidArgumentErrorf("invalid zip archive entry %q: %s", zf.Name, err)
+		}
+		if mode&os.ModeSymlink != 0 {
+			target, err := io.ReadAll(io.LimitReader(rc, maxSymlinkTargetLength+1))
+			if err == nil && len(target) > maxSymlinkTargetLength {
+				err = status.InvalidArgumentErrorf("archive entry %q has a symlink target that is too long", zf.Name)
+			}
+			if err == nil {
+				err = writeSymlink(path, string(target))
+			}
+			rc.Close()
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		err = e.writeFile(path, rc, mode)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package fetch_server
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"hash"
+	"strings"
+
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+)
+
+// checksum is an expected content hash parsed from a Subresource Integrity
+// (SRI) string, e.g. "sha256-47DEQpj8HBSa+/TImW+5JCeuQeRkm5NMpJWZG3hSuFU=".
+// See https://w3c.github.io/webappsec-subresource-integrity/
+type checksum struct {
+	algorithm string
+	newHash   func() hash.Hash
+	sum       []byte
+}
+
+var sriHashes = map[string]func() hash.Hash{
+	"sha256": sha256.New,
+	"sha384": sha512.New384,
+	"sha512": sha512.New,
+}
+
+func parseChecksum(sri string) (*checksum, error) {
+	// An SRI value may list several hashes separated by whitespace; clients
+	// only need to match one of them, so we use the first supported one.
+	for _, field := range strings.Fields(sri) {
+		algorithm, b64Sum, ok := strings.Cut(field, "-")
+		if !ok {
+			continue
+		}
+		newHash, ok := sriHashes[algorithm]
+		if !ok {
+			continue
+		}
+		// Strip any SRI options, e.g. "sha256-abc?foo".
+		b64Sum, _, _ = strings.Cut(b64Sum, "?")
+		sum, err := base64.StdEncoding.DecodeString(b64Sum)
+		if err != nil {
+			return nil, status.InvalidArgumentErrorf("Error decoding %s checksum %q: %s", algorithm, b64Sum, err)
+		}
+		if len(sum) != newHash().Size() {
+			return nil, status.InvalidArgumentErrorf("Invalid %s checksum %q: wrong length", algorithm, b64Sum)
+		}
+		return &checksum{algorithm: algorithm, newHash: newHash, sum: sum}, nil
+	}
+	return nil, status.InvalidArgumentErrorf("Unsupported checksum %q; supported algorithms are sha256, sha384 and sha512", sri)
+}
+
+// verify returns an error if the given hash does not match the checksum.
+func (c *checksum) verify(h hash.Hash) error {
+	if actual := h.Sum(nil); !bytes.Equal(actual, c.sum) {
+		return status.DataLossErrorf("Checksum mismatch: expected %s-%s, got %s-%s", c.algorithm, base64.StdEncoding.EncodeToString(c.sum), c.algorithm, base64.StdEncoding.EncodeToString(actual))
+	}
+	return nil
+}
@@ -1,36 +1,48 @@
 package fetch_server
 
 import (
-	"bytes"
 	"context"
-	"encoding/base64"
 	"fmt"
+	"hash"
 	"io"
 	"net"
 	"net/http"
 	"net/url"
+	"os"
+	"path/filepath"
 	"strings"
 	"time"
 
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
 	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_asset/asset_index"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/byte_stream_server"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/cachetools"
 	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/digest"
 	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/namespace"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
 
 	rapb "github.com/buildbuddy-io/buildbuddy/proto/remote_asset"
 	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
 	statuspb "google.golang.org/genproto/googleapis/rpc/status"
 	gcodes "google.golang.org/grpc/codes"
+	gstatus "google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
 	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 const (
-	checksumQualifier = "checksum.sri"
-	sha256Prefix      = "sha256-"
-	maxHTTPTimeout    = 60 * time.Minute
+	checksumQualifier     = "checksum.sri"
+	resourceTypeQualifier = "resource_type"
+	maxHTTPTimeout        = 60 * time.Minute
+
+	// The subdirectory of a fetched archive to return, similar to Bazel's
+	// strip_prefix.
+	directoryQualifier = "directory"
 )
 
 type FetchServer struct {
@@ -105,63 +117,388 @@ func (p *FetchServer) FetchBlob(ctx context.Context, req *rapb.FetchBlobRequest)
 		return nil, err
 	}
 
+	if match, err := p.lookupPushedAsset(ctx, cache, req.GetInstanceName(), asset_index.BlobAsset, req.GetUris(), req.GetQualifiers(), req.GetOldestContentAccepted()); err == nil {
+		return &rapb.FetchBlobResponse{
+			Status:     &statuspb.Status{Code: int32(gcodes.OK)},
+			Uri:        match.URI,
+			Qualifiers: req.GetQualifiers(),
+			ExpiresAt:  match.ExpireAt,
+			BlobDigest: match.Digest,
+		}, nil
+	} else if !status.IsNotFoundError(err) {
+		return nil, err
+	}
+
+	var expectedChecksum *checksum
 	for _, qualifier := range req.GetQualifiers() {
-		if qualifier.GetName() == checksumQualifier && strings.HasPrefix(qualifier.GetValue(), sha256Prefix) {
-			b64sha256 := strings.TrimPrefix(qualifier.GetValue(), sha256Prefix)
-			sha256, err := base64.StdEncoding.DecodeString(b64sha256)
+		if qualifier.GetName() == checksumQualifier {
+			expectedChecksum, err = parseChecksum(qualifier.GetValue())
 			if err != nil {
-				return nil, status.FailedPreconditionErrorf("Error decoding qualifier %q: %s", qualifier.GetName(), err.Error())
-			}
-			blobDigest := &repb.Digest{
-				Hash:      fmt.Sprintf("%x", sha256),
-				SizeBytes: int64(-1),
-			}
-			if data, err := cache.Get(ctx, blobDigest); err == nil {
-				blobDigest.SizeBytes = int64(len(data)) // set the actual correct size.
-				return &rapb.FetchBlobResponse{
-					Status:     &statuspb.Status{Code: int32(gcodes.OK)},
-					BlobDigest: blobDigest,
-				}, nil
+				return nil, err
 			}
 		}
 	}
+
+	// If the blob is pinned by a sha256 checksum, it may already be in the
+	// CAS, in which case we don't need to fetch it at all.
+	if expectedChecksum != nil && expectedChecksum.algorithm == "sha256" {
+		blobDigest := &repb.Digest{
+			Hash:      fmt.Sprintf("%x", expectedChecksum.sum),
+			SizeBytes: int64(-1),
+		}
+		if md, err := cache.Metadata(ctx, blobDigest); err == nil {
+			blobDigest.SizeBytes = md.SizeBytes // set the actual correct size.
+			return &rapb.FetchBlobResponse{
+				Status:     &statuspb.Status{Code: int32(gcodes.OK)},
+				Qualifiers: req.GetQualifiers(),
+				BlobDigest: blobDigest,
+			}, nil
+		}
+	}
+
 	httpClient := timeoutHTTPClient(ctx, req.GetTimeout())
+	var failures []*uriFailure
 	for _, uri := range req.GetUris() {
 		_, err := url.Parse(uri)
 		if err != nil {
 			return nil, status.InvalidArgumentErrorf("Unparsable URI: %q", uri)
 		}
-		rsp, err := httpClient.Get(uri)
-		if err != nil {
-			return nil, status.AbortedErrorf("Error fetching URI  %q: %s", uri, err.Error())
-		}
-		data, err := io.ReadAll(rsp.Body)
+		blobDigest, err := fetchBlobToCache(ctx, httpClient, cache, uri, expectedChecksum)
 		if err != nil {
-			log.Warningf("Error reading object bytes: %s", err.Error())
-			continue
-		}
-		reader := bytes.NewReader(data)
-		blobDigest, err := digest.Compute(reader)
-		if err != nil {
-			log.Warningf("Error computing object digest: %s", err.Error())
-			continue
-		}
-		if err := cache.Set(ctx, blobDigest, data); err != nil {
-			log.Warningf("Error inserting object into cache: %s", err.Error())
+			log.Warningf("Error fetching blob from %q: %s", uri, err)
+			failures = append(failures, &uriFailure{uri: uri, err: err})
 			continue
 		}
 		return &rapb.FetchBlobResponse{
 			Uri:        uri,
 			Status:     &statuspb.Status{Code: int32(gcodes.OK)},
+			Qualifiers: req.GetQualifiers(),
 			BlobDigest: blobDigest,
 		}, nil
 	}
 
 	return &rapb.FetchBlobResponse{
-		Status: &statuspb.Status{Code: int32(gcodes.NotFound)},
+		Status: failureStatus(failures),
 	}, nil
 }
 
+// fetchBlobToCache streams the contents of the given URI into the cache,
+// returning their digest. If expectedChecksum is non-nil, the contents are
+// only committed to the cache if they match it.
+func fetchBlobToCache(ctx context.Context, httpClient *http.Client, cache interfaces.Cache, uri string, expectedChecksum *checksum) (*repb.Digest, error) {
+	rsp, err := httpGet(ctx, httpClient, uri)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+
+	// When both the sha256 and the size are known up front, the body can be
+	// streamed directly into the cache.
+	if expectedChecksum != nil && expectedChecksum.algorithm == "sha256" && rsp.ContentLength >= 0 {
+		blobDigest := &repb.Digest{
+			Hash:      fmt.Sprintf("%x", expectedChecksum.sum),
+			SizeBytes: rsp.ContentLength,
+		}
+		if err := writeToCache(ctx, cache, blobDigest, rsp.Body); err != nil {
+			return nil, status.WrapErrorf(err, "Error fetching URI %q", uri)
+		}
+		return blobDigest, nil
+	}
+
+	// Otherwise, spool the body to a temporary file to compute its digest
+	// without holding it in memory, then stream the file into the cache.
+	f, err := os.CreateTemp("", "remote-asset-*")
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		f.Close()
+		os.Remove(f.Name())
+	}()
+	if err := copyVerified(f, rsp, uri, expectedChecksum); err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	blobDigest, err := digest.Compute(f)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	if err := writeToCache(ctx, cache, blobDigest, f); err != nil {
+		return nil, err
+	}
+	return blobDigest, nil
+}
+
+// writeToCache copies r into the cache under the given digest. The cache
+// writer is only closed, committing the blob, if the contents of r match the
+// digest.
+func writeToCache(ctx context.Context, cache interfaces.Cache, d *repb.Digest, r io.Reader) error {
+	if d.GetHash() == digest.EmptySha256 {
+		return nil
+	}
+	if exists, err := cache.Contains(ctx, d); err == nil && exists {
+		return nil
+	}
+	wc, err := cache.Writer(ctx, d)
+	if err != nil {
+		return err
+	}
+	cs, err := byte_stream_server.NewChecksum(repb.DigestFunction_SHA256)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(io.MultiWriter(cs, wc), r); err != nil {
+		return status.UnavailableErrorf("Error reading response body: %s", err)
+	}
+	if err := cs.Check(d); err != nil {
+		return err
+	}
+	return wc.Close()
+}
+
+// uriFailure records why fetching from a particular URI failed.
+type uriFailure struct {
+	uri string
+	err error
+}
+
+// failureStatus summarizes per-URI fetch failures into a response status. The
+// code is that of the last failure, and the reason for each URI is included in
+// both the message and the status details.
+func failureStatus(failures []*uriFailure) *statuspb.Status {
+	if len(failures) == 0 {
+		return &statuspb.Status{
+			Code:    int32(gcodes.NotFound),
+			Message: "No URIs were provided",
+		}
+	}
+	msgs := make([]string, 0, len(failures))
+	details := make([]*anypb.Any, 0, len(failures))
+	for _, f := range failures {
+		msgs = append(msgs, fmt.Sprintf("%s: %s", f.uri, status.Message(f.err)))
+		info := &errdetails.ErrorInfo{
+			Reason: gstatus.Code(f.err).String(),
+			Domain: "buildbuddy.io",
+			Metadata: map[string]string{
+				"uri":     f.uri,
+				"message": status.Message(f.err),
+			},
+		}
+		if a, err := anypb.New(info); err == nil {
+			details = append(details, a)
+		}
+	}
+	last := failures[len(failures)-1]
+	return &statuspb.Status{
+		Code:    int32(gstatus.Code(last.err)),
+		Message: fmt.Sprintf("Failed to fetch from any of the requested URIs: %s", strings.Join(msgs, "; ")),
+		Details: details,
+	}
+}
+
 func (p *FetchServer) FetchDirectory(ctx context.Context, req *rapb.FetchDirectoryRequest) (*rapb.FetchDirectoryResponse, error) {
-	return nil, status.UnimplementedError("FetchDirectory is not yet implemented")
+	ctx, err := prefix.AttachUserPrefixToContext(ctx, p.env)
+	if err != nil {
+		return nil, err
+	}
+	cache, err := namespace.CASCache(ctx, p.cache, req.GetInstanceName())
+	if err != nil {
+		return nil, err
+	}
+	match, err := p.lookupPushedAsset(ctx, cache, req.GetInstanceName(), asset_index.DirectoryAsset, req.GetUris(), req.GetQualifiers(), req.GetOldestContentAccepted())
+	if err == nil {
+		return &rapb.FetchDirectoryResponse{
+			Status:              &statuspb.Status{Code: int32(gcodes.OK)},
+			Uri:                 match.URI,
+			Qualifiers:          req.GetQualifiers(),
+			ExpiresAt:           match.ExpireAt,
+			RootDirectoryDigest: match.Digest,
+		}, nil
+	}
+	if !status.IsNotFoundError(err) {
+		return nil, err
+	}
+
+	var expectedChecksum *checksum
+	resourceType := ""
+	subdirectory := ""
+	for _, qualifier := range req.GetQualifiers() {
+		switch qualifier.GetName() {
+		case checksumQualifier:
+			expectedChecksum, err = parseChecksum(qualifier.GetValue())
+			if err != nil {
+				return nil, err
+			}
+		case resourceTypeQualifier:
+			resourceType = qualifier.GetValue()
+		case directoryQualifier:
+			subdirectory = qualifier.GetValue()
+		}
+	}
+
+	httpClient := timeoutHTTPClient(ctx, req.GetTimeout())
+	var failures []*uriFailure
+	for _, uri := range req.GetUris() {
+		if _, err := url.Parse(uri); err != nil {
+			return nil, status.InvalidArgumentErrorf("Unparsable URI: %q", uri)
+		}
+		rootDigest, err := p.fetchDirectory(ctx, httpClient, cache, uri, expectedChecksum, archiveTypeFor(uri, resourceType), subdirectory)
+		if err != nil {
+			log.Warningf("Error fetching directory from %q: %s", uri, err)
+			failures = append(failures, &uriFailure{uri: uri, err: err})
+			continue
+		}
+		// Only remember the result if it is pinned by a checksum; otherwise
+		// the contents at the URI may legitimately change.
+		if expectedChecksum != nil {
+			if err := asset_index.Store(ctx, p.cache, req.GetInstanceName(), asset_index.DirectoryAsset, []string{uri}, req.GetQualifiers(), rootDigest, nil /*=expireAt*/); err != nil {
+				log.Warningf("Error storing remote asset entry for %q: %s", uri, err)
+			}
+		}
+		return &rapb.FetchDirectoryResponse{
+			Status:              &statuspb.Status{Code: int32(gcodes.OK)},
+			Uri:                 uri,
+			Qualifiers:          req.GetQualifiers(),
+			RootDirectoryDigest: rootDigest,
+		}, nil
+	}
+	return &rapb.FetchDirectoryResponse{
+		Status: failureStatus(failures),
+	}, nil
+}
+
+// fetchDirectory downloads the archive at the given URI, extracts it, and
+// uploads its contents to the CAS, returning the digest of the root Directory.
+// If subdirectory is non-empty, only that subdirectory of the archive is
+// uploaded, and its digest returned.
+func (p *FetchServer) fetchDirectory(ctx context.Context, httpClient *http.Client, cas interfaces.Cache, uri string, expectedChecksum *checksum, t archiveType, subdirectory string) (*repb.Digest, error) {
+	if t == unknownArchive {
+		return nil, status.InvalidArgumentErrorf("Could not determine archive type of %q; set the %q qualifier", uri, resourceTypeQualifier)
+	}
+	tmpDir, err := os.MkdirTemp("", "remote-asset-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	archivePath := filepath.Join(tmpDir, "archive")
+	if err := downloadToFile(ctx, httpClient, uri, archivePath, expectedChecksum); err != nil {
+		return nil, err
+	}
+	extractDir := filepath.Join(tmpDir, "extracted")
+	if err := os.Mkdir(extractDir, 0755); err != nil {
+		return nil, err
+	}
+	if err := extractArchive(archivePath, t, extractDir); err != nil {
+		return nil, err
+	}
+	rootDir, err := safePath(extractDir, subdirectory)
+	if err != nil {
+		return nil, err
+	}
+	if info, err := os.Lstat(rootDir); err != nil || !info.IsDir() {
+		return nil, status.NotFoundErrorf("Directory %q not found in archive", subdirectory)
+	}
+	rootDigest, _, err := cachetools.UploadDirectoryToCache(ctx, cas, rootDir)
+	if err != nil {
+		return nil, err
+	}
+	return rootDigest, nil
+}
+
+// downloadToFile writes the contents of the given URI to a new file at path,
+// verifying them against expectedChecksum if it is non-nil.
+func downloadToFile(ctx context.Context, httpClient *http.Client, uri, path string, expectedChecksum *checksum) error {
+	rsp, err := httpGet(ctx, httpClient, uri)
+	if err != nil {
+		return err
+	}
+	defer rsp.Body.Close()
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := copyVerified(f, rsp, uri, expectedChecksum); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// httpGet fetches the given URI, returning an error if the server does not
+// respond with a 2xx status. The caller must close the response body.
+func httpGet(ctx context.Context, httpClient *http.Client, uri string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, status.InvalidArgumentErrorf("Invalid URI %q: %s", uri, err)
+	}
+	rsp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, status.UnavailableErrorf("Error fetching URI %q: %s", uri, err)
+	}
+	if err := checkHTTPStatus(uri, rsp); err != nil {
+		rsp.Body.Close()
+		return nil, err
+	}
+	return rsp, nil
+}
+
+// copyVerified copies the response body to w, returning an error if
+// expectedChecksum is non-nil and the body does not match it.
+func copyVerified(w io.Writer, rsp *http.Response, uri string, expectedChecksum *checksum) error {
+	var h hash.Hash
+	if expectedChecksum != nil {
+		h = expectedChecksum.newHash()
+		w = io.MultiWriter(w, h)
+	}
+	if _, err := io.Copy(w, rsp.Body); err != nil {
+		return status.UnavailableErrorf("Error reading response from %q: %s", uri, err)
+	}
+	if h != nil {
+		if err := expectedChecksum.verify(h); err != nil {
+			return status.WrapErrorf(err, "Error fetching URI %q", uri)
+		}
+	}
+	return nil
+}
+
+// checkHTTPStatus returns an error if the response does not have a 2xx status.
+func checkHTTPStatus(uri string, rsp *http.Response) error {
+	if rsp.StatusCode >= 200 && rsp.StatusCode < 300 {
+		return nil
+	}
+	switch rsp.StatusCode {
+	case http.StatusNotFound, http.StatusGone:
+		return status.NotFoundErrorf("Fetching %q returned HTTP status %q", uri, rsp.Status)
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return status.PermissionDeniedErrorf("Fetching %q returned HTTP status %q", uri, rsp.Status)
+	default:
+		return status.UnavailableErrorf("Fetching %q returned HTTP status %q", uri, rsp.Status)
+	}
+}
+
+// lookupPushedAsset returns the asset previously pushed under one of the given
+// URIs with exactly the given qualifiers, provided that its content is still
+// present in the CAS. Returns a NotFound error otherwise.
+func (p *FetchServer) lookupPushedAsset(ctx context.Context, cas interfaces.Cache, instanceName string, assetType asset_index.AssetType, uris []string, qualifiers []*rapb.Qualifier, oldestContentAccepted *timestamppb.Timestamp) (*asset_index.Match, error) {
+	match, err := asset_index.Lookup(ctx, p.cache, instanceName, assetType, uris, qualifiers, oldestContentAccepted)
+	if err != nil {
+		return nil, err
+	}
+	if match.Digest.GetSizeBytes() == 0 {
+		return match, nil
+	}
+	exists, err := cas.Contains(ctx, match.Digest)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, status.NotFoundErrorf("Content for asset %q is no longer in the CAS", match.URI)
+	}
+	return match, nil
 }
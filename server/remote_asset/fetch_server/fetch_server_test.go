@@ -0,0 +1,385 @@
+package fetch_server_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/buildbuddy-io/buildbuddy/server/remote_asset/fetch_server"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/cachetools"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/digest"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/namespace"
+	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
+	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/testing/flags"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+
+	rapb "github.com/buildbuddy-io/buildbuddy/proto/remote_asset"
+	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
+	gcodes "google.golang.org/grpc/codes"
+)
+
+type archiveEntry struct {
+	name     string
+	contents string
+	mode     int64
+	linkname string
+	// hardlink is the name of an earlier entry that this entry is a hard link
+	// to.
+	hardlink string
+}
+
+var testEntries = []archiveEntry{
+	{name: "repo-1.0/", mode: 0755},
+	{name: "repo-1.0/BUILD", contents: "exports_files(glob(['**']))", mode: 0644},
+	{name: "repo-1.0/src/", mode: 0755},
+	{name: "repo-1.0/src/main.sh", contents: "#!/bin/sh\necho hello\n", mode: 0755},
+	{name: "repo-1.0/main", linkname: "src/main.sh", mode: 0777},
+}
+
+func makeTarGz(t *testing.T, entries []archiveEntry) []byte {
+	buf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(buf)
+	tw := tar.NewWriter(gzw)
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Mode: e.mode}
+		switch {
+		case e.hardlink != "":
+			hdr.Typeflag = tar.TypeLink
+			hdr.Linkname = e.hardlink
+		case e.linkname != "":
+			hdr.Typeflag = tar.TypeSymlink
+			hdr.Linkname = e.linkname
+		case e.name[len(e.name)-1] == '/':
+			hdr.Typeflag = tar.TypeDir
+		default:
+			hdr.Typeflag = tar.TypeReg
+			hdr.Size = int64(len(e.contents))
+		}
+		require.NoError(t, tw.WriteHeader(hdr))
+		_, err := tw.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gzw.Close())
+	return buf.Bytes()
+}
+
+func makeZip(t *testing.T, entries []archiveEntry) []byte {
+	buf := &bytes.Buffer{}
+	zw := zip.NewWriter(buf)
+	for _, e := range entries {
+		if e.linkname != "" {
+			continue
+		}
+		w, err := zw.Create(e.name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(e.contents))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return buf.Bytes()
+}
+
+func sri(b []byte) string {
+	sum := sha256.Sum256(b)
+	return "sha256-" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+func serve(t *testing.T, files map[string][]byte) *httptest.Server {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write(b)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func setup(t *testing.T) (context.Context, *testenv.TestEnv, *fetch_server.FetchServer) {
+	te := testenv.GetTestEnv(t)
+	ctx, err := prefix.AttachUserPrefixToContext(context.Background(), te)
+	require.NoError(t, err)
+	fs, err := fetch_server.NewFetchServer(te)
+	require.NoError(t, err)
+	return ctx, te, fs
+}
+
+func readDirectory(ctx context.Context, t *testing.T, te *testenv.TestEnv, d *repb.Digest) *repb.Directory {
+	dir := &repb.Directory{}
+	err := cachetools.ReadProtoFromCAS(ctx, te.GetCache(), digest.NewResourceName(d, ""), dir)
+	require.NoError(t, err)
+	return dir
+}
+
+func TestFetchDirectory_TarGz(t *testing.T) {
+	ctx, te, fs := setup(t)
+	archive := makeTarGz(t, testEntries)
+	srv := serve(t, map[string][]byte{"/repo-1.0.tar.gz": archive})
+
+	rsp, err := fs.FetchDirectory(ctx, &rapb.FetchDirectoryRequest{
+		Uris: []string{srv.URL + "/missing.tar.gz", srv.URL + "/repo-1.0.tar.gz"},
+		Qualifiers: []*rapb.Qualifier{
+			{Name: "checksum.sri", Value: sri(archive)},
+			{Name: "directory", Value: "repo-1.0"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(gcodes.OK), rsp.GetStatus().GetCode(), rsp.GetStatus().GetMessage())
+	assert.Equal(t, srv.URL+"/repo-1.0.tar.gz", rsp.GetUri())
+
+	root := readDirectory(ctx, t, te, rsp.GetRootDirectoryDigest())
+	require.Len(t, root.GetFiles(), 1)
+	assert.Equal(t, "BUILD", root.GetFiles()[0].GetName())
+	require.Len(t, root.GetSymlinks(), 1)
+	assert.Equal(t, "main", root.GetSymlinks()[0].GetName())
+	assert.Equal(t, "src/main.sh", root.GetSymlinks()[0].GetTarget())
+	require.Len(t, root.GetDirectories(), 1)
+	assert.Equal(t, "src", root.GetDirectories()[0].GetName())
+
+	src := readDirectory(ctx, t, te, root.GetDirectories()[0].GetDigest())
+	require.Len(t, src.GetFiles(), 1)
+	assert.Equal(t, "main.sh", src.GetFiles()[0].GetName())
+	assert.True(t, src.GetFiles()[0].GetIsExecutable())
+	cas, err := namespace.CASCache(ctx, te.GetCache(), "")
+	require.NoError(t, err)
+	contents, err := cas.Get(ctx, src.GetFiles()[0].GetDigest())
+	require.NoError(t, err)
+	assert.Equal(t, "#!/bin/sh\necho hello\n", string(contents))
+}
+
+func TestFetchDirectory_Zip(t *testing.T) {
+	ctx, te, fs := setup(t)
+	archive := makeZip(t, testEntries)
+	srv := serve(t, map[string][]byte{"/download": archive})
+
+	rsp, err := fs.FetchDirectory(ctx, &rapb.FetchDirectoryRequest{
+		Uris: []string{srv.URL + "/download"},
+		Qualifiers: []*rapb.Qualifier{
+			{Name: "resource_type", Value: "application/zip"},
+		},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(gcodes.OK), rsp.GetStatus().GetCode(), rsp.GetStatus().GetMessage())
+
+	root := readDirectory(ctx, t, te, rsp.GetRootDirectoryDigest())
+	require.Len(t, root.GetDirectories(), 1)
+	assert.Equal(t, "repo-1.0", root.GetDirectories()[0].GetName())
+}
+
+func TestFetchDirectory_ChecksumMismatch(t *testing.T) {
+	ctx, _, fs := setup(t)
+	archive := makeTarGz(t, testEntries)
+	srv := serve(t, map[string][]byte{"/repo-1.0.tar.gz": archive})
+
+	rsp, err := fs.FetchDirectory(ctx, &rapb.FetchDirectoryRequest{
+		Uris: []string{srv.URL + "/repo-1.0.tar.gz"},
+		Qualifiers: []*rapb.Qualifier{
+			{Name: "checksum.sri", Value: sri([]byte("something else"))},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(gcodes.DataLoss), rsp.GetStatus().GetCode())
+}
+
+func TestFetchDirectory_RejectsPathTraversal(t *testing.T) {
+	ctx, _, fs := setup(t)
+	archive := makeTarGz(t, []archiveEntry{
+		{name: "../escape.txt", contents: "gotcha", mode: 0644},
+	})
+	srv := serve(t, map[string][]byte{"/evil.tar.gz": archive})
+
+	rsp, err := fs.FetchDirectory(ctx, &rapb.FetchDirectoryRequest{
+		Uris: []string{srv.URL + "/evil.tar.gz"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(gcodes.InvalidArgument), rsp.GetStatus().GetCode())
+}
+
+func TestFetchDirectory_RejectsUnsafeArchives(t *testing.T) {
+	outside := filepath.Join(t.TempDir(), "outside.txt")
+	require.NoError(t, os.WriteFile(outside, []byte("secret"), 0644))
+
+	for _, tc := range []struct {
+		name    string
+		entries []archiveEntry
+		code    gcodes.Code
+	}{
+		{
+			name: "write through symlink",
+			entries: []archiveEntry{
+				{name: "link", linkname: outside, mode: 0777},
+				{name: "link", contents: "gotcha", mode: 0644},
+			},
+			code: gcodes.InvalidArgument,
+		},
+		{
+			name: "write under symlink",
+			entries: []archiveEntry{
+				{name: "dir", linkname: filepath.Dir(outside), mode: 0777},
+				{name: "dir/outside.txt", contents: "gotcha", mode: 0644},
+			},
+			code: gcodes.InvalidArgument,
+		},
+		{
+			name: "hardlink to symlink",
+			entries: []archiveEntry{
+				{name: "link", linkname: outside, mode: 0777},
+				{name: "copy", hardlink: "link", mode: 0644},
+			},
+			code: gcodes.InvalidArgument,
+		},
+		{
+			name: "hardlink outside root",
+			entries: []archiveEntry{
+				{name: "copy", hardlink: "../outside.txt", mode: 0644},
+			},
+			code: gcodes.InvalidArgument,
+		},
+		{
+			name: "too many entries",
+			entries: []archiveEntry{
+				{name: "a", contents: "a", mode: 0644},
+				{name: "b", contents: "b", mode: 0644},
+				{name: "c", contents: "c", mode: 0644},
+			},
+			code: gcodes.ResourceExhausted,
+		},
+		{
+			name: "too large",
+			entries: []archiveEntry{
+				{name: "a", contents: "0123456789", mode: 0644},
+				{name: "b", hardlink: "a", mode: 0644},
+			},
+			code: gcodes.ResourceExhausted,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			flags.Set(t, "remote_asset.max_extracted_archive_entries", 2)
+			flags.Set(t, "remote_asset.max_extracted_archive_size_bytes", int64(15))
+			ctx, _, fs := setup(t)
+			srv := serve(t, map[string][]byte{"/evil.tar.gz": makeTarGz(t, tc.entries)})
+
+			rsp, err := fs.FetchDirectory(ctx, &rapb.FetchDirectoryRequest{
+				Uris: []string{srv.URL + "/evil.tar.gz"},
+			})
+			require.NoError(t, err)
+			assert.Equal(t, int32(tc.code), rsp.GetStatus().GetCode(), rsp.GetStatus().GetMessage())
+			b, err := os.ReadFile(outside)
+			require.NoError(t, err)
+			require.Equal(t, "secret", string(b))
+		})
+	}
+}
+
+func TestFetchDirectory_NotFound(t *testing.T) {
+	ctx, _, fs := setup(t)
+	srv := serve(t, map[string][]byte{})
+
+	rsp, err := fs.FetchDirectory(ctx, &rapb.FetchDirectoryRequest{
+		Uris: []string{srv.URL + "/missing.tar.gz"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(gcodes.NotFound), rsp.GetStatus().GetCode())
+}
+
+func TestFetchBlob(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		checksum func(b []byte) string
+	}{
+		{name: "NoChecksum", checksum: func([]byte) string { return "" }},
+		{name: "SHA256", checksum: sri},
+		{name: "SHA384", checksum: func(b []byte) string {
+			sum := sha512.Sum384(b)
+			return "sha384-" + base64.StdEncoding.EncodeToString(sum[:])
+		}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx, te, fs := setup(t)
+			blob := []byte("toolchain contents")
+			srv := serve(t, map[string][]byte{"/toolchain.bin": blob})
+			var qualifiers []*rapb.Qualifier
+			if c := tc.checksum(blob); c != "" {
+				qualifiers = append(qualifiers, &rapb.Qualifier{Name: "checksum.sri", Value: c})
+			}
+
+			rsp, err := fs.FetchBlob(ctx, &rapb.FetchBlobRequest{
+				Uris:       []string{srv.URL + "/toolchain.bin"},
+				Qualifiers: qualifiers,
+			})
+			require.NoError(t, err)
+			require.Equal(t, int32(gcodes.OK), rsp.GetStatus().GetCode(), rsp.GetStatus().GetMessage())
+
+			expectedDigest, err := digest.Compute(bytes.NewReader(blob))
+			require.NoError(t, err)
+			assert.Equal(t, expectedDigest.GetHash(), rsp.GetBlobDigest().GetHash())
+			assert.Equal(t, expectedDigest.GetSizeBytes(), rsp.GetBlobDigest().GetSizeBytes())
+			cas, err := namespace.CASCache(ctx, te.GetCache(), "")
+			require.NoError(t, err)
+			contents, err := cas.Get(ctx, rsp.GetBlobDigest())
+			require.NoError(t, err)
+			assert.Equal(t, blob, contents)
+		})
+	}
+}
+
+func TestFetchBlob_ChecksumMismatchIsNotCached(t *testing.T) {
+	ctx, te, fs := setup(t)
+	blob := []byte("<html>404 page disguised as a 200</html>")
+	srv := serve(t, map[string][]byte{"/toolchain.bin": blob})
+
+	rsp, err := fs.FetchBlob(ctx, &rapb.FetchBlobRequest{
+		Uris: []string{srv.URL + "/toolchain.bin"},
+		Qualifiers: []*rapb.Qualifier{
+			{Name: "checksum.sri", Value: sri([]byte("toolchain contents"))},
+		},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(gcodes.DataLoss), rsp.GetStatus().GetCode())
+
+	d, err := digest.Compute(bytes.NewReader(blob))
+	require.NoError(t, err)
+	cas, err := namespace.CASCache(ctx, te.GetCache(), "")
+	require.NoError(t, err)
+	exists, err := cas.Contains(ctx, d)
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestFetchBlob_HTTPErrorsAreReportedPerURI(t *testing.T) {
+	ctx, _, fs := setup(t)
+	srv := serve(t, map[string][]byte{})
+
+	rsp, err := fs.FetchBlob(ctx, &rapb.FetchBlobRequest{
+		Uris: []string{srv.URL + "/a", srv.URL + "/b"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(gcodes.NotFound), rsp.GetStatus().GetCode())
+	assert.Contains(t, rsp.GetStatus().GetMessage(), srv.URL+"/a")
+	assert.Contains(t, rsp.GetStatus().GetMessage(), srv.URL+"/b")
+
+	var uris []string
+	for _, a := range rsp.GetStatus().GetDetails() {
+		info := &errdetails.ErrorInfo{}
+		require.NoError(t, a.UnmarshalTo(info))
+		assert.Equal(t, "NotFound", info.GetReason())
+		uris = append(uris, info.GetMetadata()["uri"])
+	}
+	assert.Equal(t, []string{srv.URL + "/a", srv.URL + "/b"}, uris)
+}
@@ -4,13 +4,20 @@ import (
 	"context"
 
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_asset/asset_index"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/digest"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/namespace"
+	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 
 	rapb "github.com/buildbuddy-io/buildbuddy/proto/remote_asset"
+	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
 )
 
 type PushServer struct {
-	env environment.Env
+	env   environment.Env
+	cache interfaces.Cache
 }
 
 func Register(env environment.Env) error {
@@ -18,20 +25,86 @@ func Register(env environment.Env) error {
 	if env.GetCache() == nil {
 		return nil
 	}
-	env.SetPushServer(NewPushServer(env))
+	pushServer, err := NewPushServer(env)
+	if err != nil {
+		return status.InternalErrorf("Error initializing PushServer: %s", err)
+	}
+	env.SetPushServer(pushServer)
 	return nil
 }
 
-func NewPushServer(env environment.Env) *PushServer {
+func NewPushServer(env environment.Env) (*PushServer, error) {
+	cache := env.GetCache()
+	if cache == nil {
+		return nil, status.FailedPreconditionError("A cache is required to enable the PushServer")
+	}
 	return &PushServer{
-		env: env,
+		env:   env,
+		cache: cache,
+	}, nil
+}
+
+// checkReferencesExist verifies that the pushed content, as well as any
+// content it declares references to, is already present in the CAS. The
+// Remote Asset API requires that content be uploaded before it is pushed.
+func (p *PushServer) checkReferencesExist(ctx context.Context, instanceName string, digests []*repb.Digest) error {
+	nonEmpty := make([]*repb.Digest, 0, len(digests))
+	for _, d := range digests {
+		if _, err := digest.Validate(d); err != nil {
+			return err
+		}
+		if d.GetSizeBytes() > 0 {
+			nonEmpty = append(nonEmpty, d)
+		}
 	}
+	cas, err := namespace.CASCache(ctx, p.cache, instanceName)
+	if err != nil {
+		return err
+	}
+	missing, err := cas.FindMissing(ctx, nonEmpty)
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 {
+		return digest.MissingDigestError(missing[0])
+	}
+	return nil
 }
 
 func (p *PushServer) PushBlob(ctx context.Context, req *rapb.PushBlobRequest) (*rapb.PushBlobResponse, error) {
-	return nil, status.UnimplementedError("PushBlob is not yet implemented")
+	ctx, err := prefix.AttachUserPrefixToContext(ctx, p.env)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetBlobDigest() == nil {
+		return nil, status.InvalidArgumentError("A blob_digest is required")
+	}
+	digests := append([]*repb.Digest{req.GetBlobDigest()}, req.GetReferencesBlobs()...)
+	digests = append(digests, req.GetReferencesDirectories()...)
+	if err := p.checkReferencesExist(ctx, req.GetInstanceName(), digests); err != nil {
+		return nil, err
+	}
+	if err := asset_index.Store(ctx, p.cache, req.GetInstanceName(), asset_index.BlobAsset, req.GetUris(), req.GetQualifiers(), req.GetBlobDigest(), req.GetExpireAt()); err != nil {
+		return nil, err
+	}
+	return &rapb.PushBlobResponse{}, nil
 }
 
 func (p *PushServer) PushDirectory(ctx context.Context, req *rapb.PushDirectoryRequest) (*rapb.PushDirectoryResponse, error) {
-	return nil, status.UnimplementedError("PushDirectory is not yet implemented")
+	ctx, err := prefix.AttachUserPrefixToContext(ctx, p.env)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetRootDirectoryDigest() == nil {
+		return nil, status.InvalidArgumentError("A root_directory_digest is required")
+	}
+	digests := append([]*repb.Digest{req.GetRootDirectoryDigest()}, req.GetReferencesBlobs()...)
+	digests = append(digests, req.GetReferencesDirectories()...)
+	if err := p.checkReferencesExist(ctx, req.GetInstanceName(), digests); err != nil {
+		return nil, err
+	}
+	if err := asset_index.Store(ctx, p.cache, req.GetInstanceName(), asset_index.DirectoryAsset, req.GetUris(), req.GetQualifiers(), req.GetRootDirectoryDigest(), req.GetExpireAt()); err != nil {
+		return nil, err
+	}
+	return &rapb.PushDirectoryResponse{}, nil
 }
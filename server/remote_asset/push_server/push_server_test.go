@@ -0,0 +1,141 @@
+package push_server_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/buildbuddy-io/buildbuddy/server/remote_asset/fetch_server"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_asset/push_server"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/cachetools"
+	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
+	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/timestamppb"
+
+	rapb "github.com/buildbuddy-io/buildbuddy/proto/remote_asset"
+	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
+	statuspb "google.golang.org/genproto/googleapis/rpc/status"
+	gcodes "google.golang.org/grpc/codes"
+)
+
+const (
+	// Any fetch from this URI would fail, so the only way to resolve it is via
+	// a previously pushed asset.
+	unreachableURI = "http://localhost:0/some/archive.tar.gz"
+)
+
+func setup(t *testing.T) (context.Context, *testenv.TestEnv, *push_server.PushServer, *fetch_server.FetchServer) {
+	te := testenv.GetTestEnv(t)
+	ctx, err := prefix.AttachUserPrefixToContext(context.Background(), te)
+	require.NoError(t, err)
+	ps, err := push_server.NewPushServer(te)
+	require.NoError(t, err)
+	fs, err := fetch_server.NewFetchServer(te)
+	require.NoError(t, err)
+	return ctx, te, ps, fs
+}
+
+// assertNotResolved asserts that a fetch was not satisfied by a pushed asset.
+// Since the URIs used in these tests are unreachable, the fetch will either
+// fail outright or return a non-OK status.
+func assertNotResolved(t *testing.T, rspStatus *statuspb.Status, err error) {
+	if err == nil {
+		assert.NotEqual(t, int32(gcodes.OK), rspStatus.GetCode())
+	}
+}
+
+func TestPushBlobThenFetch(t *testing.T) {
+	ctx, te, ps, fs := setup(t)
+	d, err := cachetools.UploadBlobToCAS(ctx, te.GetCache(), "", []byte("hello world"))
+	require.NoError(t, err)
+	qualifiers := []*rapb.Qualifier{
+		{Name: "resource_type", Value: "application/x-tar"},
+		{Name: "bazel.canonical_id", Value: "foo"},
+	}
+
+	_, err = ps.PushBlob(ctx, &rapb.PushBlobRequest{
+		Uris:       []string{unreachableURI},
+		Qualifiers: qualifiers,
+		BlobDigest: d,
+	})
+	require.NoError(t, err)
+
+	// Qualifier order should not matter.
+	rsp, err := fs.FetchBlob(ctx, &rapb.FetchBlobRequest{
+		Uris:       []string{"http://localhost:0/other", unreachableURI},
+		Qualifiers: []*rapb.Qualifier{qualifiers[1], qualifiers[0]},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, int32(gcodes.OK), rsp.GetStatus().GetCode())
+	assert.Equal(t, unreachableURI, rsp.GetUri())
+	assert.True(t, proto.Equal(d, rsp.GetBlobDigest()))
+
+	// Different qualifiers should not match the pushed asset.
+	rsp, err = fs.FetchBlob(ctx, &rapb.FetchBlobRequest{
+		Uris:       []string{unreachableURI},
+		Qualifiers: qualifiers[:1],
+	})
+	assertNotResolved(t, rsp.GetStatus(), err)
+}
+
+func TestPushBlobExpiry(t *testing.T) {
+	ctx, te, ps, fs := setup(t)
+	d, err := cachetools.UploadBlobToCAS(ctx, te.GetCache(), "", []byte("hello world"))
+	require.NoError(t, err)
+
+	_, err = ps.PushBlob(ctx, &rapb.PushBlobRequest{
+		Uris:       []string{unreachableURI},
+		BlobDigest: d,
+		ExpireAt:   timestamppb.New(time.Now().Add(-1 * time.Minute)),
+	})
+	require.NoError(t, err)
+
+	rsp, err := fs.FetchBlob(ctx, &rapb.FetchBlobRequest{Uris: []string{unreachableURI}})
+	assertNotResolved(t, rsp.GetStatus(), err)
+}
+
+func TestPushBlobMissingContent(t *testing.T) {
+	ctx, _, ps, _ := setup(t)
+	d := &repb.Digest{
+		Hash:      "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9",
+		SizeBytes: 11,
+	}
+
+	_, err := ps.PushBlob(ctx, &rapb.PushBlobRequest{
+		Uris:       []string{unreachableURI},
+		BlobDigest: d,
+	})
+	require.Error(t, err)
+	assert.True(t, status.IsFailedPreconditionError(err), "expected FailedPrecondition, got %s", err)
+}
+
+func TestPushDirectoryThenFetch(t *testing.T) {
+	ctx, te, ps, fs := setup(t)
+	fileDigest, err := cachetools.UploadBlobToCAS(ctx, te.GetCache(), "", []byte("hello world"))
+	require.NoError(t, err)
+	dir := &repb.Directory{
+		Files: []*repb.FileNode{{Name: "hello.txt", Digest: fileDigest}},
+	}
+	dirDigest, err := cachetools.UploadProtoToCAS(ctx, te.GetCache(), "", dir)
+	require.NoError(t, err)
+
+	_, err = ps.PushDirectory(ctx, &rapb.PushDirectoryRequest{
+		Uris:                []string{unreachableURI},
+		RootDirectoryDigest: dirDigest,
+		ReferencesBlobs:     []*repb.Digest{fileDigest},
+	})
+	require.NoError(t, err)
+
+	rsp, err := fs.FetchDirectory(ctx, &rapb.FetchDirectoryRequest{Uris: []string{unreachableURI}})
+	require.NoError(t, err)
+	assert.Equal(t, int32(gcodes.OK), rsp.GetStatus().GetCode())
+	assert.True(t, proto.Equal(dirDigest, rsp.GetRootDirectoryDigest()))
+
+	// A pushed directory should not be returned for a blob fetch.
+	blobRsp, err := fs.FetchBlob(ctx, &rapb.FetchBlobRequest{Uris: []string{unreachableURI}})
+	assertNotResolved(t, blobRsp.GetStatus(), err)
+}
@@ -135,7 +135,8 @@ func (s *ActionCacheServer) GetActionResult(ctx context.Context, req *repb.GetAc
 	if req.ActionDigest == nil {
 		return nil, status.InvalidArgumentError("ActionDigest is a required field")
 	}
-	_, err := digest.Validate(req.ActionDigest)
+	digestFunction := digest.InferDigestFunction(req.GetDigestFunction(), req.GetActionDigest())
+	_, err := digest.ValidateWithFunction(req.ActionDigest, digestFunction)
 	if err != nil {
 		return nil, err
 	}
@@ -144,11 +145,11 @@ func (s *ActionCacheServer) GetActionResult(ctx context.Context, req *repb.GetAc
 		return nil, err
 	}
 
-	cache, err := namespace.ActionCache(ctx, s.cache, req.GetInstanceName())
+	cache, err := namespace.ActionCacheWithDigestFunction(ctx, s.cache, req.GetInstanceName(), digestFunction)
 	if err != nil {
 		return nil, err
 	}
-	casCache, err := namespace.CASCache(ctx, s.cache, req.GetInstanceName())
+	casCache, err := namespace.CASCacheWithDigestFunction(ctx, s.cache, req.GetInstanceName(), digestFunction)
 	if err != nil {
 		return nil, err
 	}
@@ -197,7 +198,8 @@ func (s *ActionCacheServer) UpdateActionResult(ctx context.Context, req *repb.Up
 	if req.ActionResult == nil {
 		return nil, status.InvalidArgumentError("ActionResult is a required field")
 	}
-	_, err := digest.Validate(req.GetActionDigest())
+	digestFunction := digest.InferDigestFunction(req.GetDigestFunction(), req.GetActionDigest())
+	_, err := digest.ValidateWithFunction(req.GetActionDigest(), digestFunction)
 	if err != nil {
 		return nil, err
 	}
@@ -218,7 +220,7 @@ func (s *ActionCacheServer) UpdateActionResult(ctx context.Context, req *repb.Up
 	ht := hit_tracker.NewHitTracker(ctx, s.env, true)
 	d := req.GetActionDigest()
 	uploadTracker := ht.TrackUpload(d)
-	cache, err := namespace.ActionCache(ctx, s.cache, req.GetInstanceName())
+	cache, err := namespace.ActionCacheWithDigestFunction(ctx, s.cache, req.GetInstanceName(), digestFunction)
 	if err != nil {
 		return nil, err
 	}
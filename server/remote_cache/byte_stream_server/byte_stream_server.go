@@ -2,11 +2,11 @@ package byte_stream_server
 
 import (
 	"context"
-	"crypto/sha256"
 	"flag"
 	"fmt"
 	"hash"
 	"io"
+	"strings"
 
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
 	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
@@ -19,6 +19,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/util/devnull"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/quota"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 
 	akpb "github.com/buildbuddy-io/buildbuddy/proto/api_key"
@@ -67,8 +68,8 @@ func NewByteStreamServer(env environment.Env) (*ByteStreamServer, error) {
 	}, nil
 }
 
-func (s *ByteStreamServer) getCache(ctx context.Context, instanceName string) (interfaces.Cache, error) {
-	return namespace.CASCache(ctx, s.cache, instanceName)
+func (s *ByteStreamServer) getCache(ctx context.Context, r *digest.ResourceName) (interfaces.Cache, error) {
+	return namespace.CASCacheWithDigestFunction(ctx, s.cache, r.GetInstanceName(), r.GetDigestFunction())
 }
 
 func minInt64(a, b int64) int64 {
@@ -92,10 +93,15 @@ func checkReadPreconditions(req *bspb.ReadRequest) error {
 }
 
 type streamWriter struct {
+	ctx    context.Context
+	env    environment.Env
 	stream bspb.ByteStream_ReadServer
 }
 
 func (w *streamWriter) Write(buf []byte) (int, error) {
+	if err := quota.Allow(w.ctx, w.env, quota.DownloadBytesNamespace, int64(len(buf))); err != nil {
+		return 0, err
+	}
 	err := w.stream.Send(&bspb.ReadResponse{
 		Data: buf,
 	})
@@ -122,15 +128,15 @@ func (s *ByteStreamServer) Read(req *bspb.ReadRequest, stream bspb.ByteStream_Re
 	}
 
 	ht := hit_tracker.NewHitTracker(ctx, s.env, false)
-	cache, err := s.getCache(ctx, r.GetInstanceName())
+	cache, err := s.getCache(ctx, r)
 	if err != nil {
 		return err
 	}
-	if r.GetDigest().GetHash() == digest.EmptySha256 {
+	if r.GetDigest().GetHash() == digest.EmptyHashFor(r.GetDigestFunction()) {
 		ht.TrackEmptyHit()
 		return nil
 	}
-	reader, err := cache.Reader(ctx, r.GetDigest(), req.ReadOffset, req.ReadLimit)
+	reader, storedCompressor, err := s.reader(ctx, cache, r, req)
 	if err != nil {
 		ht.TrackMiss(r.GetDigest())
 		return err
@@ -142,7 +148,7 @@ func (s *ByteStreamServer) Read(req *bspb.ReadRequest, stream bspb.ByteStream_Re
 		bufSize = r.GetDigest().GetSizeBytes()
 	}
 
-	if r.GetCompressor() == repb.Compressor_ZSTD {
+	if r.GetCompressor() == repb.Compressor_ZSTD && storedCompressor != repb.Compressor_ZSTD {
 		rbuf := s.bufferPool.Get(bufSize)
 		defer s.bufferPool.Put(rbuf)
 		cbuf := s.bufferPool.Get(bufSize)
@@ -158,11 +164,35 @@ func (s *ByteStreamServer) Read(req *bspb.ReadRequest, stream bspb.ByteStream_Re
 
 	copyBuf := s.bufferPool.Get(bufSize)
 	defer s.bufferPool.Put(copyBuf)
-	n, err := io.CopyBuffer(&streamWriter{stream}, reader, copyBuf[:bufSize])
+	n, err := io.CopyBuffer(&streamWriter{ctx: ctx, env: s.env, stream: stream}, reader, copyBuf[:bufSize])
 	downloadTracker.CloseWithBytesTransferred(n, r.GetCompressor())
 	return err
 }
 
+// reader returns a reader for the requested blob, along with the compressor
+// that the returned bytes are compressed with. If the client requested ZSTD and
+// the cache stores the full blob ZSTD-compressed, the stored bytes are returned
+// as-is so that they don't need to be recompressed.
+func (s *ByteStreamServer) reader(ctx context.Context, cache interfaces.Cache, r *digest.ResourceName, req *bspb.ReadRequest) (io.ReadCloser, repb.Compressor_Value, error) {
+	readsFullBlob := req.GetReadOffset() == 0 && (req.GetReadLimit() == 0 || req.GetReadLimit() >= r.GetDigest().GetSizeBytes())
+	cc, ok := cache.(interfaces.CompressedCache)
+	if ok && r.GetCompressor() == repb.Compressor_ZSTD && readsFullBlob {
+		reader, compressor, err := cc.CompressedReader(ctx, r.GetDigest())
+		if err != nil {
+			return nil, repb.Compressor_IDENTITY, err
+		}
+		if compressor == repb.Compressor_ZSTD || compressor == repb.Compressor_IDENTITY {
+			return reader, compressor, nil
+		}
+		reader.Close()
+	}
+	reader, err := cache.Reader(ctx, r.GetDigest(), req.GetReadOffset(), req.GetReadLimit())
+	if err != nil {
+		return nil, repb.Compressor_IDENTITY, err
+	}
+	return reader, repb.Compressor_IDENTITY, nil
+}
+
 // `Write()` is used to send the contents of a resource as a sequence of
 // bytes. The bytes are sent in a sequence of request protos of a client-side
 // streaming FUNC (S *BYTESTREAMSERVER).
@@ -233,7 +263,7 @@ func (s *ByteStreamServer) initStreamState(ctx context.Context, req *bspb.WriteR
 	if err != nil {
 		return nil, err
 	}
-	cache, err := s.getCache(ctx, r.GetInstanceName())
+	cache, err := s.getCache(ctx, r)
 	if err != nil {
 		return nil, err
 	}
@@ -259,10 +289,13 @@ func (s *ByteStreamServer) initStreamState(ctx context.Context, req *bspb.WriteR
 		return nil, status.AlreadyExistsError("Already exists")
 	}
 
-	ws.checksum = NewChecksum()
+	ws.checksum, err = NewChecksum(r.GetDigestFunction())
+	if err != nil {
+		return nil, err
+	}
 	ws.writer = ws.checksum
 	cacheWriteCloser := devnull.NewWriteCloser()
-	if r.GetDigest().GetHash() != digest.EmptySha256 && !exists {
+	if r.GetDigest().GetHash() != digest.EmptyHashFor(r.GetDigestFunction()) && !exists {
 		cacheWriteCloser, err = cache.Writer(ctx, r.GetDigest())
 		if err != nil {
 			return nil, err
@@ -364,6 +397,9 @@ func (s *ByteStreamServer) Write(stream bspb.ByteStream_WriteServer) error {
 				return err
 			}
 		}
+		if err := quota.Allow(ctx, s.env, quota.UploadBytesNamespace, int64(len(req.Data))); err != nil {
+			return err
+		}
 		if err := streamState.Write(req.Data); err != nil {
 			return err
 		}
@@ -441,7 +477,7 @@ func (s *ByteStreamServer) QueryWriteStatus(ctx context.Context, req *bspb.Query
 		return nil, err
 	}
 
-	cache, err := s.getCache(ctx, rn.GetInstanceName())
+	cache, err := s.getCache(ctx, rn)
 	if err != nil {
 		return nil, err
 	}
@@ -513,15 +549,21 @@ func (s *ByteStreamServer) recvAll(stream bspb.ByteStream_WriteServer) (int64, e
 }
 
 type Checksum struct {
-	hash         hash.Hash
-	bytesWritten int64
+	digestFunction repb.DigestFunction_Value
+	hash           hash.Hash
+	bytesWritten   int64
 }
 
-func NewChecksum() *Checksum {
-	return &Checksum{
-		hash:         sha256.New(),
-		bytesWritten: 0,
+func NewChecksum(digestFunction repb.DigestFunction_Value) (*Checksum, error) {
+	h, err := digest.HashForDigestFunction(digestFunction)
+	if err != nil {
+		return nil, err
 	}
+	return &Checksum{
+		digestFunction: digestFunction,
+		hash:           h,
+		bytesWritten:   0,
+	}, nil
 }
 
 func (s *Checksum) BytesWritten() int64 {
@@ -541,7 +583,7 @@ func (s *Checksum) Write(p []byte) (int, error) {
 func (s *Checksum) Check(d *repb.Digest) error {
 	computedDigest := fmt.Sprintf("%x", s.hash.Sum(nil))
 	if computedDigest != d.GetHash() {
-		return status.DataLossErrorf("Uploaded bytes sha256 hash (%q) did not match digest (%q).", computedDigest, d.GetHash())
+		return status.DataLossErrorf("Uploaded bytes %s hash (%q) did not match digest (%q).", strings.ToLower(s.digestFunction.String()), computedDigest, d.GetHash())
 	}
 	if s.BytesWritten() != d.GetSizeBytes() {
 		return status.DataLossErrorf("Uploaded bytes length (%d bytes) did not match digest (%d).", s.BytesWritten(), d.GetSizeBytes())
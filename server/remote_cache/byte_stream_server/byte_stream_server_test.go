@@ -9,15 +9,19 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/buildbuddy-io/buildbuddy/server/backends/disk_cache"
 	"github.com/buildbuddy-io/buildbuddy/server/backends/memory_metrics_collector"
+	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
 	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/cachetools"
 	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/digest"
 	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/hit_tracker"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testdigest"
 	"github.com/buildbuddy-io/buildbuddy/server/testutil/testenv"
+	"github.com/buildbuddy-io/buildbuddy/server/testutil/testfs"
 	"github.com/buildbuddy-io/buildbuddy/server/util/bazel_request"
 	"github.com/buildbuddy-io/buildbuddy/server/util/compression"
 	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/quota"
 	"github.com/buildbuddy-io/buildbuddy/server/util/random"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"github.com/buildbuddy-io/buildbuddy/server/util/testing/flags"
@@ -217,6 +221,54 @@ func TestRPCTooLongWrite(t *testing.T) {
 	}
 }
 
+// byteBudgetQuotaManager allows a fixed number of bytes per namespace.
+type byteBudgetQuotaManager struct {
+	interfaces.QuotaManager
+	remaining map[string]int64
+}
+
+func (qm *byteBudgetQuotaManager) Allow(ctx context.Context, namespace string, quantity int64) (bool, error) {
+	remaining, ok := qm.remaining[namespace]
+	if !ok {
+		return true, nil
+	}
+	if quantity > remaining {
+		return false, nil
+	}
+	qm.remaining[namespace] = remaining - quantity
+	return true, nil
+}
+
+func TestRPCByteQuotas(t *testing.T) {
+	ctx := context.Background()
+	te := testenv.GetTestEnv(t)
+	te.SetQuotaManager(&byteBudgetQuotaManager{remaining: map[string]int64{
+		quota.UploadBytesNamespace:   1500,
+		quota.DownloadBytesNamespace: 1500,
+	}})
+	clientConn := runByteStreamServer(ctx, te, t)
+	bsClient := bspb.NewByteStreamClient(clientConn)
+
+	d, readSeeker := testdigest.NewRandomDigestReader(t, 1000)
+	instanceNameDigest := digest.NewResourceName(d, "")
+	_, err := cachetools.UploadFromReader(ctx, bsClient, instanceNameDigest, readSeeker)
+	require.NoError(t, err)
+
+	// The second upload exceeds the upload quota.
+	d2, readSeeker2 := testdigest.NewRandomDigestReader(t, 1000)
+	_, err = cachetools.UploadFromReader(ctx, bsClient, digest.NewResourceName(d2, ""), readSeeker2)
+	require.True(t, status.IsResourceExhaustedError(err), "expected ResourceExhausted, got %v", err)
+
+	var buf bytes.Buffer
+	err = readBlob(ctx, bsClient, instanceNameDigest, &buf, 0)
+	require.NoError(t, err)
+
+	// The second download exceeds the download quota.
+	buf.Reset()
+	err = readBlob(ctx, bsClient, instanceNameDigest, &buf, 0)
+	require.True(t, status.IsResourceExhaustedError(err), "expected ResourceExhausted, got %v", err)
+}
+
 // Tests Read/Write of a blob that exceeds the default gRPC message size.
 func TestRPCReadWriteLargeBlob(t *testing.T) {
 	ctx := context.Background()
@@ -433,6 +485,48 @@ func TestRPCWriteUncompressedReadCompressed(t *testing.T) {
 	}
 }
 
+func TestRPCReadCompressedFromCompressedStorage(t *testing.T) {
+	ctx := context.Background()
+	te := testenv.GetTestEnv(t)
+	flags.Set(t, "cache.zstd_transcoding_enabled", true)
+	dc, err := disk_cache.NewDiskCache(te, &disk_cache.Options{RootDirectory: testfs.MakeTempDir(t), EnableCompression: true}, 1_000_000_000)
+	require.NoError(t, err)
+	te.SetCache(dc)
+
+	clientConn := runByteStreamServer(ctx, te, t)
+	bsClient := bspb.NewByteStreamClient(clientConn)
+
+	blob := compressibleBlobOfSize(1e6)
+	d, err := digest.Compute(bytes.NewReader(blob))
+	require.NoError(t, err)
+	uploadResourceName := fmt.Sprintf("uploads/%s/blobs/%s/%d", newUUID(t), d.Hash, d.SizeBytes)
+	mustUploadChunked(t, ctx, bsClient, uploadResourceName, blob)
+
+	// The server should send the bytes exactly as they are stored.
+	cacheCtx, err := prefix.AttachUserPrefixToContext(ctx, te)
+	require.NoError(t, err)
+	r, compressor, err := dc.CompressedReader(cacheCtx, d)
+	require.NoError(t, err)
+	require.Equal(t, repb.Compressor_ZSTD, compressor)
+	stored, err := io.ReadAll(r)
+	require.NoError(t, err)
+	require.NoError(t, r.Close())
+
+	resourceName := digest.NewResourceName(d, "")
+	resourceName.SetCompressor(repb.Compressor_ZSTD)
+	downloadBuf := &bytes.Buffer{}
+	err = readBlob(ctx, bsClient, resourceName, downloadBuf, 0)
+	require.NoError(t, err)
+	require.Equal(t, stored, downloadBuf.Bytes())
+	require.Equal(t, blob, zstdDecompress(t, downloadBuf.Bytes()))
+
+	// Reads at an offset are served from the decompressed bytes.
+	downloadBuf.Reset()
+	err = readBlob(ctx, bsClient, digest.NewResourceName(d, ""), downloadBuf, 100)
+	require.NoError(t, err)
+	require.Equal(t, blob[100:], downloadBuf.Bytes())
+}
+
 func compressibleBlobOfSize(sizeBytes int) []byte {
 	out := make([]byte, 0, sizeBytes)
 	for len(out) < sizeBytes {
@@ -661,6 +661,85 @@ func uploadDir(ul *BatchCASUploader, dirPath string, visited []*repb.Directory)
 	return visited, digest, nil
 }
 
+// UploadDirectoryToCache is like UploadDirectoryToCAS, but writes the
+// directory contents directly to the given cache rather than going through the
+// CAS client. The cache should already be scoped to the CAS namespace.
+func UploadDirectoryToCache(ctx context.Context, cache interfaces.Cache, rootDirPath string) (*repb.Digest, *repb.Digest, error) {
+	visited, rootDirectoryDigest, err := uploadDirToCache(ctx, cache, rootDirPath, nil /*=visited*/)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(visited) == 0 {
+		return nil, nil, status.InternalError("empty directory list after uploading directory tree; this should never happen")
+	}
+	rootTree := &repb.Tree{Root: visited[0], Children: visited[1:]}
+	treeDigest, err := uploadProtoToCache(ctx, cache, "", rootTree)
+	if err != nil {
+		return nil, nil, err
+	}
+	return rootDirectoryDigest, treeDigest, nil
+}
+
+func uploadDirToCache(ctx context.Context, cache interfaces.Cache, dirPath string, visited []*repb.Directory) ([]*repb.Directory, *repb.Digest, error) {
+	dir := &repb.Directory{}
+	// Append the directory before doing any other work, so that the root
+	// directory is located at visited[0] at the end of recursion.
+	visited = append(visited, dir)
+	entries, err := os.ReadDir(dirPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		path := filepath.Join(dirPath, name)
+
+		if entry.IsDir() {
+			var d *repb.Digest
+			visited, d, err = uploadDirToCache(ctx, cache, path, visited)
+			if err != nil {
+				return nil, nil, err
+			}
+			dir.Directories = append(dir.Directories, &repb.DirectoryNode{
+				Name:   name,
+				Digest: d,
+			})
+		} else if entry.Type().IsRegular() {
+			info, err := entry.Info()
+			if err != nil {
+				return nil, nil, err
+			}
+			f, err := os.Open(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			d, err := UploadBytesToCache(ctx, cache, f)
+			f.Close()
+			if err != nil {
+				return nil, nil, err
+			}
+			dir.Files = append(dir.Files, &repb.FileNode{
+				Name:         name,
+				Digest:       d,
+				IsExecutable: isExecutable(info),
+			})
+		} else if entry.Type()&os.ModeSymlink == os.ModeSymlink {
+			target, err := os.Readlink(path)
+			if err != nil {
+				return nil, nil, err
+			}
+			dir.Symlinks = append(dir.Symlinks, &repb.SymlinkNode{
+				Name:   name,
+				Target: target,
+			})
+		}
+	}
+	d, err := uploadProtoToCache(ctx, cache, "", dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	return visited, d, nil
+}
+
 func UploadProtoToAC(ctx context.Context, cache interfaces.Cache, instanceName string, in proto.Message) (*repb.Digest, error) {
 	ac, err := namespace.ActionCache(ctx, cache, instanceName)
 	if err != nil {
@@ -5,6 +5,7 @@ import (
 	"math"
 
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
+	"github.com/buildbuddy-io/buildbuddy/server/remote_cache/digest"
 
 	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
 	smpb "github.com/buildbuddy-io/buildbuddy/proto/semver"
@@ -48,7 +49,7 @@ func (s *CapabilitiesServer) GetCapabilities(ctx context.Context, req *repb.GetC
 	}
 	if s.supportCAS {
 		c.CacheCapabilities = &repb.CacheCapabilities{
-			DigestFunction: []repb.DigestFunction_Value{repb.DigestFunction_SHA256},
+			DigestFunction: digest.SupportedDigestFunctions(),
 			ActionCacheUpdateCapabilities: &repb.ActionCacheUpdateCapabilities{
 				UpdateEnabled: true,
 			},
@@ -68,8 +69,9 @@ func (s *CapabilitiesServer) GetCapabilities(ctx context.Context, req *repb.GetC
 	}
 	if s.supportRemoteExec {
 		c.ExecutionCapabilities = &repb.ExecutionCapabilities{
-			DigestFunction: repb.DigestFunction_SHA256,
-			ExecEnabled:    true,
+			DigestFunction:  repb.DigestFunction_SHA256,
+			DigestFunctions: []repb.DigestFunction_Value{repb.DigestFunction_SHA256},
+			ExecEnabled:     true,
 			ExecutionPriorityCapabilities: &repb.PriorityCapabilities{
 				Priorities: []*repb.PriorityCapabilities_PriorityRange{
 					{MinPriority: math.MinInt32, MaxPriority: math.MaxInt32},
@@ -3,7 +3,6 @@ package content_addressable_storage_server
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
 	"encoding/base64"
 	"flag"
 	"fmt"
@@ -21,6 +20,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/util/compression"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/quota"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 	"golang.org/x/sync/errgroup"
 	"google.golang.org/grpc/codes"
@@ -79,12 +79,22 @@ func NewContentAddressableStorageServer(env environment.Env) (*ContentAddressabl
 	}, nil
 }
 
-func (s *ContentAddressableStorageServer) getCache(ctx context.Context, instanceName string) (interfaces.Cache, error) {
-	return namespace.CASCache(ctx, s.cache, instanceName)
+func (s *ContentAddressableStorageServer) getCache(ctx context.Context, instanceName string, digestFunction repb.DigestFunction_Value) (interfaces.Cache, error) {
+	return namespace.CASCacheWithDigestFunction(ctx, s.cache, instanceName, digestFunction)
 }
 
-func (s *ContentAddressableStorageServer) getACCache(ctx context.Context, instanceName string) (interfaces.Cache, error) {
-	return namespace.ActionCache(ctx, s.cache, instanceName)
+func (s *ContentAddressableStorageServer) getACCache(ctx context.Context, instanceName string, digestFunction repb.DigestFunction_Value) (interfaces.Cache, error) {
+	return namespace.ActionCacheWithDigestFunction(ctx, s.cache, instanceName, digestFunction)
+}
+
+// requestDigestFunction returns the digest function of a batch request. If the
+// client did not set one, it is inferred from the first digest in the batch.
+func requestDigestFunction(digestFunction repb.DigestFunction_Value, digests []*repb.Digest) repb.DigestFunction_Value {
+	var first *repb.Digest
+	if len(digests) > 0 {
+		first = digests[0]
+	}
+	return digest.InferDigestFunction(digestFunction, first)
 }
 
 // Determine if blobs are present in the CAS.
@@ -99,13 +109,14 @@ func (s *ContentAddressableStorageServer) FindMissingBlobs(ctx context.Context,
 	if err != nil {
 		return nil, err
 	}
-	cache, err := s.getCache(ctx, req.GetInstanceName())
+	digestFunction := requestDigestFunction(req.GetDigestFunction(), req.GetBlobDigests())
+	cache, err := s.getCache(ctx, req.GetInstanceName(), digestFunction)
 	if err != nil {
 		return nil, err
 	}
 	digestsToLookup := make([]*repb.Digest, 0, len(req.GetBlobDigests()))
 	for _, d := range req.GetBlobDigests() {
-		if d.GetHash() == digest.EmptySha256 {
+		if d.GetHash() == digest.EmptyHashFor(digestFunction) {
 			continue
 		}
 		if d.GetHash() == digest.EmptyHash {
@@ -169,7 +180,17 @@ func (s *ContentAddressableStorageServer) BatchUpdateBlobs(ctx context.Context,
 		return rsp, nil
 	}
 
-	cache, err := s.getCache(ctx, req.GetInstanceName())
+	uploadDigests := make([]*repb.Digest, 0, len(req.Requests))
+	uploadSizeBytes := int64(0)
+	for _, uploadRequest := range req.Requests {
+		uploadDigests = append(uploadDigests, uploadRequest.GetDigest())
+		uploadSizeBytes += int64(len(uploadRequest.GetData()))
+	}
+	if err := quota.Allow(ctx, s.env, quota.UploadBytesNamespace, uploadSizeBytes); err != nil {
+		return nil, err
+	}
+	digestFunction := requestDigestFunction(req.GetDigestFunction(), uploadDigests)
+	cache, err := s.getCache(ctx, req.GetInstanceName(), digestFunction)
 	if err != nil {
 		return nil, err
 	}
@@ -179,7 +200,7 @@ func (s *ContentAddressableStorageServer) BatchUpdateBlobs(ctx context.Context,
 	kvs := make(map[*repb.Digest][]byte, len(req.Requests))
 	for _, uploadRequest := range req.Requests {
 		uploadDigest := uploadRequest.GetDigest()
-		_, err := digest.Validate(uploadDigest)
+		_, err := digest.ValidateWithFunction(uploadDigest, digestFunction)
 		if err != nil {
 			return nil, err
 		}
@@ -188,7 +209,7 @@ func (s *ContentAddressableStorageServer) BatchUpdateBlobs(ctx context.Context,
 		// so doing 100-1000 or so in this loop is fine.
 		defer uploadTracker.CloseWithBytesTransferred(int64(len(uploadRequest.GetData())), uploadRequest.GetCompressor())
 
-		if uploadDigest.GetHash() == digest.EmptySha256 {
+		if uploadDigest.GetHash() == digest.EmptyHashFor(digestFunction) {
 			rsp.Responses = append(rsp.Responses, &repb.BatchUpdateBlobsResponse_Response{
 				Digest: uploadDigest,
 				Status: &statuspb.Status{Code: int32(codes.OK)},
@@ -205,7 +226,10 @@ func (s *ContentAddressableStorageServer) BatchUpdateBlobs(ctx context.Context,
 			})
 			continue
 		}
-		checksum := sha256.New()
+		checksum, err := digest.HashForDigestFunction(digestFunction)
+		if err != nil {
+			return nil, err
+		}
 		data := uploadRequest.GetData()
 		if uploadRequest.Compressor == repb.Compressor_ZSTD {
 			data, err = zstdDecompress(uploadRequest.GetData(), uploadRequest.GetDigest().GetSizeBytes())
@@ -276,17 +300,19 @@ func (s *ContentAddressableStorageServer) BatchReadBlobs(ctx context.Context, re
 	if err != nil {
 		return nil, err
 	}
-	cache, err := s.getCache(ctx, req.GetInstanceName())
+	digestFunction := requestDigestFunction(req.GetDigestFunction(), req.GetDigests())
+	cache, err := s.getCache(ctx, req.GetInstanceName(), digestFunction)
 	if err != nil {
 		return nil, err
 	}
+	compressor := s.responseCompressor(req.GetAcceptableCompressors())
 	type closeTrackerFunc func(res *repb.BatchReadBlobsResponse_Response)
 	cacheRequest := make([]*repb.Digest, 0, len(req.Digests))
 	closeTrackerFuncs := make([]closeTrackerFunc, 0, len(req.Digests))
 	rsp.Responses = make([]*repb.BatchReadBlobsResponse_Response, 0, len(req.Digests))
 	ht := hit_tracker.NewHitTracker(ctx, s.env, false)
 	for _, readDigest := range req.GetDigests() {
-		_, err := digest.Validate(readDigest)
+		_, err := digest.ValidateWithFunction(readDigest, digestFunction)
 		if err != nil {
 			return nil, err
 		}
@@ -295,13 +321,13 @@ func (s *ContentAddressableStorageServer) BatchReadBlobs(ctx context.Context, re
 			downloadTracker.CloseWithBytesTransferred(int64(len(res.Data)), res.Compressor)
 		})
 
-		if readDigest.GetHash() != digest.EmptySha256 {
+		if readDigest.GetHash() != digest.EmptyHashFor(digestFunction) {
 			cacheRequest = append(cacheRequest, readDigest)
 		}
 	}
 	cacheRsp, err := cache.GetMulti(ctx, cacheRequest)
 	for _, d := range req.GetDigests() {
-		if d.GetHash() == digest.EmptySha256 {
+		if d.GetHash() == digest.EmptyHashFor(digestFunction) {
 			rsp.Responses = append(rsp.Responses, &repb.BatchReadBlobsResponse_Response{
 				Digest: d,
 				Status: &statuspb.Status{Code: int32(codes.OK)},
@@ -323,9 +349,14 @@ func (s *ContentAddressableStorageServer) BatchReadBlobs(ctx context.Context, re
 			blobRsp.Status = &statuspb.Status{Code: int32(codes.Internal)}
 		} else {
 			blobRsp.Status = &statuspb.Status{Code: int32(codes.OK)}
-			if remote_cache_config.ZstdTranscodingEnabled() && clientAcceptsCompressor(req.AcceptableCompressors, repb.Compressor_ZSTD) {
-				blobRsp.Data = compression.CompressZstd(nil, blobRsp.Data)
-				blobRsp.Compressor = repb.Compressor_ZSTD
+			if compressor == repb.Compressor_ZSTD {
+				// Small or incompressible blobs can get larger when
+				// compressed; send those as-is, which clients must always
+				// accept.
+				if compressed := compression.CompressZstd(nil, blobRsp.Data); len(compressed) < len(blobRsp.Data) {
+					blobRsp.Data = compressed
+					blobRsp.Compressor = repb.Compressor_ZSTD
+				}
 			}
 		}
 
@@ -340,6 +371,16 @@ func (s *ContentAddressableStorageServer) BatchReadBlobs(ctx context.Context, re
 		alert.UnexpectedEvent("cas_batch_response_length_mismatch", "Unexpected batch read response length (%d expected, got %d)", len(closeTrackerFuncs), len(rsp.Responses))
 	}
 
+	// Only charge for the bytes that are actually returned, so that missing
+	// blobs don't count towards the download quota.
+	readSizeBytes := int64(0)
+	for _, blobRsp := range rsp.Responses {
+		readSizeBytes += int64(len(blobRsp.GetData()))
+	}
+	if err := quota.Allow(ctx, s.env, quota.DownloadBytesNamespace, readSizeBytes); err != nil {
+		return nil, err
+	}
+
 	return rsp, nil
 }
 
@@ -348,6 +389,15 @@ func (s *ContentAddressableStorageServer) supportsCompressor(compressor repb.Com
 		compressor == repb.Compressor_ZSTD && remote_cache_config.ZstdTranscodingEnabled()
 }
 
+// responseCompressor returns the compressor to use for blobs returned by
+// BatchReadBlobs, given the compressors accepted by the client.
+func (s *ContentAddressableStorageServer) responseCompressor(acceptableCompressors []repb.Compressor_Value) repb.Compressor_Value {
+	if s.supportsCompressor(repb.Compressor_ZSTD) && clientAcceptsCompressor(acceptableCompressors, repb.Compressor_ZSTD) {
+		return repb.Compressor_ZSTD
+	}
+	return repb.Compressor_IDENTITY
+}
+
 func clientAcceptsCompressor(acceptableCompressors []repb.Compressor_Value, compressor repb.Compressor_Value) bool {
 	// Per protocol, IDENTITY is always accepted.
 	if compressor == repb.Compressor_IDENTITY {
@@ -362,10 +412,16 @@ func clientAcceptsCompressor(acceptableCompressors []repb.Compressor_Value, comp
 }
 
 func zstdDecompress(data []byte, decompressedLength int64) ([]byte, error) {
-	buf := make([]byte, decompressedLength)
+	// The decompressed length comes from the client-provided digest, so don't
+	// trust it for more than the largest blob that could fit in a batch
+	// request; the buffer will grow if needed.
+	if decompressedLength > gRPCMaxSize {
+		decompressedLength = gRPCMaxSize
+	}
+	buf := make([]byte, 0, decompressedLength)
 	out, err := compression.DecompressZstd(buf, data)
 	if err != nil {
-		return nil, status.InternalErrorf("Failed to decompress zstd-compressed blob: %s", err)
+		return nil, status.DataLossErrorf("Failed to decompress zstd-compressed blob: %s", err)
 	}
 	return out, nil
 }
@@ -449,8 +505,8 @@ func (d *dirStack) SerializeToToken() (string, error) {
 	return token, nil
 }
 
-func (s *ContentAddressableStorageServer) fetchDir(ctx context.Context, cache interfaces.Cache, reqDigest *repb.Digest) (*repb.Directory, error) {
-	_, err := digest.Validate(reqDigest)
+func (s *ContentAddressableStorageServer) fetchDir(ctx context.Context, cache interfaces.Cache, reqDigest *repb.Digest, digestFunction repb.DigestFunction_Value) (*repb.Directory, error) {
+	_, err := digest.ValidateWithFunction(reqDigest, digestFunction)
 	if err != nil {
 		return nil, err
 	}
@@ -480,7 +536,7 @@ func (s *ContentAddressableStorageServer) fetchDirectory(ctx context.Context, ca
 	subdirDigests := make([]*repb.Digest, 0, len(dir.Directories))
 	for _, dirNode := range dir.Directories {
 		d := dirNode.GetDigest()
-		if d.GetHash() == digest.EmptySha256 {
+		if digest.IsEmptyHash(d) {
 			continue
 		}
 		subdirDigests = append(subdirDigests, d)
@@ -540,7 +596,8 @@ func (s *ContentAddressableStorageServer) GetTree(req *repb.GetTreeRequest, stre
 	if req.RootDigest == nil {
 		return status.InvalidArgumentError("RootDigest is required to GetTree")
 	}
-	if req.GetRootDigest().GetHash() == digest.EmptySha256 {
+	digestFunction := digest.InferDigestFunction(req.GetDigestFunction(), req.GetRootDigest())
+	if req.GetRootDigest().GetHash() == digest.EmptyHashFor(digestFunction) {
 		return nil
 	}
 
@@ -548,15 +605,15 @@ func (s *ContentAddressableStorageServer) GetTree(req *repb.GetTreeRequest, stre
 	if err != nil {
 		return err
 	}
-	cache, err := s.getCache(ctx, req.GetInstanceName())
+	cache, err := s.getCache(ctx, req.GetInstanceName(), digestFunction)
 	if err != nil {
 		return err
 	}
-	acCache, err := s.getACCache(ctx, req.GetInstanceName())
+	acCache, err := s.getACCache(ctx, req.GetInstanceName(), digestFunction)
 	if err != nil {
 		return err
 	}
-	rootDir, err := s.fetchDir(ctx, cache, req.GetRootDigest())
+	rootDir, err := s.fetchDir(ctx, cache, req.GetRootDigest(), digestFunction)
 	if err != nil {
 		return err
 	}
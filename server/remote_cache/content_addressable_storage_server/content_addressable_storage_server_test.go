@@ -22,6 +22,7 @@ import (
 	"github.com/buildbuddy-io/buildbuddy/server/util/bazel_request"
 	"github.com/buildbuddy-io/buildbuddy/server/util/compression"
 	"github.com/buildbuddy-io/buildbuddy/server/util/prefix"
+	"github.com/buildbuddy-io/buildbuddy/server/util/quota"
 	"github.com/buildbuddy-io/buildbuddy/server/util/testing/flags"
 	"github.com/google/uuid"
 	"github.com/stretchr/testify/assert"
@@ -69,6 +70,44 @@ func (e *evilCache) GetMulti(ctx context.Context, digests []*repb.Digest) (map[*
 	return rsp, err
 }
 
+// recordingQuotaManager records the quantities charged per namespace.
+type recordingQuotaManager struct {
+	interfaces.QuotaManager
+	charged map[string]int64
+}
+
+func (qm *recordingQuotaManager) Allow(ctx context.Context, namespace string, quantity int64) (bool, error) {
+	qm.charged[namespace] += quantity
+	return true, nil
+}
+
+func TestBatchReadBlobsChargesReturnedBytes(t *testing.T) {
+	ctx := context.Background()
+	te := testenv.GetTestEnv(t)
+	qm := &recordingQuotaManager{charged: map[string]int64{}}
+	te.SetQuotaManager(qm)
+	ctx, err := prefix.AttachUserPrefixToContext(ctx, te)
+	require.NoError(t, err)
+
+	clientConn := runCASServer(ctx, te, t)
+	casClient := repb.NewContentAddressableStorageClient(clientConn)
+
+	d, buf := testdigest.NewRandomDigestBuf(t, 100)
+	_, err = casClient.BatchUpdateBlobs(ctx, &repb.BatchUpdateBlobsRequest{
+		Requests: []*repb.BatchUpdateBlobsRequest_Request{{Digest: d, Data: buf}},
+	})
+	require.NoError(t, err)
+	missing, _ := testdigest.NewRandomDigestBuf(t, 1000)
+
+	rsp, err := casClient.BatchReadBlobs(ctx, &repb.BatchReadBlobsRequest{
+		Digests: []*repb.Digest{d, missing},
+	})
+	require.NoError(t, err)
+	require.Equal(t, int32(gcodes.OK), rsp.GetResponses()[0].GetStatus().GetCode())
+	require.Equal(t, int32(gcodes.NotFound), rsp.GetResponses()[1].GetStatus().GetCode())
+	require.EqualValues(t, 100, qm.charged[quota.DownloadBytesNamespace])
+}
+
 func TestBatchUpdateBlobs(t *testing.T) {
 	ctx := context.Background()
 	te := testenv.GetTestEnv(t)
@@ -223,6 +262,63 @@ func TestBatchUpdateRejectsCompressedBlobsIfCompressionDisabled(t *testing.T) {
 	}
 }
 
+func TestBatchReadBlobsSendsIncompressibleBlobsUncompressed(t *testing.T) {
+	ctx := context.Background()
+	te := testenv.GetTestEnv(t)
+	flags.Set(t, "cache.zstd_transcoding_enabled", true)
+	clientConn := runCASServer(ctx, te, t)
+	casClient := repb.NewContentAddressableStorageClient(clientConn)
+
+	// Random bytes don't compress, so the zstd frame would be larger than
+	// the blob itself.
+	d, buf := testdigest.NewRandomDigestBuf(t, 100)
+	_, err := casClient.BatchUpdateBlobs(ctx, &repb.BatchUpdateBlobsRequest{
+		Requests: []*repb.BatchUpdateBlobsRequest_Request{{Digest: d, Data: buf}},
+	})
+	require.NoError(t, err)
+
+	readResp, err := casClient.BatchReadBlobs(ctx, &repb.BatchReadBlobsRequest{
+		Digests:               []*repb.Digest{d},
+		AcceptableCompressors: []repb.Compressor_Value{repb.Compressor_ZSTD},
+	})
+	require.NoError(t, err)
+	require.Len(t, readResp.GetResponses(), 1)
+	assert.Equal(t, int32(codes.OK), readResp.GetResponses()[0].GetStatus().GetCode())
+	assert.Equal(t, repb.Compressor_IDENTITY, readResp.GetResponses()[0].GetCompressor())
+	assert.Equal(t, buf, readResp.GetResponses()[0].GetData())
+}
+
+func TestBatchUpdateRejectsCorruptCompressedBlobs(t *testing.T) {
+	ctx := context.Background()
+	te := testenv.GetTestEnv(t)
+	flags.Set(t, "cache.zstd_transcoding_enabled", true)
+	clientConn := runCASServer(ctx, te, t)
+	casClient := repb.NewContentAddressableStorageClient(clientConn)
+
+	blob := []byte("AAAAAAAAAAAAAAAAAAAAAAAAA")
+	d, err := digest.Compute(bytes.NewReader(blob))
+	require.NoError(t, err)
+	compressedBlob := compression.CompressZstd(nil, blob)
+
+	batchUpdateResp, err := casClient.BatchUpdateBlobs(ctx, &repb.BatchUpdateBlobsRequest{
+		Requests: []*repb.BatchUpdateBlobsRequest_Request{
+			// Not a valid zstd frame.
+			{Digest: d, Data: blob, Compressor: repb.Compressor_ZSTD},
+			// Valid zstd frame, but the digest claims a different size.
+			{Digest: &repb.Digest{Hash: d.GetHash(), SizeBytes: d.GetSizeBytes() + 1}, Data: compressedBlob, Compressor: repb.Compressor_ZSTD},
+		},
+	})
+	require.NoError(t, err)
+	require.Len(t, batchUpdateResp.GetResponses(), 2)
+	for i, resp := range batchUpdateResp.GetResponses() {
+		assert.Equal(t, int32(codes.DataLoss), resp.GetStatus().GetCode(), "BatchUpdateResponse[%d].Status != DataLoss", i)
+	}
+
+	missingResp, err := casClient.FindMissingBlobs(ctx, &repb.FindMissingBlobsRequest{BlobDigests: []*repb.Digest{d}})
+	require.NoError(t, err)
+	assert.Equal(t, digestStrings(d), digestStrings(missingResp.GetMissingBlobDigests()...))
+}
+
 func TestBatchUpdateRejectCorruptBlobs(t *testing.T) {
 	ctx := context.Background()
 	te := testenv.GetTestEnv(t)
@@ -265,6 +361,96 @@ func TestBatchUpdateRejectCorruptBlobs(t *testing.T) {
 	assert.Equal(t, int32(gcodes.OK), rsp.GetResponses()[2].GetStatus().GetCode())
 }
 
+func TestBatchUpdateAndReadBlobsWithDigestFunctions(t *testing.T) {
+	ctx := context.Background()
+	te := testenv.GetTestEnv(t)
+	clientConn := runCASServer(ctx, te, t)
+	casClient := repb.NewContentAddressableStorageClient(clientConn)
+	bsClient := bspb.NewByteStreamClient(clientConn)
+
+	for _, df := range []repb.DigestFunction_Value{
+		repb.DigestFunction_SHA1,
+		repb.DigestFunction_SHA384,
+		repb.DigestFunction_SHA512,
+		repb.DigestFunction_BLAKE3,
+	} {
+		t.Run(df.String(), func(t *testing.T) {
+			blob := []byte("hello " + df.String())
+			d, err := digest.ComputeWithFunction(bytes.NewReader(blob), df)
+			require.NoError(t, err)
+
+			updateRsp, err := casClient.BatchUpdateBlobs(ctx, &repb.BatchUpdateBlobsRequest{
+				Requests:       []*repb.BatchUpdateBlobsRequest_Request{{Digest: d, Data: blob}},
+				DigestFunction: df,
+			})
+			require.NoError(t, err)
+			require.Len(t, updateRsp.GetResponses(), 1)
+			assert.Equal(t, int32(gcodes.OK), updateRsp.GetResponses()[0].GetStatus().GetCode())
+
+			missingRsp, err := casClient.FindMissingBlobs(ctx, &repb.FindMissingBlobsRequest{
+				BlobDigests:    []*repb.Digest{d},
+				DigestFunction: df,
+			})
+			require.NoError(t, err)
+			assert.Empty(t, missingRsp.GetMissingBlobDigests())
+
+			readRsp, err := casClient.BatchReadBlobs(ctx, &repb.BatchReadBlobsRequest{
+				Digests:        []*repb.Digest{d},
+				DigestFunction: df,
+			})
+			require.NoError(t, err)
+			require.Len(t, readRsp.GetResponses(), 1)
+			assert.Equal(t, int32(gcodes.OK), readRsp.GetResponses()[0].GetStatus().GetCode())
+			assert.Equal(t, blob, readRsp.GetResponses()[0].GetData())
+
+			// The blob should also be readable via ByteStream.
+			rn := digest.NewResourceName(d, "")
+			rn.SetDigestFunction(df)
+			buf := &bytes.Buffer{}
+			err = cachetools.GetBlob(ctx, bsClient, rn, buf)
+			require.NoError(t, err)
+			assert.Equal(t, blob, buf.Bytes())
+		})
+	}
+}
+
+func TestDigestFunctionsAreIsolated(t *testing.T) {
+	ctx := context.Background()
+	te := testenv.GetTestEnv(t)
+	clientConn := runCASServer(ctx, te, t)
+	casClient := repb.NewContentAddressableStorageClient(clientConn)
+
+	// BLAKE3 and SHA256 hashes have the same length, so a BLAKE3 digest
+	// looked up without an explicit digest function is treated as SHA256 and
+	// must not match the BLAKE3 blob.
+	blob := []byte("some blob")
+	d, err := digest.ComputeWithFunction(bytes.NewReader(blob), repb.DigestFunction_BLAKE3)
+	require.NoError(t, err)
+	_, err = casClient.BatchUpdateBlobs(ctx, &repb.BatchUpdateBlobsRequest{
+		Requests:       []*repb.BatchUpdateBlobsRequest_Request{{Digest: d, Data: blob}},
+		DigestFunction: repb.DigestFunction_BLAKE3,
+	})
+	require.NoError(t, err)
+
+	missingRsp, err := casClient.FindMissingBlobs(ctx, &repb.FindMissingBlobsRequest{
+		BlobDigests: []*repb.Digest{d},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, digestStrings(d), digestStrings(missingRsp.GetMissingBlobDigests()...))
+
+	// Uploading bytes that don't hash to the digest under the requested
+	// function should fail.
+	sha256Digest, err := digest.Compute(bytes.NewReader(blob))
+	require.NoError(t, err)
+	updateRsp, err := casClient.BatchUpdateBlobs(ctx, &repb.BatchUpdateBlobsRequest{
+		Requests:       []*repb.BatchUpdateBlobsRequest_Request{{Digest: sha256Digest, Data: blob}},
+		DigestFunction: repb.DigestFunction_BLAKE3,
+	})
+	require.NoError(t, err)
+	require.Len(t, updateRsp.GetResponses(), 1)
+	assert.Equal(t, int32(gcodes.DataLoss), updateRsp.GetResponses()[0].GetStatus().GetCode())
+}
+
 func TestMalevolentCache(t *testing.T) {
 	ctx := context.Background()
 	te := testenv.GetTestEnv(t)
@@ -3,8 +3,11 @@ package digest
 import (
 	"bytes"
 	"context"
+	"crypto/sha1"
 	"crypto/sha256"
+	"crypto/sha512"
 	"fmt"
+	"hash"
 	"io"
 	"math/rand"
 	"path/filepath"
@@ -14,6 +17,7 @@ import (
 	"sync"
 
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
+	"github.com/zeebo/blake3"
 	"google.golang.org/genproto/googleapis/rpc/errdetails"
 	"google.golang.org/protobuf/proto"
 
@@ -26,25 +30,44 @@ import (
 )
 
 const (
-	hashKeyLength = 64
-	EmptySha256   = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
-	EmptyHash     = ""
+	EmptySha256 = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	EmptyHash   = ""
 )
 
 var (
 	// Cache keys must be:
 	//  - lower case
 	//  - ascii
-	//  - a sha256 sum
-	hashKeyRegex = regexp.MustCompile("^[a-f0-9]{64}$")
+	//  - a hex-encoded hash sum
+	hashKeyRegex = regexp.MustCompile("^[a-f0-9]+$")
 
 	// Matches:
 	// - "blobs/469db13020c60f8bdf9c89aa4e9a449914db23139b53a24d064f967a51057868/39120"
 	// - "blobs/ac/469db13020c60f8bdf9c89aa4e9a449914db23139b53a24d064f967a51057868/39120"
+	// - "blobs/blake3/8afb02ca7aace3ae5cd8748ac589e2e33022b1a4bfd22d5d234c5887e270fe9c/17997850"
 	// - "uploads/2042a8f9-eade-4271-ae58-f5f6f5a32555/blobs/8afb02ca7aace3ae5cd8748ac589e2e33022b1a4bfd22d5d234c5887e270fe9c/17997850"
-	uploadRegex      = regexp.MustCompile(`^(?:(?:(?P<instance_name>.*)/)?uploads/(?P<uuid>[a-f0-9-]{36})/)?(?P<blob_type>blobs|compressed-blobs/zstd)/(?P<hash>[a-f0-9]{64})/(?P<size>\d+)`)
-	downloadRegex    = regexp.MustCompile(`^(?:(?P<instance_name>.*)/)?(?P<blob_type>blobs|compressed-blobs/zstd)/(?P<hash>[a-f0-9]{64})/(?P<size>\d+)`)
-	actionCacheRegex = regexp.MustCompile(`^(?:(?P<instance_name>.*)/)?(?P<blob_type>blobs|compressed-blobs/zstd)/ac/(?P<hash>[a-f0-9]{64})/(?P<size>\d+)`)
+	uploadRegex      = regexp.MustCompile(`^(?:(?:(?P<instance_name>.*)/)?uploads/(?P<uuid>[a-f0-9-]{36})/)?(?P<blob_type>blobs|compressed-blobs/zstd)/(?:(?P<digest_function>sha1|sha256|sha384|sha512|blake3)/)?(?P<hash>[a-f0-9]{40,128})/(?P<size>\d+)`)
+	downloadRegex    = regexp.MustCompile(`^(?:(?P<instance_name>.*)/)?(?P<blob_type>blobs|compressed-blobs/zstd)/(?:(?P<digest_function>sha1|sha256|sha384|sha512|blake3)/)?(?P<hash>[a-f0-9]{40,128})/(?P<size>\d+)`)
+	actionCacheRegex = regexp.MustCompile(`^(?:(?P<instance_name>.*)/)?(?P<blob_type>blobs|compressed-blobs/zstd)/ac/(?:(?P<digest_function>sha1|sha256|sha384|sha512|blake3)/)?(?P<hash>[a-f0-9]{40,128})/(?P<size>\d+)`)
+
+	// hashFunctions lists the digest functions that the cache supports.
+	hashFunctions = map[repb.DigestFunction_Value]func() hash.Hash{
+		repb.DigestFunction_SHA256: sha256.New,
+		repb.DigestFunction_SHA1:   sha1.New,
+		repb.DigestFunction_SHA384: sha512.New384,
+		repb.DigestFunction_SHA512: sha512.New,
+		repb.DigestFunction_BLAKE3: func() hash.Hash { return blake3.New() },
+	}
+
+	// emptyHashes maps each supported digest function to the hash of the
+	// empty blob.
+	emptyHashes = func() map[repb.DigestFunction_Value]string {
+		m := make(map[repb.DigestFunction_Value]string, len(hashFunctions))
+		for df, newHash := range hashFunctions {
+			m[df] = fmt.Sprintf("%x", newHash().Sum(nil))
+		}
+		return m
+	}()
 )
 
 type ResourceName struct {
@@ -77,14 +100,24 @@ func (r *ResourceName) SetCompressor(compressor repb.Compressor_Value) {
 	r.rn.Compressor = compressor
 }
 
+// GetDigestFunction returns the digest function of the resource. If it was
+// not set explicitly, it is inferred from the length of the hash.
+func (r *ResourceName) GetDigestFunction() repb.DigestFunction_Value {
+	return InferDigestFunction(r.rn.GetDigestFunction(), r.GetDigest())
+}
+
+func (r *ResourceName) SetDigestFunction(digestFunction repb.DigestFunction_Value) {
+	r.rn.DigestFunction = digestFunction
+}
+
 // DownloadString returns a string representing the resource name for download
 // purposes.
 func (r *ResourceName) DownloadString() string {
 	// Normalize slashes, e.g. "//foo/bar//"" becomes "/foo/bar".
 	instanceName := filepath.Join(filepath.SplitList(r.GetInstanceName())...)
 	return fmt.Sprintf(
-		"%s/%s/%s/%d",
-		instanceName, blobTypeSegment(r.GetCompressor()),
+		"%s/%s/%s%s/%d",
+		instanceName, blobTypeSegment(r.GetCompressor()), r.digestFunctionSegment(),
 		r.GetDigest().GetHash(), r.GetDigest().GetSizeBytes())
 }
 
@@ -98,12 +131,23 @@ func (r *ResourceName) UploadString() (string, error) {
 		return "", err
 	}
 	return fmt.Sprintf(
-		"%s/uploads/%s/%s/%s/%d",
-		instanceName, u.String(), blobTypeSegment(r.GetCompressor()),
+		"%s/uploads/%s/%s/%s%s/%d",
+		instanceName, u.String(), blobTypeSegment(r.GetCompressor()), r.digestFunctionSegment(),
 		r.GetDigest().GetHash(), r.GetDigest().GetSizeBytes(),
 	), nil
 }
 
+// digestFunctionSegment returns the "<digest_function>/" resource name
+// segment, which is only needed when the digest function can't be inferred
+// from the length of the hash.
+func (r *ResourceName) digestFunctionSegment() string {
+	df := r.GetDigestFunction()
+	if df == InferDigestFunction(repb.DigestFunction_UNKNOWN, r.GetDigest()) {
+		return ""
+	}
+	return strings.ToLower(df.String()) + "/"
+}
+
 // Key is a representation of a digest that can be used as a map key.
 type Key struct {
 	Hash      string
@@ -117,18 +161,89 @@ func (dk Key) ToDigest() *repb.Digest {
 	return &repb.Digest{Hash: dk.Hash, SizeBytes: dk.SizeBytes}
 }
 
+// SupportedDigestFunctions returns the digest functions supported by the
+// cache, in order of preference.
+func SupportedDigestFunctions() []repb.DigestFunction_Value {
+	return []repb.DigestFunction_Value{
+		repb.DigestFunction_SHA256,
+		repb.DigestFunction_BLAKE3,
+		repb.DigestFunction_SHA1,
+		repb.DigestFunction_SHA384,
+		repb.DigestFunction_SHA512,
+	}
+}
+
+// HashForDigestFunction returns a new hash.Hash for the given digest function.
+func HashForDigestFunction(digestFunction repb.DigestFunction_Value) (hash.Hash, error) {
+	newHash, ok := hashFunctions[digestFunction]
+	if !ok {
+		return nil, status.InvalidArgumentErrorf("Unsupported digest function %s", digestFunction)
+	}
+	return newHash(), nil
+}
+
+// InferDigestFunction returns digestFunction if it is set. Otherwise, as
+// described by the REAPI, the digest function is inferred from the length of
+// the digest's hash, falling back to SHA256.
+func InferDigestFunction(digestFunction repb.DigestFunction_Value, d *repb.Digest) repb.DigestFunction_Value {
+	if digestFunction != repb.DigestFunction_UNKNOWN {
+		return digestFunction
+	}
+	switch len(d.GetHash()) {
+	case sha1.Size * 2:
+		return repb.DigestFunction_SHA1
+	case sha512.Size384 * 2:
+		return repb.DigestFunction_SHA384
+	case sha512.Size * 2:
+		return repb.DigestFunction_SHA512
+	default:
+		return repb.DigestFunction_SHA256
+	}
+}
+
+// EmptyHashFor returns the hash of the empty blob for the given digest function,
+// or "" if the digest function is unsupported.
+func EmptyHashFor(digestFunction repb.DigestFunction_Value) string {
+	return emptyHashes[digestFunction]
+}
+
+// IsEmptyHash returns whether d is the digest of the empty blob under any
+// supported digest function.
+func IsEmptyHash(d *repb.Digest) bool {
+	if d.GetSizeBytes() != 0 {
+		return false
+	}
+	for _, h := range emptyHashes {
+		if d.GetHash() == h {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns an error if d is not a valid SHA256 digest.
 func Validate(d *repb.Digest) (string, error) {
+	return ValidateWithFunction(d, repb.DigestFunction_SHA256)
+}
+
+// ValidateWithFunction returns an error if d is not a valid digest for the
+// given digest function.
+func ValidateWithFunction(d *repb.Digest, digestFunction repb.DigestFunction_Value) (string, error) {
 	if d == nil {
 		return "", status.InvalidArgumentError("Invalid (nil) Digest")
 	}
+	newHash, ok := hashFunctions[digestFunction]
+	if !ok {
+		return "", status.InvalidArgumentErrorf("Unsupported digest function %s", digestFunction)
+	}
 	if d.SizeBytes == int64(0) {
-		if d.Hash == EmptySha256 {
+		if d.Hash == emptyHashes[digestFunction] {
 			return "", status.OK()
 		}
-		return "", status.InvalidArgumentError("Invalid (zero-length) SHA256 hash")
+		return "", status.InvalidArgumentErrorf("Invalid (zero-length) %s hash", digestFunction)
 	}
 
-	if len(d.Hash) != hashKeyLength {
+	if hashKeyLength := newHash().Size() * 2; len(d.Hash) != hashKeyLength {
 		return "", status.InvalidArgumentError(fmt.Sprintf("Hash length was %d, expected %d", len(d.Hash), hashKeyLength))
 	}
 
@@ -147,7 +262,16 @@ func ComputeForMessage(in proto.Message) (*repb.Digest, error) {
 }
 
 func Compute(in io.Reader) (*repb.Digest, error) {
-	h := sha256.New()
+	return ComputeWithFunction(in, repb.DigestFunction_SHA256)
+}
+
+// ComputeWithFunction computes the digest of in using the given digest
+// function.
+func ComputeWithFunction(in io.Reader, digestFunction repb.DigestFunction_Value) (*repb.Digest, error) {
+	h, err := HashForDigestFunction(digestFunction)
+	if err != nil {
+		return nil, err
+	}
 	// Read file in 32KB chunks (default)
 	n, err := io.Copy(h, in)
 	if err != nil {
@@ -218,6 +342,12 @@ func parseResourceName(resourceName string, matcher *regexp.Regexp) (*ResourceNa
 	d := &repb.Digest{Hash: hash, SizeBytes: sizeBytes}
 	r := NewResourceName(d, instanceName)
 	r.SetCompressor(compressor)
+	if dfStr := result["digest_function"]; dfStr != "" {
+		r.SetDigestFunction(repb.DigestFunction_Value(repb.DigestFunction_Value_value[strings.ToUpper(dfStr)]))
+	}
+	if newHash, ok := hashFunctions[r.GetDigestFunction()]; !ok || len(hash) != newHash().Size()*2 {
+		return nil, status.InvalidArgumentErrorf("Unparsable resource name (invalid %s hash): %s", r.GetDigestFunction(), resourceName)
+	}
 	return r, nil
 }
 
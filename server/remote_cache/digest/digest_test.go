@@ -2,6 +2,7 @@ package digest
 
 import (
 	"regexp"
+	"strings"
 	"testing"
 
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
@@ -62,6 +63,31 @@ func TestParseResourceName(t *testing.T) {
 			matcher:      uploadRegex,
 			wantParsed:   NewResourceName(&repb.Digest{Hash: "072d9dd55aacaa829d7d1cc9ec8c4b5180ef49acac4a3c2f3ca16a3db134982d", SizeBytes: 1234}, "instance_name"),
 		},
+		{ // download, SHA1 hash
+			resourceName: "/blobs/ac6a0fd0ab5f5f7f4d3f5e5c9a9da1b1d3e0bdd5/1234",
+			matcher:      downloadRegex,
+			wantParsed:   NewResourceName(&repb.Digest{Hash: "ac6a0fd0ab5f5f7f4d3f5e5c9a9da1b1d3e0bdd5", SizeBytes: 1234}, ""),
+		},
+		{ // download, explicit BLAKE3 digest function
+			resourceName: "my_instance_name/blobs/blake3/072d9dd55aacaa829d7d1cc9ec8c4b5180ef49acac4a3c2f3ca16a3db134982d/1234",
+			matcher:      downloadRegex,
+			wantParsed:   newResourceNameWithFunction(&repb.Digest{Hash: "072d9dd55aacaa829d7d1cc9ec8c4b5180ef49acac4a3c2f3ca16a3db134982d", SizeBytes: 1234}, "my_instance_name", repb.DigestFunction_BLAKE3),
+		},
+		{ // download, digest function does not match hash length
+			resourceName: "/blobs/sha512/072d9dd55aacaa829d7d1cc9ec8c4b5180ef49acac4a3c2f3ca16a3db134982d/1234",
+			matcher:      downloadRegex,
+			wantError:    status.InvalidArgumentError(""),
+		},
+		{ // download, hash length does not match any digest function
+			resourceName: "/blobs/072d9dd55aacaa829d7d1cc9ec8c4b5180ef49acac4a3c2f3ca16a3db134982d00/1234",
+			matcher:      downloadRegex,
+			wantError:    status.InvalidArgumentError(""),
+		},
+		{ // upload, UUID and explicit BLAKE3 digest function
+			resourceName: "uploads/2148e1f1-aacc-41eb-a31c-22b6da7c7ac1/blobs/blake3/072d9dd55aacaa829d7d1cc9ec8c4b5180ef49acac4a3c2f3ca16a3db134982d/1234",
+			matcher:      uploadRegex,
+			wantParsed:   newResourceNameWithFunction(&repb.Digest{Hash: "072d9dd55aacaa829d7d1cc9ec8c4b5180ef49acac4a3c2f3ca16a3db134982d", SizeBytes: 1234}, "", repb.DigestFunction_BLAKE3),
+		},
 		{ // upload, UUID, instance name, and compression
 			resourceName: "instance_name/uploads/2148e1f1-aacc-41eb-a31c-22b6da7c7ac1/compressed-blobs/zstd/072d9dd55aacaa829d7d1cc9ec8c4b5180ef49acac4a3c2f3ca16a3db134982d/1234",
 			matcher:      uploadRegex,
@@ -78,7 +104,8 @@ func TestParseResourceName(t *testing.T) {
 			gotParsed.GetDigest().GetHash() != tc.wantParsed.GetDigest().GetHash() ||
 			gotParsed.GetDigest().GetSizeBytes() != tc.wantParsed.GetDigest().GetSizeBytes() ||
 			gotParsed.GetInstanceName() != tc.wantParsed.GetInstanceName() ||
-			gotParsed.GetCompressor() != tc.wantParsed.GetCompressor()) {
+			gotParsed.GetCompressor() != tc.wantParsed.GetCompressor() ||
+			gotParsed.GetDigestFunction() != tc.wantParsed.GetDigestFunction()) {
 			t.Errorf("parseResourceName(%q): got %+v; want %+v", tc.resourceName, gotParsed, tc.wantParsed)
 		}
 	}
@@ -90,6 +117,43 @@ func newZstdResourceName(d *repb.Digest, instanceName string) *ResourceName {
 	return r
 }
 
+func newResourceNameWithFunction(d *repb.Digest, instanceName string, digestFunction repb.DigestFunction_Value) *ResourceName {
+	r := NewResourceName(d, instanceName)
+	r.SetDigestFunction(digestFunction)
+	return r
+}
+
+func TestDigestFunctions(t *testing.T) {
+	for _, df := range SupportedDigestFunctions() {
+		d, err := ComputeWithFunction(strings.NewReader("hello world"), df)
+		require.NoError(t, err)
+		_, err = ValidateWithFunction(d, df)
+		require.NoError(t, err, df.String())
+
+		emptyDigest, err := ComputeWithFunction(strings.NewReader(""), df)
+		require.NoError(t, err)
+		require.Equal(t, EmptyHashFor(df), emptyDigest.GetHash())
+		require.True(t, IsEmptyHash(emptyDigest))
+
+		// Resource names should round-trip, including digest functions
+		// that can't be inferred from the hash length.
+		r := NewResourceName(d, "instance")
+		r.SetDigestFunction(df)
+		parsed, err := ParseDownloadResourceName(r.DownloadString())
+		require.NoError(t, err)
+		require.Equal(t, df, parsed.GetDigestFunction())
+		require.Equal(t, d.GetHash(), parsed.GetDigest().GetHash())
+	}
+
+	sha256Digest, err := Compute(strings.NewReader("hello world"))
+	require.NoError(t, err)
+	_, err = ValidateWithFunction(sha256Digest, repb.DigestFunction_SHA512)
+	require.True(t, status.IsInvalidArgumentError(err))
+	_, err = ValidateWithFunction(sha256Digest, repb.DigestFunction_MD5)
+	require.True(t, status.IsInvalidArgumentError(err))
+	require.Equal(t, "instance/blobs/"+sha256Digest.GetHash()+"/11", NewResourceName(sha256Digest, "instance").DownloadString())
+}
+
 func TestElementsMatch(t *testing.T) {
 	d1 := &repb.Digest{Hash: "1234", SizeBytes: 100}
 	d2 := &repb.Digest{Hash: "1111", SizeBytes: 10}
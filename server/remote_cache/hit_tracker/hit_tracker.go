@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"regexp"
 	"sort"
+	"strings"
 	"time"
 
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
@@ -377,6 +378,11 @@ func (t *transferTimer) CloseWithBytesTransferred(transferredSizeBytes int64, co
 	durationMetric(t.timeCounter).With(prometheus.Labels{
 		metrics.CacheTypeLabel: ct,
 	}).Observe(float64(dur.Microseconds()))
+	metrics.CacheTransferredSizeBytes.With(prometheus.Labels{
+		metrics.CacheTypeLabel:      ct,
+		metrics.CacheEventTypeLabel: et,
+		metrics.CompressorLabel:     strings.ToLower(compressor.String()),
+	}).Add(float64(transferredSizeBytes))
 
 	if err := h.recordCacheUsage(t.d, t.actionCounter); err != nil {
 		return err
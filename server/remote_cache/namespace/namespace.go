@@ -2,8 +2,14 @@ package namespace
 
 import (
 	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"strings"
 
 	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+
+	repb "github.com/buildbuddy-io/buildbuddy/proto/remote_execution"
 )
 
 func CASCache(ctx context.Context, cache interfaces.Cache, instanceName string) (interfaces.Cache, error) {
@@ -13,3 +19,169 @@ func CASCache(ctx context.Context, cache interfaces.Cache, instanceName string)
 func ActionCache(ctx context.Context, cache interfaces.Cache, instanceName string) (interfaces.Cache, error) {
 	return cache.WithIsolation(ctx, interfaces.ActionCacheType, instanceName)
 }
+
+// CASCacheWithDigestFunction is like CASCache, but additionally isolates
+// blobs hashed with the given digest function from blobs hashed with any
+// other digest function.
+func CASCacheWithDigestFunction(ctx context.Context, cache interfaces.Cache, instanceName string, digestFunction repb.DigestFunction_Value) (interfaces.Cache, error) {
+	c, err := CASCache(ctx, cache, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	return withDigestFunction(c, digestFunction), nil
+}
+
+// ActionCacheWithDigestFunction is like ActionCache, but additionally isolates
+// entries keyed with the given digest function from entries keyed with any
+// other digest function.
+func ActionCacheWithDigestFunction(ctx context.Context, cache interfaces.Cache, instanceName string, digestFunction repb.DigestFunction_Value) (interfaces.Cache, error) {
+	c, err := ActionCache(ctx, cache, instanceName)
+	if err != nil {
+		return nil, err
+	}
+	return withDigestFunction(c, digestFunction), nil
+}
+
+func withDigestFunction(cache interfaces.Cache, digestFunction repb.DigestFunction_Value) interfaces.Cache {
+	// SHA256 digests are stored as-is, so that data written before other
+	// digest functions were supported remains readable.
+	if digestFunction == repb.DigestFunction_UNKNOWN || digestFunction == repb.DigestFunction_SHA256 {
+		return cache
+	}
+	return &digestFunctionCache{
+		cache:  cache,
+		prefix: strings.ToLower(digestFunction.String()) + "/",
+	}
+}
+
+// digestFunctionCache stores each blob under a key derived by re-hashing the
+// digest function name together with the blob's hash. This guarantees that
+// digests computed with different functions never collide, without requiring
+// every cache backend to understand digest functions.
+type digestFunctionCache struct {
+	cache  interfaces.Cache
+	prefix string
+}
+
+func (c *digestFunctionCache) key(d *repb.Digest) *repb.Digest {
+	h := sha256.New()
+	h.Write([]byte(c.prefix))
+	h.Write([]byte(d.GetHash()))
+	return &repb.Digest{
+		Hash:      fmt.Sprintf("%x", h.Sum(nil)),
+		SizeBytes: d.GetSizeBytes(),
+	}
+}
+
+// keys returns the storage keys for the given digests, along with a map from
+// each storage key back to the digest it was derived from.
+func (c *digestFunctionCache) keys(digests []*repb.Digest) ([]*repb.Digest, map[*repb.Digest]*repb.Digest) {
+	keys := make([]*repb.Digest, 0, len(digests))
+	original := make(map[*repb.Digest]*repb.Digest, len(digests))
+	for _, d := range digests {
+		k := c.key(d)
+		keys = append(keys, k)
+		original[k] = d
+	}
+	return keys, original
+}
+
+func (c *digestFunctionCache) WithIsolation(ctx context.Context, cacheType interfaces.CacheType, remoteInstanceName string) (interfaces.Cache, error) {
+	isolated, err := c.cache.WithIsolation(ctx, cacheType, remoteInstanceName)
+	if err != nil {
+		return nil, err
+	}
+	return &digestFunctionCache{cache: isolated, prefix: c.prefix}, nil
+}
+
+func (c *digestFunctionCache) Contains(ctx context.Context, d *repb.Digest) (bool, error) {
+	return c.cache.Contains(ctx, c.key(d))
+}
+
+func (c *digestFunctionCache) Metadata(ctx context.Context, d *repb.Digest) (*interfaces.CacheMetadata, error) {
+	return c.cache.Metadata(ctx, c.key(d))
+}
+
+func (c *digestFunctionCache) FindMissing(ctx context.Context, digests []*repb.Digest) ([]*repb.Digest, error) {
+	keys, original := c.keys(digests)
+	missingKeys, err := c.cache.FindMissing(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	missing := make([]*repb.Digest, 0, len(missingKeys))
+	for _, k := range missingKeys {
+		if d, ok := original[k]; ok {
+			missing = append(missing, d)
+		} else {
+			// Some implementations may return copies of the requested
+			// digests, so fall back to comparing by value.
+			missing = append(missing, c.findOriginal(k, original))
+		}
+	}
+	return missing, nil
+}
+
+func (c *digestFunctionCache) findOriginal(k *repb.Digest, original map[*repb.Digest]*repb.Digest) *repb.Digest {
+	for key, d := range original {
+		if key.GetHash() == k.GetHash() && key.GetSizeBytes() == k.GetSizeBytes() {
+			return d
+		}
+	}
+	return k
+}
+
+func (c *digestFunctionCache) Get(ctx context.Context, d *repb.Digest) ([]byte, error) {
+	return c.cache.Get(ctx, c.key(d))
+}
+
+func (c *digestFunctionCache) GetMulti(ctx context.Context, digests []*repb.Digest) (map[*repb.Digest][]byte, error) {
+	keys, original := c.keys(digests)
+	found, err := c.cache.GetMulti(ctx, keys)
+	if err != nil {
+		return nil, err
+	}
+	rsp := make(map[*repb.Digest][]byte, len(found))
+	for k, data := range found {
+		d, ok := original[k]
+		if !ok {
+			d = c.findOriginal(k, original)
+		}
+		rsp[d] = data
+	}
+	return rsp, nil
+}
+
+func (c *digestFunctionCache) Set(ctx context.Context, d *repb.Digest, data []byte) error {
+	return c.cache.Set(ctx, c.key(d), data)
+}
+
+func (c *digestFunctionCache) SetMulti(ctx context.Context, kvs map[*repb.Digest][]byte) error {
+	keyed := make(map[*repb.Digest][]byte, len(kvs))
+	for d, data := range kvs {
+		keyed[c.key(d)] = data
+	}
+	return c.cache.SetMulti(ctx, keyed)
+}
+
+func (c *digestFunctionCache) Delete(ctx context.Context, d *repb.Digest) error {
+	return c.cache.Delete(ctx, c.key(d))
+}
+
+func (c *digestFunctionCache) Reader(ctx context.Context, d *repb.Digest, offset, limit int64) (io.ReadCloser, error) {
+	return c.cache.Reader(ctx, c.key(d), offset, limit)
+}
+
+func (c *digestFunctionCache) Writer(ctx context.Context, d *repb.Digest) (io.WriteCloser, error) {
+	return c.cache.Writer(ctx, c.key(d))
+}
+
+func (c *digestFunctionCache) CompressedReader(ctx context.Context, d *repb.Digest) (io.ReadCloser, repb.Compressor_Value, error) {
+	if cc, ok := c.cache.(interfaces.CompressedCache); ok {
+		return cc.CompressedReader(ctx, c.key(d))
+	}
+	r, err := c.cache.Reader(ctx, c.key(d), 0, 0)
+	if err != nil {
+		return nil, repb.Compressor_IDENTITY, err
+	}
+	return r, repb.Compressor_IDENTITY, nil
+}
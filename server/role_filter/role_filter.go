@@ -2,6 +2,7 @@ package role_filter
 
 import (
 	"context"
+	"sort"
 
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
 	"github.com/buildbuddy-io/buildbuddy/server/util/authutil"
@@ -24,7 +25,8 @@ var (
 		"CreateUser",
 		"GetGroup",
 		// Invocations can be shared publicly, so authorization for these RPCs is
-		// done purely using perms bits attached to each row.
+		// done using perms bits attached to each row. Group read access to a
+		// non-public invocation additionally requires ViewInvocations.
 		"GetInvocation",
 		"GetEventLogChunk",
 		"GetCacheScoreCard",
@@ -50,50 +52,57 @@ var (
 		"DeleteFile",
 	}
 
-	// DeveloperRPCs can be called only by developers or admins of the selected
-	// group.
-	GroupDeveloperRPCs = []string{
+	// GroupRPCCapabilities maps RPCs which operate on the selected group to
+	// the capabilities that the user must be granted within the group in order
+	// to call them.
+	GroupRPCCapabilities = map[string]role.Capability{
 		// Invocation history and historical data for the org
-		"SearchInvocation",
-		"GetInvocationStat",
-		"GetTrend",
+		"SearchInvocation":  role.ViewInvocations,
+		"GetInvocationStat": role.ViewInvocations,
+		"GetTrend":          role.ViewInvocations,
 		// Per-invocation actions
-		"UpdateInvocation",
-		"DeleteInvocation",
-		"CancelExecutions",
-		"ExecuteWorkflow",
-		// Setup
-		"GetApiKeys",
-		// Remote Bazel
-		"Run",
-	}
-
-	// AdminOnlyRPCs can only be called by admins of the selected group.
-	GroupAdminOnlyRPCs = []string{
+		"UpdateInvocation": role.UpdateInvocations,
+		"CancelExecutions": role.UpdateInvocations,
+		"DeleteInvocation": role.DeleteInvocations,
 		// Org details management
-		"UpdateGroup",
-		// Org members management
-		"GetGroupUsers",
-		"UpdateGroupUsers",
+		"UpdateGroup": role.ManageGroup,
+		// Org members and roles management
+		"GetGroupUsers":    role.ManageGroup,
+		"UpdateGroupUsers": role.ManageGroup,
+		"GetCustomRoles":   role.ManageGroup,
+		"CreateCustomRole": role.ManageGroup,
+		"UpdateCustomRole": role.ManageGroup,
+		"DeleteCustomRole": role.ManageGroup,
 		// Org GitHub account link management
-		"UnlinkGitHubAccount",
+		"UnlinkGitHubAccount": role.ManageGroup,
 		// API key management
-		"CreateApiKey",
-		"UpdateApiKey",
-		"DeleteApiKey",
+		"GetApiKeys":   role.ViewAPIKeys,
+		"CreateApiKey": role.ManageAPIKeys,
+		"UpdateApiKey": role.ManageAPIKeys,
+		"DeleteApiKey": role.ManageAPIKeys,
+		"RotateApiKey": role.ManageAPIKeys,
 		// Workflow management
-		"CreateWorkflow",
-		"DeleteWorkflow",
-		"GetWorkflows",
-		"GetRepos",
+		"ExecuteWorkflow": role.ExecuteWorkflows,
+		"CreateWorkflow":  role.ManageWorkflows,
+		"DeleteWorkflow":  role.ManageWorkflows,
+		"GetWorkflows":    role.ManageWorkflows,
+		"GetRepos":        role.ManageWorkflows,
 		// RBE deployment view
-		"GetExecutionNodes",
+		"GetExecutionNodes": role.ViewExecutionNodes,
 		// BuildBuddy usage data
-		"GetUsage",
+		"GetUsage": role.ViewUsage,
+		// Secret management
+		"ListSecrets":  role.ManageSecrets,
+		"CreateSecret": role.ManageSecrets,
+		"DeleteSecret": role.ManageSecrets,
+		// Remote Bazel
+		"Run": role.RemoteRun,
+		// Audit log of administrative actions
+		"GetAuditLog": role.ViewAuditLog,
 	}
 
 	// ServerAdminOnlyRPCs can only be called by server admins. It is different
-	// from AdminOnlyRPCs in that it requires the authenticated user to be an
+	// from group RPCs in that it requires the authenticated user to be an
 	// admin of the configured server-admin group, and not just an admin of
 	// their authenticated group.
 	ServerAdminOnlyRPCs = []string{
@@ -141,12 +150,23 @@ func AuthorizeRPC(ctx context.Context, env environment.Env, rpcName string) erro
 		return status.UnauthenticatedError("Could not determine authenticated group ID from request")
 	}
 
-	allowedRoles := role.Admin | role.Developer
-	if stringSliceContains(GroupAdminOnlyRPCs, rpcName) {
-		allowedRoles = role.Admin
-	}
+	// RPCs which don't require any particular capability can be called by any
+	// member of the group.
+	required := GroupRPCCapabilities[rpcName]
+	return authutil.AuthorizeGroupCapability(u, groupID, required)
+}
 
-	return authutil.AuthorizeGroupRole(u, groupID, allowedRoles)
+// GroupRPCsAllowedBy returns the group RPCs that may be called by a user who
+// has been granted the given capabilities, sorted by name.
+func GroupRPCsAllowedBy(caps role.Capability) []string {
+	rpcs := make([]string, 0, len(GroupRPCCapabilities))
+	for rpc, required := range GroupRPCCapabilities {
+		if caps&required == required {
+			rpcs = append(rpcs, rpc)
+		}
+	}
+	sort.Strings(rpcs)
+	return rpcs
 }
 
 func stringSliceContains(slice []string, val string) bool {
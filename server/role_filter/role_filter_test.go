@@ -5,6 +5,7 @@ import (
 	"testing"
 
 	"github.com/buildbuddy-io/buildbuddy/server/role_filter"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
 	"github.com/stretchr/testify/assert"
 
 	apipb "github.com/buildbuddy-io/buildbuddy/proto/api/v1"
@@ -24,8 +25,7 @@ func TestAllRPCsHaveExplicitRolesSpecified(t *testing.T) {
 
 	allDefinedMethods := []string{}
 	allDefinedMethods = append(allDefinedMethods, role_filter.RoleIndependentRPCs...)
-	allDefinedMethods = append(allDefinedMethods, role_filter.GroupAdminOnlyRPCs...)
-	allDefinedMethods = append(allDefinedMethods, role_filter.GroupDeveloperRPCs...)
+	allDefinedMethods = append(allDefinedMethods, role_filter.GroupRPCsAllowedBy(role.AllCapabilities)...)
 	allDefinedMethods = append(allDefinedMethods, role_filter.ServerAdminOnlyRPCs...)
 
 	assert.Subset(
@@ -38,3 +38,17 @@ func TestAllRPCsHaveExplicitRolesSpecified(t *testing.T) {
 			"(check for typos, or if you deleted an RPC, remove it from role_filter.go)",
 	)
 }
+
+func TestGroupRPCsAllowedBy(t *testing.T) {
+	assert.Empty(t, role_filter.GroupRPCsAllowedBy(0))
+
+	developerRPCs := role_filter.GroupRPCsAllowedBy(role.Capabilities(role.Developer, 0))
+	assert.Contains(t, developerRPCs, "SearchInvocation")
+	assert.Contains(t, developerRPCs, "GetApiKeys")
+	assert.NotContains(t, developerRPCs, "CreateApiKey")
+	assert.NotContains(t, developerRPCs, "GetUsage")
+	assert.NotContains(t, developerRPCs, "UpdateGroupUsers")
+
+	viewerRPCs := role_filter.GroupRPCsAllowedBy(role.Capabilities(role.Custom, role.ViewInvocations|role.ViewUsage))
+	assert.ElementsMatch(t, []string{"SearchInvocation", "GetInvocationStat", "GetTrend", "GetUsage"}, viewerRPCs)
+}
@@ -225,6 +225,10 @@ type UserGroup struct {
 	// Constants are defined in the perms package.
 	Role uint32
 
+	// The ID of the group's custom role assigned to the user, if Role is
+	// role.Custom.
+	CustomRoleID string `gorm:"not null;default:''"`
+
 	// The user's membership status.
 	// Values correspond to `GroupMembershipStatus` enum values in `grp.proto`.
 	MembershipStatus int32 `gorm:"index:membership_status_index"`
@@ -237,6 +241,9 @@ func (ug *UserGroup) TableName() string {
 type GroupRole struct {
 	Group Group
 	Role  uint32
+	// CustomCapabilities are the capabilities of the user's custom role, if
+	// Role is role.Custom.
+	CustomCapabilities uint32
 }
 
 type User struct {
@@ -327,12 +334,74 @@ type APIKey struct {
 	// migrate old DB rows to reflect the new default.
 	Capabilities        int32 `gorm:"default:1"`
 	VisibleToDevelopers bool  `gorm:"not null;default:0;type:tinyint(1)"`
+
+	// The time after which the key can no longer be used, in microseconds
+	// since the Unix epoch. Zero if the key never expires.
+	ExpiryUsec int64 `gorm:"not null;default:0"`
+
+	// The approximate time at which the key was last used to authenticate a
+	// request, and the IP address of the client that used it.
+	LastUsedUsec int64 `gorm:"not null;default:0"`
+	LastUsedIP   string
 }
 
 func (k *APIKey) TableName() string {
 	return "APIKeys"
 }
 
+// CustomRole is a group-defined role which grants a configurable set of
+// capabilities to the group members assigned to it.
+type CustomRole struct {
+	Model
+	RoleID  string `gorm:"primaryKey"`
+	GroupID string `gorm:"index:custom_role_group_id_index"`
+	// The user-specified name of the role.
+	Name string
+	// Bitmask of capabilities granted by this role.
+	// Constants are defined in the role package.
+	Capabilities uint32
+}
+
+func (*CustomRole) TableName() string {
+	return "CustomRoles"
+}
+
+// AuditLog is a record of an administrative action performed on a resource
+// owned by a group.
+type AuditLog struct {
+	Model
+	AuditLogID    string `gorm:"primaryKey"`
+	GroupID       string `gorm:"index:audit_log_group_time_index,priority:1"`
+	EventTimeUsec int64  `gorm:"index:audit_log_group_time_index,priority:2"`
+
+	// The principal that performed the action.
+	AuthUserID        string
+	AuthUserEmail     string
+	AuthImpersonating bool `gorm:"not null;default:0;type:tinyint(1)"`
+
+	// The resource that the action was performed on.
+	// Values correspond to `ResourceType` enum values in `auditlog.proto`.
+	ResourceType int32
+	ResourceID   string
+	ResourceName string
+
+	// Values correspond to `Action` enum values in `auditlog.proto`.
+	Action int32
+
+	// JSON-encoded protos describing the resource before and after the
+	// action.
+	BeforeJSON string `gorm:"type:text"`
+	AfterJSON  string `gorm:"type:text"`
+
+	// Request metadata.
+	ClientIP  string
+	UserAgent string
+}
+
+func (*AuditLog) TableName() string {
+	return "AuditLogs"
+}
+
 type Execution struct {
 	// The subscriber ID, a concatenated string of the
 	// auth Issuer ID and the subcriber ID string.
@@ -340,6 +409,7 @@ type Execution struct {
 	UserID      string `gorm:"index:executions_user_id"`
 	GroupID     string `gorm:"index:executions_group_id"`
 	Worker      string
+	ExecutorID  string
 	// Command Snippet
 	CommandSnippet          string
 	InvocationID            string `gorm:"index:executions_invocation_id_stage"`
@@ -493,6 +563,11 @@ type Workflow struct {
 	// registering the webhook. This will only be set for the case where we
 	// successfully auto-registered the webhook.
 	GitProviderWebhookID string
+	// HasSchedule is whether the workflow's config had actions with schedule
+	// triggers when it was last checked. Only these workflows are checked by
+	// the scheduler. New workflows start out with this set, so that the
+	// scheduler checks their config at least once.
+	HasSchedule bool `gorm:"not null;default:1;type:tinyint(1)"`
 }
 
 func (wf *Workflow) TableName() string {
@@ -574,6 +649,10 @@ type QuotaBucket struct {
 	// The number of requests that will be allowed to exceed the rate in a single
 	// burst and must be non-negative.
 	MaxBurst int64
+
+	// The maximum total quantity a quota key may hold at once. If set, the
+	// bucket limits an amount, like stored bytes, rather than a rate.
+	MaxQuantity int64
 }
 
 func (*QuotaBucket) TableName() string {
@@ -596,6 +675,21 @@ func (*QuotaGroup) TableName() string {
 	return "QuotaGroups"
 }
 
+// Secret is an encrypted value owned by a group, which can be exposed to the
+// group's workflow actions.
+type Secret struct {
+	Model
+	GroupID string `gorm:"primaryKey"`
+	Name    string `gorm:"primaryKey"`
+	// Value is the encrypted secret value. See enterprise/server/secrets for
+	// the encryption format.
+	Value []byte
+}
+
+func (*Secret) TableName() string {
+	return "Secrets"
+}
+
 type PostAutoMigrateLogic func() error
 
 // Manual migration called before auto-migration.
@@ -998,4 +1092,7 @@ func init() {
 	registerTable("UA", &Usage{})
 	registerTable("QB", &QuotaBucket{})
 	registerTable("QG", &QuotaGroup{})
+	registerTable("SC", &Secret{})
+	registerTable("RL", &CustomRole{})
+	registerTable("AL", &AuditLog{})
 }
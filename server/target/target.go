@@ -244,7 +244,7 @@ func readPaginatedTargets(ctx context.Context, env environment.Env, req *trpb.Ge
 	if repo != "" {
 		joinQuery.AddWhereClause("inv.repo_url = ?", repo)
 	}
-	if err := perms.AddPermissionsCheckToQueryWithTableAlias(ctx, env, joinQuery, "inv"); err != nil {
+	if err := perms.AddInvocationPermissionsCheckToQueryWithTableAlias(ctx, env, joinQuery, "inv"); err != nil {
 		return nil, err
 	}
 
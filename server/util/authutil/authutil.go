@@ -26,3 +26,50 @@ func AuthorizeGroupRole(u interfaces.UserInfo, groupID string, allowedRoles role
 	}
 	return nil
 }
+
+// IsServerAdmin returns whether the given user is a global administrator or an
+// admin of the server admin group, if one is configured.
+func IsServerAdmin(u interfaces.UserInfo, serverAdminGroupID string) bool {
+	if u.IsAdmin() {
+		return true
+	}
+	if serverAdminGroupID == "" {
+		return false
+	}
+	for _, m := range u.GetGroupMemberships() {
+		if m.GroupID == serverAdminGroupID && m.Role == role.Admin {
+			return true
+		}
+	}
+	return false
+}
+
+// GroupCapabilities returns the capabilities granted to the given user within
+// the given group.
+func GroupCapabilities(u interfaces.UserInfo, groupID string) role.Capability {
+	for _, m := range u.GetGroupMemberships() {
+		if m.GroupID == groupID {
+			return role.Capabilities(m.Role, m.CustomCapabilities)
+		}
+	}
+	return 0
+}
+
+// AuthorizeGroupCapability checks whether the given user has been granted all
+// of the given capabilities within the given group.
+func AuthorizeGroupCapability(u interfaces.UserInfo, groupID string, required role.Capability) error {
+	isMember := false
+	for _, m := range u.GetGroupMemberships() {
+		if m.GroupID == groupID {
+			isMember = m.Role != role.None
+			break
+		}
+	}
+	if !isMember {
+		return status.PermissionDeniedError("You do not have access to the requested organization")
+	}
+	if GroupCapabilities(u, groupID)&required != required {
+		return status.PermissionDeniedError("You do not have the appropriate role within this organization")
+	}
+	return nil
+}
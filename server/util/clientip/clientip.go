@@ -0,0 +1,56 @@
+package clientip
+
+import (
+	"context"
+	"flag"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+var (
+	trustedProxyHops = flag.Int("app.trusted_proxy_hops", 1, "The number of trusted proxies in front of the app that append the address of their client to the X-Forwarded-For header. Entries to the left of those appended by trusted proxies are controlled by the client and are ignored.")
+)
+
+// Get returns the IP address of the client that sent the request being served
+// with the given context, or "" if it is unknown. For requests forwarded by
+// trusted proxies, this is the address of the client as seen by the outermost
+// trusted proxy.
+func Get(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok && *trustedProxyHops > 0 {
+		var ips []string
+		for _, val := range md.Get("X-Forwarded-For") {
+			for _, ip := range strings.Split(val, ",") {
+				if ip = strings.TrimSpace(ip); ip != "" {
+					ips = append(ips, ip)
+				}
+			}
+		}
+		if len(ips) > 0 {
+			// Each trusted proxy appends the address of its client, so the
+			// client's address is the one appended by the outermost trusted
+			// proxy. If there are fewer entries than trusted proxies, the
+			// leftmost entry was still appended by a trusted proxy.
+			i := len(ips) - *trustedProxyHops
+			if i < 0 {
+				i = 0
+			}
+			return stripPort(ips[i])
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		return stripPort(p.Addr.String())
+	}
+	return ""
+}
+
+// stripPort returns the host part of addr if it is a host:port pair, or addr
+// itself otherwise.
+func stripPort(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
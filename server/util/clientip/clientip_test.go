@@ -0,0 +1,47 @@
+package clientip_test
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/buildbuddy-io/buildbuddy/server/util/clientip"
+	"github.com/buildbuddy-io/buildbuddy/server/util/testing/flags"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+func TestGet(t *testing.T) {
+	ctx := context.Background()
+	require.Equal(t, "", clientip.Get(ctx))
+
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1234}})
+	require.Equal(t, "10.0.0.2", clientip.Get(ctx))
+
+	ctx = peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("::1"), Port: 1234}})
+	require.Equal(t, "::1", clientip.Get(ctx))
+
+	// The leftmost entry is controlled by the client, so the entry appended by
+	// the trusted proxy is used.
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("X-Forwarded-For", "1.2.3.4, 10.0.0.1"))
+	require.Equal(t, "10.0.0.1", clientip.Get(ctx))
+
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("X-Forwarded-For", "1.2.3.4:5678"))
+	require.Equal(t, "1.2.3.4", clientip.Get(ctx))
+}
+
+func TestGet_TrustedProxyHops(t *testing.T) {
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.2"), Port: 1234}})
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("X-Forwarded-For", "5.6.7.8, 1.2.3.4, 10.0.0.1"))
+
+	flags.Set(t, "app.trusted_proxy_hops", 2)
+	require.Equal(t, "1.2.3.4", clientip.Get(ctx))
+
+	flags.Set(t, "app.trusted_proxy_hops", 5)
+	require.Equal(t, "5.6.7.8", clientip.Get(ctx))
+
+	// With no trusted proxies, the header is ignored entirely.
+	flags.Set(t, "app.trusted_proxy_hops", 0)
+	require.Equal(t, "10.0.0.2", clientip.Get(ctx))
+}
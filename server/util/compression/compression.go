@@ -151,6 +151,72 @@ func NewZstdChunkingCompressor(reader io.Reader, readBuf []byte, compressBuf []b
 	return pr, nil
 }
 
+type zstdCompressor struct {
+	encoder *zstd.Encoder
+}
+
+// NewZstdCompressingWriter returns a WriteCloser that zstd-compresses the bytes
+// written to it and writes the compressed stream to the given writer. Close
+// must be called to flush the remaining compressed bytes; it does not close
+// the given writer.
+func NewZstdCompressingWriter(writer io.Writer) (io.WriteCloser, error) {
+	enc, err := zstd.NewWriter(writer, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	return &zstdCompressor{encoder: enc}, nil
+}
+
+func (c *zstdCompressor) Write(p []byte) (int, error) {
+	return c.encoder.Write(p)
+}
+
+func (c *zstdCompressor) Close() error {
+	return c.encoder.Close()
+}
+
+type zstdDecompressingReader struct {
+	io.Reader
+	decoder *DecoderRef
+	closer  io.Closer
+}
+
+// NewZstdDecompressingReader returns a ReadCloser that decompresses the zstd
+// stream read from the given reader. The first offset decompressed bytes are
+// skipped, and if limit is greater than 0, at most limit bytes are returned.
+// Closing the returned reader closes the given reader.
+func NewZstdDecompressingReader(reader io.ReadCloser, offset, limit int64) (io.ReadCloser, error) {
+	decoder, err := zstdDecoderPool.Get(reader)
+	if err != nil {
+		return nil, err
+	}
+	r := &zstdDecompressingReader{
+		Reader:  decoder,
+		decoder: decoder,
+		closer:  reader,
+	}
+	if offset > 0 {
+		if _, err := io.CopyN(io.Discard, decoder, offset); err != nil {
+			r.Close()
+			return nil, err
+		}
+	}
+	if limit > 0 {
+		r.Reader = io.LimitReader(decoder, limit)
+	}
+	return r, nil
+}
+
+func (r *zstdDecompressingReader) Close() error {
+	if r.decoder != nil {
+		if err := zstdDecoderPool.Put(r.decoder); err != nil {
+			log.Errorf("Failed to return zstd decoder to pool: %s", err)
+		}
+		r.decoder = nil
+	}
+	return r.closer.Close()
+}
+
 // DecoderRef wraps a *zstd.Decoder. Since it does not directly start any
 // goroutines, it can be garbage collected before the wrapped decoder can.
 // When garbage collected, a finalizer automatically closes the wrapped decoder,
@@ -7,8 +7,10 @@ import (
 
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
 	"github.com/buildbuddy-io/buildbuddy/server/interfaces"
+	"github.com/buildbuddy-io/buildbuddy/server/util/authutil"
 	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 	"github.com/buildbuddy-io/buildbuddy/server/util/query_builder"
+	"github.com/buildbuddy-io/buildbuddy/server/util/role"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
 
 	aclpb "github.com/buildbuddy-io/buildbuddy/proto/acl"
@@ -140,6 +142,28 @@ func AuthorizeRead(authenticatedUser *interfaces.UserInfo, acl *aclpb.ACL) error
 	return status.PermissionDeniedError("You do not have permission to perform this action.")
 }
 
+// AuthorizeInvocationRead is like AuthorizeRead, but additionally requires the
+// ViewInvocations capability when the user can only read a non-public
+// invocation through their membership in the invocation's group.
+func AuthorizeInvocationRead(authenticatedUser *interfaces.UserInfo, acl *aclpb.ACL) error {
+	if err := AuthorizeRead(authenticatedUser, acl); err != nil {
+		return err
+	}
+	u := *authenticatedUser
+	perms, err := FromACL(acl)
+	if err != nil {
+		return err
+	}
+	if perms&OTHERS_READ != 0 || u.IsAdmin() {
+		return nil
+	}
+	isOwner := u.GetUserID() == acl.GetUserId().GetId()
+	if isOwner && perms&OWNER_READ != 0 {
+		return nil
+	}
+	return authutil.AuthorizeGroupCapability(u, acl.GetGroupId(), role.ViewInvocations)
+}
+
 func AuthorizeWrite(authenticatedUser *interfaces.UserInfo, acl *aclpb.ACL) error {
 	if authenticatedUser == nil {
 		return status.InvalidArgumentError("authenticatedUser cannot be nil.")
@@ -187,6 +211,34 @@ func AddPermissionsCheckToQueryWithTableAlias(ctx context.Context, env environme
 }
 
 func GetPermissionsCheckClauses(ctx context.Context, env environment.Env, q *query_builder.Query, tableAlias string) (*query_builder.OrClauses, error) {
+	return getPermissionsCheckClauses(ctx, env, tableAlias, 0)
+}
+
+// AddInvocationPermissionsCheckToQueryWithTableAlias is like
+// AddPermissionsCheckToQueryWithTableAlias, but only grants group read access
+// to invocations of groups in which the user has the ViewInvocations
+// capability.
+func AddInvocationPermissionsCheckToQueryWithTableAlias(ctx context.Context, env environment.Env, q *query_builder.Query, tableAlias string) error {
+	o, err := GetInvocationPermissionsCheckClauses(ctx, env, q, tableAlias)
+	if err != nil {
+		return err
+	}
+	orQuery, orArgs := o.Build()
+	q = q.AddWhereClause("("+orQuery+")", orArgs...)
+	return nil
+}
+
+// GetInvocationPermissionsCheckClauses is like GetPermissionsCheckClauses, but
+// only grants group read access to invocations of groups in which the user has
+// the ViewInvocations capability.
+func GetInvocationPermissionsCheckClauses(ctx context.Context, env environment.Env, q *query_builder.Query, tableAlias string) (*query_builder.OrClauses, error) {
+	return getPermissionsCheckClauses(ctx, env, tableAlias, role.ViewInvocations)
+}
+
+// getPermissionsCheckClauses returns clauses matching rows readable by the
+// authenticated user. Group read access is only granted for groups in which
+// the user has all of the given capabilities.
+func getPermissionsCheckClauses(ctx context.Context, env environment.Env, tableAlias string, groupCapabilities role.Capability) (*query_builder.OrClauses, error) {
 	tablePrefix := ""
 	if tableAlias != "" {
 		tablePrefix = tableAlias + "."
@@ -204,14 +256,19 @@ func GetPermissionsCheckClauses(ctx context.Context, env environment.Env, q *que
 				}
 				groupParams := make([]string, 0)
 				for _, groupID := range u.GetAllowedGroups() {
+					if authutil.GroupCapabilities(u, groupID)&groupCapabilities != groupCapabilities {
+						continue
+					}
 					groupArgs = append(groupArgs, groupID)
 					groupParams = append(groupParams, "?")
 				}
-				groupParamString := "(" + strings.Join(groupParams, ", ") + ")"
-				groupQueryStr := fmt.Sprintf("(%sperms & ? != 0 AND %sgroup_id IN %s)", tablePrefix, tablePrefix, groupParamString)
-				o.AddOr(groupQueryStr, groupArgs...)
+				if len(groupParams) > 0 {
+					groupParamString := "(" + strings.Join(groupParams, ", ") + ")"
+					groupQueryStr := fmt.Sprintf("(%sperms & ? != 0 AND %sgroup_id IN %s)", tablePrefix, tablePrefix, groupParamString)
+					o.AddOr(groupQueryStr, groupArgs...)
+				}
 				o.AddOr(fmt.Sprintf("(%sperms & ? != 0 AND %suser_id = ?)", tablePrefix, tablePrefix), OWNER_READ, u.GetUserID())
-			} else if u.GetGroupID() != "" {
+			} else if u.GetGroupID() != "" && authutil.GroupCapabilities(u, u.GetGroupID())&groupCapabilities == groupCapabilities {
 				groupArgs := []interface{}{
 					GROUP_READ,
 					u.GetGroupID(),
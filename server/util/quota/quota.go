@@ -2,11 +2,24 @@ package quota
 
 import (
 	"context"
-	"strings"
 
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
+	"github.com/buildbuddy-io/buildbuddy/server/util/clientip"
+	"github.com/buildbuddy-io/buildbuddy/server/util/log"
 	"github.com/buildbuddy-io/buildbuddy/server/util/status"
-	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// UploadBytesNamespace limits the rate of bytes uploaded to the cache.
+	UploadBytesNamespace = "/cache/upload_bytes"
+
+	// DownloadBytesNamespace limits the rate of bytes downloaded from the
+	// cache.
+	DownloadBytesNamespace = "/cache/download_bytes"
+
+	// StoredBytesNamespace limits the total number of bytes stored in the
+	// cache. Buckets in this namespace must set max_quantity.
+	StoredBytesNamespace = "/cache/stored_bytes"
 )
 
 func getGroupID(ctx context.Context, env environment.Env) string {
@@ -20,30 +33,35 @@ func getGroupID(ctx context.Context, env environment.Env) string {
 	return ""
 }
 
-func getIP(ctx context.Context) string {
-	md, ok := metadata.FromIncomingContext(ctx)
-	if !ok {
-		return ""
-	}
-
-	vals := md.Get("X-Forwarded-For")
-
-	if len(vals) == 0 {
-		return ""
-	}
-	ips := strings.Split(vals[0], ",")
-	return ips[0]
-}
-
 // GetKey gets the key for quota accounting from the context.
 // If available, group_id is used, otherwise the key falls back to the ip address.
 func GetKey(ctx context.Context, env environment.Env) (string, error) {
 	if groupID := getGroupID(ctx, env); groupID != "" {
 		return groupID, nil
 	}
-	if ip := getIP(ctx); ip != "" {
+	if ip := clientip.Get(ctx); ip != "" {
 		return ip, nil
 	}
 	return "", status.InternalErrorf("quota key is empty")
 
 }
+
+// Allow charges quantity against the quota of the user (identified from the
+// ctx) in the namespace, and returns a ResourceExhausted error if the quota
+// has been exceeded. If no quota manager is configured, or the quota manager
+// fails, the request is allowed.
+func Allow(ctx context.Context, env environment.Env, namespace string, quantity int64) error {
+	qm := env.GetQuotaManager()
+	if qm == nil || quantity <= 0 {
+		return nil
+	}
+	allow, err := qm.Allow(ctx, namespace, quantity)
+	if err != nil {
+		log.Warningf("Quota Manager failed: %s", err)
+		return nil
+	}
+	if !allow {
+		return status.ResourceExhaustedErrorf("Quota exceeded for %s", namespace)
+	}
+	return nil
+}
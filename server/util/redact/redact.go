@@ -3,6 +3,7 @@ package redact
 import (
 	"context"
 	"regexp"
+	"sort"
 	"strings"
 
 	"github.com/buildbuddy-io/buildbuddy/server/environment"
@@ -62,6 +63,25 @@ func stripURLSecrets(input string) string {
 	return urlSecretRegex.ReplaceAllString(input, "")
 }
 
+// RedactSecrets replaces all occurrences of the given secret values in text
+// with a placeholder. Longer secrets are redacted first, so that a secret which
+// contains another secret is fully redacted.
+func RedactSecrets(text string, secrets []string) string {
+	sorted := make([]string, 0, len(secrets))
+	for _, s := range secrets {
+		if s != "" {
+			sorted = append(sorted, s)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return len(sorted[i]) > len(sorted[j])
+	})
+	for _, s := range sorted {
+		text = strings.ReplaceAll(text, s, envVarRedactedPlaceholder)
+	}
+	return text
+}
+
 func stripURLSecretsFromCmdLine(tokens []string) {
 	for i, token := range tokens {
 		// Prevent flag name from being included in redaction pattern.
@@ -260,3 +260,21 @@ func TestRedactMetadata_WorkspaceStatus_StripsRepoURLCredentials(t *testing.T) {
 	require.Equal(t, 1, len(workspaceStatus.Item))
 	assert.Equal(t, "https://github.com/buildbuddy-io/metadata_repo_url", workspaceStatus.Item[0].Value)
 }
+
+func TestRedactSecrets(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		text     string
+		secrets  []string
+		expected string
+	}{
+		{"no secrets", "token=abc123", nil, "token=abc123"},
+		{"empty secret is ignored", "token=abc123", []string{""}, "token=abc123"},
+		{"all occurrences", "abc123 and abc123\n", []string{"abc123"}, "<REDACTED> and <REDACTED>\n"},
+		{"overlapping secrets", "token=abc123", []string{"abc", "abc123"}, "token=<REDACTED>"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, redact.RedactSecrets(tc.text, tc.secrets))
+		})
+	}
+}
@@ -1,6 +1,8 @@
 package role
 
 import (
+	"sort"
+
 	grpb "github.com/buildbuddy-io/buildbuddy/proto/group"
 )
 
@@ -17,6 +19,9 @@ const (
 	Developer Role = 1 << 0
 	// Admin means a user has unrestricted access within a group.
 	Admin Role = 1 << 1
+	// Custom means a user is granted exactly the capabilities of the group's
+	// custom role that they are assigned to.
+	Custom Role = 1 << 2
 
 	// DefaultRole is the role assigned to users when joining a group they did
 	// not create.
@@ -30,12 +35,90 @@ func ToProto(role Role) grpb.Group_Role {
 	if role&Admin == Admin {
 		return grpb.Group_ADMIN_ROLE
 	}
+	if role&Custom == Custom {
+		return grpb.Group_CUSTOM_ROLE
+	}
 	return grpb.Group_DEVELOPER_ROLE
 }
 
 func FromProto(role grpb.Group_Role) Role {
-	if role == grpb.Group_ADMIN_ROLE {
+	switch role {
+	case grpb.Group_ADMIN_ROLE:
 		return Admin
+	case grpb.Group_CUSTOM_ROLE:
+		return Custom
+	default:
+		return Developer
+	}
+}
+
+// Capability is a bitmask of actions that a user is authorized to perform
+// within a group. Each bit corresponds to a grp.Capability enum value.
+type Capability uint32
+
+const (
+	ViewInvocations    = Capability(1 << grpb.Capability_VIEW_INVOCATIONS)
+	UpdateInvocations  = Capability(1 << grpb.Capability_UPDATE_INVOCATIONS)
+	DeleteInvocations  = Capability(1 << grpb.Capability_DELETE_INVOCATIONS)
+	ExecuteWorkflows   = Capability(1 << grpb.Capability_EXECUTE_WORKFLOWS)
+	ManageWorkflows    = Capability(1 << grpb.Capability_MANAGE_WORKFLOWS)
+	ViewAPIKeys        = Capability(1 << grpb.Capability_VIEW_API_KEYS)
+	ManageAPIKeys      = Capability(1 << grpb.Capability_MANAGE_API_KEYS)
+	ViewUsage          = Capability(1 << grpb.Capability_VIEW_USAGE)
+	ManageGroup        = Capability(1 << grpb.Capability_MANAGE_GROUP)
+	ManageSecrets      = Capability(1 << grpb.Capability_MANAGE_SECRETS)
+	ViewExecutionNodes = Capability(1 << grpb.Capability_VIEW_EXECUTION_NODES)
+	RemoteRun          = Capability(1 << grpb.Capability_REMOTE_RUN)
+	ViewAuditLog       = Capability(1 << grpb.Capability_VIEW_AUDIT_LOG)
+
+	// AllCapabilities are granted to group admins.
+	AllCapabilities = ViewInvocations | UpdateInvocations | DeleteInvocations |
+		ExecuteWorkflows | ManageWorkflows | ViewAPIKeys | ManageAPIKeys |
+		ViewUsage | ManageGroup | ManageSecrets | ViewExecutionNodes | RemoteRun |
+		ViewAuditLog
+
+	// DeveloperCapabilities are granted to group developers.
+	DeveloperCapabilities = ViewInvocations | UpdateInvocations |
+		DeleteInvocations | ExecuteWorkflows | ViewAPIKeys | RemoteRun
+)
+
+// Capabilities returns the capabilities granted by the given role. The
+// capabilities of a custom role are stored alongside the role and must be
+// passed as customCapabilities; they are ignored for built-in roles.
+func Capabilities(r Role, customCapabilities Capability) Capability {
+	if r&Admin == Admin {
+		return AllCapabilities
+	}
+	if r&Custom == Custom {
+		return customCapabilities & AllCapabilities
+	}
+	if r&Developer == Developer {
+		return DeveloperCapabilities
+	}
+	return 0
+}
+
+func CapabilitiesToProto(caps Capability) []grpb.Capability {
+	out := make([]grpb.Capability, 0)
+	for _, c := range grpb.Capability_value {
+		if c == int32(grpb.Capability_UNKNOWN_CAPABILITY) {
+			continue
+		}
+		if caps&Capability(1<<c) != 0 {
+			out = append(out, grpb.Capability(c))
+		}
+	}
+	sort.Slice(out, func(i, j int) bool { return out[i] < out[j] })
+	return out
+}
+
+func CapabilitiesFromProto(caps []grpb.Capability) Capability {
+	out := Capability(0)
+	for _, c := range caps {
+		if c == grpb.Capability_UNKNOWN_CAPABILITY {
+			continue
+		}
+		out |= Capability(1 << c)
 	}
-	return Developer
+	return out & AllCapabilities
 }